@@ -0,0 +1,196 @@
+package auth
+
+// API key authentication for programmatic clients. Keys look like
+// "cdx_<prefix>_<secret>": the prefix identifies the key in listings and
+// lookups, and only a SHA-256 hash of the whole key is stored. Clients send
+// the key as "Authorization: Bearer <key>". A key acts as its user, further
+// limited by its scopes and optional expiry.
+
+import (
+	"codex/src/memory"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// KeyPrefix starts every API key so leaked keys are easy to recognise.
+const KeyPrefix = "cdx_"
+
+// Scopes an API key can hold. ScopeAdmin grants every other scope and every
+// key may read.
+const (
+	ScopeRead        = "read"         // read projects, documents, models and status
+	ScopeChat        = "chat"         // chat with the assistant
+	ScopeProjects    = "projects"     // create, switch and rename projects, upload documents
+	ScopeModelsAdmin = "models:admin" // download, import, activate and refresh models
+	ScopeMetrics     = "metrics"      // scrape GET /metrics
+	ScopeAdmin       = "admin"        // everything, including deleting projects
+)
+
+// KnownScopes lists the valid scopes in documentation order.
+var KnownScopes = []string{ScopeRead, ScopeChat, ScopeProjects, ScopeModelsAdmin, ScopeMetrics, ScopeAdmin}
+
+// DefaultScopes are given to keys created without explicit scopes.
+var DefaultScopes = []string{ScopeRead, ScopeChat}
+
+// ErrInvalidKey is returned for malformed, unknown, revoked or expired keys.
+var ErrInvalidKey = errors.New("invalid or expired API key")
+
+// ParseScopes splits a comma-separated scope list and validates it.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, sc := range strings.Split(s, ",") {
+		sc = strings.TrimSpace(sc)
+		if sc == "" || seen[sc] {
+			continue
+		}
+		known := false
+		for _, k := range KnownScopes {
+			known = known || k == sc
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q (want %s)", sc, strings.Join(KnownScopes, ", "))
+		}
+		seen[sc] = true
+		scopes = append(scopes, sc)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("no scopes given")
+	}
+	return scopes, nil
+}
+
+// HasScope reports whether k grants scope. Every key may read, so a key
+// holding only models:admin can still look up the model it downloads.
+func HasScope(k *memory.APIKey, scope string) bool {
+	if scope == ScopeRead {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateKey generates a key for user and stores its hash. The returned
+// plaintext key is shown once and cannot be recovered later. A zero expires
+// means the key does not expire.
+func CreateKey(db *sql.DB, user *memory.User, name string, scopes []string, expires time.Time) (string, *memory.APIKey, error) {
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	for attempt := 0; ; attempt++ {
+		prefix, err := randomHex(4)
+		if err != nil {
+			return "", nil, err
+		}
+		secret, err := randomHex(24)
+		if err != nil {
+			return "", nil, err
+		}
+		key := KeyPrefix + prefix + "_" + secret
+		k := &memory.APIKey{
+			UserID:    user.ID,
+			Name:      name,
+			Prefix:    prefix,
+			Hash:      hashToken(key),
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+		}
+		if !expires.IsZero() {
+			k.ExpiresAt = &expires
+		}
+		err = memory.CreateAPIKey(db, k)
+		if err == nil {
+			return key, k, nil
+		}
+		// a prefix collision violates the unique index; try another
+		if attempt == 2 {
+			return "", nil, err
+		}
+	}
+}
+
+// randomHex returns n random bytes hex encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// KeyPrefixOf returns the lookup prefix of a key, or "" when it is not a
+// Codex API key.
+func KeyPrefixOf(key string) string {
+	rest, ok := strings.CutPrefix(key, KeyPrefix)
+	if !ok {
+		return ""
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return ""
+	}
+	return prefix
+}
+
+// AuthenticateKey resolves a key to its user. It returns ErrInvalidKey for
+// keys that are malformed, unknown, revoked or expired, or whose user no
+// longer exists.
+func AuthenticateKey(db *sql.DB, key string) (*memory.User, *memory.APIKey, error) {
+	prefix := KeyPrefixOf(key)
+	if prefix == "" {
+		return nil, nil, ErrInvalidKey
+	}
+	k, err := memory.GetAPIKeyByPrefix(db, prefix)
+	if errors.Is(err, memory.ErrKeyNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashToken(key))) != 1 ||
+		k.RevokedAt != nil || (k.ExpiresAt != nil && now.After(*k.ExpiresAt)) {
+		return nil, nil, ErrInvalidKey
+	}
+	u, err := memory.GetUser(db, k.UserID)
+	if errors.Is(err, memory.ErrUserNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if k.LastUsed == nil || now.Sub(*k.LastUsed) > touchInterval {
+		if err := memory.TouchAPIKey(db, k.ID, now); err != nil {
+			log.Printf("auth: touch api key: %v", err)
+		}
+	}
+	return u, k, nil
+}
+
+// keyKey is the context key of the API key used by a request.
+type keyKey struct{}
+
+// WithKey returns a context recording that the request was authenticated
+// with k.
+func WithKey(ctx context.Context, k *memory.APIKey) context.Context {
+	return context.WithValue(ctx, keyKey{}, k)
+}
+
+// CurrentKey returns the API key of the request context or nil for session
+// and anonymous requests.
+func CurrentKey(ctx context.Context) *memory.APIKey {
+	k, _ := ctx.Value(keyKey{}).(*memory.APIKey)
+	return k
+}
@@ -0,0 +1,283 @@
+package auth
+
+// Package auth implements user login for the web UI and API. Passwords are
+// hashed with bcrypt, logins create server-side sessions whose random token
+// is handed to the browser in an HttpOnly cookie, and Middleware resolves
+// that cookie, or an API key, to the current user on every request.
+//
+// AI Awareness: handlers read the caller with CurrentUser; a nil user means
+// the request is anonymous.
+
+import (
+	"codex/src/memory"
+	"codex/src/notify"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CookieName is the cookie carrying the session token.
+const CookieName = "session"
+
+// AnonCookieName is the cookie identifying an anonymous browser.
+const AnonCookieName = "anon"
+
+// MinPasswordLength is the shortest accepted password.
+const MinPasswordLength = 8
+
+// SessionTTL is how long a session stays valid without activity. Every
+// authenticated request extends it.
+var SessionTTL = 7 * 24 * time.Hour
+
+// SecureCookies marks the session and anonymous cookies Secure so browsers
+// only send them over HTTPS or to localhost.
+var SecureCookies = true
+
+// touchInterval limits how often session activity is written back.
+const touchInterval = time.Minute
+
+// ErrInvalidCredentials is returned by Login for an unknown user or a wrong
+// password; the two are not distinguished.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrWeakPassword is returned for passwords shorter than MinPasswordLength.
+var ErrWeakPassword = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+// CheckPassword reports whether password matches hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// dummyHash is compared against when the user does not exist so a failed
+// login takes as long either way. It is computed on first use to keep
+// start-up fast.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("codex-dummy-password"), bcrypt.DefaultCost)
+	return h
+})
+
+// Login verifies the credentials and opens a session. It returns the token
+// to send to the client and the user.
+func Login(db *sql.DB, username, password string, r *http.Request) (string, *memory.User, error) {
+	u, err := memory.GetUserByName(db, username)
+	if errors.Is(err, memory.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !CheckPassword(u.PasswordHash, password) {
+		return "", nil, ErrInvalidCredentials
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	s := memory.Session{
+		ID:        hashToken(token),
+		UserID:    u.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+		LastSeen:  now,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	if err := memory.CreateSession(db, s); err != nil {
+		return "", nil, err
+	}
+	if err := memory.RecordLogin(db, u.ID, now); err != nil {
+		log.Printf("auth: record login: %v", err)
+	}
+	// a login from a browser the user has not used before may mean the
+	// password leaked; the very first login is not worth an alert
+	isNew, seenOthers, err := memory.RecordDevice(db, u.ID, hashToken(r.UserAgent()), now)
+	if err != nil {
+		log.Printf("auth: record device: %v", err)
+	} else if isNew && seenOthers {
+		notify.Send(notify.EventNewDevice, notify.Login{Username: u.Username, IP: s.IP, UserAgent: s.UserAgent, Time: now})
+	}
+	if _, err := memory.PurgeExpiredSessions(db, now); err != nil {
+		log.Printf("auth: purge sessions: %v", err)
+	}
+	return token, u, nil
+}
+
+// Logout ends the session identified by token.
+func Logout(db *sql.DB, token string) error {
+	return memory.DeleteSession(db, hashToken(token))
+}
+
+// Authenticate resolves a session token to its user, extending the session.
+// It returns nil without error when the token is unknown or expired.
+func Authenticate(db *sql.DB, token string) (*memory.User, error) {
+	if token == "" {
+		return nil, nil
+	}
+	now := time.Now()
+	id := hashToken(token)
+	s, err := memory.GetSession(db, id, now)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	u, err := memory.GetUser(db, s.UserID)
+	if errors.Is(err, memory.ErrUserNotFound) {
+		memory.DeleteSession(db, id)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if now.Sub(s.LastSeen) > touchInterval {
+		if err := memory.TouchSession(db, id, now, now.Add(SessionTTL)); err != nil {
+			log.Printf("auth: touch session: %v", err)
+		}
+	}
+	return u, nil
+}
+
+// newToken returns a random session token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken derives the stored session ID from a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the remote address without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetSessionCookie sends the session token to the client.
+func SetSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(SessionTTL),
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the client.
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// userKey is the context key of the current user.
+type userKey struct{}
+
+// WithUser returns a context carrying u as the current user.
+func WithUser(ctx context.Context, u *memory.User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// CurrentUser returns the user of the request context or nil.
+func CurrentUser(ctx context.Context) *memory.User {
+	u, _ := ctx.Value(userKey{}).(*memory.User)
+	return u
+}
+
+// Middleware populates the current user from an "Authorization: Bearer"
+// API key or the session cookie. Requests without either continue
+// anonymously, identified by their "anon" cookie; an invalid API key is
+// rejected with 401 straight away so scripts do not silently run as
+// anonymous. Requests from other hosts are marked so they never get the
+// open access of a server without users.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		anon := ""
+		if c, err := r.Cookie(AnonCookieName); err == nil {
+			anon = c.Value
+		}
+		r = r.WithContext(withRemote(WithAnonymous(r.Context(), anon), r))
+		key, hasKey := bearerToken(r)
+		c, err := r.Cookie(CookieName)
+		if !hasKey && (err != nil || c.Value == "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		db, err := memory.InitDB()
+		if err != nil {
+			log.Printf("auth middleware InitDB error: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer db.Close()
+		if hasKey {
+			u, k, err := AuthenticateKey(db, key)
+			if err != nil {
+				if !errors.Is(err, ErrInvalidKey) {
+					log.Printf("auth middleware api key error: %v", err)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, ErrInvalidKey.Error(), http.StatusUnauthorized)
+				return
+			}
+			ctx := WithKey(WithUser(r.Context(), u), k)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		u, err := Authenticate(db, c.Value)
+		if err != nil {
+			log.Printf("auth middleware session error: %v", err)
+		}
+		if u != nil {
+			r = r.WithContext(WithUser(r.Context(), u))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
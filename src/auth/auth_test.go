@@ -0,0 +1,333 @@
+package auth
+
+// Tests for password hashing, login sessions and the middleware.
+
+import (
+	"codex/src/memory"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoginSessionLifecycle logs in, resolves the cookie through the
+// middleware, logs out and checks that the session no longer works.
+func TestLoginSessionLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	if _, err := HashPassword("short"); err != ErrWeakPassword {
+		t.Fatalf("expected ErrWeakPassword, got %v", err)
+	}
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword error: %v", err)
+	}
+	if _, err := memory.CreateUser(db, "alice", hash, "member"); err != nil {
+		t.Fatalf("CreateUser error: %v", err)
+	}
+	if _, err := memory.CreateUser(db, "Alice", hash, "member"); err != memory.ErrUserExists {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	if _, _, err := Login(db, "alice", "wrong password", req); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, _, err := Login(db, "bob", "correct horse", req); err != ErrInvalidCredentials {
+		t.Fatalf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+	token, u, err := Login(db, "alice", "correct horse", req)
+	if err != nil || u.Username != "alice" {
+		t.Fatalf("Login: %v %+v", err, u)
+	}
+
+	var seen *memory.User
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = CurrentUser(r.Context())
+	}))
+	call := func(token string) *memory.User {
+		seen = nil
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+		r.AddCookie(&http.Cookie{Name: CookieName, Value: token})
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		return seen
+	}
+	if got := call(token); got == nil || got.ID != u.ID {
+		t.Fatalf("middleware did not resolve the session: %+v", got)
+	}
+	if got := call("forged"); got != nil {
+		t.Fatalf("forged token accepted: %+v", got)
+	}
+
+	if err := Logout(db, token); err != nil {
+		t.Fatalf("Logout error: %v", err)
+	}
+	if got := call(token); got != nil {
+		t.Fatalf("session still valid after logout")
+	}
+
+	// expired sessions are ignored and purged on the next login
+	old := SessionTTL
+	SessionTTL = -time.Second
+	expired, _, _ := Login(db, "alice", "correct horse", req)
+	SessionTTL = old
+	if got := call(expired); got != nil {
+		t.Fatalf("expired session accepted")
+	}
+	Login(db, "alice", "correct horse", req)
+	if n, _ := memory.PurgeExpiredSessions(db, time.Now()); n != 0 {
+		t.Fatalf("expired session was not purged at login")
+	}
+}
+
+// TestRequireRoles checks open access before the first user exists and the
+// 401 and 403 answers afterwards.
+func TestRequireRoles(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	h := Require(RoleAdmin, ScopeModelsAdmin, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	call := func(u *memory.User) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/models/x/enable", nil)
+		if u != nil {
+			r = r.WithContext(WithUser(r.Context(), u))
+		}
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		return rec.Code
+	}
+	if code := call(nil); code != http.StatusNoContent {
+		t.Fatalf("expected open access without users, got %d", code)
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	member, _ := memory.CreateUser(db, "mia", "x", string(RoleMember))
+	admin, _ := memory.CreateUser(db, "ada", "x", string(RoleAdmin))
+	forgetUsers()
+	if code := call(nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for anonymous, got %d", code)
+	}
+	if code := call(member); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", code)
+	}
+	if code := call(admin); code != http.StatusNoContent {
+		t.Fatalf("expected admin to pass, got %d", code)
+	}
+
+	rw := RequireWrite(RoleViewer, RoleMember, ScopeProjects, func(w http.ResponseWriter, r *http.Request) {})
+	viewer := &memory.User{Username: "val", Role: string(RoleViewer)}
+	for method, want := range map[string]int{http.MethodGet: http.StatusOK, http.MethodPost: http.StatusForbidden} {
+		r := httptest.NewRequest(method, "/api/projects", nil)
+		rec := httptest.NewRecorder()
+		rw(rec, r.WithContext(WithUser(r.Context(), viewer)))
+		if rec.Code != want {
+			t.Fatalf("%s by viewer: got %d, want %d", method, rec.Code, want)
+		}
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Fatalf("expected error for unknown role")
+	}
+}
+
+// TestOpenAccessLoopback checks that a server without users is only open
+// to clients on this host and closes once a user exists.
+func TestOpenAccessLoopback(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	h := Middleware(Require(RoleAdmin, ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(addr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/projects/delete", nil)
+		r.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	for addr, want := range map[string]int{
+		"127.0.0.1:5000":  http.StatusOK,
+		"[::1]:5000":      http.StatusOK,
+		"192.0.2.1:5000":  http.StatusUnauthorized,
+		"[2001:db8::1]:1": http.StatusUnauthorized,
+	} {
+		if code := call(addr); code != want {
+			t.Fatalf("%s without users: got %d, want %d", addr, code, want)
+		}
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	memory.CreateUser(db, "ada", "x", string(RoleAdmin))
+	// the cached state is only refreshed after usersRecheck
+	if code := call("127.0.0.1:5000"); code != http.StatusOK {
+		t.Fatalf("cached open state: got %d", code)
+	}
+	forgetUsers()
+	if code := call("127.0.0.1:5000"); code != http.StatusUnauthorized {
+		t.Fatalf("loopback with users: got %d, want 401", code)
+	}
+}
+
+// TestAPIKeys authenticates with a bearer key and checks scopes, expiry and
+// revocation.
+func TestAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	u, _ := memory.CreateUser(db, "ada", "x", string(RoleAdmin))
+	key, k, err := CreateKey(db, u, "laptop", []string{ScopeChat}, time.Time{})
+	if err != nil {
+		t.Fatalf("CreateKey error: %v", err)
+	}
+	if KeyPrefixOf(key) != k.Prefix || k.Hash == key {
+		t.Fatalf("unexpected key %q %+v", key, k)
+	}
+	// unset times are left out of the JSON rather than sent as year 1
+	stored, _ := memory.GetAPIKeyByPrefix(db, k.Prefix)
+	if b, _ := json.Marshal(stored); strings.Contains(string(b), "expires_at") || strings.Contains(string(b), "last_used") ||
+		strings.Contains(string(b), "revoked_at") {
+		t.Fatalf("unset times serialised: %s", b)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/chat", Require(RoleMember, ScopeChat, func(w http.ResponseWriter, r *http.Request) {}))
+	mux.HandleFunc("/api/models/refresh", Require(RoleAdmin, ScopeModelsAdmin, func(w http.ResponseWriter, r *http.Request) {}))
+	h := Middleware(mux)
+	call := func(path, key string) int {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := call("/api/chat", key); code != http.StatusOK {
+		t.Fatalf("chat with key: %d", code)
+	}
+	if code := call("/api/models/refresh", key); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without models:admin scope, got %d", code)
+	}
+	if code := call("/api/chat", key+"x"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong secret, got %d", code)
+	}
+
+	expired, _, _ := CreateKey(db, u, "old", []string{ScopeAdmin}, time.Now().Add(-time.Minute))
+	if code := call("/api/models/refresh", expired); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an expired key, got %d", code)
+	}
+	if err := memory.RevokeAPIKey(db, k.Prefix, time.Now()); err != nil {
+		t.Fatalf("RevokeAPIKey error: %v", err)
+	}
+	if code := call("/api/chat", key); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a revoked key, got %d", code)
+	}
+	stored, _ = memory.GetAPIKeyByPrefix(db, k.Prefix)
+	if b, _ := json.Marshal(stored); !strings.Contains(string(b), "last_used") || !strings.Contains(string(b), "revoked_at") {
+		t.Fatalf("set times missing from JSON: %s", b)
+	}
+	if _, err := ParseScopes("chat, models:admin"); err != nil {
+		t.Fatalf("ParseScopes error: %v", err)
+	}
+	if _, err := ParseScopes("chat,root"); err == nil {
+		t.Fatalf("expected error for unknown scope")
+	}
+}
+
+// TestProjectAccess checks that callers only see projects shared with them,
+// that admins see everything and that active projects are per caller.
+func TestProjectAccess(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	anon := WithAnonymous(context.Background(), "b1")
+	memory.CreateProject(db, "draft", Principal(anon))
+	if list, _ := VisibleProjects(anon, db); len(list) != 1 {
+		t.Fatalf("anonymous owner should see its project: %v", list)
+	}
+
+	alice, _ := memory.CreateUser(db, "alice", "x", string(RoleMember))
+	bob, _ := memory.CreateUser(db, "bob", "x", string(RoleMember))
+	admin, _ := memory.CreateUser(db, "ada", "x", string(RoleAdmin))
+	forgetUsers()
+	actx := WithUser(context.Background(), alice)
+	bctx := WithUser(context.Background(), bob)
+	memory.CreateProject(db, "private", Principal(actx))
+	memory.AddProject(db, "shared")
+
+	if list, _ := VisibleProjects(bctx, db); len(list) != 1 || list[0] != "shared" {
+		t.Fatalf("bob should only see the shared project: %v", list)
+	}
+	if list, _ := VisibleProjects(WithUser(context.Background(), admin), db); len(list) != 3 {
+		t.Fatalf("admin should see every project: %v", list)
+	}
+	if a, _ := ProjectAccess(anon, db, "draft"); a != memory.AccessOwner {
+		t.Fatalf("anonymous owner access = %q", a)
+	}
+	if a, _ := ProjectAccess(WithAnonymous(context.Background(), "b2"), db, "draft"); a != "" {
+		t.Fatalf("other browser must not see draft once users exist: %q", a)
+	}
+
+	memory.SetActiveProjectFor(db, Principal(actx), "private")
+	memory.SetActiveProjectFor(db, Principal(bctx), "private")
+	if p, _ := ActiveProject(actx, db); p != "private" {
+		t.Fatalf("alice active = %q", p)
+	}
+	if p, _ := ActiveProject(bctx, db); p != "" {
+		t.Fatalf("unreadable project must not be active for bob: %q", p)
+	}
+	memory.SetProjectMember(db, "private", Principal(bctx), memory.AccessRead)
+	if p, _ := ActiveProject(bctx, db); p != "private" {
+		t.Fatalf("bob active after sharing = %q", p)
+	}
+
+	check := func(ctx context.Context, need string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/projects/rename", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		CheckProject(rec, r, db, "private", need)
+		return rec.Code
+	}
+	if code := check(bctx, memory.AccessWrite); code != http.StatusForbidden {
+		t.Fatalf("reader writing: got %d, want 403", code)
+	}
+	if code := check(WithAnonymous(context.Background(), "b1"), memory.AccessRead); code != http.StatusNotFound {
+		t.Fatalf("stranger reading: got %d, want 404", code)
+	}
+	if code := check(actx, memory.AccessOwner); code != http.StatusOK {
+		t.Fatalf("owner: got %d, want 200", code)
+	}
+}
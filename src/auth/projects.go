@@ -0,0 +1,120 @@
+package auth
+
+// Project access. Every request acts as a principal: "user:<id>" for a
+// logged in user or API key and "anon:<id>" for an anonymous browser,
+// identified by the cookie the handlers assign. Principals own and share
+// projects and each has its own active project, so teammates on one server
+// no longer switch each other's context. Admins, and local callers while the
+// server runs open, may access every project.
+//
+// AI Awareness: memory and knowledge queries made for a request must go
+// through ActiveProject or ProjectAccess so they only see projects the
+// caller may read.
+
+import (
+	"codex/src/memory"
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// UserPrincipal returns the principal of a user account.
+func UserPrincipal(u *memory.User) string {
+	return "user:" + strconv.Itoa(u.ID)
+}
+
+// anonKey is the context key of the anonymous principal.
+type anonKey struct{}
+
+// WithAnonymous returns a context identifying an anonymous caller by the ID
+// of its browser cookie. An empty ID still marks the caller as anonymous.
+func WithAnonymous(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, anonKey{}, "anon:"+id)
+}
+
+// Principal returns the principal of the context: the current user, else
+// the anonymous caller. It is "" outside HTTP requests, for example in the
+// CLI, which acts on the global active project with full access.
+func Principal(ctx context.Context) string {
+	if u := CurrentUser(ctx); u != nil {
+		return UserPrincipal(u)
+	}
+	p, _ := ctx.Value(anonKey{}).(string)
+	return p
+}
+
+// IsAdmin reports whether the caller may access every project: local
+// callers, admins whose API key, if any, holds the admin scope, and
+// anonymous callers on this host while no user exists.
+func IsAdmin(ctx context.Context) bool {
+	u := CurrentUser(ctx)
+	if u == nil {
+		return Principal(ctx) == "" || openAccess(ctx)
+	}
+	if !UserRole(u).Allows(RoleAdmin) {
+		return false
+	}
+	k := CurrentKey(ctx)
+	return k == nil || HasScope(k, ScopeAdmin)
+}
+
+// ProjectAccess returns the caller's access to project, one of the
+// memory.Access levels, or "" when the project does not exist or the caller
+// may not see it.
+func ProjectAccess(ctx context.Context, db *sql.DB, project string) (string, error) {
+	if IsAdmin(ctx) {
+		ok, err := memory.ProjectExists(db, project)
+		if err != nil || !ok {
+			return "", err
+		}
+		return memory.AccessOwner, nil
+	}
+	return memory.ProjectAccess(db, project, Principal(ctx))
+}
+
+// VisibleProjects lists the projects the caller may see.
+func VisibleProjects(ctx context.Context, db *sql.DB) ([]string, error) {
+	if IsAdmin(ctx) {
+		return memory.ListProjects(db)
+	}
+	return memory.ListProjectsFor(db, Principal(ctx))
+}
+
+// ActiveProject returns the caller's active project, or "" when none is
+// selected or the caller lost access to it.
+func ActiveProject(ctx context.Context, db *sql.DB) (string, error) {
+	name, err := memory.GetActiveProjectFor(db, Principal(ctx))
+	if err != nil || name == "" {
+		return "", err
+	}
+	access, err := ProjectAccess(ctx, db, name)
+	if err != nil || access == "" {
+		return "", err
+	}
+	return name, nil
+}
+
+// CheckProject verifies that the request may act on project with need
+// access. Otherwise it answers 404 when the caller cannot see the project,
+// so private project names are not revealed, or 403 when its access is too
+// low, and returns false.
+func CheckProject(w http.ResponseWriter, r *http.Request, db *sql.DB, project, need string) bool {
+	access, err := ProjectAccess(r.Context(), db, project)
+	if err != nil {
+		log.Printf("auth: project access %s: %v", project, err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if access == "" {
+		http.Error(w, "project not found", http.StatusNotFound)
+		return false
+	}
+	if !memory.AccessAllows(access, need) {
+		log.Printf("auth: %s denied %s %s, needs %s access to %s", Principal(r.Context()), r.Method, r.URL.Path, need, project)
+		http.Error(w, "forbidden: "+need+" access to the project required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package auth
+
+// Role-based authorization. Every user has one of three roles ordered by
+// privilege: viewers may read projects, models and status, members may also
+// chat and change projects, and admins may additionally download, import
+// and activate models and delete projects. Handlers are wrapped with Require
+// or call Check; an anonymous request answers 401 and a user whose role is
+// too low answers 403 so the web UI can send the user to the login page or
+// show a permission error. Requests made with an API key must in addition
+// hold the scope named by the route.
+//
+// Until the first user is created the server runs open for clients on this
+// host: their anonymous requests are treated as admin so existing
+// single-user setups keep working. Other hosts get 401 until an account
+// exists.
+
+import (
+	"codex/src/memory"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Role is a user's privilege level.
+type Role string
+
+// Known roles from least to most privileged.
+const (
+	RoleViewer Role = "viewer"
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+)
+
+// rank orders the roles.
+var rank = map[Role]int{RoleViewer: 1, RoleMember: 2, RoleAdmin: 3}
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if rank[r] == 0 {
+		return "", fmt.Errorf("unknown role %q (want viewer, member or admin)", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether a holder of r may do what need requires.
+func (r Role) Allows(need Role) bool {
+	return rank[r] > 0 && rank[r] >= rank[need]
+}
+
+// UserRole returns the role of u, treating unknown values as viewer.
+func UserRole(u *memory.User) Role {
+	if r, err := ParseRole(u.Role); err == nil {
+		return r
+	}
+	return RoleViewer
+}
+
+// usersRecheck is how often a server without users looks for the first
+// one again, since `codex users add` creates it from another process.
+const usersRecheck = 5 * time.Second
+
+// users caches whether the database has any user. Once a user is seen the
+// server stays closed until it restarts, even if every user is deleted.
+var users struct {
+	sync.Mutex
+	path    string // absolute path of the database the state belongs to
+	exist   bool
+	checked time.Time
+}
+
+// usersExist reports whether any user exists, querying the database at
+// most every usersRecheck while there is none. Errors count as existing so
+// a broken database never opens the server.
+func usersExist() bool {
+	path, err := filepath.Abs(memory.Path())
+	if err != nil {
+		path = memory.Path()
+	}
+	users.Lock()
+	defer users.Unlock()
+	if users.path == path && (users.exist || time.Since(users.checked) < usersRecheck) {
+		return users.exist
+	}
+	users.path, users.exist, users.checked = path, true, time.Now()
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("auth: InitDB error: %v", err)
+		return true
+	}
+	defer db.Close()
+	n, err := memory.CountUsers(db)
+	if err != nil {
+		log.Printf("auth: CountUsers error: %v", err)
+		return true
+	}
+	users.exist = n > 0
+	return users.exist
+}
+
+// forgetUsers drops the cached state so the next check queries the
+// database. Tests call it after creating users.
+func forgetUsers() {
+	users.Lock()
+	users.path = ""
+	users.Unlock()
+}
+
+// openAccess reports whether an anonymous caller is allowed everything:
+// no user exists yet and the request comes from this host. Remote clients
+// always have to log in, so a fresh install is not open to the network.
+func openAccess(ctx context.Context) bool {
+	if remote, _ := ctx.Value(remoteKey{}).(bool); remote {
+		return false
+	}
+	return !usersExist()
+}
+
+// remoteKey is the context key marking requests from other hosts.
+type remoteKey struct{}
+
+// withRemote marks the context of a request whose client is not on a
+// loopback address. Contexts without the mark, such as the CLI's, count as
+// local.
+func withRemote(ctx context.Context, r *http.Request) context.Context {
+	if ip := net.ParseIP(clientIP(r)); ip != nil && ip.IsLoopback() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, true)
+}
+
+// Check verifies that the request's user holds need and, for API key
+// requests, that the key grants scope. Otherwise it writes 401 for anonymous
+// requests or 403 for users with a lower role or keys without the scope and
+// returns false.
+func Check(w http.ResponseWriter, r *http.Request, need Role, scope string) bool {
+	u := CurrentUser(r.Context())
+	if u == nil {
+		if openAccess(r.Context()) {
+			return true
+		}
+		http.Error(w, "login required", http.StatusUnauthorized)
+		return false
+	}
+	if !UserRole(u).Allows(need) {
+		log.Printf("auth: %s (%s) denied %s %s, needs %s", u.Username, u.Role, r.Method, r.URL.Path, need)
+		http.Error(w, fmt.Sprintf("forbidden: %s role required", need), http.StatusForbidden)
+		return false
+	}
+	if k := CurrentKey(r.Context()); k != nil && !HasScope(k, scope) {
+		log.Printf("auth: key %s of %s denied %s %s, needs scope %s", k.Prefix, u.Username, r.Method, r.URL.Path, scope)
+		http.Error(w, fmt.Sprintf("forbidden: API key lacks the %s scope", scope), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Require wraps h so it only runs for users holding need and keys holding
+// scope.
+func Require(need Role, scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Check(w, r, need, scope) {
+			h(w, r)
+		}
+	}
+}
+
+// RequireWrite wraps h so GET and HEAD requests need the read role and
+// scope and every other method needs write and scope.
+func RequireWrite(read, write Role, scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		need := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need, scope = read, ScopeRead
+		}
+		if Check(w, r, need, scope) {
+			h(w, r)
+		}
+	}
+}
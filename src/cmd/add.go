@@ -30,7 +30,12 @@ var addCmd = &cobra.Command{
 		role := args[1]
 		content := args[2]
 
-		if err := memory.AddMemory(project, role, content, importance); err != nil {
+		// Without --importance the memory package scores the entry.
+		var imp []int
+		if cmd.Flags().Changed("importance") {
+			imp = append(imp, importance)
+		}
+		if err := memory.AddMemory(project, role, content, imp...); err != nil {
 			fmt.Println("Error:", err)
 		} else {
 			fmt.Println("Memory added.")
@@ -41,6 +46,6 @@ var addCmd = &cobra.Command{
 // init attaches the command to the rootCmd so it becomes available when the
 // CLI is executed. Cobra uses init functions to wire commands together.
 func init() {
-	addCmd.Flags().IntVarP(&importance, "importance", "i", 0, "importance score")
+	addCmd.Flags().IntVarP(&importance, "importance", "i", 0, "importance score (default: scored automatically)")
 	rootCmd.AddCommand(addCmd)
 }
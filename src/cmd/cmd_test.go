@@ -5,9 +5,26 @@ package cmd
 // common error scenarios.
 
 import (
+	"bytes"
+	"codex/src/auth"
+	"codex/src/config"
+	"codex/src/llama"
 	"codex/src/memory"
+	"codex/src/models"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestAddCommand verifies that the `add` CLI subcommand writes a memory entry
@@ -45,3 +62,287 @@ func TestExecuteInvalidCommand(t *testing.T) {
 		t.Fatalf("expected error for invalid command")
 	}
 }
+
+// TestListCommandJSON runs `models list` against a stand-in hub that serves
+// two pages. It checks that the filter flags reach the query string, that the
+// Link header is followed and that JSON output is produced without prompting.
+func TestListCommandJSON(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("cursor") == "" {
+			if q.Get("author") != "org" || q.Get("sort") != "likes" || q.Get("filter") != "gguf" || q.Get("pipeline_tag") != "text-generation" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/models?cursor=2>; rel="next"`, srv.URL))
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "org/a", "likes": 9}})
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "org/b", "likes": 3}})
+	}))
+	defer srv.Close()
+	prev := models.CurrentClientConfig()
+	defer models.Configure(prev)
+	models.Configure(models.ClientConfig{BaseURL: srv.URL})
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"models", "list", "--pipeline", "text-generation", "--author", "org",
+		"--sort", "likes", "--gguf-only", "--limit", "5", "--output", "json"})
+	if err := Execute(); err != nil {
+		t.Fatalf("users list error: %v", err)
+	}
+	var got []struct {
+		ID    string `json:"modelId"`
+		Likes int    `json:"likes"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if len(got) != 2 || got[0].ID != "org/a" || got[1].ID != "org/b" {
+		t.Fatalf("unexpected output: %+v", got)
+	}
+}
+
+// TestUsersCommands creates a user with a piped password, changes it and
+// removes the account.
+func TestUsersCommands(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetIn(nil)
+	run := func(stdin string, args ...string) error {
+		rootCmd.SetIn(strings.NewReader(stdin))
+		rootCmd.SetArgs(args)
+		return Execute()
+	}
+	if err := run("s3cret-pass\n", "users", "add", "carol"); err != nil {
+		t.Fatalf("users add error: %v", err)
+	}
+	if err := run("short\n", "users", "passwd", "carol"); err == nil {
+		t.Fatalf("expected weak password error")
+	}
+	if err := run("an0ther-pass\n", "users", "passwd", "carol"); err != nil {
+		t.Fatalf("users passwd error: %v", err)
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	u, err := memory.GetUserByName(db, "carol")
+	if err != nil || !auth.CheckPassword(u.PasswordHash, "an0ther-pass") {
+		t.Fatalf("password not changed: %v", err)
+	}
+	if err := run("", "users", "rm", "carol"); err != nil {
+		t.Fatalf("users rm error: %v", err)
+	}
+	if _, err := memory.GetUserByName(db, "carol"); err != memory.ErrUserNotFound {
+		t.Fatalf("user not removed: %v", err)
+	}
+}
+
+// TestConfigCommands writes a config file with `config init`, refuses to
+// overwrite it, and checks that `config validate` accepts it and reports a
+// broken setting.
+func TestConfigCommands(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	run := func(args ...string) error {
+		rootCmd.SetArgs(args)
+		return Execute()
+	}
+	if err := run("config", "init"); err != nil {
+		t.Fatalf("config init error: %v", err)
+	}
+	if err := run("config", "init"); err == nil {
+		t.Fatalf("expected config init to refuse an existing file")
+	}
+	if err := run("config", "validate"); err != nil || !strings.Contains(out.String(), "codex.yaml: ok") {
+		t.Fatalf("config validate = %v, output %q", err, out.String())
+	}
+	os.WriteFile("bad.yaml", []byte("sampling:\n  temperature: 7\n"), 0600)
+	err := run("config", "validate", "bad.yaml")
+	if err == nil || !strings.Contains(err.Error(), "sampling.temperature") {
+		t.Fatalf("config validate bad.yaml = %v", err)
+	}
+}
+
+// TestConfigBackendClient checks that commands other than serve talk to the
+// backend and use the sampling settings from codex.yaml.
+func TestConfigBackendClient(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+	prev := llama.DefaultClient()
+	defer llama.SetDefaultClient(prev)
+
+	os.WriteFile("codex.yaml", []byte("backend:\n  url: http://gpu-box:9000\n  timeout: 30s\n  retries: 1\nsampling:\n  temperature: 0.2\n"), 0600)
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"users", "list"})
+	if err := Execute(); err != nil {
+		t.Fatalf("users list error: %v", err)
+	}
+	c := llama.DefaultClient()
+	if c.BaseURL != "http://gpu-box:9000" || c.Timeout != 30*time.Second || c.MaxRetries != 1 || c.Sampling.Temperature != 0.2 {
+		t.Fatalf("client = %s timeout %v retries %d sampling %+v", c.BaseURL, c.Timeout, c.MaxRetries, c.Sampling)
+	}
+}
+
+// TestLifecycleShutdown checks the shutdown sequence: a request that
+// outlives the drain timeout is aborted through its context, background jobs
+// are cancelled before the flush steps run, and a quick request started
+// before the signal still completes.
+func TestLifecycleShutdown(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	started := make(chan struct{}, 2)
+	aborted := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		close(aborted)
+	})
+	mux.HandleFunc("/quick", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})
+	cfg := config.Default().Server
+	cfg.ShutdownTimeout = 200 * time.Millisecond
+	l := newLifecycle(cfg, mux)
+	var steps []string
+	l.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		steps = append(steps, "job")
+	})
+	l.AtShutdown(func() { steps = append(steps, "flush") })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go l.srv.Serve(ln)
+	base := "http://" + ln.Addr().String()
+	go http.Get(base + "/slow")
+	quick := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/quick")
+		if err != nil {
+			quick <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		quick <- string(b)
+	}()
+	<-started
+	<-started
+
+	l.Shutdown()
+	select {
+	case <-aborted:
+	default:
+		t.Fatalf("slow request was not aborted")
+	}
+	if got := <-quick; got != "done" {
+		t.Fatalf("quick request = %q, want done", got)
+	}
+	if strings.Join(steps, ",") != "job,flush" {
+		t.Fatalf("shutdown steps = %v", steps)
+	}
+}
+
+// TestSelfSignedTLS checks that a self-signed certificate is generated in
+// the data directory, reused on the next start and served over HTTPS with
+// the HSTS header, and that plain HTTP is redirected.
+func TestSelfSignedTLS(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.DataDir = t.TempDir()
+	cfg.TLSSelfSigned = true
+	cfg.TLSHosts = "codex.lan, 10.0.0.5"
+	cfg.HSTSMaxAge = 24 * time.Hour
+	certFile, keyFile, err := tlsFiles(cfg)
+	if err != nil {
+		t.Fatalf("tlsFiles error: %v", err)
+	}
+	cert, err := loadCert(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load generated certificate: %v", err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "codex.lan", "10.0.0.5"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("certificate does not cover %s: %v", host, err)
+		}
+	}
+	if cert.IsCA || cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("certificate is not a leaf: IsCA %v, key usage %v", cert.IsCA, cert.KeyUsage)
+	}
+	if !insideDir(cfg.DataDir, keyFile) || insideDir(filepath.Join(cfg.DataDir, "tls", "sub"), keyFile) {
+		t.Errorf("insideDir misplaces %s", keyFile)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file mode = %v, %v", info, err)
+	}
+	if _, _, err := tlsFiles(cfg); err != nil {
+		t.Fatalf("second tlsFiles error: %v", err)
+	}
+	again, _ := loadCert(certFile, keyFile)
+	if again.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("certificate regenerated although still valid")
+	}
+
+	l := newLifecycle(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	l.UseTLS(certFile, keyFile, "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go l.srv.ServeTLS(ln, certFile, keyFile)
+	defer l.srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("https request: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=86400" {
+		t.Fatalf("HSTS header = %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://codex.lan/api/chat?x=1", nil)
+	redirectHandler(":8443").ServeHTTP(rec, req)
+	if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "https://codex.lan:8443/api/chat?x=1" {
+		t.Fatalf("redirect = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,230 @@
+package cmd
+
+// This file loads codex.yaml for every command and defines the `config`
+// command group for creating, inspecting and checking it.
+
+import (
+	"codex/src/auth"
+	"codex/src/config"
+	"codex/src/llama"
+	"codex/src/memory"
+	"codex/src/models"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// settings is the configuration of this invocation. The root command loads
+// it before any subcommand runs; flags given on the command line are already
+// applied.
+var settings = config.Default()
+
+// configPath is the --config flag.
+var configPath string
+
+// flagKeys maps command line flags to the settings they override. Flags
+// that were not given leave the file, environment or default value alone.
+var flagKeys = map[string]string{
+	"data-dir":        "server.data_dir",
+	"listen":          "server.listen",
+	"ui-dir":          "server.ui_dir",
+	"tls-cert":        "server.tls_cert",
+	"tls-key":         "server.tls_key",
+	"tls-self-signed": "server.tls_self_signed",
+	"http-redirect":   "server.http_redirect",
+	"hsts-max-age":    "server.hsts_max_age",
+	"llama-url":       "backend.url",
+	"llama-server":    "backend.binary",
+	"llama-host":      "backend.host",
+	"llama-port":      "backend.port",
+	"ctx-size":        "backend.ctx_size",
+	"threads":         "backend.threads",
+	"batch-size":      "backend.batch_size",
+	"llama-timeout":   "backend.timeout",
+	"llama-retries":   "backend.retries",
+}
+
+// loadConfig reads the config file at path, when not empty, and the
+// environment and overlays the flags of cmd, giving the precedence
+// flags > env > file > defaults.
+func loadConfig(cmd *cobra.Command, path string) (*config.Config, error) {
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, key := range flagKeys {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || !f.Changed {
+			continue
+		}
+		if err := c.Set(key, f.Value.String(), config.SourceFlag); err != nil {
+			return nil, fmt.Errorf("--%s: %w", name, err)
+		}
+		// launching a binary given on the command line means a managed
+		// backend unless the type was given too
+		if name == "llama-server" && f.Value.String() != "" && c.Source("backend.type") != config.SourceFlag {
+			c.Backend.Type = config.BackendManaged
+		}
+	}
+	return c, nil
+}
+
+// loadSettings loads and validates the configuration and applies the parts
+// shared by every command: the data directory, the session settings and the
+// llama client, so ingest, index and the models commands talk to the
+// configured backend too.
+func loadSettings(cmd *cobra.Command) error {
+	c, err := loadConfig(cmd, config.Path(configPath))
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration (see codex config validate):\n%w", err)
+	}
+	if err := os.MkdirAll(c.Server.DataDir, 0755); err != nil {
+		return fmt.Errorf("data directory: %w", err)
+	}
+	memory.SetPath(filepath.Join(c.Server.DataDir, "memory.db"))
+	models.SetDir(filepath.Join(c.Server.DataDir, "models"))
+	auth.SessionTTL = c.Auth.SessionTTL
+	auth.SecureCookies = c.Auth.SecureCookies
+	llama.SetDefaultClient(backendClient(c))
+	settings = c
+	return nil
+}
+
+// backendURL returns the address of the llama.cpp server: backend.url when
+// set, else the host and port a managed server is launched on, else the
+// default local server.
+func backendURL(be config.Backend) string {
+	switch {
+	case be.URL != "":
+		return be.URL
+	case be.Type == config.BackendManaged:
+		return fmt.Sprintf("http://%s:%d", be.Host, be.Port)
+	}
+	return "http://localhost:8080"
+}
+
+// backendClient builds the llama client described by c.
+func backendClient(c *config.Config) *llama.Client {
+	client := llama.NewClient(backendURL(c.Backend))
+	client.Timeout = c.Backend.Timeout
+	client.MaxRetries = c.Backend.Retries
+	sp := c.Sampling
+	client.Sampling = llama.Sampling{NPredict: sp.NPredict, Temperature: sp.Temperature, TopP: sp.TopP, TopK: sp.TopK}
+	return client
+}
+
+// configCmd groups the configuration subcommands. They load the file
+// themselves so a broken file can still be inspected and fixed.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Create, show and check the codex.yaml configuration",
+	Long: `Codex reads its settings from codex.yaml in the working directory, or the
+file named by --config or $CODEX_CONFIG. Every key "section.name" can be
+overridden by the environment variable CODEX_SECTION_NAME and some by a
+command line flag. Flags win over the environment, which wins over the file,
+which wins over the built in defaults.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+}
+
+// showReveal prints secrets in `config show`.
+var showReveal bool
+
+// configShowCmd prints the effective configuration and where each value
+// came from.
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.Path(configPath)
+		c, err := loadConfig(cmd, path)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if path != "" {
+			fmt.Fprintf(out, "# read from %s\n", path)
+		} else {
+			fmt.Fprintln(out, "# no config file; run codex config init to create one")
+		}
+		return c.Write(out, false, true, showReveal)
+	},
+}
+
+// configValidateCmd checks a config file and the environment.
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check the configuration for errors",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.Path(configPath)
+		if len(args) == 1 {
+			path = args[0]
+		}
+		c, err := loadConfig(cmd, path)
+		if err == nil {
+			err = c.Validate()
+		}
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			path = "defaults and environment"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: ok\n", path)
+		return nil
+	},
+}
+
+// initForce allows `config init` to replace an existing file.
+var initForce bool
+
+// configInitCmd writes a commented config file with the default values.
+var configInitCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "Write a codex.yaml with the default settings",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.DefaultFile
+		if len(args) == 1 {
+			path = args[0]
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if initForce {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(path, flags, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists; use --force to replace it", path)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(f, "# Codex configuration. Values are overridden by environment variables,")
+		fmt.Fprintln(f, "# listed after each description, and those by command line flags.")
+		fmt.Fprintln(f)
+		err = config.Default().Write(f, true, false, false)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", path)
+		return nil
+	},
+}
+
+// init registers the config commands with the root command.
+func init() {
+	configShowCmd.Flags().BoolVar(&showReveal, "reveal", false, "print secrets such as smtp.password")
+	configInitCmd.Flags().BoolVar(&initForce, "force", false, "replace an existing file")
+	configCmd.AddCommand(configShowCmd, configValidateCmd, configInitCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,81 @@
+package cmd
+
+// This file defines the `index` command which indexes a source repository
+// into a project's knowledge base.
+
+import (
+	"codex/src/knowledge"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// indexOpts holds the flags of `codex index`.
+var indexOpts struct {
+	name       string
+	chunkSize  int
+	overlap    int
+	embeddings string
+	watch      time.Duration
+}
+
+// indexCmd walks a repository, skipping files matched by its .gitignore
+// files, and stores its source split along function boundaries. Running it
+// again only re-reads files whose modification time changed and removes
+// files that were deleted. With --watch it keeps re-indexing.
+var indexCmd = &cobra.Command{
+	Use:   "index [project] [dir]",
+	Short: "Index a source repository into a project's knowledge base",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		emb, err := knowledge.EmbedderByName(indexOpts.embeddings)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		ix := &knowledge.RepoIndexer{
+			Ingester: knowledge.Ingester{Embedder: emb, ChunkSize: indexOpts.chunkSize, Overlap: indexOpts.overlap},
+			Name:     indexOpts.name,
+			Report: func(r knowledge.Result) {
+				switch {
+				case r.Removed:
+					fmt.Fprintf(out, "removed   %s\n", r.Source)
+				case r.IsUnsupported():
+					// binary files are common in repositories
+				case r.Err != nil:
+					fmt.Fprintf(out, "skipped   %s: %v\n", r.Source, r.Err)
+				default:
+					fmt.Fprintf(out, "indexed   %s (%d chunks)\n", r.Source, r.Chunks)
+				}
+			},
+		}
+		for {
+			st, err := ix.Index(cmd.Context(), args[0], args[1])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%d files: %d indexed (%d chunks), %d unchanged, %d removed, %d skipped\n",
+				st.Files, st.Indexed, st.Chunks, st.Unchanged, st.Removed, st.Skipped)
+			if indexOpts.watch <= 0 {
+				return nil
+			}
+			select {
+			case <-cmd.Context().Done():
+				return nil
+			case <-time.After(indexOpts.watch):
+			}
+		}
+	},
+}
+
+// init registers the command with the root command.
+func init() {
+	f := indexCmd.Flags()
+	f.StringVar(&indexOpts.name, "name", "", "prefix for the indexed file names (default: the directory name)")
+	f.IntVar(&indexOpts.chunkSize, "chunk-size", knowledge.DefaultChunkSize, "largest chunk in characters; longer functions are split")
+	f.IntVar(&indexOpts.overlap, "overlap", knowledge.DefaultOverlap, "characters shared by the parts of a split function")
+	f.StringVar(&indexOpts.embeddings, "embeddings", "hash", "embedder: hash (built in) or llama (model server /embedding)")
+	f.DurationVar(&indexOpts.watch, "watch", 0, "re-index at this interval instead of exiting")
+	rootCmd.AddCommand(indexCmd)
+}
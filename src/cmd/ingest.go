@@ -0,0 +1,63 @@
+package cmd
+
+// This file defines the `ingest` command which loads documents into a
+// project's knowledge base so chat can answer questions about them.
+
+import (
+	"codex/src/knowledge"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// ingestOpts holds the chunking and embedding flags of `codex ingest`.
+var ingestOpts struct {
+	chunkSize  int
+	overlap    int
+	embeddings string
+}
+
+// ingestCmd reads text, Markdown, source files and PDFs, chunks them and
+// stores the chunks with embeddings under the given project. Directories are
+// walked recursively; unchanged files are skipped.
+var ingestCmd = &cobra.Command{
+	Use:   "ingest [project] [paths...]",
+	Short: "Add documents to a project's knowledge base",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		emb, err := knowledge.EmbedderByName(ingestOpts.embeddings)
+		if err != nil {
+			return err
+		}
+		in := &knowledge.Ingester{Embedder: emb, ChunkSize: ingestOpts.chunkSize, Overlap: ingestOpts.overlap}
+		out := cmd.OutOrStdout()
+		var added, unchanged, skipped int
+		err = in.IngestPaths(cmd.Context(), args[0], args[1:], func(r knowledge.Result) {
+			switch {
+			case r.Err != nil:
+				skipped++
+				fmt.Fprintf(out, "skipped   %s: %v\n", r.Source, r.Err)
+			case r.Unchanged:
+				unchanged++
+				fmt.Fprintf(out, "unchanged %s\n", r.Source)
+			default:
+				added++
+				fmt.Fprintf(out, "ingested  %s (%s, %d chunks)\n", r.Source, r.Kind, r.Chunks)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d ingested, %d unchanged, %d skipped\n", added, unchanged, skipped)
+		return nil
+	},
+}
+
+// init registers the command with the root command.
+func init() {
+	f := ingestCmd.Flags()
+	f.IntVar(&ingestOpts.chunkSize, "chunk-size", knowledge.DefaultChunkSize, "target chunk size in characters")
+	f.IntVar(&ingestOpts.overlap, "overlap", knowledge.DefaultOverlap, "characters shared by consecutive chunks")
+	f.StringVar(&ingestOpts.embeddings, "embeddings", "hash", "embedder: hash (built in) or llama (model server /embedding)")
+	rootCmd.AddCommand(ingestCmd)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+// This file defines the `keys` command group for managing API keys used by
+// scripts and editor plugins.
+
+import (
+	"codex/src/auth"
+	"codex/src/memory"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// keysOpts holds the flags of `keys create`.
+var keysOpts struct {
+	name    string
+	scopes  string
+	expires string
+}
+
+// keysCmd groups the API key subcommands.
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage API keys",
+}
+
+// keysCreateCmd issues a key for a user and prints it once.
+var keysCreateCmd = &cobra.Command{
+	Use:   "create [username]",
+	Short: "Create an API key for a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		scopes, err := auth.ParseScopes(keysOpts.scopes)
+		if err != nil {
+			return err
+		}
+		var expires time.Time
+		if keysOpts.expires != "" {
+			d, err := parseDays(keysOpts.expires)
+			if err != nil {
+				return err
+			}
+			expires = time.Now().Add(d)
+		}
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		u, err := memory.GetUserByName(db, args[0])
+		if err != nil {
+			return err
+		}
+		key, k, err := auth.CreateKey(db, u, keysOpts.name, scopes, expires)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintln(out, key)
+		fmt.Fprintf(cmd.ErrOrStderr(), "Created key %s for %s with scopes %s. Store it now; it cannot be shown again.\n",
+			k.Prefix, u.Username, strings.Join(k.Scopes, ","))
+		return nil
+	},
+}
+
+// keysListCmd shows keys without their secrets.
+var keysListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "List API keys, optionally of one user",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		userID := 0
+		if len(args) == 1 {
+			u, err := memory.GetUserByName(db, args[0])
+			if err != nil {
+				return err
+			}
+			userID = u.ID
+		}
+		keys, err := memory.ListAPIKeys(db, userID)
+		if err != nil {
+			return err
+		}
+		names := map[int]string{}
+		users, err := memory.ListUsers(db)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			names[u.ID] = u.Username
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PREFIX\tUSER\tNAME\tSCOPES\tEXPIRES\tLAST USED\tSTATUS")
+		now := time.Now()
+		for _, k := range keys {
+			status := "active"
+			switch {
+			case k.RevokedAt != nil:
+				status = "revoked"
+			case k.ExpiresAt != nil && now.After(*k.ExpiresAt):
+				status = "expired"
+			}
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s\n", auth.KeyPrefix, k.Prefix, names[k.UserID], k.Name,
+				strings.Join(k.Scopes, ","), formatTime(orZero(k.ExpiresAt), "never"), formatTime(orZero(k.LastUsed), "never"), status)
+		}
+		return tw.Flush()
+	},
+}
+
+// keysRevokeCmd disables a key. It accepts the prefix as listed, with or
+// without "cdx_", or the full key.
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke [prefix]",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix := auth.KeyPrefixOf(args[0])
+		if prefix == "" {
+			prefix = strings.TrimPrefix(args[0], auth.KeyPrefix)
+		}
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := memory.RevokeAPIKey(db, prefix, time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Revoked key %s%s.\n", auth.KeyPrefix, prefix)
+		return nil
+	},
+}
+
+// parseDays parses a duration that may also be given in days, e.g. "90d".
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// formatTime renders t for tables, or zero when it is unset.
+func formatTime(t time.Time, zero string) string {
+	if t.IsZero() {
+		return zero
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// orZero returns *t, or the zero time when t is nil.
+func orZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// init registers the keys commands with the root command.
+func init() {
+	f := keysCreateCmd.Flags()
+	f.StringVar(&keysOpts.name, "name", "", "label shown in listings, e.g. the machine or plugin using the key")
+	f.StringVar(&keysOpts.scopes, "scopes", strings.Join(auth.DefaultScopes, ","), "comma-separated scopes: "+strings.Join(auth.KnownScopes, ", "))
+	f.StringVar(&keysOpts.expires, "expires", "", "lifetime such as 90d or 12h (default: never expires)")
+	keysCmd.AddCommand(keysCreateCmd, keysListCmd, keysRevokeCmd)
+	rootCmd.AddCommand(keysCmd)
+}
@@ -6,11 +6,13 @@ package cmd
 // management.
 
 import (
-	"bufio"
+	"codex/src/llama"
+	"codex/src/memory"
 	"codex/src/models"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"net/http"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -18,9 +20,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// pipelineTypes lists the available model categories that the user can browse
-// when interacting with Hugging Face.  The selection is presented by the `list`
-// subcommand.
+// pipelineTypes lists the common model categories that can be passed to
+// `models list --pipeline`. Any Hugging Face pipeline tag is accepted; this
+// list is only used for the flag help text.
 var pipelineTypes = []string{"text-generation", "text2text-generation", "text-classification", "code", "conversational"}
 
 // modelsCmd is the top-level command under which all model related operations
@@ -31,49 +33,86 @@ var modelsCmd = &cobra.Command{
 	Short: "Manage Hugging Face models",
 }
 
-// listCmd prompts the user to choose a model pipeline and prints a table of
-// available models.  The star marker denotes models that have already been
-// downloaded locally.
+// listOpts collects the `models list` flags. They map directly onto the
+// Hugging Face query parameters via models.ListOptions.
+var listOpts models.ListOptions
+
+// listOutput selects the output format of `models list`: "table" or "json".
+var listOutput string
+
+// listCmd prints models matching the given filters. It never prompts so it
+// can be used from scripts. In table output the star marker denotes models
+// that have already been downloaded locally; JSON output adds a
+// "downloaded" field instead.
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List models for a pipeline type",
+	Short: "List Hugging Face models",
+	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Println("Select model type:")
-		for i, t := range pipelineTypes {
-			fmt.Printf("%d) %s\n", i+1, t)
-		}
-		fmt.Print("Choice: ")
-		choiceStr, _ := reader.ReadString('\n')
-		choiceStr = strings.TrimSpace(choiceStr)
-		idx := 0
-		fmt.Sscanf(choiceStr, "%d", &idx)
-		if idx < 1 || idx > len(pipelineTypes) {
-			return fmt.Errorf("invalid choice")
-		}
-		pipeline := pipelineTypes[idx-1]
-		selectedPipeline = pipeline
-		list, err := models.ListModelsByType(pipeline)
+		if listOutput != "table" && listOutput != "json" {
+			return fmt.Errorf("invalid output %q (use table or json)", listOutput)
+		}
+		selectedPipeline = listOpts.Pipeline
+		list, err := models.ListAllModels(listOpts)
+		if errors.Is(err, models.ErrOffline) {
+			if listOpts.Pipeline == "" {
+				return fmt.Errorf("--pipeline is required in offline mode")
+			}
+			list, err = cachedModelList(listOpts.Pipeline)
+			if err == nil {
+				list, err = models.FilterModels(list, listOpts)
+			}
+		}
 		if err != nil {
 			return err
 		}
 		state, _ := models.LoadState()
-		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(tw, "MODEL ID\tLAST MODIFIED\tDOWNLOADS")
+		downloaded := func(id string) bool {
+			if state == nil {
+				return false
+			}
+			_, ok := state.Models[id]
+			return ok
+		}
+
+		out := cmd.OutOrStdout()
+		if listOutput == "json" {
+			type entry struct {
+				models.ModelInfo
+				Downloaded bool `json:"downloaded"`
+			}
+			entries := make([]entry, len(list))
+			for i, m := range list {
+				entries[i] = entry{ModelInfo: m, Downloaded: downloaded(m.ID)}
+			}
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MODEL ID\tLAST MODIFIED\tDOWNLOADS\tLIKES")
 		for _, m := range list {
 			marker := ""
-			if state != nil {
-				if _, ok := state.Models[m.ID]; ok {
-					marker = "*"
-				}
+			if downloaded(m.ID) {
+				marker = "*"
 			}
-			fmt.Fprintf(tw, "%s%s\t%s\t%d\n", m.ID, marker, m.LastModified, m.Downloads)
+			fmt.Fprintf(tw, "%s%s\t%s\t%d\t%d\n", m.ID, marker, m.LastModified, m.Downloads, m.Likes)
 		}
-		tw.Flush()
-		return nil
+		return tw.Flush()
 	},
 }
 
+// cachedModelList returns the models stored in the model_cache table for the
+// pipeline. It is used in offline mode instead of querying Hugging Face.
+func cachedModelList(pipeline string) ([]models.ModelInfo, error) {
+	db, err := memory.InitDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return memory.GetModelList(db, pipeline)
+}
+
 // downloadCmd retrieves one or more model files from Hugging Face and updates
 // the local state file.  The --all flag downloads every model from the most
 // recently selected pipeline.
@@ -113,15 +152,14 @@ var downloadCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			state.Models[id] = &models.LocalModel{
-				ID:         id,
-				Path:       filepath.Join("models", id),
-				Version:    sha,
-				Downloaded: time.Now(),
+			lm, err := models.RecordDownload(id, sha)
+			if err != nil {
+				return err
 			}
 			fmt.Println("Downloaded", id)
+			printGGUFInfo(lm.GGUF)
 		}
-		return models.SaveState(state)
+		return nil
 	},
 }
 
@@ -159,8 +197,17 @@ var useCmd = &cobra.Command{
 	},
 }
 
+// statusServer is the address of a running `codex serve` instance queried for
+// the state of the managed llama-server process.
+var statusServer string
+
+// statusLogs is the number of llama-server log lines printed by `status`.
+var statusLogs int
+
 // statusCmd prints details about whichever model is currently active.  It is
 // useful for debugging which files the assistant will use for generation.
+// When a Codex server is reachable the state of its llama-server process is
+// shown as well.
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show active model info",
@@ -169,6 +216,7 @@ var statusCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer printBackendStatus()
 		if state.Active == "" {
 			fmt.Println("No active model set")
 			return nil
@@ -179,6 +227,104 @@ var statusCmd = &cobra.Command{
 		fmt.Println("Downloaded:", m.Downloaded.Format(time.RFC3339))
 		fmt.Println("Version:", m.Version)
 		fmt.Println("Type:", m.Type)
+		if m.File != "" {
+			fmt.Println("File:", m.File)
+		}
+		printGGUFInfo(m.GGUF)
+		return nil
+	},
+}
+
+// printBackendStatus queries the server for the llama-server process state and
+// prints it. An unreachable server is reported rather than treated as an
+// error since `status` is often run while the server is down.
+func printBackendStatus() {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(strings.TrimRight(statusServer, "/") + "/api/backend/status")
+	if err != nil {
+		fmt.Println("Backend: server not reachable at", statusServer)
+		return
+	}
+	defer resp.Body.Close()
+	var st llama.SupervisorStatus
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&st) != nil {
+		fmt.Println("Backend: unexpected response", resp.Status)
+		return
+	}
+	if !st.Managed {
+		fmt.Println("Backend: external (not managed by Codex)")
+		return
+	}
+	fmt.Println("Backend:", st.State)
+	if st.Model != "" {
+		fmt.Println("Backend model:", st.Model)
+	}
+	if st.PID != 0 {
+		fmt.Printf("PID: %d (up %s)\n", st.PID, time.Since(st.StartedAt).Round(time.Second))
+	}
+	fmt.Println("Restarts:", st.Restarts)
+	if st.LastExit != "" {
+		fmt.Println("Last exit:", st.LastExit)
+	}
+	logs := st.Logs
+	if len(logs) > statusLogs {
+		logs = logs[len(logs)-statusLogs:]
+	}
+	for _, l := range logs {
+		fmt.Println("  |", l)
+	}
+}
+
+// printGGUFInfo writes the model properties read from a GGUF header. Nothing
+// is printed for models without GGUF information.
+func printGGUFInfo(info *models.GGUFInfo) {
+	if info == nil {
+		return
+	}
+	fmt.Println("Architecture:", info.Architecture)
+	if info.ParameterCount > 0 {
+		fmt.Printf("Parameters: %.2fB\n", float64(info.ParameterCount)/1e9)
+	}
+	if info.Quantization != "" {
+		fmt.Println("Quantization:", info.Quantization)
+	}
+	if info.ContextLength > 0 {
+		fmt.Println("Context length:", info.ContextLength)
+	}
+	if info.BlockCount > 0 {
+		fmt.Printf("Layers: %d, heads: %d (kv %d), embedding: %d\n",
+			info.BlockCount, info.HeadCount, info.HeadCountKV, info.EmbeddingLength)
+	}
+}
+
+// importID overrides the model ID derived from the file name when importing.
+var importID string
+
+// importLink and importCopy choose how `models import` places the files under
+// the models directory. With neither flag the files are registered in place.
+var importLink, importCopy bool
+
+// importCmd registers a GGUF file or directory that already exists on disk as
+// a local model so it can be activated without downloading anything.
+var importCmd = &cobra.Command{
+	Use:   "import [path]",
+	Short: "Register a local GGUF file or directory as a model",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode := models.ImportInPlace
+		if importLink {
+			mode = models.ImportLink
+		} else if importCopy {
+			mode = models.ImportCopy
+		}
+		lm, err := models.ImportModel(args[0], models.ImportOptions{ID: importID, Mode: mode})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Imported", lm.ID)
+		fmt.Println("File:", lm.File)
+		fmt.Println("Version:", lm.Version)
+		printGGUFInfo(lm.GGUF)
 		return nil
 	},
 }
@@ -192,7 +338,24 @@ func init() {
 	modelsCmd.AddCommand(downloadCmd)
 	modelsCmd.AddCommand(useCmd)
 	modelsCmd.AddCommand(statusCmd)
+	modelsCmd.AddCommand(importCmd)
+
+	listCmd.Flags().StringVar(&listOpts.Pipeline, "pipeline", "", "pipeline tag, e.g. "+strings.Join(pipelineTypes, ", "))
+	listCmd.Flags().StringVar(&listOpts.Search, "search", "", "search model IDs")
+	listCmd.Flags().StringVar(&listOpts.Author, "author", "", "only models from this user or organisation")
+	listCmd.Flags().StringVar(&listOpts.Sort, "sort", "downloads", "sort by downloads, likes or modified")
+	listCmd.Flags().IntVar(&listOpts.Limit, "limit", 50, "maximum number of models (0 for all)")
+	listCmd.Flags().BoolVar(&listOpts.GGUFOnly, "gguf-only", false, "only models that ship GGUF files")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "output format: table or json")
 
 	downloadCmd.Flags().BoolVar(&downloadAll, "all", false, "download all models from list")
 	downloadCmd.Flags().BoolVar(&forceDownload, "force", false, "force re-download")
+
+	statusCmd.Flags().StringVar(&statusServer, "server", "http://localhost:8081", "Codex server to query for backend status")
+	statusCmd.Flags().IntVar(&statusLogs, "logs", 10, "number of llama-server log lines to show")
+
+	importCmd.Flags().StringVar(&importID, "id", "", "model ID (defaults to the file name)")
+	importCmd.Flags().BoolVar(&importLink, "link", false, "symlink the files into the models directory")
+	importCmd.Flags().BoolVar(&importCopy, "copy", false, "copy the files into the models directory")
+	importCmd.MarkFlagsMutuallyExclusive("link", "copy")
 }
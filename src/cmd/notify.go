@@ -0,0 +1,59 @@
+package cmd
+
+// This file defines the `notify` command group for checking the email
+// notification setup.
+
+import (
+	"codex/src/notify"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// notifyCmd groups the notification subcommands.
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Check email notifications",
+}
+
+// notifyTestCmd sends a test message straight away, without the retry
+// queue, so configuration errors are reported on the terminal.
+var notifyTestCmd = &cobra.Command{
+	Use:   "test [address...]",
+	Short: "Send a test email to the given addresses or the admins",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sc := settings.SMTP
+		n := notify.FromSettings(sc.Addr, sc.From, sc.Username, sc.Password)
+		if n == nil {
+			return errors.New("smtp.addr is not set in codex.yaml or SMTP_ADDR")
+		}
+		to := args
+		if len(to) == 0 {
+			var err error
+			if to, err = n.Recipients(); err != nil {
+				return err
+			}
+			if len(to) == 0 {
+				return fmt.Errorf("%w; pass an address or run codex users email", notify.ErrNoRecipients)
+			}
+		}
+		subject, body, err := notify.Render(notify.EventTest, notify.Test{Addr: n.Addr, Time: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := n.Send(notify.Message{To: to, Subject: subject, Body: body}); err != nil {
+			return fmt.Errorf("sending through %s: %w", n.Addr, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Sent a test message to %s.\n", strings.Join(to, ", "))
+		return nil
+	},
+}
+
+// init registers the notify commands with the root command.
+func init() {
+	notifyCmd.AddCommand(notifyTestCmd)
+	rootCmd.AddCommand(notifyCmd)
+}
@@ -8,6 +8,9 @@ package cmd
 // command is built using cobra and attached to the rootCmd defined below.
 
 import (
+	"codex/src/config"
+	"codex/src/models"
+
 	"github.com/spf13/cobra"
 )
 
@@ -21,9 +24,30 @@ var rootCmd = &cobra.Command{
 	Short: "Codex AI Assistant CLI",
 }
 
+// offline forces the models package into offline mode for this invocation
+// in addition to the HF_HUB_OFFLINE environment variable.
+var offline bool
+
 // Execute is called by main and triggers cobra's command parsing. It will run
 // the appropriate subcommand based on os.Args. This is considered an entry
 // point for any CLI interaction with Codex.
 func Execute() error {
 	return rootCmd.Execute()
 }
+
+// init registers flags shared by every subcommand. Persistent flags and the
+// configuration file are applied before the selected command runs.
+func init() {
+	pf := rootCmd.PersistentFlags()
+	pf.BoolVar(&offline, "offline", false, "never contact Hugging Face; serve model data from the local cache")
+	pf.StringVar(&configPath, "config", "", "configuration file (default $CODEX_CONFIG or ./codex.yaml when present)")
+	pf.String("data-dir", config.Default().Server.DataDir, "directory holding memory.db and downloaded models")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if offline {
+			cfg := models.CurrentClientConfig()
+			cfg.Offline = true
+			models.Configure(cfg)
+		}
+		return loadSettings(cmd)
+	}
+}
@@ -10,38 +10,185 @@ package cmd
 // for chat and project management.
 
 import (
+	"codex/src/auth"
+	"codex/src/config"
 	handlers2 "codex/src/handlers"
+	"codex/src/health"
+	"codex/src/knowledge"
+	"codex/src/llama"
+	"codex/src/memory"
+	"codex/src/models"
+	"codex/src/notify"
+	"codex/src/queue"
+	"codex/src/summary"
+	"codex/src/tools"
+	"codex/src/webhook"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-// serveCmd wires up an HTTP router and listens on port 8081. The routes are
-// implemented in the handlers package and allow the AI to be accessed through
-// REST style requests.
-// Extension Point: modify this command to serve additional endpoints or adjust
-// server configuration such as port and TLS settings.
+// serveCmd wires up an HTTP router and listens on server.listen, port 8081
+// by default. The routes are implemented in the handlers package and allow
+// the AI to be accessed through REST style requests.
+// Extension Point: modify this command to serve additional endpoints; server
+// settings belong in the config package.
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the Codex web server",
 	Run: func(cmd *cobra.Command, args []string) {
+		// Routes live on the server's own mux rather than
+		// http.DefaultServeMux so nothing else can register on it.
+		mux := http.NewServeMux()
 		// All API endpoints are now grouped under the /api prefix so the
 		// root path only serves the client UI.
-		http.HandleFunc("/api/chat", handlers2.ChatHandler)
-		http.HandleFunc("/api/projects", handlers2.ProjectsHandler)
-		http.HandleFunc("/api/projects/switch", handlers2.SwitchProjectHandler)
-		http.HandleFunc("/api/projects/rename", handlers2.RenameProjectHandler)
-		http.HandleFunc("/api/projects/", handlers2.DeleteProjectHandler)
-		http.HandleFunc("/api/models", handlers2.ModelsHandler)
-		http.HandleFunc("/api/models/", handlers2.ModelActionHandler)
-		http.HandleFunc("/api/models/refresh", handlers2.RefreshModelsHandler)
-
-		// Serve the web UI. Prefer the built client under /client when
-		// running in Docker, but fall back to the source directory for
-		// local development.
+		// Routes are wrapped with the least role they require; the
+		// model and project item handlers check further per action.
+		mux.HandleFunc("/api/chat", auth.Require(auth.RoleMember, auth.ScopeChat, handlers2.ChatHandler))
+		mux.HandleFunc("/api/projects", auth.RequireWrite(auth.RoleViewer, auth.RoleMember, auth.ScopeProjects, handlers2.ProjectsHandler))
+		mux.HandleFunc("/api/projects/switch", auth.Require(auth.RoleMember, auth.ScopeProjects, handlers2.SwitchProjectHandler))
+		mux.HandleFunc("/api/projects/rename", auth.Require(auth.RoleMember, auth.ScopeProjects, handlers2.RenameProjectHandler))
+		mux.HandleFunc("/api/projects/", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.ProjectItemHandler))
+		mux.HandleFunc("/api/models", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.ModelsHandler))
+		mux.HandleFunc("/api/models/", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.ModelActionHandler))
+		mux.HandleFunc("/api/models/refresh", auth.Require(auth.RoleAdmin, auth.ScopeModelsAdmin, handlers2.RefreshModelsHandler))
+		mux.HandleFunc("/api/models/import", auth.Require(auth.RoleAdmin, auth.ScopeModelsAdmin, handlers2.ImportModelHandler))
+		mux.HandleFunc("/api/backend/status", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.BackendStatusHandler))
+		mux.HandleFunc("/api/queue", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.QueueStatsHandler))
+		mux.HandleFunc("/api/tools", auth.Require(auth.RoleViewer, auth.ScopeRead, handlers2.ToolsHandler))
+		mux.HandleFunc("/api/webhooks", auth.Require(auth.RoleAdmin, auth.ScopeAdmin, handlers2.WebhooksHandler))
+		mux.HandleFunc("/api/webhooks/", auth.Require(auth.RoleAdmin, auth.ScopeAdmin, handlers2.WebhookItemHandler))
+		mux.HandleFunc("/api/auth/login", handlers2.LoginHandler)
+		mux.HandleFunc("/api/auth/logout", handlers2.LogoutHandler)
+		mux.HandleFunc("/api/auth/me", handlers2.MeHandler)
+		mux.HandleFunc("/healthz", handlers2.HealthzHandler)
+		mux.HandleFunc("/readyz", handlers2.ReadyzHandler)
+		mux.HandleFunc("/metrics", auth.Require(auth.RoleViewer, auth.ScopeMetrics, handlers2.MetricsHandler))
+
+		// Resolve the session cookie to the current user on every
+		// request and count and time it by route for /metrics. The
+		// lifecycle starts the background jobs below and stops them
+		// again on shutdown.
+		server := newLifecycle(settings.Server, handlers2.Instrument(mux, auth.Middleware(mux)))
+		scheme := "http"
+		if settings.Server.TLS() {
+			certFile, keyFile, err := tlsFiles(settings.Server)
+			if err != nil {
+				log.Fatalf("tls: %v", err)
+			}
+			// the read_file tool refuses the key anyway, but a key in a
+			// directory shared with the assistant is one mistake away
+			if toolsRoot != "" && insideDir(toolsRoot, keyFile) {
+				log.Fatalf("tls: key %s must not be inside --tools-root %s", keyFile, toolsRoot)
+			}
+			server.UseTLS(certFile, keyFile, settings.Server.HTTPRedirect)
+			scheme = "https"
+		}
+
+		// Launch llama-server ourselves for a managed backend. Otherwise
+		// the backend is expected to be running already.
+		be := settings.Backend
+		llamaServer.Binary, llamaServer.Host, llamaServer.Port = be.Binary, be.Host, be.Port
+		llamaServer.CtxSize, llamaServer.Threads, llamaServer.BatchSize = be.CtxSize, be.Threads, be.BatchSize
+		managed := be.Type == config.BackendManaged
+		if managed {
+			sup := llama.NewSupervisor(llamaServer)
+			var id, file string
+			if lm, err := models.ActiveModel(); err != nil {
+				log.Printf("active model lookup error: %v", err)
+			} else if lm != nil {
+				id, file = lm.ID, lm.File
+			}
+			if err := sup.Start(context.Background(), id, file); err != nil {
+				log.Fatalf("llama-server supervisor: %v", err)
+			}
+			llama.SetSupervisor(sup)
+			server.AtShutdown(sup.Stop)
+			log.Printf("Managing llama-server %s on port %d", llamaServer.Binary, llamaServer.Port)
+		}
+
+		// loadSettings pointed the llama client at the backend; a managed
+		// server is reached on the host and port it was launched with.
+		log.Printf("Model backend %s (%s), data in %s", llama.DefaultClient().BaseURL, be.Type, settings.Server.DataDir)
+
+		// Embed ingested documents and retrieval queries with the
+		// selected embedder.
+		emb, err := knowledge.EmbedderByName(embeddings)
+		if err != nil {
+			log.Fatalf("embeddings: %v", err)
+		}
+		knowledge.SetEmbedder(emb)
+
+		// Confine the assistant's read_file tool to the workspace,
+		// away from the database, models, TLS key and config file.
+		// Without --tools-root the tool is disabled.
+		tools.SetFileRoot(toolsRoot, settings.Server.DataDir, config.Path(configPath), settings.Server.TLSKey)
+		if toolsRoot != "" {
+			log.Printf("read_file tool reads from %s", toolsRoot)
+		}
+
+		// Let the model rate new memories when requested; the
+		// heuristic scorer is used otherwise and as fallback. Ratings
+		// run after the chat has answered and wait in the queue.
+		if llmImportance {
+			memory.SetScorer(memory.LLMScorer{Complete: queue.Queued(queueUserImportance, llama.SendPromptContext)})
+		}
+
+		// Condense old conversation into summary memories in the
+		// background, taking turns with chats in the queue below.
+		if summaryInterval > 0 {
+			cfg := summary.DefaultConfig()
+			cfg.Interval = summaryInterval
+			server.Go(summary.New(cfg, summary.CompleteFunc(queue.Queued(queueUserSummary, llama.SendPromptContext))).Run)
+		}
+
+		// Hold chat requests in a fair queue so no more than the
+		// backend's slot count run at once.
+		queue.SetDefault(queue.New(chatQueue.maxInFlight, chatQueue.maxQueue))
+		log.Printf("Chat queue: %d in flight, %d queued", chatQueue.maxInFlight, chatQueue.maxQueue)
+
+		// Email admins about downloads, backend outages and logins from
+		// new devices when an SMTP server is configured.
+		smtpCfg := settings.SMTP
+		if n := notify.FromSettings(smtpCfg.Addr, smtpCfg.From, smtpCfg.Username, smtpCfg.Password); n != nil {
+			notify.SetDefault(n)
+			server.Go(n.Run)
+			server.AtShutdown(func() {
+				// give the mail server as long as requests got to finish
+				ctx, cancel := context.WithTimeout(context.Background(), settings.Server.ShutdownTimeout)
+				defer cancel()
+				n.Flush(ctx)
+			})
+			log.Printf("Email notifications via %s from %s", n.Addr, n.From)
+		}
+
+		// Post events to registered webhooks, resuming deliveries left
+		// pending by a previous run.
+		dispatcher := webhook.NewDispatcher()
+		webhook.SetDefault(dispatcher)
+		memory.SetEntryHook(webhook.MemoryHook)
+		server.Go(dispatcher.Run)
+
+		// Probe the backend, database and model state in the background
+		// so chat requests can fail fast when the model is unavailable.
+		monitor := health.NewMonitor(healthInterval)
+		health.SetDefault(monitor)
+		server.Go(monitor.Run)
+
+		// Serve the web UI from server.ui_dir. Without one prefer the
+		// built client under /client when running in Docker, but fall
+		// back to the source directory for local development.
 		uiDirs := []string{"/client", "src/client"}
+		if settings.Server.UIDir != "" {
+			uiDirs = []string{settings.Server.UIDir}
+		}
 		var uiDir string
 		for _, d := range uiDirs {
 			if _, err := os.Stat(d); err == nil {
@@ -51,22 +198,110 @@ var serveCmd = &cobra.Command{
 		}
 		if uiDir == "" {
 			log.Printf("UI directory not found, root path disabled")
-			http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 				http.NotFound(w, r)
 			})
 		} else {
 			log.Printf("Serving UI from %s", uiDir)
 			fs := http.FileServer(http.Dir(uiDir))
-			http.Handle("/", fs)
+			mux.Handle("/", fs)
 		}
 
-		log.Println("Codex API running on http://localhost:8081")
-		log.Fatal(http.ListenAndServe(":8081", nil))
+		// Shut down gracefully on Ctrl-C and on SIGTERM from `docker
+		// stop`. A second signal kills the process immediately.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		context.AfterFunc(ctx, stop)
+		log.Printf("Codex API running on %s://%s", scheme, displayAddr(settings.Server.Listen))
+		if settings.Server.HTTPRedirect != "" {
+			log.Printf("Redirecting http://%s to HTTPS", displayAddr(settings.Server.HTTPRedirect))
+		}
+		if err := server.Serve(ctx); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
 	},
 }
 
+// llamaServer holds the settings used to launch a managed llama-server
+// process. The binary, address and sizes come from the backend section of
+// the configuration; the flags below only set the rest.
+var llamaServer = llama.DefaultServerConfig()
+
+// displayAddr turns a listen address such as ":8081" into one a browser
+// can open.
+func displayAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Queue users of background completions, so they are served round-robin
+// with the people chatting.
+const (
+	queueUserSummary    = "system:summary"
+	queueUserImportance = "system:importance"
+)
+
+// chatQueue holds the --max-inflight and --max-queue limits for the chat
+// request queue.
+var chatQueue struct {
+	maxInFlight int
+	maxQueue    int
+}
+
+// llmImportance enables model-based importance scoring of new memories.
+var llmImportance bool
+
+// summaryInterval is how often old memories are summarised; zero disables
+// the summariser.
+var summaryInterval time.Duration
+
+// embeddings selects the embedder used for the knowledge base.
+var embeddings string
+
+// toolsRoot is the directory the read_file tool may read from; empty
+// disables the tool.
+var toolsRoot string
+
+// healthInterval controls how often the health monitor probes dependencies.
+var healthInterval time.Duration
+
 // init registers the serve command with the rootCmd so users can start the
 // HTTP API via `codex serve`.
 func init() {
+	f := serveCmd.Flags()
+	f.IntVar(&chatQueue.maxInFlight, "max-inflight", 1, "concurrent chat requests sent to the model backend; match llama-server --parallel")
+	f.IntVar(&chatQueue.maxQueue, "max-queue", 32, "chat requests allowed to wait for the backend before answering 429")
+	f.BoolVar(&llmImportance, "llm-importance", false, "ask the model to rate the importance of new memories")
+	f.DurationVar(&summaryInterval, "summary-interval", summary.DefaultConfig().Interval, "how often old memories are summarised (0 disables)")
+	f.StringVar(&embeddings, "embeddings", "hash", "embedder for the knowledge base: hash (built in) or llama (model server /embedding)")
+	f.StringVar(&toolsRoot, "tools-root", "", "directory the assistant's read_file tool is confined to (default: tool disabled)")
+	f.DurationVar(&healthInterval, "health-interval", 5*time.Second, "interval between backend health probes")
+	// These flags override settings of the configuration file; see
+	// flagKeys. Their values are read from settings, not bound here.
+	def := config.Default()
+	f.String("listen", def.Server.Listen, "address the HTTP server listens on")
+	f.String("tls-cert", "", "PEM certificate to serve HTTPS with (needs --tls-key)")
+	f.String("tls-key", "", "PEM private key of --tls-cert")
+	f.Bool("tls-self-signed", false, "serve HTTPS with a self-signed certificate kept in the data directory")
+	f.String("http-redirect", "", "address redirecting plain HTTP to HTTPS, such as :80")
+	f.Duration("hsts-max-age", 0, "Strict-Transport-Security max-age sent over HTTPS (0 disables)")
+	f.String("ui-dir", "", "built web client to serve (default /client or src/client)")
+	f.String("llama-url", "", "base URL of the llama.cpp server (default http://localhost:8080 or the managed server)")
+	f.Duration("llama-timeout", def.Backend.Timeout, "timeout for a single request to the model backend")
+	f.Int("llama-retries", def.Backend.Retries, "retries for transient model backend failures")
+	f.String("llama-server", "", "path to llama-server; when set Codex launches and supervises it")
+	f.String("llama-host", def.Backend.Host, "host the managed llama-server listens on")
+	f.Int("llama-port", def.Backend.Port, "port the managed llama-server listens on")
+	f.Int("ctx-size", 0, "context size passed to llama-server")
+	f.Int("threads", 0, "CPU threads passed to llama-server")
+	f.Int("batch-size", 0, "batch size passed to llama-server")
+	f.StringArrayVar(&llamaServer.ExtraArgs, "llama-arg", nil, "extra llama-server argument (repeatable)")
+	f.StringVar(&llamaServer.LogPath, "llama-log", llamaServer.LogPath, "file receiving llama-server output")
 	rootCmd.AddCommand(serveCmd)
 }
@@ -0,0 +1,167 @@
+package cmd
+
+// Lifecycle of `codex serve`. The HTTP server and the background jobs it
+// starts share one shutdown sequence so SIGTERM from Docker or Ctrl-C no
+// longer cuts chats and downloads off mid-write:
+//
+//  1. stop accepting connections, including the HTTP to HTTPS redirect,
+//     and let requests in flight finish, for at
+//     most server.shutdown_timeout
+//  2. cancel the requests still running, which aborts their backend calls
+//     and downloads cleanly
+//  3. cancel the background jobs (summariser, health monitor, notifier,
+//     webhook dispatcher) and wait for them
+//  4. run the registered flush steps, such as stopping llama-server and
+//     sending queued email, then close the database
+
+import (
+	"codex/src/config"
+	handlers2 "codex/src/handlers"
+	"codex/src/memory"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// abortGrace is how long aborted requests get to return before their
+// connections are closed.
+const abortGrace = 5 * time.Second
+
+// lifecycle owns the HTTP server and the background work stopped with it.
+type lifecycle struct {
+	srv     *http.Server
+	timeout time.Duration
+	// certFile and keyFile switch srv to HTTPS; redirect, when set,
+	// answers plain HTTP with a redirect to it.
+	certFile, keyFile string
+	redirect          *http.Server
+
+	// requests is the base context of every request; cancelling it aborts
+	// the requests still running after the drain timeout.
+	requests       context.Context
+	cancelRequests context.CancelFunc
+	// jobs is the context of the background jobs.
+	jobs     context.Context
+	stopJobs context.CancelFunc
+	running  sync.WaitGroup
+	flush    []func()
+}
+
+// newLifecycle builds an http.Server for h from the server settings. Read
+// and idle timeouts protect against slow or idle clients; the write timeout
+// is lifted by handlers that stream, such as chats and model downloads.
+// Responses over HTTPS carry the configured HSTS header.
+func newLifecycle(cfg config.Server, h http.Handler) *lifecycle {
+	l := &lifecycle{timeout: cfg.ShutdownTimeout}
+	l.requests, l.cancelRequests = context.WithCancel(context.Background())
+	l.jobs, l.stopJobs = context.WithCancel(context.Background())
+	l.srv = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           withHSTS(cfg, h),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return l.requests },
+	}
+	handlers2.SetServerContext(l.requests)
+	return l
+}
+
+// UseTLS serves HTTPS with the given certificate and key. With a redirect
+// address a second, plain HTTP server sends browsers to the HTTPS one.
+func (l *lifecycle) UseTLS(certFile, keyFile, redirect string) {
+	l.certFile, l.keyFile = certFile, keyFile
+	l.srv.TLSConfig = tlsConfig()
+	if redirect != "" {
+		l.redirect = &http.Server{
+			Addr:              redirect,
+			Handler:           redirectHandler(l.srv.Addr),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       l.srv.IdleTimeout,
+		}
+	}
+}
+
+// Go runs a background job until shutdown cancels its context.
+func (l *lifecycle) Go(job func(ctx context.Context)) {
+	l.running.Add(1)
+	go func() {
+		defer l.running.Done()
+		job(l.jobs)
+	}()
+}
+
+// AtShutdown registers a step run after requests and jobs have stopped, in
+// registration order.
+func (l *lifecycle) AtShutdown(f func()) {
+	l.flush = append(l.flush, f)
+}
+
+// Serve listens until ctx is cancelled, typically by a signal, and then
+// shuts everything down. It returns an error when the server cannot listen.
+func (l *lifecycle) Serve(ctx context.Context) error {
+	errc := make(chan error, 2)
+	go func() {
+		if l.certFile != "" {
+			errc <- l.srv.ListenAndServeTLS(l.certFile, l.keyFile)
+		} else {
+			errc <- l.srv.ListenAndServe()
+		}
+	}()
+	if l.redirect != nil {
+		go func() { errc <- l.redirect.ListenAndServe() }()
+	}
+	select {
+	case err := <-errc:
+		l.stopJobs()
+		l.cancelRequests()
+		l.srv.Close()
+		if l.redirect != nil {
+			l.redirect.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+	l.Shutdown()
+	return nil
+}
+
+// Shutdown runs the shutdown sequence described at the top of this file.
+func (l *lifecycle) Shutdown() {
+	log.Printf("Shutting down: waiting up to %s for requests in flight", l.timeout)
+	drain, cancel := context.WithTimeout(context.Background(), l.timeout)
+	if l.redirect != nil {
+		if err := l.redirect.Shutdown(drain); err != nil {
+			l.redirect.Close()
+		}
+	}
+	err := l.srv.Shutdown(drain)
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Shutdown timeout reached, aborting remaining requests")
+		l.cancelRequests()
+		grace, cancel := context.WithTimeout(context.Background(), abortGrace)
+		if err := l.srv.Shutdown(grace); err != nil {
+			l.srv.Close()
+		}
+		cancel()
+	} else if err != nil {
+		log.Printf("HTTP shutdown error: %v", err)
+	}
+	l.cancelRequests()
+
+	l.stopJobs()
+	l.running.Wait()
+	for _, f := range l.flush {
+		f()
+	}
+	if err := memory.Optimize(); err != nil {
+		log.Printf("closing database: %v", err)
+	}
+	log.Printf("Shutdown complete")
+}
@@ -0,0 +1,238 @@
+package cmd
+
+// HTTPS for `codex serve`. Session and anonymous cookies are marked Secure,
+// which browsers only keep over HTTPS, so anything but localhost needs TLS.
+// The server uses the certificate given by server.tls_cert and
+// server.tls_key, or with server.tls_self_signed one generated in the data
+// directory for use on a LAN. server.http_redirect answers plain HTTP with a
+// redirect to HTTPS and server.hsts_max_age tells browsers to stay there.
+
+import (
+	"codex/src/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// selfSignedValidity is how long a generated certificate is valid.
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedRenew is how long before expiry a generated certificate is
+	// replaced on startup.
+	selfSignedRenew = 30 * 24 * time.Hour
+)
+
+// tlsFiles returns the certificate and key files to serve HTTPS with, or
+// empty names when TLS is off. A self-signed certificate is created or
+// renewed first.
+func tlsFiles(cfg config.Server) (certFile, keyFile string, err error) {
+	if cfg.TLSSelfSigned {
+		return selfSignedCert(filepath.Join(cfg.DataDir, "tls"), certHosts(cfg.TLSHosts))
+	}
+	return cfg.TLSCert, cfg.TLSKey, nil
+}
+
+// certHosts lists the names a self-signed certificate is issued for:
+// localhost, this machine's hostname and addresses, and the comma separated
+// extra names, such as a LAN DNS name.
+func certHosts(extra string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && !ipn.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipn.IP.String())
+			}
+		}
+	}
+	for _, h := range strings.Split(extra, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
+
+// selfSignedCert returns cert.pem and key.pem in dir, generating them when
+// they are missing, expire within selfSignedRenew or do not cover hosts. The
+// certificate is a leaf that cannot sign others, so a browser told to trust
+// it trusts nothing else, and the key is only readable by the owner.
+// Certificates generated as a CA by earlier versions are replaced.
+func selfSignedCert(dir string, hosts []string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if cert, err := loadCert(certFile, keyFile); err == nil && !cert.IsCA && covers(cert, hosts) && time.Until(cert.NotAfter) > selfSignedRenew {
+		return certFile, keyFile, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("tls directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Codex"}, CommonName: "Codex self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return "", "", err
+	}
+	log.Printf("Generated self-signed certificate %s for %s, SHA-256 fingerprint %s",
+		certFile, strings.Join(hosts, ", "), fingerprint(der))
+	return certFile, keyFile, nil
+}
+
+// insideDir reports whether path is dir or lies below it, following
+// symbolic links where they exist.
+func insideDir(dir, path string) bool {
+	resolve := func(p string) string {
+		p, _ = filepath.Abs(p)
+		if r, err := filepath.EvalSymlinks(p); err == nil {
+			return r
+		}
+		return p
+	}
+	rel, err := filepath.Rel(resolve(dir), resolve(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// loadCert parses a certificate and checks that key belongs to it.
+func loadCert(certFile, keyFile string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// covers reports whether cert is valid for every host.
+func covers(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// writePEM replaces path with a single PEM block.
+func writePEM(path, kind string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: kind, Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fingerprint formats the SHA-256 hash of a certificate as browsers show it.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// withHSTS adds the Strict-Transport-Security header to responses sent over
+// HTTPS when server.hsts_max_age is set. Browsers ignore it on plain HTTP.
+// AI Awareness: HSTS is remembered by browsers for max-age; with a
+// self-signed certificate they then refuse to let users click through
+// certificate warnings, so keep it off until the certificate is trusted.
+func withHSTS(cfg config.Server, h http.Handler) http.Handler {
+	if cfg.HSTSMaxAge <= 0 {
+		return h
+	}
+	value := "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+	if cfg.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// redirectHandler sends every plain HTTP request to the same host and path
+// on the HTTPS listen address. 308 keeps the method and body of API calls.
+func redirectHandler(listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(listen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "HTTPS required", http.StatusBadRequest)
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// tlsConfig is the TLS configuration of the HTTPS server.
+func tlsConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
@@ -0,0 +1,248 @@
+package cmd
+
+// This file defines the `users` command group for managing the accounts that
+// can log in to the web UI and API.
+
+import (
+	"bufio"
+	"codex/src/auth"
+	"codex/src/memory"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// usersCmd groups the account management subcommands.
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage user accounts",
+}
+
+// usersRole and usersEmail hold the --role and --email flags of `users add`.
+var usersRole, usersEmail string
+
+// usersAddCmd creates an account. The password is read from the terminal
+// without echo, or from the first line of standard input when it is piped.
+// Without --role the first account becomes an admin and later ones members.
+var usersAddCmd = &cobra.Command{
+	Use:   "add [username]",
+	Short: "Create a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		role := auth.RoleMember
+		if usersRole != "" {
+			if role, err = auth.ParseRole(usersRole); err != nil {
+				return err
+			}
+		} else if n, err := memory.CountUsers(db); err != nil {
+			return err
+		} else if n == 0 {
+			role = auth.RoleAdmin
+		}
+		hash, err := readNewPassword(cmd)
+		if err != nil {
+			return err
+		}
+		u, err := memory.CreateUser(db, args[0], hash, string(role))
+		if err != nil {
+			return err
+		}
+		if usersEmail != "" {
+			if err := memory.SetUserEmail(db, u.ID, usersEmail); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Created %s %s.\n", u.Role, u.Username)
+		return nil
+	},
+}
+
+// usersPasswdCmd changes a password and logs the user out everywhere.
+var usersPasswdCmd = &cobra.Command{
+	Use:   "passwd [username]",
+	Short: "Change a user's password",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		u, err := memory.GetUserByName(db, args[0])
+		if err != nil {
+			return err
+		}
+		hash, err := readNewPassword(cmd)
+		if err != nil {
+			return err
+		}
+		if err := memory.SetPasswordHash(db, u.ID, hash); err != nil {
+			return err
+		}
+		if err := memory.DeleteUserSessions(db, u.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Password changed for %s; existing sessions were ended.\n", u.Username)
+		return nil
+	},
+}
+
+// usersRmCmd deletes an account and its sessions.
+var usersRmCmd = &cobra.Command{
+	Use:   "rm [username]",
+	Short: "Delete a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		u, err := memory.GetUserByName(db, args[0])
+		if err != nil {
+			return err
+		}
+		if err := memory.DeleteUser(db, u.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Deleted user %s.\n", u.Username)
+		return nil
+	},
+}
+
+// usersRoleCmd changes the role of an account.
+var usersRoleCmd = &cobra.Command{
+	Use:   "role [username] [viewer|member|admin]",
+	Short: "Change a user's role",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		role, err := auth.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		u, err := memory.GetUserByName(db, args[0])
+		if err != nil {
+			return err
+		}
+		if err := memory.SetUserRole(db, u.ID, string(role)); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is now %s.\n", u.Username, role)
+		return nil
+	},
+}
+
+// usersEmailCmd sets the address notifications are sent to. An empty
+// address removes it.
+var usersEmailCmd = &cobra.Command{
+	Use:   "email [username] [address]",
+	Short: "Set a user's email address",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if args[1] != "" && !strings.Contains(args[1], "@") {
+			return fmt.Errorf("invalid email address %q", args[1])
+		}
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		u, err := memory.GetUserByName(db, args[0])
+		if err != nil {
+			return err
+		}
+		if err := memory.SetUserEmail(db, u.ID, args[1]); err != nil {
+			return err
+		}
+		if args[1] == "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed the email address of %s.\n", u.Username)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Email of %s set to %s.\n", u.Username, args[1])
+		}
+		return nil
+	},
+}
+
+// usersListCmd prints every account.
+var usersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := memory.InitDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		users, err := memory.ListUsers(db)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tROLE\tEMAIL\tCREATED\tLAST LOGIN")
+		for _, u := range users {
+			email := u.Email
+			if email == "" {
+				email = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.Username, u.Role, email, formatTime(u.CreatedAt, ""), formatTime(u.LastLogin, "never"))
+		}
+		return tw.Flush()
+	},
+}
+
+// readNewPassword asks for a password twice on a terminal, or reads one line
+// from piped input, and returns its hash.
+func readNewPassword(cmd *cobra.Command) (string, error) {
+	var pw string
+	if f, ok := cmd.InOrStdin().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		out := cmd.ErrOrStderr()
+		fmt.Fprint(out, "Password: ")
+		first, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(out, "Repeat password: ")
+		second, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", err
+		}
+		if string(first) != string(second) {
+			return "", errors.New("passwords do not match")
+		}
+		pw = string(first)
+	} else {
+		line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		pw = strings.TrimRight(line, "\r\n")
+	}
+	return auth.HashPassword(pw)
+}
+
+// init registers the users commands with the root command.
+func init() {
+	usersAddCmd.Flags().StringVar(&usersRole, "role", "", "viewer, member or admin (default: admin for the first user, member otherwise)")
+	usersAddCmd.Flags().StringVar(&usersEmail, "email", "", "address that receives notifications")
+	usersCmd.AddCommand(usersAddCmd, usersPasswdCmd, usersRoleCmd, usersEmailCmd, usersRmCmd, usersListCmd)
+	rootCmd.AddCommand(usersCmd)
+}
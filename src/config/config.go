@@ -0,0 +1,441 @@
+package config
+
+// Package config loads the settings of `codex serve` and the CLI from a
+// codex.yaml file and the environment. Values are resolved in this order,
+// later sources winning:
+//
+//	defaults < codex.yaml < environment < command line flags
+//
+// Flags are applied by the cmd package; this package handles the rest. Every
+// key "section.name" can be overridden by the variable CODEX_SECTION_NAME,
+// e.g. CODEX_BACKEND_URL for backend.url. A few keys also honour the
+// variables used before the config file existed, such as SMTP_ADDR and
+// LLAMA_SERVER_BIN.
+//
+// Only YAML is supported. TOML was considered and left out on purpose: a
+// single format keeps `codex config init`, the line-numbered errors and the
+// documentation in one place.
+//
+// AI Awareness: the sampling section changes every completion the assistant
+// makes, so adjust it with care.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFile is the config file looked for in the working directory when
+// no path is given.
+const DefaultFile = "codex.yaml"
+
+// EnvFile names the variable that points at a config file.
+const EnvFile = "CODEX_CONFIG"
+
+// Backend types. An external backend is started by someone else and reached
+// at backend.url; a managed one is launched by Codex from backend.binary.
+const (
+	BackendExternal = "external"
+	BackendManaged  = "managed"
+)
+
+// Sources of a value reported by Config.Source.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config holds every setting read from the config file. Each field carries
+// its key, a doc string used by `codex config init` and optionally extra
+// environment variables; secret values are masked by Display.
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Backend  Backend  `yaml:"backend"`
+	Sampling Sampling `yaml:"sampling"`
+	Auth     Auth     `yaml:"auth"`
+	SMTP     SMTP     `yaml:"smtp"`
+
+	// sources records where each key's value came from.
+	sources map[string]string
+}
+
+// Server holds the HTTP server settings.
+type Server struct {
+	Listen  string `yaml:"listen" doc:"address the HTTP server listens on"`
+	DataDir string `yaml:"data_dir" doc:"directory holding memory.db and downloaded models"`
+	UIDir   string `yaml:"ui_dir" doc:"built web client to serve at /; empty searches /client and src/client"`
+	// Chats and model downloads lift WriteTimeout for themselves since
+	// they stream for as long as generation or the transfer takes.
+	ReadTimeout     time.Duration `yaml:"read_timeout" doc:"time allowed to read a request including its body; 0 disables"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" doc:"time allowed to write a response, except chats and downloads; 0 disables"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" doc:"how long an idle keep-alive connection stays open"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" doc:"how long a stopping server waits for requests in flight before aborting them"`
+	// TLS is enabled by a certificate and key or by TLSSelfSigned, which
+	// keeps a generated certificate in the data directory.
+	TLSCert               string        `yaml:"tls_cert" doc:"PEM certificate (chain) to serve HTTPS with"`
+	TLSKey                string        `yaml:"tls_key" doc:"PEM private key of tls_cert"`
+	TLSSelfSigned         bool          `yaml:"tls_self_signed" doc:"serve HTTPS with a self-signed certificate generated in data_dir/tls"`
+	TLSHosts              string        `yaml:"tls_hosts" doc:"extra comma separated names and IPs for the self-signed certificate"`
+	HTTPRedirect          string        `yaml:"http_redirect" doc:"address answering plain HTTP with a redirect to HTTPS; empty disables"`
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age" doc:"Strict-Transport-Security max-age sent over HTTPS; 0 disables"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains" doc:"add includeSubDomains to the HSTS header"`
+}
+
+// TLS reports whether the server speaks HTTPS.
+func (s Server) TLS() bool {
+	return s.TLSCert != "" || s.TLSSelfSigned
+}
+
+// Backend describes the model server.
+type Backend struct {
+	Type      string        `yaml:"type" doc:"external (already running at url) or managed (launched from binary)"`
+	URL       string        `yaml:"url" doc:"base URL of the llama.cpp server; empty uses http://localhost:8080 or the managed server"`
+	Binary    string        `yaml:"binary" env:"LLAMA_SERVER_BIN" doc:"path to llama-server for a managed backend"`
+	Host      string        `yaml:"host" doc:"host the managed llama-server listens on"`
+	Port      int           `yaml:"port" doc:"port the managed llama-server listens on"`
+	CtxSize   int           `yaml:"ctx_size" doc:"context size passed to llama-server; 0 keeps the model default"`
+	Threads   int           `yaml:"threads" doc:"CPU threads passed to llama-server; 0 lets it decide"`
+	BatchSize int           `yaml:"batch_size" doc:"batch size passed to llama-server; 0 keeps its default"`
+	Timeout   time.Duration `yaml:"timeout" doc:"timeout for a single request to the backend"`
+	Retries   int           `yaml:"retries" doc:"retries for transient backend failures"`
+}
+
+// Sampling holds the default completion parameters.
+type Sampling struct {
+	Temperature float64 `yaml:"temperature" doc:"sampling temperature"`
+	NPredict    int     `yaml:"n_predict" doc:"tokens generated per completion; -1 generates until the model stops"`
+	TopP        float64 `yaml:"top_p" doc:"nucleus sampling threshold; 0 keeps the backend default"`
+	TopK        int     `yaml:"top_k" doc:"top-k sampling limit; 0 keeps the backend default"`
+}
+
+// Auth holds session settings.
+type Auth struct {
+	SessionTTL    time.Duration `yaml:"session_ttl" doc:"how long an idle login session stays valid"`
+	SecureCookies bool          `yaml:"secure_cookies" doc:"mark cookies Secure; browsers then only send them over HTTPS or to localhost"`
+}
+
+// SMTP configures email notifications. Notifications are off while Addr is
+// empty.
+type SMTP struct {
+	Addr     string `yaml:"addr" env:"SMTP_ADDR" doc:"host:port of the mail server; empty disables email"`
+	From     string `yaml:"from" env:"SMTP_FROM" doc:"sender address"`
+	Username string `yaml:"username" env:"SMTP_USERNAME" doc:"enables PLAIN authentication"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true" doc:"password for username"`
+}
+
+// Default returns the settings used when nothing is configured. They match
+// the behaviour of Codex before the config file existed.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:          ":8081",
+			DataDir:         ".",
+			ReadTimeout:     time.Minute,
+			WriteTimeout:    5 * time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Backend: Backend{
+			Type:    BackendExternal,
+			Host:    "127.0.0.1",
+			Port:    8080,
+			Timeout: 2 * time.Minute,
+			Retries: 2,
+		},
+		Sampling: Sampling{Temperature: 0.7, NPredict: 300},
+		Auth:     Auth{SessionTTL: 7 * 24 * time.Hour, SecureCookies: true},
+		SMTP:     SMTP{From: "codex@localhost"},
+	}
+}
+
+// Path returns the config file to read: explicit when set, otherwise
+// $CODEX_CONFIG, otherwise codex.yaml when it exists. An empty result means
+// no file is used.
+func Path(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if p := os.Getenv(EnvFile); p != "" {
+		return p
+	}
+	if _, err := os.Stat(DefaultFile); err == nil {
+		return DefaultFile
+	}
+	return ""
+}
+
+// Load returns the defaults overridden by the file at path, when path is
+// not empty, and then by the environment. The result is not validated.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.decodeYAML(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, f := range c.fields() {
+		for _, name := range f.env {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := c.set(f.key, v, SourceEnv); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			break
+		}
+	}
+	// A binary configured the old way, without a type, still means the
+	// backend is managed.
+	if c.Backend.Binary != "" && c.Source("backend.type") == SourceDefault {
+		c.Backend.Type = BackendManaged
+	}
+	return c, nil
+}
+
+// field is one setting found by reflection.
+type field struct {
+	key    string
+	env    []string
+	doc    string
+	secret bool
+	v      reflect.Value
+}
+
+// fields lists every setting in file order.
+func (c *Config) fields() []field {
+	var res []field
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		prefix := section.Tag.Get("yaml")
+		if prefix == "" {
+			continue
+		}
+		sv := root.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			sf := sv.Type().Field(j)
+			key := prefix + "." + sf.Tag.Get("yaml")
+			env := []string{"CODEX_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))}
+			if alias := sf.Tag.Get("env"); alias != "" {
+				env = append(env, alias)
+			}
+			res = append(res, field{key: key, env: env, doc: sf.Tag.Get("doc"), secret: sf.Tag.Get("secret") == "true", v: sv.Field(j)})
+		}
+	}
+	return res
+}
+
+// lookup returns the field of key.
+func (c *Config) lookup(key string) (field, bool) {
+	for _, f := range c.fields() {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Keys returns every setting key in file order.
+func (c *Config) Keys() []string {
+	var keys []string
+	for _, f := range c.fields() {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+// EnvNames returns the environment variables that override key, in order of
+// preference.
+func (c *Config) EnvNames(key string) []string {
+	f, _ := c.lookup(key)
+	return f.env
+}
+
+// Value formats the value of key so it can be parsed back by Set or used as
+// a flag value. Unknown keys return "".
+func (c *Config) Value(key string) string {
+	f, ok := c.lookup(key)
+	if !ok {
+		return ""
+	}
+	switch v := f.v.Interface().(type) {
+	case time.Duration:
+		return shortDuration(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Display is Value with secrets masked.
+func (c *Config) Display(key string) string {
+	if f, ok := c.lookup(key); ok && f.secret && f.v.String() != "" {
+		return "********"
+	}
+	return c.Value(key)
+}
+
+// Source reports where the value of key came from.
+func (c *Config) Source(key string) string {
+	if s, ok := c.sources[key]; ok {
+		return s
+	}
+	return SourceDefault
+}
+
+// Set parses value into key and records source as its origin. The cmd
+// package uses it to apply flags.
+func (c *Config) Set(key, value, source string) error {
+	return c.set(key, value, source)
+}
+
+// set parses value into the field of key.
+func (c *Config) set(key, value, source string) error {
+	f, ok := c.lookup(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	switch f.v.Interface().(type) {
+	case string:
+		f.v.SetString(value)
+	case int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a whole number", key, value)
+		}
+		f.v.SetInt(int64(n))
+	case float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", key, value)
+		}
+		f.v.SetFloat(n)
+	case bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", key, value)
+		}
+		f.v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration such as 30s or 2h", key, value)
+		}
+		f.v.SetInt(int64(d))
+	}
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// Validate checks the settings and returns every problem found, joined.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+	if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		bad("server.listen", "%q is not host:port", c.Server.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		bad("server.listen", "invalid port %q", port)
+	}
+	if c.Server.DataDir == "" {
+		bad("server.data_dir", "must not be empty")
+	}
+	for key, d := range map[string]time.Duration{"server.read_timeout": c.Server.ReadTimeout, "server.write_timeout": c.Server.WriteTimeout, "server.idle_timeout": c.Server.IdleTimeout} {
+		if d < 0 {
+			bad(key, "must not be negative")
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		bad("server.shutdown_timeout", "must be positive")
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		bad("server.tls_key", "tls_cert and tls_key must be set together")
+	}
+	if c.Server.TLSSelfSigned && c.Server.TLSCert != "" {
+		bad("server.tls_self_signed", "cannot be combined with tls_cert")
+	}
+	if c.Server.HTTPRedirect != "" {
+		if !c.Server.TLS() {
+			bad("server.http_redirect", "requires TLS")
+		} else if _, _, err := net.SplitHostPort(c.Server.HTTPRedirect); err != nil {
+			bad("server.http_redirect", "%q is not host:port", c.Server.HTTPRedirect)
+		} else if c.Server.HTTPRedirect == c.Server.Listen {
+			bad("server.http_redirect", "must differ from server.listen")
+		}
+	}
+	if c.Server.HSTSMaxAge < 0 {
+		bad("server.hsts_max_age", "must not be negative")
+	} else if c.Server.HSTSMaxAge > 0 && !c.Server.TLS() {
+		bad("server.hsts_max_age", "requires TLS")
+	}
+	switch c.Backend.Type {
+	case BackendExternal:
+	case BackendManaged:
+		if c.Backend.Binary == "" {
+			bad("backend.binary", "required for a managed backend")
+		}
+	default:
+		bad("backend.type", "%q is not %s or %s", c.Backend.Type, BackendExternal, BackendManaged)
+	}
+	if c.Backend.URL != "" {
+		if u, err := url.Parse(c.Backend.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("backend.url", "%q is not an http or https URL", c.Backend.URL)
+		}
+	}
+	if c.Backend.Port < 1 || c.Backend.Port > 65535 {
+		bad("backend.port", "%d is out of range", c.Backend.Port)
+	}
+	for key, n := range map[string]int{"backend.ctx_size": c.Backend.CtxSize, "backend.threads": c.Backend.Threads, "backend.batch_size": c.Backend.BatchSize, "backend.retries": c.Backend.Retries, "sampling.top_k": c.Sampling.TopK} {
+		if n < 0 {
+			bad(key, "must not be negative")
+		}
+	}
+	if c.Backend.Timeout <= 0 {
+		bad("backend.timeout", "must be positive")
+	}
+	if c.Sampling.Temperature < 0 || c.Sampling.Temperature > 2 {
+		bad("sampling.temperature", "%g is outside 0-2", c.Sampling.Temperature)
+	}
+	if c.Sampling.NPredict == 0 || c.Sampling.NPredict < -1 {
+		bad("sampling.n_predict", "must be positive or -1")
+	}
+	if c.Sampling.TopP < 0 || c.Sampling.TopP > 1 {
+		bad("sampling.top_p", "%g is outside 0-1", c.Sampling.TopP)
+	}
+	if c.Auth.SessionTTL <= 0 {
+		bad("auth.session_ttl", "must be positive")
+	}
+	if c.SMTP.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTP.Addr); err != nil {
+			bad("smtp.addr", "%q is not host:port", c.SMTP.Addr)
+		}
+		if !strings.Contains(c.SMTP.From, "@") {
+			bad("smtp.from", "%q is not an email address", c.SMTP.From)
+		}
+	}
+	if c.SMTP.Password != "" && c.SMTP.Username == "" {
+		bad("smtp.password", "set without smtp.username")
+	}
+	// map iteration above is unordered; report problems in a stable order
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
@@ -0,0 +1,161 @@
+package config
+
+// Tests for loading codex.yaml, environment overrides and validation.
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile stores content as a config file in a temporary directory.
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "codex.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadPrecedence checks that the file overrides defaults, the
+// environment overrides the file and sources are reported.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `# test config
+server:
+  listen: "127.0.0.1:9000"   # quoted
+  data_dir: /var/lib/codex
+
+backend:
+  url: http://gpu-box:8080
+  timeout: 5m
+sampling:
+  temperature: 0.2
+smtp:
+  addr: 'mail:25'
+`)
+	t.Setenv("CODEX_BACKEND_TIMEOUT", "90s")
+	t.Setenv("SMTP_FROM", "codex@example.com")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if c.Server.Listen != "127.0.0.1:9000" || c.Server.DataDir != "/var/lib/codex" {
+		t.Fatalf("server = %+v", c.Server)
+	}
+	if c.Backend.URL != "http://gpu-box:8080" || c.Backend.Timeout != 90*time.Second {
+		t.Fatalf("backend = %+v", c.Backend)
+	}
+	if c.Sampling.Temperature != 0.2 || c.Sampling.NPredict != 300 {
+		t.Fatalf("sampling = %+v", c.Sampling)
+	}
+	if c.SMTP.Addr != "mail:25" || c.SMTP.From != "codex@example.com" {
+		t.Fatalf("smtp = %+v", c.SMTP)
+	}
+	for key, want := range map[string]string{
+		"server.listen":   SourceFile,
+		"backend.timeout": SourceEnv,
+		"smtp.from":       SourceEnv,
+		"backend.retries": SourceDefault,
+	} {
+		if got := c.Source(key); got != want {
+			t.Errorf("Source(%s) = %s, want %s", key, got, want)
+		}
+	}
+	if err := c.Set("server.listen", ":7000", SourceFlag); err != nil || c.Server.Listen != ":7000" {
+		t.Fatalf("Set = %v, listen %s", err, c.Server.Listen)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+}
+
+// TestLegacyBinary checks that LLAMA_SERVER_BIN alone selects a managed
+// backend as it did before the config file existed.
+func TestLegacyBinary(t *testing.T) {
+	t.Setenv("LLAMA_SERVER_BIN", "/usr/bin/llama-server")
+	c, err := Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if c.Backend.Type != BackendManaged || c.Backend.Binary != "/usr/bin/llama-server" {
+		t.Fatalf("backend = %+v", c.Backend)
+	}
+}
+
+// TestParseErrors checks that malformed YAML and unknown or mistyped
+// settings are reported with their line.
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"server:\n  listen: :80\n  nope: 1\n":       "line 3: field nope not found",
+		"listen: :80\n":                             "line 1: field listen not found",
+		"server:\n  listen: [a, b]\n":               "line 2: cannot unmarshal !!seq",
+		"backend:\n  port: eighty\n":                "line 2: cannot unmarshal !!str `eighty` into int",
+		"backend:\n  timeout: soon\n":               "line 2: cannot unmarshal",
+		"server:\n  listen: :80\n    data_dir: x\n": "line 3",
+		"server:\n  listen: :80\n  listen: :81\n":   "already defined at line 2",
+	}
+	for content, want := range cases {
+		_, err := Load(writeFile(t, content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load(%q) error = %v, want %q", content, err, want)
+		}
+	}
+
+	// an empty value clears the setting and still counts as set by the file
+	c, err := Load(writeFile(t, "smtp:\n  from:\n"))
+	if err != nil || c.SMTP.From != "" || c.Source("smtp.from") != SourceFile {
+		t.Fatalf("empty value: %v %q %s", err, c.SMTP.From, c.Source("smtp.from"))
+	}
+}
+
+// TestValidate checks that every problem is reported at once.
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Server.Listen = "8081"
+	c.Backend.Type = BackendManaged
+	c.Sampling.TopP = 1.5
+	c.Server.TLSCert = "cert.pem"
+	c.Server.HSTSMaxAge = -time.Second
+	err := c.Validate()
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	for _, want := range []string{"server.listen", "backend.binary", "sampling.top_p", "server.tls_key", "server.hsts_max_age"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %s in %v", want, err)
+		}
+	}
+}
+
+// TestWriteRoundTrip checks that the file written by `codex config init`
+// loads back to the defaults and that secrets are masked.
+func TestWriteRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	if err := Default().Write(&b, true, false, false); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	c, err := Load(writeFile(t, b.String()))
+	if err != nil {
+		t.Fatalf("Load error: %v\n%s", err, b.String())
+	}
+	def := Default()
+	for _, key := range def.Keys() {
+		if c.Value(key) != def.Value(key) {
+			t.Errorf("%s = %q, want %q", key, c.Value(key), def.Value(key))
+		}
+	}
+	if !strings.Contains(b.String(), "session_ttl: 168h") {
+		t.Errorf("durations not shortened:\n%s", b.String())
+	}
+
+	c.SMTP.Password = "hunter22"
+	b.Reset()
+	c.Write(&b, false, true, false)
+	if strings.Contains(b.String(), "hunter22") {
+		t.Fatalf("secret printed:\n%s", b.String())
+	}
+}
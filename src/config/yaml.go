@@ -0,0 +1,108 @@
+package config
+
+// Reading and writing codex.yaml. The file is decoded with yaml.v3 straight
+// into the Config structs, rejecting settings they do not declare; a second
+// pass over the parsed document records which keys the file set so Source
+// can report them. Write produces the same layout with comments.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML overrides the settings of c with those in data. Unknown keys,
+// values of the wrong type and duplicate keys are errors carrying their
+// line. An empty value, such as "url:", sets the zero value.
+func (c *Config) decodeYAML(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		section := root.Content[i+1]
+		for j := 0; j+1 < len(section.Content); j += 2 {
+			key := root.Content[i].Value + "." + section.Content[j].Value
+			f, ok := c.lookup(key)
+			if !ok {
+				continue
+			}
+			// yaml.v3 leaves fields alone for null values
+			if v := section.Content[j+1]; v.Kind == yaml.ScalarNode && v.Tag == "!!null" {
+				f.v.Set(reflect.Zero(f.v.Type()))
+			}
+			if c.sources == nil {
+				c.sources = map[string]string{}
+			}
+			c.sources[key] = SourceFile
+		}
+	}
+	return nil
+}
+
+// Write formats c as a codex.yaml file. With docs every setting is preceded
+// by its description and environment variables; with sources every value is
+// followed by where it came from. Secrets are masked unless reveal is set.
+func (c *Config) Write(w io.Writer, docs, sources, reveal bool) error {
+	var b bytes.Buffer
+	section := ""
+	for _, f := range c.fields() {
+		sec, name, _ := strings.Cut(f.key, ".")
+		if sec != section {
+			if section != "" {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%s:\n", sec)
+			section = sec
+		}
+		if docs {
+			fmt.Fprintf(&b, "  # %s (%s)\n", f.doc, strings.Join(f.env, ", "))
+		}
+		value := c.Display(f.key)
+		if reveal {
+			value = c.Value(f.key)
+		}
+		if _, isString := f.v.Interface().(string); isString {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, "  %s: %s", name, value)
+		if sources {
+			fmt.Fprintf(&b, "  # %s", c.Source(f.key))
+		}
+		b.WriteString("\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// shortDuration formats d without trailing zero units, e.g. "168h" rather
+// than "168h0m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
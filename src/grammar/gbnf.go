@@ -0,0 +1,374 @@
+package grammar
+
+// Conversion from JSON Schema to GBNF, the grammar format accepted by
+// llama.cpp's `grammar` completion parameter.  The generated grammar follows
+// the layout of llama.cpp's own json.gbnf: every value rule consumes trailing
+// whitespace so rules can be concatenated freely.
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// primitiveRules are shared by every generated grammar.
+var primitiveRules = map[string]string{
+	"ws":      `[ \t\n]*`,
+	"char":    `[^"\\\x7F\x00-\x1F] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F])`,
+	"string":  `"\"" char* "\"" ws`,
+	"number":  `"-"? ([0-9] | [1-9] [0-9]*) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws`,
+	"integer": `"-"? ([0-9] | [1-9] [0-9]*) ws`,
+	"boolean": `("true" | "false") ws`,
+	"null":    `"null" ws`,
+	"value":   `object | array | string | number | boolean | null`,
+	"object":  `"{" ws ( string ":" ws value ("," ws string ":" ws value)* )? "}" ws`,
+	"array":   `"[" ws ( value ("," ws value)* )? "]" ws`,
+}
+
+// primitiveDeps lists the shared rules each primitive rule refers to.
+var primitiveDeps = map[string][]string{
+	"string":  {"char", "ws"},
+	"number":  {"ws"},
+	"integer": {"ws"},
+	"boolean": {"ws"},
+	"null":    {"ws"},
+	"value":   {"object", "array", "string", "number", "boolean", "null"},
+	"object":  {"ws", "string", "value"},
+	"array":   {"ws", "value"},
+}
+
+// JSONGrammar accepts any JSON object. It backs response_format json_object.
+var JSONGrammar = mustGrammar(`{"type":"object"}`)
+
+func mustGrammar(schema string) string {
+	s, err := Parse([]byte(schema))
+	if err != nil {
+		panic(err)
+	}
+	g, err := FromSchema(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// converter accumulates grammar rules while walking a schema.
+type converter struct {
+	root    *Schema
+	rules   map[string]string
+	refs    map[string]string // $ref -> rule name
+	pending map[string]bool   // reserved rule names awaiting a body
+}
+
+// FromSchema converts s into a GBNF grammar whose root rule matches JSON
+// documents valid under the schema's structural keywords. Patterns are not
+// expressed in the grammar and are only enforced by Validate.
+func FromSchema(s *Schema) (string, error) {
+	c := &converter{root: s, rules: map[string]string{}, refs: map[string]string{}, pending: map[string]bool{}}
+	expr, err := c.visit(s, "root")
+	if err != nil {
+		return "", err
+	}
+	if expr != "root" {
+		c.rules["root"] = expr
+	}
+	names := make([]string, 0, len(c.rules))
+	for n := range c.rules {
+		if n != "root" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "root ::= %s\n", c.rules["root"])
+	for _, n := range names {
+		fmt.Fprintf(&b, "%s ::= %s\n", n, c.rules[n])
+	}
+	return b.String(), nil
+}
+
+// primitive registers a shared rule and its dependencies and returns its name.
+func (c *converter) primitive(name string) string {
+	if _, ok := c.rules[name]; ok {
+		return name
+	}
+	c.rules[name] = primitiveRules[name]
+	for _, d := range primitiveDeps[name] {
+		c.primitive(d)
+	}
+	return name
+}
+
+var ruleNameRe = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// define adds a rule under a unique name derived from name.
+func (c *converter) define(name, body string) string {
+	name = strings.Trim(ruleNameRe.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		name = "rule"
+	}
+	if _, ok := primitiveRules[name]; ok {
+		name += "-"
+	}
+	if c.pending[name] {
+		delete(c.pending, name)
+		c.rules[name] = body
+		return name
+	}
+	base := name
+	for i := 1; ; i++ {
+		existing, ok := c.rules[name]
+		if !ok {
+			c.rules[name] = body
+			return name
+		}
+		if existing == body {
+			return name
+		}
+		name = base + strconv.Itoa(i)
+	}
+}
+
+// literal returns a grammar expression matching the JSON encoding of raw.
+func literal(raw json.RawMessage) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(b)), nil
+}
+
+// visit returns a grammar expression (usually a rule name) for s.
+func (c *converter) visit(s *Schema, name string) (string, error) {
+	switch {
+	case s.Ref != "":
+		if rule, ok := c.refs[s.Ref]; ok {
+			return rule, nil
+		}
+		target, err := resolve(c.root, s.Ref)
+		if err != nil {
+			return "", err
+		}
+		// Reserve the rule name first so recursive schemas terminate; the
+		// first rule defined under that name fills the reservation.
+		rule := c.define("ref-"+s.Ref[strings.LastIndex(s.Ref, "/")+1:], "pending "+s.Ref)
+		c.refs[s.Ref] = rule
+		c.pending[rule] = true
+		expr, err := c.visit(target, rule)
+		if err != nil {
+			return "", err
+		}
+		if c.pending[rule] {
+			delete(c.pending, rule)
+			c.rules[rule] = expr
+		}
+		return rule, nil
+
+	case len(s.Const) > 0:
+		lit, err := literal(s.Const)
+		if err != nil {
+			return "", err
+		}
+		return c.define(name, lit+" "+c.primitive("ws")), nil
+
+	case len(s.Enum) > 0:
+		alts := make([]string, 0, len(s.Enum))
+		for _, e := range s.Enum {
+			lit, err := literal(e)
+			if err != nil {
+				return "", err
+			}
+			alts = append(alts, lit)
+		}
+		return c.define(name, "("+strings.Join(alts, " | ")+") "+c.primitive("ws")), nil
+
+	case len(s.AnyOf) > 0 || len(s.OneOf) > 0:
+		subs := append(append([]*Schema(nil), s.AnyOf...), s.OneOf...)
+		alts := make([]string, 0, len(subs))
+		for i, sub := range subs {
+			expr, err := c.visit(sub, fmt.Sprintf("%s-%d", name, i))
+			if err != nil {
+				return "", err
+			}
+			alts = append(alts, expr)
+		}
+		return c.define(name, strings.Join(alts, " | ")), nil
+	}
+
+	types := s.Types
+	if len(types) == 0 {
+		switch {
+		case len(s.Properties) > 0:
+			types = []string{"object"}
+		case s.Items != nil:
+			types = []string{"array"}
+		default:
+			return c.primitive("value"), nil
+		}
+	}
+	alts := make([]string, 0, len(types))
+	for _, t := range types {
+		expr, err := c.visitType(s, t, name)
+		if err != nil {
+			return "", err
+		}
+		alts = append(alts, expr)
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return c.define(name, strings.Join(alts, " | ")), nil
+}
+
+// visitType builds the rule for one JSON type of s.
+func (c *converter) visitType(s *Schema, typ, name string) (string, error) {
+	switch typ {
+	case "string":
+		if s.MinLength == nil && s.MaxLength == nil {
+			return c.primitive("string"), nil
+		}
+		min, max := bounds(s.MinLength, s.MaxLength)
+		body := `"\"" ` + repeat(c.primitive("char"), "", min, max) + ` "\"" ` + c.primitive("ws")
+		return c.define(name, body), nil
+	case "number":
+		return c.primitive("number"), nil
+	case "integer":
+		return c.primitive("integer"), nil
+	case "boolean":
+		return c.primitive("boolean"), nil
+	case "null":
+		return c.primitive("null"), nil
+	case "array":
+		if s.Items == nil && s.MinItems == nil && s.MaxItems == nil {
+			return c.primitive("array"), nil
+		}
+		item := c.primitive("value")
+		if s.Items != nil {
+			var err error
+			if item, err = c.visit(s.Items, name+"-item"); err != nil {
+				return "", err
+			}
+		}
+		min, max := bounds(s.MinItems, s.MaxItems)
+		body := `"[" ` + c.primitive("ws") + " " + repeat(item, `"," `+c.primitive("ws"), min, max) + ` "]" ` + c.primitive("ws")
+		return c.define(name, body), nil
+	case "object":
+		return c.visitObject(s, name)
+	}
+	return "", fmt.Errorf("unsupported type %q", typ)
+}
+
+// visitObject builds the rule for an object schema. Required properties are
+// emitted in declaration order followed by optional ones, each optional
+// property may be omitted. Objects without properties accept any members
+// unless additionalProperties is false.
+func (c *converter) visitObject(s *Schema, name string) (string, error) {
+	if len(s.Properties) == 0 {
+		if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			return c.define(name, `"{" `+c.primitive("ws")+` "}" `+c.primitive("ws")), nil
+		}
+		return c.primitive("object"), nil
+	}
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	var req, opt []string
+	for _, p := range s.Properties {
+		val, err := c.visit(p.Schema, name+"-"+p.Name)
+		if err != nil {
+			return "", err
+		}
+		kv := c.define(name+"-"+p.Name+"-kv", strconv.Quote(strconv.Quote(p.Name))+` ":" `+c.primitive("ws")+" "+val)
+		if required[p.Name] {
+			req = append(req, kv)
+		} else {
+			opt = append(opt, kv)
+		}
+	}
+	sep := `"," ` + c.primitive("ws")
+	var body strings.Builder
+	body.WriteString(`"{" ` + c.primitive("ws"))
+	if len(req) > 0 {
+		body.WriteString(" " + strings.Join(req, " "+sep+" "))
+		for _, kv := range opt {
+			body.WriteString(" (" + sep + " " + kv + ")?")
+		}
+	} else {
+		// Without required members the first emitted property has no
+		// leading comma, so each optional property may start the list.
+		alts := make([]string, len(opt))
+		for i, kv := range opt {
+			alt := kv
+			for _, next := range opt[i+1:] {
+				alt += " (" + sep + " " + next + ")?"
+			}
+			alts[i] = alt
+		}
+		body.WriteString(" (" + strings.Join(alts, " | ") + ")?")
+	}
+	body.WriteString(` "}" ` + c.primitive("ws"))
+	return c.define(name, body.String()), nil
+}
+
+// bounds converts optional min/max keywords into repeat arguments, using -1
+// for an unbounded maximum.
+func bounds(minp, maxp *int) (int, int) {
+	min, max := 0, -1
+	if minp != nil && *minp > 0 {
+		min = *minp
+	}
+	if maxp != nil && *maxp >= min {
+		max = *maxp
+	}
+	return min, max
+}
+
+// repeat expands item repeated between min and max times (max < 0 means
+// unbounded) with sep between repetitions, without relying on GBNF's {m,n}
+// syntax so older llama.cpp builds accept the grammar.
+func repeat(item, sep string, min, max int) string {
+	join := func(first bool) string {
+		if first || sep == "" {
+			return item
+		}
+		return sep + " " + item
+	}
+	var parts []string
+	for i := 0; i < min; i++ {
+		parts = append(parts, join(i == 0))
+	}
+	switch {
+	case max < 0 && min == 0:
+		rest := item
+		if sep != "" {
+			rest = item + " (" + sep + " " + item + ")*"
+			return "(" + rest + ")?"
+		}
+		return item + "*"
+	case max < 0 && sep == "":
+		parts = append(parts, item+"*")
+	case max < 0:
+		parts = append(parts, "("+join(false)+")*")
+	default:
+		tail := ""
+		for i := max; i > min; i-- {
+			inner := join(i == 1)
+			if tail != "" {
+				inner += " " + tail
+			}
+			tail = "(" + inner + ")?"
+		}
+		if tail != "" {
+			parts = append(parts, tail)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,77 @@
+package grammar
+
+// Tests for schema to grammar conversion and response validation.
+
+import (
+	"strings"
+	"testing"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "member"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"manager": {"$ref": "#/$defs/person"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"person": {"type": "object", "properties": {"name": {"type": "string"}}}}
+}`
+
+// TestFromSchema checks that the grammar keeps property order and expresses
+// required, optional, enum and bounded array members.
+func TestFromSchema(t *testing.T) {
+	s, err := Parse([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	g, err := FromSchema(s)
+	if err != nil {
+		t.Fatalf("FromSchema error: %v", err)
+	}
+	for _, want := range []string{
+		`root ::= "{" ws root-name-kv "," ws root-age-kv ("," ws root-role-kv)? ("," ws root-tags-kv)? ("," ws root-manager-kv)? "}" ws`,
+		`root-name-kv ::= "\"name\"" ":" ws root-name`,
+		`root-role ::= ("\"admin\"" | "\"member\"") ws`,
+		`root-tags ::= "[" ws (string ("," ws string)?)? "]" ws`,
+		`root-manager-kv ::= "\"manager\"" ":" ws ref-person`,
+		`ref-person ::= "{" ws (ref-person-name-kv)? "}" ws`,
+		`integer ::= `,
+	} {
+		if !strings.Contains(g, want+"\n") && !strings.Contains(g, want) {
+			t.Fatalf("grammar missing %q:\n%s", want, g)
+		}
+	}
+	if !strings.HasPrefix(JSONGrammar, "root ::= object\n") {
+		t.Fatalf("unexpected JSON grammar:\n%s", JSONGrammar)
+	}
+}
+
+// TestValidate checks accepted and rejected documents.
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if err := Validate(s, []byte(`{"name":"ada","age":36,"role":"admin","manager":{"name":"bob"}}`)); err != nil {
+		t.Fatalf("valid document rejected: %v", err)
+	}
+	bad := map[string]string{
+		`{"name":"ada"}`:                            "missing required",
+		`{"name":"ada","age":1.5}`:                  "expected",
+		`{"name":"","age":1}`:                       "shorter",
+		`{"name":"a","age":1,"role":"x"}`:           "enum",
+		`{"name":"a","age":1,"extra":true}`:         "unexpected property",
+		`{"name":"a","age":1,"tags":["a","b","c"]}`: "more than 2",
+		`{"name":"a","age":1`:                       "invalid JSON",
+	}
+	for doc, want := range bad {
+		err := Validate(s, []byte(doc))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected error containing %q, got %v", doc, want, err)
+		}
+	}
+}
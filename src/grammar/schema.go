@@ -0,0 +1,150 @@
+package grammar
+
+// Package grammar turns structured output requests into constraints the
+// llama.cpp backend understands.  A JSON Schema is converted to a GBNF grammar
+// so sampling can only produce matching JSON, and the generated text is
+// validated against the same schema before it is returned to the caller.
+//
+// Only the commonly used subset of JSON Schema is supported: type, enum,
+// const, properties, required, additionalProperties, items, anyOf/oneOf,
+// $ref into $defs/definitions and the length, item count, numeric range and
+// pattern keywords. Unknown keywords are ignored.
+//
+// AI Awareness: grammars constrain what the model can emit; an overly strict
+// schema can force the model into poor answers.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Schema is a parsed JSON Schema document or sub-schema.
+type Schema struct {
+	// Types lists the allowed JSON types. "type" may be a string or an
+	// array in the source document; both are normalised into this slice.
+	Types []string `json:"-"`
+
+	Properties           Properties         `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"-"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []json.RawMessage  `json:"enum,omitempty"`
+	Const                json.RawMessage    `json:"const,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// Property is one entry of an object schema's properties, kept in document
+// order so generated objects list keys the way the schema author wrote them.
+type Property struct {
+	Name   string
+	Schema *Schema
+}
+
+// Properties preserves the declaration order of an object's properties.
+type Properties []Property
+
+// UnmarshalJSON reads the properties object token by token to keep key order.
+func (p *Properties) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return errors.New("properties must be an object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := tok.(string)
+		var s Schema
+		if err := dec.Decode(&s); err != nil {
+			return fmt.Errorf("property %q: %w", name, err)
+		}
+		*p = append(*p, Property{Name: name, Schema: &s})
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// UnmarshalJSON handles the keywords whose JSON shape varies: "type" may be a
+// string or an array and "additionalProperties" may be a boolean or a schema.
+// Boolean schemas (true/false) are accepted as "anything".
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	if t := bytes.TrimSpace(data); bytes.Equal(t, []byte("true")) || bytes.Equal(t, []byte("false")) {
+		*s = Schema{}
+		return nil
+	}
+	type plain Schema
+	var aux struct {
+		*plain
+		Type                 json.RawMessage `json:"type"`
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+	aux.plain = (*plain)(s)
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.Type) > 0 {
+		var one string
+		if err := json.Unmarshal(aux.Type, &one); err == nil {
+			s.Types = []string{one}
+		} else if err := json.Unmarshal(aux.Type, &s.Types); err != nil {
+			return errors.New("type must be a string or an array of strings")
+		}
+	}
+	if len(aux.AdditionalProperties) > 0 {
+		var b bool
+		if err := json.Unmarshal(aux.AdditionalProperties, &b); err == nil {
+			s.AdditionalProperties = &b
+		}
+	}
+	return nil
+}
+
+// Parse decodes a JSON Schema document.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &s, nil
+}
+
+// resolve follows a local $ref ("#/$defs/x" or "#/definitions/x") against
+// the root schema.
+func resolve(root *Schema, ref string) (*Schema, error) {
+	var defs map[string]*Schema
+	var name string
+	switch {
+	case ref == "#":
+		return root, nil
+	case strings.HasPrefix(ref, "#/$defs/"):
+		defs, name = root.Defs, strings.TrimPrefix(ref, "#/$defs/")
+	case strings.HasPrefix(ref, "#/definitions/"):
+		defs, name = root.Definitions, strings.TrimPrefix(ref, "#/definitions/")
+	default:
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	s, ok := defs[name]
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %q", ref)
+	}
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package grammar
+
+// Validation of generated JSON against a schema. The grammar already keeps
+// the model on the right structure, but patterns, numeric ranges and
+// truncated output can only be caught after generation.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"unicode/utf8"
+)
+
+// ValidationError reports where a document failed validation. Path uses a
+// JSON Pointer style, e.g. "/items/0/name".
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return "response does not match schema: " + e.Msg
+	}
+	return fmt.Sprintf("response does not match schema at %s: %s", e.Path, e.Msg)
+}
+
+// Validate checks that data is a single JSON document valid under s. A nil
+// schema only requires well formed JSON.
+func Validate(s *Schema, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return &ValidationError{Msg: "invalid JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return &ValidationError{Msg: "trailing data after JSON value"}
+	}
+	if s == nil {
+		return nil
+	}
+	return validate(s, s, v, "")
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// typeAllowed reports whether a value of type got satisfies want. Integers
+// are also numbers.
+func typeAllowed(want []string, got string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, t := range want {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// equalJSON compares a decoded value with a raw JSON literal.
+func equalJSON(v interface{}, raw json.RawMessage) bool {
+	var want interface{}
+	if json.Unmarshal(raw, &want) != nil {
+		return false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	var got interface{}
+	json.Unmarshal(b, &got)
+	return reflect.DeepEqual(got, want)
+}
+
+func validate(root, s *Schema, v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf(format, args...)}
+	}
+	if s.Ref != "" {
+		target, err := resolve(root, s.Ref)
+		if err != nil {
+			return fail("%v", err)
+		}
+		return validate(root, target, v, path)
+	}
+	if len(s.Const) > 0 && !equalJSON(v, s.Const) {
+		return fail("must equal %s", s.Const)
+	}
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			if equalJSON(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fail("value is not one of the allowed enum values")
+		}
+	}
+	if len(s.AnyOf) > 0 || len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range append(append([]*Schema(nil), s.AnyOf...), s.OneOf...) {
+			if validate(root, sub, v, path) == nil {
+				matched++
+			}
+		}
+		if matched == 0 {
+			return fail("value matches none of the allowed schemas")
+		}
+		if len(s.OneOf) > 0 && len(s.AnyOf) == 0 && matched > 1 {
+			return fail("value matches more than one schema in oneOf")
+		}
+	}
+
+	typ := jsonType(v)
+	if !typeAllowed(s.Types, typ) {
+		return fail("expected %v, got %s", s.Types, typ)
+	}
+	switch x := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fail("shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("longer than %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fail("invalid pattern: %v", err)
+			}
+			if !re.MatchString(x) {
+				return fail("does not match pattern %q", s.Pattern)
+			}
+		}
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			return fail("invalid number %s", x)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("less than minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("greater than maximum %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(x) < *s.MinItems {
+			return fail("fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(x) > *s.MaxItems {
+			return fail("more than %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range x {
+				if err := validate(root, s.Items, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := x[r]; !ok {
+				return fail("missing required property %q", r)
+			}
+		}
+		known := map[string]bool{}
+		for _, p := range s.Properties {
+			known[p.Name] = true
+			if pv, ok := x[p.Name]; ok {
+				if err := validate(root, p.Schema, pv, path+"/"+p.Name); err != nil {
+					return err
+				}
+			}
+		}
+		if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			for k := range x {
+				if !known[k] {
+					return fail("unexpected property %q", k)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package handlers
+
+// Login endpoints. A successful POST /api/auth/login sets the session cookie
+// that auth.Middleware resolves on later requests.
+
+import (
+	"codex/src/auth"
+	"codex/src/memory"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// LoginRequest is the body of POST /api/auth/login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginHandler checks the credentials, opens a session and returns the user.
+// Wrong credentials answer 401.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+		http.Error(w, "username and password required", http.StatusBadRequest)
+		return
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("LoginHandler InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	token, u, err := auth.Login(db, req.Username, req.Password, r)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		log.Printf("LoginHandler failed login for %q from %s", req.Username, r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "login error", http.StatusInternalServerError)
+		log.Printf("LoginHandler error: %v", err)
+		return
+	}
+	auth.SetSessionCookie(w, token)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.Printf("LoginHandler encode error: %v", err)
+	}
+}
+
+// LogoutHandler ends the current session and clears the cookie.
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(auth.CookieName); err == nil && c.Value != "" {
+		db, err := memory.InitDB()
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("LogoutHandler InitDB error: %v", err)
+			return
+		}
+		defer db.Close()
+		if err := auth.Logout(db, c.Value); err != nil {
+			log.Printf("LogoutHandler error: %v", err)
+		}
+	}
+	auth.ClearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MeHandler returns the logged in user or 401.
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	u := auth.CurrentUser(r.Context())
+	if u == nil {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.Printf("MeHandler encode error: %v", err)
+	}
+}
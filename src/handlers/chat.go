@@ -9,12 +9,25 @@ package handlers
 // LLM client defined in the llama package.
 
 import (
+	"codex/src/auth"
+	"codex/src/grammar"
+	"codex/src/knowledge"
 	"codex/src/llama"
+	"codex/src/memory"
+	"codex/src/queue"
+	"codex/src/tools"
+	"codex/src/webhook"
+	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // ChatRequest is the JSON payload accepted by the chat endpoint. It simply
@@ -24,6 +37,30 @@ import (
 type ChatRequest struct {
 	// Prompt is the user's message that will be sent to the LLM.
 	Prompt string `json:"prompt"`
+	// ResponseFormat asks for machine-readable output. It mirrors the
+	// OpenAI shape: {"type":"json_object"} or {"type":"json_schema",
+	// "json_schema":{"name":..,"schema":{..}}}.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Grammar is a raw GBNF grammar passed to the backend unchanged. It
+	// cannot be combined with ResponseFormat.
+	Grammar string `json:"grammar,omitempty"`
+	// Tools names the tools the assistant may call while answering; "*"
+	// enables every registered tool. Tools cannot be combined with
+	// structured output.
+	Tools []string `json:"tools,omitempty"`
+}
+
+// ResponseFormat selects structured output for a chat request.
+type ResponseFormat struct {
+	// Type is "text", "json_object" or "json_schema".
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema names the schema the response must satisfy.
+type JSONSchema struct {
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 // ChatResponse represents the JSON body returned by the chat endpoint. The
@@ -31,16 +68,282 @@ type ChatRequest struct {
 type ChatResponse struct {
 	// Response contains the text generated by the LLM.
 	Response string `json:"response"`
+	// JSON holds the validated document when structured output was
+	// requested.
+	JSON json.RawMessage `json:"json,omitempty"`
+	// ToolCalls lists the tools the assistant called, in order.
+	ToolCalls []tools.Call `json:"tool_calls,omitempty"`
+	// Citations lists the knowledge base passages included in the prompt.
+	// The answer refers to them by Index, e.g. [1].
+	Citations []Citation `json:"citations,omitempty"`
+}
+
+// Citation identifies a retrieved document passage.
+type Citation struct {
+	Index     int     `json:"index"`
+	Source    string  `json:"source"`
+	StartLine int     `json:"start_line"`
+	EndLine   int     `json:"end_line"`
+	Symbol    string  `json:"symbol,omitempty"`
+	Score     float64 `json:"score"`
+}
+
+// chatOptions is the compiled output constraint and tool selection of a chat
+// request.
+type chatOptions struct {
+	tools   []*tools.Tool
+	grammar string
+	// validate is set when the output must be JSON; schema may be nil for
+	// json_object.
+	validate bool
+	schema   *grammar.Schema
+	hint     string
+}
+
+// compileOptions converts the request's response_format or grammar into a
+// backend grammar and resolves the requested tools. Errors describe invalid
+// requests and map to 400.
+func compileOptions(req ChatRequest) (chatOptions, error) {
+	var f chatOptions
+	rf := req.ResponseFormat
+	structured := req.Grammar != "" || (rf != nil && rf.Type != "" && rf.Type != "text")
+	if rf != nil && rf.Type != "" && rf.Type != "text" && req.Grammar != "" {
+		return f, errors.New("response_format and grammar cannot be combined")
+	}
+	if len(req.Tools) > 0 {
+		if structured {
+			return f, errors.New("tools cannot be combined with response_format or grammar")
+		}
+		selected, err := tools.Default().Select(req.Tools)
+		if err != nil {
+			return f, err
+		}
+		f.tools = selected
+		return f, nil
+	}
+	if req.Grammar != "" {
+		f.grammar = req.Grammar
+		return f, nil
+	}
+	if rf == nil {
+		return f, nil
+	}
+	switch rf.Type {
+	case "", "text":
+		return f, nil
+	case "json_object":
+		f.grammar = grammar.JSONGrammar
+		f.validate = true
+		f.hint = "\n\nRespond only with a JSON object."
+		return f, nil
+	case "json_schema":
+		if rf.JSONSchema == nil || len(rf.JSONSchema.Schema) == 0 {
+			return f, errors.New("response_format json_schema requires a schema")
+		}
+		schema, err := grammar.Parse(rf.JSONSchema.Schema)
+		if err != nil {
+			return f, err
+		}
+		g, err := grammar.FromSchema(schema)
+		if err != nil {
+			return f, fmt.Errorf("unsupported JSON schema: %w", err)
+		}
+		f.grammar, f.schema, f.validate = g, schema, true
+		f.hint = "\n\nRespond only with JSON matching this schema:\n" + string(rf.JSONSchema.Schema)
+		return f, nil
+	}
+	return f, fmt.Errorf("unknown response_format type %q", rf.Type)
+}
+
+// generate runs the prompt through the backend under the compiled format and
+// validates structured output. A *grammar.ValidationError is returned when
+// the model produced JSON that does not satisfy the schema.
+//
+// The prompt is prefixed with the caller's active project's conversation
+// context and both turns are recorded in memory once generation succeeds,
+// unless the caller may only read the project.
+func generate(ctx context.Context, req ChatRequest, f chatOptions) (ChatResponse, error) {
+	project, prompt, cites := conversationPrompt(ctx, req.Prompt)
+	if len(f.tools) > 0 {
+		complete := func(ctx context.Context, prompt string) (string, error) {
+			return llama.SendPromptWith(ctx, prompt, llama.Options{})
+		}
+		onCall := func(c tools.Call) { recordToolCall(project, c) }
+		out, calls, err := tools.Run(ctx, prompt, complete, tools.Options{Tools: f.tools, OnCall: onCall})
+		if err == nil {
+			recordTurn(project, req.Prompt, out)
+			chatCompleted(ctx, project, req.Prompt, out, len(calls), len(cites))
+		}
+		return ChatResponse{Response: out, ToolCalls: calls, Citations: cites}, err
+	}
+	out, err := llama.SendPromptWith(ctx, prompt+f.hint, llama.Options{Grammar: f.grammar})
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	res := ChatResponse{Response: out, Citations: cites}
+	if f.validate {
+		doc := strings.TrimSpace(out)
+		if err := grammar.Validate(f.schema, []byte(doc)); err != nil {
+			return res, err
+		}
+		res.JSON = json.RawMessage(doc)
+	}
+	recordTurn(project, req.Prompt, out)
+	chatCompleted(ctx, project, req.Prompt, out, 0, len(cites))
+	return res, nil
 }
 
-// ensureAnonCookie assigns a persistent anonymous ID when the requester is not
-// logged in. The value can be used by future features to associate chat
-// history with a specific browser session.
+// chatCompleted emits the chat.completed webhook event.
+func chatCompleted(ctx context.Context, project, prompt, answer string, toolCalls, citations int) {
+	webhook.Emit(webhook.EventChatCompleted, webhook.ChatCompleted{
+		Project:   project,
+		Principal: auth.Principal(ctx),
+		Prompt:    prompt,
+		Response:  answer,
+		ToolCalls: toolCalls,
+		Citations: citations,
+	})
+}
+
+// Limits on the memory included in a chat prompt. Older entries are added as
+// notes when their importance is at least contextNoteImportance.
+const (
+	contextSummaries      = 3
+	contextTurns          = 12
+	contextNotes          = 3
+	contextNoteImportance = 5
+	contextDocuments      = 4
+	contextDocumentScore  = 0.15
+)
+
+// conversationPrompt returns the caller's active project and prompt prefixed
+// with that project's context: passages from its knowledge base relevant to the prompt,
+// its newest summaries, important older notes ranked by decayed importance,
+// then the recent turns that have not been summarised yet. Summaries are
+// preferred over old raw turns so long projects keep short prompts. The
+// retrieved passages are returned as citations. Without an active project or
+// any memory the prompt is returned unchanged. The returned project is empty
+// when the caller may read but not write it, so nothing is recorded.
+func conversationPrompt(ctx context.Context, prompt string) (string, string, []Citation) {
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("conversationPrompt InitDB error: %v", err)
+		return "", prompt, nil
+	}
+	defer db.Close()
+	project, err := auth.ActiveProject(ctx, db)
+	if err != nil || project == "" {
+		return "", prompt, nil
+	}
+	record := project
+	if access, _ := auth.ProjectAccess(ctx, db, project); !memory.AccessAllows(access, memory.AccessWrite) {
+		record = ""
+	}
+	hits, err := knowledge.Search(ctx, db, project, prompt, contextDocuments, contextDocumentScore)
+	if err != nil {
+		log.Printf("conversationPrompt knowledge search error: %v", err)
+	}
+	sums, turns, err := memory.ConversationContext(db, project, contextSummaries, contextTurns)
+	if err != nil {
+		log.Printf("conversationPrompt context error: %v", err)
+	}
+	notes := importantNotes(db, project, turns)
+	if len(hits) == 0 && len(sums) == 0 && len(turns) == 0 && len(notes) == 0 {
+		return record, prompt, nil
+	}
+	var b strings.Builder
+	var cites []Citation
+	if len(hits) > 0 {
+		b.WriteString("Relevant documents and code (cite them as [n] or file:line when you use them):\n")
+		for i, h := range hits {
+			c := Citation{Index: i + 1, Source: h.Source, StartLine: h.StartLine, EndLine: h.EndLine, Symbol: h.Symbol, Score: h.Score}
+			cites = append(cites, c)
+			fmt.Fprintf(&b, "[%d] %s:%d-%d", c.Index, c.Source, c.StartLine, c.EndLine)
+			if c.Symbol != "" {
+				fmt.Fprintf(&b, " (%s)", c.Symbol)
+			}
+			fmt.Fprintf(&b, "\n%s\n\n", strings.TrimSpace(h.Content))
+		}
+	}
+	if len(sums) > 0 {
+		b.WriteString("Summary of earlier conversation:\n")
+		for _, e := range sums {
+			b.WriteString(e.Content + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if len(notes) > 0 {
+		b.WriteString("Important notes:\n")
+		for _, e := range notes {
+			fmt.Fprintf(&b, "- %s: %s\n", e.Role, e.Content)
+		}
+		b.WriteString("\n")
+	}
+	if len(turns) > 0 {
+		b.WriteString("Recent conversation:\n")
+		for _, e := range turns {
+			fmt.Fprintf(&b, "%s: %s\n", e.Role, e.Content)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "user: %s\nassistant:", prompt)
+	return record, b.String(), cites
+}
+
+// importantNotes returns the project's highest ranked older entries that are
+// not already part of the recent turns or summaries.
+func importantNotes(db *sql.DB, project string, turns []memory.MemoryEntry) []memory.MemoryEntry {
+	ranked, err := memory.RankedEntries(db, project, contextNotes+len(turns), memory.DefaultHalfLife)
+	if err != nil {
+		log.Printf("importantNotes error: %v", err)
+		return nil
+	}
+	recent := map[int]bool{}
+	for _, e := range turns {
+		recent[e.ID] = true
+	}
+	var notes []memory.MemoryEntry
+	for _, e := range ranked {
+		if recent[e.ID] || e.Role == memory.SummaryRole || e.Importance < contextNoteImportance {
+			continue
+		}
+		notes = append(notes, e)
+		if len(notes) == contextNotes {
+			break
+		}
+	}
+	return notes
+}
+
+// recordTurn stores the user prompt and the assistant's answer in the
+// project's memory. Nothing is stored without an active project.
+func recordTurn(project, prompt, answer string) {
+	if project == "" {
+		return
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("recordTurn InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	if err := memory.AddEntry(db, project, "user", prompt); err != nil {
+		log.Printf("recordTurn AddEntry error: %v", err)
+		return
+	}
+	if err := memory.AddEntry(db, project, "assistant", answer); err != nil {
+		log.Printf("recordTurn AddEntry error: %v", err)
+	}
+}
+
+// ensureAnonCookie identifies the requester: logged in users by their user
+// ID and everyone else by a persistent anonymous ID assigned here. The value
+// associates queued requests and chat history with a person or browser.
 func ensureAnonCookie(w http.ResponseWriter, r *http.Request) string {
-	if c, err := r.Cookie("session"); err == nil && c.Value != "" {
-		return c.Value
+	if u := auth.CurrentUser(r.Context()); u != nil {
+		return auth.UserPrincipal(u)
 	}
-	if c, err := r.Cookie("anon"); err == nil && c.Value != "" {
+	if c, err := r.Cookie(auth.AnonCookieName); err == nil && c.Value != "" {
 		return c.Value
 	}
 	// generate a random identifier and set it as a cookie
@@ -48,10 +351,10 @@ func ensureAnonCookie(w http.ResponseWriter, r *http.Request) string {
 	if _, err := rand.Read(b); err == nil {
 		id := hex.EncodeToString(b)
 		http.SetCookie(w, &http.Cookie{
-			Name:     "anon",
+			Name:     auth.AnonCookieName,
 			Value:    id,
 			Path:     "/",
-			Secure:   true,
+			Secure:   auth.SecureCookies,
 			SameSite: http.SameSiteStrictMode,
 		})
 		return id
@@ -59,6 +362,24 @@ func ensureAnonCookie(w http.ResponseWriter, r *http.Request) string {
 	return ""
 }
 
+// hasAnonCookie reports whether the client sent its anonymous cookie, so its
+// principal will be the same on the next request.
+func hasAnonCookie(r *http.Request) bool {
+	c, err := r.Cookie(auth.AnonCookieName)
+	return err == nil && c.Value != ""
+}
+
+// identify assigns the anonymous cookie when needed and returns the
+// requester's ID with a request whose context carries it, so project access
+// applies to browsers on their first request too.
+func identify(w http.ResponseWriter, r *http.Request) (string, *http.Request) {
+	id := ensureAnonCookie(w, r)
+	if auth.CurrentUser(r.Context()) == nil {
+		r = r.WithContext(auth.WithAnonymous(r.Context(), id))
+	}
+	return id, r
+}
+
 // ChatHandler receives a chat prompt via HTTP POST and returns the assistant's
 // response. This endpoint is used by the `serve` command to expose chat
 // functionality over HTTP. AI Awareness: this is the bridge between external
@@ -72,8 +393,19 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// queued and generating chats may take minutes; the request context
+	// ends them when the client leaves or the server stops
+	noWriteTimeout(w)
+
 	// assign a tracking cookie so anonymous sessions can be correlated
-	ensureAnonCookie(w, r)
+	_, r = identify(w, r)
+	user := queueUser(r)
+
+	// fail fast when the health monitor knows the backend is down
+	if backendUnavailable(w) {
+		log.Printf("ChatHandler backend not ready")
+		return
+	}
 
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -83,16 +415,44 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("ChatHandler prompt=%q", req.Prompt)
 
+	opts, err := compileOptions(req)
+	if err != nil {
+		log.Printf("ChatHandler options error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for a backend slot. Without a queue every request is forwarded
+	// immediately.
+	if q := queue.Default(); q != nil {
+		t, err := q.Join(user)
+		if err != nil {
+			log.Printf("ChatHandler queue rejected %s: %v", user, err)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds()))
+			http.Error(w, "too many queued requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		defer t.Release()
+		if wantsStream(r) {
+			streamChat(w, r, t, req, opts)
+			return
+		}
+		if err := t.Wait(r.Context()); err != nil {
+			log.Printf("ChatHandler left queue: %v", err)
+			return
+		}
+	}
+
 	// Forward the prompt to the LLM backend. The llama package abstracts
-	// the HTTP communication.
-	result, err := llama.SendPrompt(req.Prompt)
+	// the HTTP communication; passing the request context stops generation
+	// when the client disconnects.
+	res, err := generate(r.Context(), req, opts)
 	if err != nil {
 		log.Printf("ChatHandler llama error: %v", err)
-		http.Error(w, "LLM error: "+err.Error(), http.StatusInternalServerError)
+		reportBackendError(w, err)
 		return
 	}
 
-	res := ChatResponse{Response: result}
 	log.Printf("ChatHandler response %+v", res)
 	w.Header().Set("Content-Type", "application/json")
 	// Respond with the generated text. Additional metadata could be added
@@ -101,3 +461,26 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("ChatHandler encode error: %v", err)
 	}
 }
+
+// recordToolCall stores a tool invocation in the project's memory with role
+// "tool" so later conversations can see what the assistant did. Nothing is
+// stored without a project.
+func recordToolCall(project string, c tools.Call) {
+	if project == "" {
+		return
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("recordToolCall InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	outcome := c.Result
+	if c.Error != "" {
+		outcome = "error: " + c.Error
+	}
+	content := fmt.Sprintf("%s(%s) -> %s", c.Name, c.Arguments, outcome)
+	if err := memory.AddEntry(db, project, "tool", content); err != nil {
+		log.Printf("recordToolCall AddEntry error: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+// Knowledge base endpoints. Documents uploaded to a project are chunked and
+// embedded by the knowledge package; chat retrieves the most relevant chunks
+// and cites them in its answer.
+
+import (
+	"codex/src/auth"
+	"codex/src/knowledge"
+	"codex/src/memory"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DocumentResult is returned for every document posted to
+// /api/projects/{name}/documents.
+type DocumentResult struct {
+	Source    string `json:"source"`
+	Kind      string `json:"kind,omitempty"`
+	Chunks    int    `json:"chunks"`
+	Unchanged bool   `json:"unchanged,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ProjectItemHandler routes requests below /api/projects/. Document and
+// member requests go to DocumentsHandler and MembersHandler, which members
+// may change and viewers read, and everything else to DeleteProjectHandler,
+// which is reserved to admins. The handlers further check the caller's
+// access to the project itself.
+func ProjectItemHandler(w http.ResponseWriter, r *http.Request) {
+	_, r = identify(w, r)
+	rest := strings.TrimPrefix(r.URL.Path, "/api/projects/")
+	if name, ok := strings.CutSuffix(rest, "/documents"); ok && name != "" {
+		if r.Method == http.MethodGet || auth.Check(w, r, auth.RoleMember, auth.ScopeProjects) {
+			DocumentsHandler(w, r, name)
+		}
+		return
+	}
+	if name, ok := strings.CutSuffix(rest, "/members"); ok && name != "" {
+		if r.Method == http.MethodGet || auth.Check(w, r, auth.RoleMember, auth.ScopeProjects) {
+			MembersHandler(w, r, name)
+		}
+		return
+	}
+	if auth.Check(w, r, auth.RoleAdmin, auth.ScopeAdmin) {
+		DeleteProjectHandler(w, r)
+	}
+}
+
+// DocumentsHandler manages a project's ingested documents. GET lists them,
+// POST ingests multipart "file" uploads or a JSON body {"source","content"}
+// and DELETE removes the document named by the source query parameter.
+// Reading needs read access to the project and changes need write access;
+// uploading to a project that does not exist creates it for the caller.
+func DocumentsHandler(w http.ResponseWriter, r *http.Request, project string) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	db, err := memory.InitDB()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("DocumentsHandler InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+
+	switch r.Method {
+	case http.MethodGet:
+		if !auth.CheckProject(w, r, db, project, memory.AccessRead) {
+			return
+		}
+		docs, err := memory.ListDocuments(db, project, r.URL.Query().Get("prefix"))
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("DocumentsHandler ListDocuments error: %v", err)
+			return
+		}
+		if docs == nil {
+			docs = []memory.Document{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(docs); err != nil {
+			log.Printf("DocumentsHandler encode error: %v", err)
+		}
+	case http.MethodPost:
+		uploads, err := readUploads(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			log.Printf("DocumentsHandler upload error: %v", err)
+			return
+		}
+		if !createProject(w, r, db, project) || !auth.CheckProject(w, r, db, project, memory.AccessWrite) {
+			return
+		}
+		in := &knowledge.Ingester{}
+		results := make([]DocumentResult, 0, len(uploads))
+		for _, u := range uploads {
+			res := in.IngestData(r.Context(), db, project, u.source, u.data, time.Now())
+			dr := DocumentResult{Source: res.Source, Kind: res.Kind, Chunks: res.Chunks, Unchanged: res.Unchanged}
+			if res.Err != nil {
+				dr.Error = res.Err.Error()
+				log.Printf("DocumentsHandler ingest %s error: %v", u.source, res.Err)
+			}
+			results = append(results, dr)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Printf("DocumentsHandler encode error: %v", err)
+		}
+	case http.MethodDelete:
+		source := r.URL.Query().Get("source")
+		if source == "" {
+			http.Error(w, "source required", http.StatusBadRequest)
+			return
+		}
+		if !auth.CheckProject(w, r, db, project, memory.AccessWrite) {
+			return
+		}
+		if err := memory.DeleteDocument(db, project, source); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("DocumentsHandler DeleteDocument error: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// upload is one document received by DocumentsHandler.
+type upload struct {
+	source string
+	data   []byte
+}
+
+// readUploads decodes the documents of a POST request. Multipart bodies may
+// carry several "file" parts; other bodies are JSON with a source name and
+// the text content.
+func readUploads(w http.ResponseWriter, r *http.Request) ([]upload, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, knowledge.MaxFileSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			return nil, err
+		}
+		var out []upload
+		for _, fh := range r.MultipartForm.File["file"] {
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, upload{source: fh.Filename, data: data})
+		}
+		if len(out) == 0 {
+			return nil, errNoDocuments
+		}
+		return out, nil
+	}
+	var req struct {
+		Source  string `json:"source"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Source == "" || req.Content == "" {
+		return nil, errNoDocuments
+	}
+	return []upload{{source: req.Source, data: []byte(req.Content)}}, nil
+}
+
+// errNoDocuments is returned when a POST carries nothing to ingest.
+var errNoDocuments = errors.New(`no documents: send multipart "file" parts or JSON {"source","content"}`)
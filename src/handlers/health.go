@@ -0,0 +1,136 @@
+package handlers
+
+// Health and readiness endpoints. Both report the status cached by the
+// health monitor; /healthz answers 200 while the process can reach its
+// database and /readyz only once the model backend can serve chats.
+
+import (
+	"codex/src/grammar"
+	"codex/src/health"
+	"codex/src/llama"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// currentHealth returns the monitor's cached status, probing directly when no
+// monitor is running or no probe has completed yet.
+func currentHealth(r *http.Request) health.Status {
+	if m := health.Default(); m != nil {
+		if st := m.Status(); st.Known() {
+			return st
+		}
+	}
+	return health.Probe(r.Context())
+}
+
+// writeHealth encodes the status with the given HTTP code.
+func writeHealth(w http.ResponseWriter, code int, st health.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Printf("health encode error: %v", err)
+	}
+}
+
+// HealthzHandler reports liveness. It answers 200 as long as the SQLite
+// store is usable; backend problems are included in the body but do not fail
+// the check so orchestrators do not restart Codex while a model loads.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	st := currentHealth(r)
+	code := http.StatusOK
+	if st.Database.Status != health.StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, st)
+}
+
+// ReadyzHandler reports readiness: 200 when the backend and database are both
+// available, otherwise 503 with a Retry-After hint.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	st := currentHealth(r)
+	if !st.Ready() {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds()))
+		writeHealth(w, http.StatusServiceUnavailable, st)
+		return
+	}
+	writeHealth(w, http.StatusOK, st)
+}
+
+// retryAfterSeconds suggests how long clients should wait before retrying:
+// one monitor interval, or five seconds without a monitor.
+func retryAfterSeconds() int {
+	d := 5 * time.Second
+	if m := health.Default(); m != nil {
+		d = m.Interval()
+	}
+	if s := int(d.Round(time.Second) / time.Second); s > 0 {
+		return s
+	}
+	return 1
+}
+
+// backendUnavailable writes a 503 with Retry-After when the cached health
+// status says the backend cannot serve requests. It returns true when the
+// response was written and the caller should stop.
+func backendUnavailable(w http.ResponseWriter) bool {
+	m := health.Default()
+	if m == nil {
+		return false
+	}
+	st := m.Status()
+	if !st.Known() || st.Backend.Status == health.StatusOK {
+		return false
+	}
+	msg := "model backend unavailable"
+	if st.Backend.Status == health.StatusLoading {
+		msg = "model is loading"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds()))
+	http.Error(w, msg, http.StatusServiceUnavailable)
+	return true
+}
+
+// reportBackendError passes a backend failure to the health monitor and
+// writes the response chosen by backendErrorStatus. Nothing is written when
+// the client has gone away.
+func reportBackendError(w http.ResponseWriter, err error) {
+	if m := health.Default(); m != nil {
+		m.ReportBackendFailure(err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	code, msg := backendErrorStatus(err)
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds()))
+	}
+	http.Error(w, msg, code)
+}
+
+// backendErrorStatus maps a generation error to an HTTP status and message:
+// 503 for an unavailable or loading backend, 413 when the prompt does not fit
+// the context window, 502 for a malformed backend answer or structured output
+// that fails validation and 504 on timeout.
+func backendErrorStatus(err error) (int, string) {
+	var verr *grammar.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		return http.StatusBadGateway, verr.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, llama.ErrTimeout):
+		return http.StatusGatewayTimeout, "LLM request timed out"
+	case errors.Is(err, llama.ErrBackendUnavailable), errors.Is(err, llama.ErrModelLoading):
+		return http.StatusServiceUnavailable, err.Error()
+	case errors.Is(err, llama.ErrContextOverflow):
+		return http.StatusRequestEntityTooLarge, "prompt is too long for the model's context window"
+	case errors.Is(err, llama.ErrBadResponse):
+		return http.StatusBadGateway, "LLM error: " + err.Error()
+	default:
+		return http.StatusInternalServerError, "LLM error: " + err.Error()
+	}
+}
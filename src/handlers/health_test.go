@@ -0,0 +1,96 @@
+package handlers
+
+// Tests for the /healthz and /readyz endpoints against a stand-in llama.cpp
+// server that is ready, loading or unreachable.
+
+import (
+	"codex/src/health"
+	"codex/src/llama"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestHealthEndpoints checks status codes, the reported backend state and
+// Retry-After for each backend state, probing directly and through a
+// monitor.
+func TestHealthEndpoints(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+	prev := llama.DefaultClient()
+	defer llama.SetDefaultClient(prev)
+	defer health.SetDefault(health.Default())
+	health.SetDefault(nil)
+
+	state := "ready"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state == "loading" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model"}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cases := []struct {
+		state    string
+		url      string
+		backend  string
+		readyz   int
+		retryHdr string
+	}{
+		{"ready", srv.URL, health.StatusOK, http.StatusOK, ""},
+		{"loading", srv.URL, health.StatusLoading, http.StatusServiceUnavailable, "5"},
+		{"unavailable", down.URL, health.StatusUnavailable, http.StatusServiceUnavailable, "5"},
+	}
+	for _, tc := range cases {
+		state = tc.state
+		llama.SetDefaultClient(llama.NewClient(tc.url))
+		for _, ep := range []struct {
+			path string
+			h    http.HandlerFunc
+			code int
+		}{
+			// liveness only depends on the database
+			{"/healthz", HealthzHandler, http.StatusOK},
+			{"/readyz", ReadyzHandler, tc.readyz},
+		} {
+			rec := httptest.NewRecorder()
+			ep.h(rec, httptest.NewRequest(http.MethodGet, ep.path, nil))
+			if rec.Code != ep.code {
+				t.Errorf("%s %s: code %d, want %d", tc.state, ep.path, rec.Code, ep.code)
+			}
+			var st health.Status
+			if err := json.NewDecoder(rec.Body).Decode(&st); err != nil || st.Backend.Status != tc.backend {
+				t.Errorf("%s %s: backend %+v (%v), want %s", tc.state, ep.path, st.Backend, err, tc.backend)
+			}
+			want := ""
+			if ep.path == "/readyz" {
+				want = tc.retryHdr
+			}
+			if got := rec.Header().Get("Retry-After"); got != want {
+				t.Errorf("%s %s: Retry-After %q, want %q", tc.state, ep.path, got, want)
+			}
+		}
+	}
+
+	// a monitor's cached failure is served without probing, and clients
+	// are told to come back after one probe interval
+	m := health.NewMonitor(2 * time.Second)
+	health.SetDefault(m)
+	llama.SetDefaultClient(llama.NewClient(srv.URL))
+	state = "ready"
+	m.ReportBackendFailure(&llama.BackendError{Kind: llama.ErrModelLoading, Message: "Loading model"})
+	rec := httptest.NewRecorder()
+	ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("readyz with monitor: code %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+// Project sharing endpoints. Owners add users to their projects as readers,
+// writers or further owners; members can see who else has access.
+
+import (
+	"codex/src/auth"
+	"codex/src/memory"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MemberInfo is one entry of GET /api/projects/{name}/members. Username is
+// empty for anonymous owners.
+type MemberInfo struct {
+	memory.ProjectMember
+	Username string `json:"username,omitempty"`
+}
+
+// MembersHandler manages who may access a project. GET lists the members
+// for anyone who can read the project. POST {"user","access"} grants a user
+// read, write or owner access and DELETE ?user=name revokes it; both need
+// owner access, except that members may always remove themselves.
+func MembersHandler(w http.ResponseWriter, r *http.Request, project string) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	db, err := memory.InitDB()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("MembersHandler InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+
+	switch r.Method {
+	case http.MethodGet:
+		if !auth.CheckProject(w, r, db, project, memory.AccessRead) {
+			return
+		}
+		members, err := memory.ProjectMembers(db, project)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("MembersHandler ProjectMembers error: %v", err)
+			return
+		}
+		res := make([]MemberInfo, 0, len(members))
+		for _, m := range members {
+			res = append(res, MemberInfo{ProjectMember: m, Username: principalName(db, m.Principal)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("MembersHandler encode error: %v", err)
+		}
+	case http.MethodPost:
+		var req struct {
+			User   string `json:"user"`
+			Access string `json:"access"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.User == "" {
+			log.Printf("MembersHandler decode error: %v", err)
+			http.Error(w, "invalid", http.StatusBadRequest)
+			return
+		}
+		if req.Access == "" {
+			req.Access = memory.AccessWrite
+		}
+		if !memory.ValidAccess(req.Access) {
+			http.Error(w, "access must be read, write or owner", http.StatusBadRequest)
+			return
+		}
+		if !auth.CheckProject(w, r, db, project, memory.AccessOwner) {
+			return
+		}
+		u, ok := lookupMember(w, db, req.User)
+		if !ok {
+			return
+		}
+		err := memory.SetProjectMember(db, project, auth.UserPrincipal(u), req.Access)
+		if !memberResult(w, err) {
+			return
+		}
+		log.Printf("MembersHandler %s granted %s %s access to %s", auth.Principal(r.Context()), u.Username, req.Access, project)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("user")
+		if name == "" {
+			http.Error(w, "user required", http.StatusBadRequest)
+			return
+		}
+		u, ok := lookupMember(w, db, name)
+		if !ok {
+			return
+		}
+		principal := auth.UserPrincipal(u)
+		need := memory.AccessOwner
+		if principal == auth.Principal(r.Context()) {
+			need = memory.AccessRead
+		}
+		if !auth.CheckProject(w, r, db, project, need) {
+			return
+		}
+		if !memberResult(w, memory.RemoveProjectMember(db, project, principal)) {
+			return
+		}
+		log.Printf("MembersHandler %s removed %s from %s", auth.Principal(r.Context()), u.Username, project)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lookupMember loads the user named in a members request, answering 404 when
+// there is none.
+func lookupMember(w http.ResponseWriter, db *sql.DB, name string) (*memory.User, bool) {
+	u, err := memory.GetUserByName(db, name)
+	if errors.Is(err, memory.ErrUserNotFound) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("MembersHandler GetUserByName error: %v", err)
+		return nil, false
+	}
+	return u, true
+}
+
+// memberResult reports a membership change error, answering 409 when it
+// would leave the project without an owner.
+func memberResult(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, memory.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, memory.ErrProjectNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("MembersHandler update error: %v", err)
+	}
+	return false
+}
+
+// principalName returns the username behind a "user:<id>" principal or ""
+// for anonymous principals and deleted users.
+func principalName(db *sql.DB, principal string) string {
+	id, ok := strings.CutPrefix(principal, "user:")
+	if !ok {
+		return ""
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return ""
+	}
+	u, err := memory.GetUser(db, n)
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
@@ -0,0 +1,112 @@
+package handlers
+
+// Prometheus metrics of the HTTP API. Instrument counts and times every
+// request by the route that served it; MetricsHandler serves these together
+// with the metrics of the llama, models and memory packages.
+
+import (
+	"codex/src/metrics"
+	"codex/src/queue"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("codex_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = metrics.NewHistogramVec("codex_http_request_duration_seconds",
+		"Time to serve HTTP requests by route. Chats and downloads include generation and transfer.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "route")
+)
+
+// queueStat reads one number of the chat queue's statistics at scrape time.
+func queueStat(f func(queue.Stats) float64) func() float64 {
+	return func() float64 {
+		q := queue.Default()
+		if q == nil {
+			return 0
+		}
+		return f(q.Stats())
+	}
+}
+
+func init() {
+	metrics.NewGaugeFunc("codex_chat_queue_depth", "Chat requests waiting for the model backend.",
+		queueStat(func(s queue.Stats) float64 { return float64(s.Queued) }))
+	metrics.NewGaugeFunc("codex_chat_in_flight", "Chat requests being answered by the model backend.",
+		queueStat(func(s queue.Stats) float64 { return float64(s.InFlight) }))
+	metrics.NewCounterFunc("codex_chat_queue_rejected_total", "Chat requests turned away because the queue was full.",
+		queueStat(func(s queue.Stats) float64 { return float64(s.Rejected) }))
+}
+
+// MetricsHandler serves every metric in the Prometheus text format. Like the
+// health endpoints it does not log each scrape.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	metrics.Default().Handler().ServeHTTP(w, r)
+}
+
+// Instrument wraps h, the server's handler chain, so each request is
+// counted and timed under the pattern routes matched it with, such as
+// "/api/projects/". Paths no route matches share one label so scanners
+// cannot grow the number of series.
+func Instrument(routes *http.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := routes.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(rec, r)
+		code := rec.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		httpRequests.With(route, methodLabel(r.Method), strconv.Itoa(code)).Inc()
+		httpDuration.With(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel limits the method label to the standard methods.
+func methodLabel(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code of a response. It passes Flush
+// through for event streams and Unwrap for http.ResponseController.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
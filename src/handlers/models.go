@@ -1,19 +1,41 @@
 package handlers
 
 import (
+	"codex/src/auth"
 	"codex/src/llama"
 	"codex/src/memory"
 	"codex/src/models"
+	"codex/src/notify"
+	"codex/src/webhook"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// ModelsHandler lists Hugging Face models for a pipeline type.
-// It requires the `pipeline` query parameter.
+// ModelsHandler lists cached Hugging Face models. The optional `pipeline`
+// parameter selects a pipeline type; when its cache is empty (or `refresh=1`
+// is given) the list is fetched from Hugging Face first. Results can be
+// narrowed and paged with:
+//
+//	q                 substring of the model ID
+//	sort, order       downloads|likes|modified|id and asc|desc
+//	limit, cursor     page size and the cursor from X-Next-Cursor
+//	tag               required tag (repeatable)
+//	license           license name, e.g. apache-2.0
+//	gguf=1            only models with GGUF files
+//	llama_compatible=1
+//	backend           entry in compatible_backends, e.g. onnx
+//
+// The body is a JSON array for compatibility with existing clients. The next
+// page cursor and total match count are sent in the X-Next-Cursor and
+// X-Total-Count headers, and a Link header points at the next page. In
+// offline mode the list is served exclusively from the model_cache table.
 func ModelsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s", r.Method, r.URL.String())
 	if r.Method != http.MethodGet {
@@ -21,47 +43,114 @@ func ModelsHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("ModelsHandler method not allowed: %s", r.Method)
 		return
 	}
-	pipeline := r.URL.Query().Get("pipeline")
-	if pipeline == "" {
+	query, err := parseModelQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("ModelsHandler bad query: %v", err)
+		return
+	}
+	pipeline := query.Pipeline
+	offline := models.Offline()
+	refresh := r.URL.Query().Get("refresh") == "1" && !offline
+	if refresh && pipeline == "" {
 		http.Error(w, "pipeline required", http.StatusBadRequest)
-		log.Printf("ModelsHandler missing pipeline")
+		log.Printf("ModelsHandler refresh without pipeline")
+		return
+	}
+	// forcing a refresh contacts Hugging Face and is reserved to admins
+	if refresh && !auth.Check(w, r, auth.RoleAdmin, auth.ScopeModelsAdmin) {
 		return
 	}
-	refresh := r.URL.Query().Get("refresh") == "1"
 	db, err := memory.InitDB()
-	if err == nil {
-		defer db.Close()
-	} else if err != nil {
+	if err != nil {
 		log.Printf("ModelsHandler InitDB error: %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
 	}
+	defer db.Close()
 
-	var list []models.ModelInfo
-	if db != nil && !refresh {
-		list, err = memory.GetModelList(db, pipeline)
+	if pipeline != "" && !offline {
+		cached, err := memory.CountCachedModels(db, pipeline)
 		if err != nil {
-			log.Printf("ModelsHandler GetModelList error: %v", err)
+			log.Printf("ModelsHandler CountCachedModels error: %v", err)
 		}
-	}
-	if len(list) == 0 || refresh {
-		list, err = models.ListModelsByType(pipeline)
-		if err != nil {
-			log.Printf("ModelsHandler ListModelsByType error: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if db != nil {
+		if cached == 0 || refresh {
+			list, err := models.ListModelsByType(pipeline)
+			if err != nil {
+				log.Printf("ModelsHandler ListModelsByType error: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			if err := memory.SaveModelList(db, pipeline, list); err != nil {
 				log.Printf("ModelsHandler SaveModelList error: %v", err)
 			}
 		}
 	}
+
+	res, err := memory.QueryModelCache(db, query)
+	if errors.Is(err, memory.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ModelsHandler QueryModelCache error: %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	log.Printf("ModelsHandler response count=%d", len(list))
-	if err := json.NewEncoder(w).Encode(list); err != nil {
+	w.Header().Set("X-Total-Count", strconv.Itoa(res.Total))
+	if res.Next != "" {
+		next := *r.URL
+		q := next.Query()
+		q.Set("cursor", res.Next)
+		q.Del("refresh")
+		next.RawQuery = q.Encode()
+		w.Header().Set("X-Next-Cursor", res.Next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	log.Printf("ModelsHandler response count=%d", len(res.Models))
+	if err := json.NewEncoder(w).Encode(res.Models); err != nil {
 		log.Printf("ModelsHandler encode error: %v", err)
 	}
 }
 
+// parseModelQuery converts the query string of a models listing into a
+// memory.ModelQuery, validating numeric and enumerated values.
+func parseModelQuery(v url.Values) (memory.ModelQuery, error) {
+	q := memory.ModelQuery{
+		Pipeline:        v.Get("pipeline"),
+		Q:               v.Get("q"),
+		Sort:            v.Get("sort"),
+		Order:           strings.ToLower(v.Get("order")),
+		Cursor:          v.Get("cursor"),
+		Tags:            v["tag"],
+		License:         v.Get("license"),
+		GGUF:            v.Get("gguf") == "1",
+		LlamaCompatible: v.Get("llama_compatible") == "1",
+		Backend:         v.Get("backend"),
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			return q, fmt.Errorf("limit must be between 1 and 1000")
+		}
+		q.Limit = n
+	} else if q.Cursor != "" {
+		return q, fmt.Errorf("cursor requires limit")
+	}
+	switch q.Sort {
+	case "", "downloads", "likes", "modified", "id":
+	default:
+		return q, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	switch q.Order {
+	case "", "asc", "desc":
+	default:
+		return q, fmt.Errorf("unknown order %q", q.Order)
+	}
+	return q, nil
+}
+
 // ModelActionHandler exposes actions such as download and enable for a specific
 // model. The action is taken from the URL path after the model ID.
 func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,7 +169,10 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		id := parts[0]
-		refresh := r.URL.Query().Get("refresh") == "1"
+		refresh := r.URL.Query().Get("refresh") == "1" && !models.Offline()
+		if refresh && !auth.Check(w, r, auth.RoleAdmin, auth.ScopeModelsAdmin) {
+			return
+		}
 		db, err := memory.InitDB()
 		if err == nil {
 			defer db.Close()
@@ -97,6 +189,11 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if md == nil || refresh {
 			md, err = models.GetModelMetadata(id)
+			if errors.Is(err, models.ErrOffline) {
+				log.Printf("ModelActionHandler %s not cached in offline mode", id)
+				http.Error(w, "model not cached (offline mode)", http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				log.Printf("ModelActionHandler GetModelMetadata remote error: %v", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -133,6 +230,10 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		stats, err := models.GetGlobalStats()
+		if errors.Is(err, models.ErrOffline) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -142,6 +243,11 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Downloading and activating models is reserved to admins.
+	if (action == "enable" || action == "download") && !auth.Check(w, r, auth.RoleAdmin, auth.ScopeModelsAdmin) {
+		return
+	}
+
 	switch action {
 	case "enable":
 		if r.Method != http.MethodPost {
@@ -150,17 +256,20 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("ModelActionHandler enable id=%s", id)
-		path, err := models.ActivateModel(id)
+		lm, err := models.ActivateModel(id)
 		if err != nil {
 			log.Printf("ActivateModel error: %v", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if err := llama.LoadModel(path); err != nil {
-			log.Printf("LoadModel error: %v", err)
+		// A managed backend is restarted with the new model; an external
+		// one is asked to reload it in place.
+		if err := llama.UseModel(lm.ID, lm.File); err != nil {
+			log.Printf("UseModel error: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		webhook.Emit(webhook.EventModelActivated, webhook.ModelActivated{Model: lm.ID, File: lm.File})
 		w.WriteHeader(http.StatusNoContent)
 	case "download":
 		if r.Method != http.MethodGet {
@@ -177,17 +286,30 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
+		noWriteTimeout(w)
 		progress := func(done, total int) {
 			pct := int(float64(done) / float64(total) * 100)
 			fmt.Fprintf(w, "data: %d\n\n", pct)
 			flusher.Flush()
 		}
-		if _, err := models.DownloadModelWithProgress(id, progress); err != nil {
-			log.Printf("DownloadModelWithProgress error: %v", err)
+		start := time.Now()
+		// The download continues when the client goes away so admins
+		// are still notified, but stops cleanly on server shutdown.
+		sha, err := models.DownloadModelContext(serverContext(), id, progress)
+		if err == nil {
+			_, err = models.RecordDownload(id, sha)
+		}
+		report := notify.Download{Model: id, Version: sha, Duration: time.Since(start).Round(time.Second), Time: time.Now()}
+		if err != nil {
+			log.Printf("ModelActionHandler download %s error: %v", id, err)
+			report.Error = err.Error()
+			notify.Send(notify.EventDownloadFailed, report)
 			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
 			flusher.Flush()
 			return
 		}
+		notify.Send(notify.EventDownloadDone, report)
+		webhook.Emit(webhook.EventModelDownloaded, webhook.ModelDownloaded{Model: id, Version: sha})
 		fmt.Fprintf(w, "event: done\ndata: ok\n\n")
 		flusher.Flush()
 	case "stats":
@@ -197,7 +319,17 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("ModelActionHandler stats id=%s", id)
-		stats, err := models.GetModelDetail(id)
+		var stats *models.ModelDetail
+		var err error
+		if models.Offline() {
+			stats, err = cachedModelDetail(id)
+			if err == nil && stats == nil {
+				http.Error(w, "model not cached (offline mode)", http.StatusNotFound)
+				return
+			}
+		} else {
+			stats, err = models.GetModelDetail(id)
+		}
 		if err != nil {
 			log.Printf("GetModelDetail stats error: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -212,6 +344,40 @@ func ModelActionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ImportModelHandler registers a GGUF file or directory that already exists on
+// the server's disk as a local model. It accepts a JSON body with the path, an
+// optional ID and an optional mode ("link" or "copy") and responds with the
+// resulting LocalModel entry.
+func ImportModelHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("ImportModelHandler method not allowed")
+		return
+	}
+	var req struct {
+		Path string `json:"path"`
+		ID   string `json:"id"`
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		log.Printf("ImportModelHandler decode error: %v", err)
+		http.Error(w, "invalid", http.StatusBadRequest)
+		return
+	}
+	lm, err := models.ImportModel(req.Path, models.ImportOptions{ID: req.ID, Mode: models.ImportMode(req.Mode)})
+	if err != nil {
+		log.Printf("ImportModelHandler ImportModel error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(lm); err != nil {
+		log.Printf("ImportModelHandler encode error: %v", err)
+	}
+}
+
 // RefreshModelsHandler re-fetches model listings from Hugging Face and updates
 // the local database cache. The pipeline parameter must be supplied via query
 // string and the handler responds with the latest list.
@@ -229,6 +395,11 @@ func RefreshModelsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	list, err := models.ListModelsByType(pipeline)
+	if errors.Is(err, models.ErrOffline) {
+		log.Printf("RefreshModelsHandler skipped in offline mode")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		log.Printf("RefreshModelsHandler ListModelsByType error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -248,3 +419,28 @@ func RefreshModelsHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("RefreshModelsHandler encode error: %v", err)
 	}
 }
+
+// cachedModelDetail reads a model's detail from the model_cache table. It
+// returns nil without error when the model has not been cached.
+func cachedModelDetail(id string) (*models.ModelDetail, error) {
+	db, err := memory.InitDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return memory.GetModelDetail(db, id)
+}
+
+// BackendStatusHandler reports the state of the llama.cpp process when it is
+// managed by Codex, including the tail of its log output.
+func BackendStatusHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(llama.BackendStatus()); err != nil {
+		log.Printf("BackendStatusHandler encode error: %v", err)
+	}
+}
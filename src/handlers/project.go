@@ -4,7 +4,10 @@ package handlers
 // contexts.  Each project maps to a separate memory namespace in the database.
 
 import (
+	"codex/src/auth"
 	"codex/src/memory"
+	"codex/src/webhook"
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -16,18 +19,21 @@ import (
 
 // ProjectsResponse is returned by GET /api/projects
 type ProjectsResponse struct {
-	// Projects contains the list of all known project names.
+	// Projects contains the names of the projects the caller may see.
 	Projects []string `json:"projects"`
-	// Active indicates which project is currently selected.
+	// Active indicates which project the caller has selected.
 	Active string `json:"active"`
 }
 
 // ProjectsHandler handles listing and creating projects. It responds to both
 // GET and POST on the /api/projects endpoint and interacts with the memory package
-// for persistence. Extension Point: additional methods such as PUT could be
-// added here to extend project metadata management.
+// for persistence. Listings only include projects shared with the caller and
+// new projects are owned by their creator. Extension Point: additional
+// methods such as PUT could be added here to extend project metadata
+// management.
 func ProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s", r.Method, r.URL.Path)
+	_, r = identify(w, r)
 	db, err := memory.InitDB()
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
@@ -41,13 +47,13 @@ func ProjectsHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		list, err := memory.ListProjects(db)
+		list, err := auth.VisibleProjects(r.Context(), db)
 		if err != nil {
 			http.Error(w, "db error", http.StatusInternalServerError)
 			log.Printf("ProjectsHandler ListProjects error: %v", err)
 			return
 		}
-		active, _ := memory.GetActiveProject(db)
+		active, _ := auth.ActiveProject(r.Context(), db)
 		resp := ProjectsResponse{Projects: list, Active: active}
 		log.Printf("ProjectsHandler response %+v", resp)
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -62,9 +68,7 @@ func ProjectsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid", http.StatusBadRequest)
 			return
 		}
-		if err := memory.AddProject(db, req.Name); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
-			log.Printf("ProjectsHandler AddProject error: %v", err)
+		if !createProject(w, r, db, req.Name) {
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
@@ -74,12 +78,47 @@ func ProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SwitchProjectHandler sets the active project. Clients post a project name to
-// /api/projects/switch to change context for subsequent chat operations. AI
-// Awareness: by switching project, the assistant focuses memory queries on a
-// different conversation context.
+// createProject creates name owned by the caller. Creating a project the
+// caller can already see succeeds without changes; a name taken by a project
+// the caller cannot see answers 409. It reports whether the project is
+// usable.
+//
+// An anonymous client that sent no "anon" cookie, such as curl, would get a
+// new principal on every request and lose its project straight away, so
+// its projects are created shared with everyone instead.
+func createProject(w http.ResponseWriter, r *http.Request, db *sql.DB, name string) bool {
+	exists, err := memory.ProjectExists(db, name)
+	if err == nil && exists {
+		var access string
+		if access, err = auth.ProjectAccess(r.Context(), db, name); err == nil && access == "" {
+			http.Error(w, "project name already taken", http.StatusConflict)
+			return false
+		}
+	}
+	if err == nil && !exists {
+		owner := auth.Principal(r.Context())
+		if auth.CurrentUser(r.Context()) == nil && !hasAnonCookie(r) {
+			log.Printf("createProject %s: no anonymous cookie, creating a shared project", name)
+			owner = ""
+		}
+		err = memory.CreateProject(db, name, owner)
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("createProject %s error: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// SwitchProjectHandler sets the caller's active project. Clients post a
+// project name to /api/projects/switch to change context for their own
+// subsequent chat operations; other users keep theirs. AI Awareness: by
+// switching project, the assistant focuses memory queries on a different
+// conversation context.
 func SwitchProjectHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s", r.Method, r.URL.Path)
+	_, r = identify(w, r)
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		log.Printf("SwitchProjectHandler method not allowed")
@@ -101,7 +140,10 @@ func SwitchProjectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid", http.StatusBadRequest)
 		return
 	}
-	if err := memory.SetActiveProject(db, req.Name); err != nil {
+	if !auth.CheckProject(w, r, db, req.Name, memory.AccessRead) {
+		return
+	}
+	if err := memory.SetActiveProjectFor(db, auth.Principal(r.Context()), req.Name); err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		log.Printf("SwitchProjectHandler SetActiveProject error: %v", err)
 		return
@@ -127,8 +169,8 @@ func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	// Remove the project using the memory package which will also tidy up
-	// any associated settings.
+	// Remove the project using the memory package which also deletes its
+	// memories, documents and members and tidies up associated settings.
 	db, err := memory.InitDB()
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
@@ -141,14 +183,16 @@ func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("DeleteProjectHandler DeleteProject error: %v", err)
 		return
 	}
+	webhook.Emit(webhook.EventProjectDeleted, webhook.ProjectDeleted{Project: name, Principal: auth.Principal(r.Context())})
 	w.WriteHeader(http.StatusOK)
 }
 
-// RenameProjectHandler renames a project. This updates all stored memories and
-// the active project setting so the assistant remains consistent across the
-// database.
+// RenameProjectHandler renames a project. This updates all stored memories,
+// members and active project settings so the assistant remains consistent
+// across the database. Only the project's owners may rename it.
 func RenameProjectHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s", r.Method, r.URL.Path)
+	_, r = identify(w, r)
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		log.Printf("RenameProjectHandler method not allowed")
@@ -170,6 +214,17 @@ func RenameProjectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid", http.StatusBadRequest)
 		return
 	}
+	if !auth.CheckProject(w, r, db, req.Old, memory.AccessOwner) {
+		return
+	}
+	if exists, err := memory.ProjectExists(db, req.New); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("RenameProjectHandler ProjectExists error: %v", err)
+		return
+	} else if exists {
+		http.Error(w, "project name already taken", http.StatusConflict)
+		return
+	}
 	if err := memory.RenameProject(db, req.Old, req.New); err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		log.Printf("RenameProjectHandler RenameProject error: %v", err)
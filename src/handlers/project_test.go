@@ -0,0 +1,55 @@
+package handlers
+
+// Tests for project creation by anonymous clients with and without the
+// "anon" cookie.
+
+import (
+	"codex/src/auth"
+	"codex/src/memory"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCreateProjectAnonymous checks that a browser with its cookie owns the
+// projects it creates while a cookieless client creates shared ones, which
+// it can still use on its next request.
+func TestCreateProjectAnonymous(t *testing.T) {
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(t.TempDir())
+
+	create := func(name, cookie string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/projects", strings.NewReader(`{"name":"`+name+`"}`))
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: auth.AnonCookieName, Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		ProjectsHandler(rec, r)
+		return rec.Code
+	}
+	if code := create("owned", "b1"); code != http.StatusCreated {
+		t.Fatalf("create with cookie: %d", code)
+	}
+	if code := create("scripted", ""); code != http.StatusCreated {
+		t.Fatalf("create without cookie: %d", code)
+	}
+	// a second cookieless request is a different principal but may use it
+	if code := create("scripted", ""); code != http.StatusCreated {
+		t.Fatalf("repeat create without cookie: %d", code)
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	if m, _ := memory.ProjectMembers(db, "owned"); len(m) != 1 || m[0].Principal != "anon:b1" {
+		t.Fatalf("owned members = %+v", m)
+	}
+	if m, _ := memory.ProjectMembers(db, "scripted"); len(m) != 0 {
+		t.Fatalf("cookieless project should be shared, members = %+v", m)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+// Queue related endpoints. Chat requests wait in the queue package's fair
+// queue before reaching the model backend; streaming clients are told their
+// position over server-sent events while they wait.
+
+import (
+	"codex/src/auth"
+	"codex/src/health"
+	"codex/src/queue"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// queueUser returns the key used for per-user fairness: the logged in user,
+// the anonymous cookie when the client sent one, and otherwise the client
+// address. A cookie minted for this request is ignored, or every cookie-less
+// script request would count as a new user.
+func queueUser(r *http.Request) string {
+	if u := auth.CurrentUser(r.Context()); u != nil {
+		return auth.UserPrincipal(u)
+	}
+	if c, err := r.Cookie(auth.AnonCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// wantsStream reports whether the client asked for server-sent events, either
+// through the Accept header or ?stream=1.
+func wantsStream(r *http.Request) bool {
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return true
+	}
+	v := r.URL.Query().Get("stream")
+	return v == "1" || v == "true"
+}
+
+// sseEvent writes one server-sent event with a JSON payload and flushes it.
+func sseEvent(w http.ResponseWriter, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("sse encode error: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// streamChat serves a queued chat request as a server-sent event stream. The
+// client receives `queue` events with its position while waiting, then a
+// single `message` event with the ChatResponse or an `error` event with the
+// HTTP status the request would otherwise have returned.
+func streamChat(w http.ResponseWriter, r *http.Request, t *queue.Ticket, req ChatRequest, opts chatOptions) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+wait:
+	for {
+		select {
+		case p := <-t.Position():
+			sseEvent(w, "queue", map[string]int{"position": p})
+		case <-t.Ready():
+			break wait
+		case <-ctx.Done():
+			log.Printf("ChatHandler stream left queue: %v", ctx.Err())
+			return
+		}
+	}
+	sseEvent(w, "queue", map[string]int{"position": 0})
+
+	res, err := generate(ctx, req, opts)
+	if err != nil {
+		log.Printf("ChatHandler llama error: %v", err)
+		if m := health.Default(); m != nil {
+			m.ReportBackendFailure(err)
+		}
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		code, msg := backendErrorStatus(err)
+		sseEvent(w, "error", map[string]interface{}{"status": code, "error": msg})
+		return
+	}
+	sseEvent(w, "message", res)
+}
+
+// QueueStatsHandler returns the chat queue counters as JSON. Without a queue
+// it reports an empty object.
+func QueueStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var st interface{} = struct{}{}
+	if q := queue.Default(); q != nil {
+		st = q.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Printf("QueueStatsHandler encode error: %v", err)
+	}
+}
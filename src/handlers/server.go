@@ -0,0 +1,44 @@
+package handlers
+
+// Hooks into the HTTP server's lifecycle. `codex serve` registers a context
+// that ends when the server shuts down, and long running responses lift the
+// server's write timeout for themselves.
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	serverMu  sync.RWMutex
+	serverCtx = context.Background()
+)
+
+// SetServerContext registers a context cancelled when the server stops.
+// Work that may outlive its request, such as a model download whose client
+// went away, stops with it instead of being killed mid-write.
+func SetServerContext(ctx context.Context) {
+	serverMu.Lock()
+	serverCtx = ctx
+	serverMu.Unlock()
+}
+
+// serverContext returns the context registered with SetServerContext.
+func serverContext() context.Context {
+	serverMu.RLock()
+	defer serverMu.RUnlock()
+	return serverCtx
+}
+
+// noWriteTimeout lifts the server's write timeout for a response that may
+// take as long as generation or a transfer does, such as chats and event
+// streams. Their end is bounded by the request context instead.
+func noWriteTimeout(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("clear write deadline error: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+// Tool discovery endpoint. Clients list the registered tools before enabling
+// them on a chat request through ChatRequest.Tools.
+
+import (
+	"codex/src/tools"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ToolsHandler returns the name, description and parameter schema of every
+// registered tool.
+func ToolsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tools.Default().List()); err != nil {
+		log.Printf("ToolsHandler encode error: %v", err)
+	}
+}
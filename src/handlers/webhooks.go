@@ -0,0 +1,212 @@
+package handlers
+
+// Webhook management endpoints. Admins register URLs that receive signed
+// event deliveries and inspect the delivery log of each webhook.
+
+import (
+	"codex/src/memory"
+	"codex/src/webhook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookCreated is returned by POST /api/webhooks. The secret is only
+// shown here.
+type WebhookCreated struct {
+	memory.Webhook
+	Secret string `json:"secret"`
+}
+
+// defaultDeliveries is how many log entries GET .../deliveries returns
+// without a limit parameter.
+const defaultDeliveries = 50
+
+// WebhooksHandler lists webhooks on GET and registers one on POST with a
+// JSON body {"url", "events", "secret"}. Events default to every event and a
+// random secret is generated when none is given.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	db, err := memory.InitDB()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("WebhooksHandler InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := memory.ListWebhooks(db)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhooksHandler ListWebhooks error: %v", err)
+			return
+		}
+		if hooks == nil {
+			hooks = []memory.Webhook{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(hooks); err != nil {
+			log.Printf("WebhooksHandler encode error: %v", err)
+		}
+	case http.MethodPost:
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Secret string   `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("WebhooksHandler decode error: %v", err)
+			http.Error(w, "invalid", http.StatusBadRequest)
+			return
+		}
+		if err := validateWebhook(req.URL, req.Events); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Events) == 0 {
+			req.Events = []string{"*"}
+		}
+		if req.Secret == "" {
+			if req.Secret, err = webhook.NewSecret(); err != nil {
+				http.Error(w, "cannot generate secret", http.StatusInternalServerError)
+				return
+			}
+		}
+		h := &memory.Webhook{URL: req.URL, Secret: req.Secret, Events: req.Events, Active: true, CreatedAt: time.Now()}
+		if err := memory.CreateWebhook(db, h); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhooksHandler CreateWebhook error: %v", err)
+			return
+		}
+		log.Printf("WebhooksHandler created webhook %d for %s (%s)", h.ID, h.URL, strings.Join(h.Events, ","))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(WebhookCreated{Webhook: *h, Secret: h.Secret}); err != nil {
+			log.Printf("WebhooksHandler encode error: %v", err)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateWebhook checks the URL and event filter of a new webhook.
+func validateWebhook(raw string, events []string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	for _, e := range events {
+		if !webhook.ValidEvent(e) {
+			return fmt.Errorf("unknown event %q (want * or %s)", e, strings.Join(webhook.Events, ", "))
+		}
+	}
+	return nil
+}
+
+// WebhookItemHandler serves /api/webhooks/{id}: GET shows the webhook,
+// PATCH {"active": false} pauses it and DELETE removes it with its log.
+// GET /api/webhooks/{id}/deliveries?limit=n returns the newest deliveries
+// and POST /api/webhooks/{id}/ping sends a test event.
+func WebhookItemHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	rest := strings.TrimPrefix(r.URL.Path, "/api/webhooks/")
+	idPart, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("WebhookItemHandler InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	h, err := memory.GetWebhook(db, id)
+	if errors.Is(err, memory.ErrWebhookNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		log.Printf("WebhookItemHandler GetWebhook error: %v", err)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, h)
+	case action == "" && r.Method == http.MethodPatch:
+		var req struct {
+			Active *bool `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Active == nil {
+			http.Error(w, `invalid: send {"active": true|false}`, http.StatusBadRequest)
+			return
+		}
+		if err := memory.SetWebhookActive(db, id, *req.Active); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhookItemHandler SetWebhookActive error: %v", err)
+			return
+		}
+		h.Active = *req.Active
+		writeJSON(w, h)
+	case action == "" && r.Method == http.MethodDelete:
+		if err := memory.DeleteWebhook(db, id); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhookItemHandler DeleteWebhook error: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "deliveries" && r.Method == http.MethodGet:
+		limit := defaultDeliveries
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		dels, err := memory.ListDeliveries(db, id, limit)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhookItemHandler ListDeliveries error: %v", err)
+			return
+		}
+		if dels == nil {
+			dels = []memory.Delivery{}
+		}
+		writeJSON(w, dels)
+	case action == "ping" && r.Method == http.MethodPost:
+		body, _ := json.Marshal(webhook.Payload{Event: webhook.EventPing, CreatedAt: time.Now().UTC(), Data: webhook.Ping{Webhook: id}})
+		if err := webhook.Enqueue(db, id, webhook.EventPing, body); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("WebhookItemHandler Enqueue error: %v", err)
+			return
+		}
+		if d := webhook.Default(); d != nil {
+			d.Wake()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case action == "" || action == "deliveries" || action == "ping":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON encodes v as the response body.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("encode error: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package health
+
+// Package health tracks whether the assistant can currently serve requests.
+// A Monitor periodically probes the llama.cpp backend, the SQLite store and
+// the model state file and caches the result so request handlers can check
+// readiness without adding latency.  The HTTP handlers expose the cached
+// status on /healthz and /readyz.
+//
+// AI Awareness: ChatHandler consults this status before forwarding prompts so
+// a missing or loading model produces a clear 503 instead of a timeout.
+
+import (
+	"codex/src/llama"
+	"codex/src/memory"
+	"codex/src/models"
+	"codex/src/notify"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Component states.
+const (
+	StatusOK          = "ok"
+	StatusLoading     = "loading"
+	StatusUnavailable = "unavailable"
+	StatusError       = "error"
+	StatusUnknown     = "unknown"
+)
+
+// Component describes the result of probing one dependency.
+type Component struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Status is the combined result of the most recent probe.
+type Status struct {
+	Backend  Component `json:"backend"`
+	Database Component `json:"database"`
+	Model    Component `json:"model"`
+	// ActiveModel is the ID of the model selected in state.json.
+	ActiveModel string    `json:"active_model,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// Ready reports whether chat requests can be served.
+func (s Status) Ready() bool {
+	return s.Backend.Status == StatusOK && s.Database.Status == StatusOK
+}
+
+// Known reports whether any probe has completed.
+func (s Status) Known() bool {
+	return !s.CheckedAt.IsZero()
+}
+
+// Probe checks every dependency once. It is used by the monitor and can be
+// called directly when no monitor is running.
+func Probe(ctx context.Context) Status {
+	st := Status{CheckedAt: time.Now()}
+
+	bctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	err := llama.Health(bctx)
+	cancel()
+	switch {
+	case err == nil:
+		st.Backend.Status = StatusOK
+	case errors.Is(err, llama.ErrModelLoading):
+		st.Backend = Component{Status: StatusLoading, Error: err.Error()}
+	case errors.Is(err, llama.ErrBackendUnavailable):
+		st.Backend = Component{Status: StatusUnavailable, Error: err.Error()}
+	default:
+		st.Backend = Component{Status: StatusError, Error: err.Error()}
+	}
+
+	st.Database.Status = StatusOK
+	if db, err := memory.InitDB(); err != nil {
+		st.Database = Component{Status: StatusError, Error: err.Error()}
+	} else {
+		if err := db.PingContext(ctx); err != nil {
+			st.Database = Component{Status: StatusError, Error: err.Error()}
+		}
+		db.Close()
+	}
+
+	// A missing active model is reported but does not make the service
+	// unready: an external backend may have been started with a model
+	// that Codex does not track.
+	st.Model.Status = StatusOK
+	if lm, err := models.ActiveModel(); err != nil {
+		st.Model = Component{Status: StatusError, Error: err.Error()}
+	} else if lm == nil {
+		st.Model = Component{Status: StatusUnknown, Error: "no active model set"}
+	} else {
+		st.ActiveModel = lm.ID
+	}
+	return st
+}
+
+// alertInterval is the least time between two emails about the backend
+// becoming unhealthy, so a flapping backend does not flood the inbox.
+const alertInterval = 15 * time.Minute
+
+// Monitor periodically probes dependencies and caches the result.
+type Monitor struct {
+	interval time.Duration
+
+	mu        sync.RWMutex
+	status    Status
+	wake      chan struct{}
+	lastAlert time.Time
+}
+
+// NewMonitor returns a monitor probing every interval.
+func NewMonitor(interval time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Monitor{interval: interval, wake: make(chan struct{}, 1)}
+}
+
+// Interval returns the time between probes.
+func (m *Monitor) Interval() time.Duration {
+	return m.interval
+}
+
+// Run probes until ctx is cancelled. The first probe happens immediately.
+func (m *Monitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		m.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// check runs a probe and stores the result, logging state changes.
+func (m *Monitor) check(ctx context.Context) {
+	st := Probe(ctx)
+	m.mu.Lock()
+	prev := m.status
+	m.status = st
+	m.mu.Unlock()
+	if prev.Backend.Status != st.Backend.Status {
+		log.Printf("health: backend %s -> %s %s", prev.Backend.Status, st.Backend.Status, st.Backend.Error)
+		m.alert(prev, st)
+	}
+	if prev.Database.Status != st.Database.Status {
+		log.Printf("health: database %s -> %s %s", prev.Database.Status, st.Database.Status, st.Database.Error)
+	}
+}
+
+// Status returns the cached probe result.
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// ReportBackendFailure records a backend error observed while serving a
+// request so later requests fail fast, and schedules an early re-probe.
+// Timeouts, overflowing prompts and bad answers come from a server that is
+// up and are ignored.
+func (m *Monitor) ReportBackendFailure(err error) {
+	status := StatusError
+	switch {
+	case errors.Is(err, llama.ErrModelLoading):
+		status = StatusLoading
+	case errors.Is(err, llama.ErrBackendUnavailable):
+		status = StatusUnavailable
+	default:
+		return
+	}
+	m.mu.Lock()
+	prev := m.status
+	m.status.Backend = Component{Status: status, Error: err.Error()}
+	m.status.CheckedAt = time.Now()
+	st := m.status
+	m.mu.Unlock()
+	m.alert(prev, st)
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// alert emails the admins when the backend goes from ok to unavailable or
+// failing. Loading is expected while models switch and is not reported.
+func (m *Monitor) alert(prev, st Status) {
+	if prev.Backend.Status != StatusOK || (st.Backend.Status != StatusUnavailable && st.Backend.Status != StatusError) {
+		return
+	}
+	m.mu.Lock()
+	if time.Since(m.lastAlert) < alertInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastAlert = time.Now()
+	m.mu.Unlock()
+	notify.Send(notify.EventBackendUnhealthy, notify.Backend{
+		Status: st.Backend.Status,
+		Error:  st.Backend.Error,
+		Model:  prev.ActiveModel,
+		Time:   st.CheckedAt,
+	})
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultMonitor *Monitor
+)
+
+// SetDefault registers the monitor used by the HTTP handlers.
+func SetDefault(m *Monitor) {
+	defaultMu.Lock()
+	defaultMonitor = m
+	defaultMu.Unlock()
+}
+
+// Default returns the registered monitor or nil.
+func Default() *Monitor {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultMonitor
+}
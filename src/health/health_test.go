@@ -0,0 +1,114 @@
+package health
+
+// Tests for the health monitor. A stand-in llama.cpp server answers /health
+// so the monitor sees a ready, loading or unreachable backend.
+
+import (
+	"codex/src/llama"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Backend states served by fakeBackend.
+const (
+	backendReady   = "ready"
+	backendLoading = "loading"
+	backendDown    = "down"
+)
+
+// fakeBackend makes the default llama client talk to a server in state.
+func fakeBackend(t *testing.T, state string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state == backendLoading {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model"}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	if state == backendDown {
+		srv.Close()
+	} else {
+		t.Cleanup(srv.Close)
+	}
+	prev := llama.DefaultClient()
+	t.Cleanup(func() { llama.SetDefaultClient(prev) })
+	llama.SetDefaultClient(llama.NewClient(srv.URL))
+}
+
+// inTempDir runs the test in an empty directory so the database and model
+// state are fresh.
+func inTempDir(t *testing.T) {
+	cwd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(cwd) })
+	os.Chdir(t.TempDir())
+}
+
+// TestMonitorStates checks the cached status after each probe as the
+// backend changes state.
+func TestMonitorStates(t *testing.T) {
+	inTempDir(t)
+	m := NewMonitor(0)
+	if m.Status().Known() {
+		t.Fatalf("status known before the first probe")
+	}
+	cases := []struct {
+		backend string
+		want    string
+		ready   bool
+	}{
+		{backendLoading, StatusLoading, false},
+		{backendReady, StatusOK, true},
+		{backendDown, StatusUnavailable, false},
+		{backendReady, StatusOK, true},
+	}
+	for _, tc := range cases {
+		fakeBackend(t, tc.backend)
+		m.check(context.Background())
+		st := m.Status()
+		if st.Backend.Status != tc.want || st.Ready() != tc.ready || !st.Known() {
+			t.Fatalf("backend %s: status %+v, want %s (ready %v)", tc.backend, st.Backend, tc.want, tc.ready)
+		}
+		if st.Database.Status != StatusOK {
+			t.Fatalf("database = %+v", st.Database)
+		}
+		if st.Model.Status != StatusUnknown {
+			t.Fatalf("model = %+v, want unknown without an active model", st.Model)
+		}
+	}
+}
+
+// TestReportBackendFailure checks which request errors change the cached
+// backend status and trigger an early probe.
+func TestReportBackendFailure(t *testing.T) {
+	inTempDir(t)
+	fakeBackend(t, backendReady)
+	cases := []struct {
+		name string
+		err  error
+		want string
+		wake bool
+	}{
+		{"loading", &llama.BackendError{Kind: llama.ErrModelLoading, Message: "Loading model"}, StatusLoading, true},
+		{"unavailable", &llama.BackendError{Kind: llama.ErrBackendUnavailable, Message: "connection refused"}, StatusUnavailable, true},
+		{"timeout", &llama.BackendError{Kind: llama.ErrTimeout, Message: "no answer"}, StatusOK, false},
+		{"overflow", &llama.BackendError{Kind: llama.ErrContextOverflow, Message: "too long"}, StatusOK, false},
+		{"deadline", context.DeadlineExceeded, StatusOK, false},
+	}
+	for _, tc := range cases {
+		m := NewMonitor(0)
+		m.check(context.Background())
+		m.ReportBackendFailure(tc.err)
+		if got := m.Status().Backend.Status; got != tc.want {
+			t.Errorf("%s: backend status %s, want %s", tc.name, got, tc.want)
+		}
+		woken := len(m.wake) == 1
+		if woken != tc.wake {
+			t.Errorf("%s: re-probe scheduled %v, want %v", tc.name, woken, tc.wake)
+		}
+	}
+}
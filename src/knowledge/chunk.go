@@ -0,0 +1,105 @@
+package knowledge
+
+// Chunking. Documents are split on line boundaries into pieces of roughly
+// ChunkSize characters; consecutive pieces overlap so a passage cut at a
+// boundary still appears whole in one of them. Markdown prefers to break
+// before headings and records the current heading as the piece's symbol.
+
+import "strings"
+
+// Piece is a chunk of text with its 1-based line range.
+type Piece struct {
+	Text      string
+	StartLine int
+	EndLine   int
+	Symbol    string
+}
+
+// Default chunking parameters in characters.
+const (
+	DefaultChunkSize = 1200
+	DefaultOverlap   = 200
+)
+
+// ChunkText splits text into overlapping pieces. Lines longer than size are
+// split on their own.
+func ChunkText(text string, size, overlap int, markdown bool) []Piece {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	type line struct {
+		text    string
+		no      int
+		heading string
+	}
+	var lines []line
+	heading := ""
+	for i, l := range strings.Split(text, "\n") {
+		if markdown && strings.HasPrefix(l, "#") {
+			heading = strings.TrimSpace(strings.TrimLeft(l, "#"))
+		}
+		for len(l) > size {
+			// cut at the last space, or mid-word without splitting a
+			// UTF-8 sequence
+			cut := strings.LastIndexByte(l[:size], ' ')
+			if cut <= 0 {
+				cut = size
+				for cut > 1 && l[cut]&0xC0 == 0x80 {
+					cut--
+				}
+			}
+			lines = append(lines, line{l[:cut], i + 1, heading})
+			l = l[cut:]
+		}
+		lines = append(lines, line{l, i + 1, heading})
+	}
+
+	var pieces []Piece
+	emit := func(from, to int) {
+		var b strings.Builder
+		for _, l := range lines[from:to] {
+			b.WriteString(l.text)
+			b.WriteByte('\n')
+		}
+		t := strings.TrimSpace(b.String())
+		if t == "" {
+			return
+		}
+		pieces = append(pieces, Piece{Text: t, StartLine: lines[from].no, EndLine: lines[to-1].no, Symbol: lines[from].heading})
+	}
+
+	start, n := 0, 0
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		breakHere := n > 0 && n+len(l.text)+1 > size
+		if markdown && strings.HasPrefix(l.text, "#") && n >= size/2 {
+			breakHere = true
+		}
+		if !breakHere {
+			n += len(l.text) + 1
+			continue
+		}
+		emit(start, i)
+		// step back so the next piece repeats about `overlap` characters,
+		// always moving forward at least one line
+		next, back := i, 0
+		for next-1 > start && back+len(lines[next-1].text)+1 <= overlap {
+			next--
+			back += len(lines[next].text) + 1
+		}
+		if markdown && strings.HasPrefix(l.text, "#") {
+			next = i
+		}
+		start, n = next, 0
+		for _, x := range lines[start : i+1] {
+			n += len(x.text) + 1
+		}
+	}
+	if start < len(lines) {
+		emit(start, len(lines))
+	}
+	return pieces
+}
@@ -0,0 +1,231 @@
+package knowledge
+
+// Code-aware chunking. Source files are split along declaration boundaries so
+// a retrieved chunk holds a whole function or type with its doc comment and
+// the citation names it. Go files are parsed with go/ast; JavaScript,
+// TypeScript and Python use line patterns for top-level definitions. Files
+// that cannot be parsed and other languages fall back to ChunkText.
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// segment is a range of lines (1-based, inclusive) forming one declaration.
+type segment struct {
+	start, end int
+	symbol     string
+}
+
+// CodePieces splits a source file along function and type boundaries.
+// Declarations longer than size are split further with overlap and keep
+// their symbol.
+func CodePieces(source, text string, size, overlap int) []Piece {
+	lines := strings.Split(text, "\n")
+	var segs []segment
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".go":
+		segs = goSegments(source, text, lines)
+	case ".py":
+		segs = patternSegments(lines, pyDef, pyAttach)
+	case ".js", ".jsx", ".mjs", ".ts", ".tsx":
+		segs = patternSegments(lines, jsDef, jsAttach)
+	}
+	if len(segs) == 0 {
+		return ChunkText(text, size, overlap, false)
+	}
+	var pieces []Piece
+	for _, s := range segs {
+		body := strings.Join(lines[s.start-1:s.end], "\n")
+		if strings.TrimSpace(body) == "" {
+			continue
+		}
+		if len(body) <= size {
+			pieces = append(pieces, Piece{Text: strings.TrimSpace(body), StartLine: s.start, EndLine: s.end, Symbol: s.symbol})
+			continue
+		}
+		for _, p := range ChunkText(body, size, overlap, false) {
+			p.StartLine += s.start - 1
+			p.EndLine += s.start - 1
+			p.Symbol = s.symbol
+			pieces = append(pieces, p)
+		}
+	}
+	return pieces
+}
+
+// goSegments returns one segment per top-level declaration of a Go file,
+// including its doc comment and any comments since the previous declaration.
+// The package clause and anything before the first declaration form the
+// first segment. Nil is returned when the file does not parse.
+func goSegments(source, text string, lines []string) []segment {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, source, text, parser.ParseComments)
+	if err != nil || len(f.Decls) == 0 {
+		return nil
+	}
+	line := func(p token.Pos) int { return fset.Position(p).Line }
+	var segs []segment
+	prevEnd := 0
+	for i, d := range f.Decls {
+		start := line(d.Pos())
+		var symbol string
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = line(d.Doc.Pos())
+			}
+			symbol = goFuncName(d)
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = line(d.Doc.Pos())
+			}
+			symbol = goGenName(d)
+		}
+		if i == 0 {
+			segs = append(segs, segment{start: 1, end: start - 1, symbol: "package " + f.Name.Name})
+		} else {
+			// free-standing comments go with the declaration below
+			from := prevEnd + 1
+			for from < start && strings.TrimSpace(lines[from-1]) == "" {
+				from++
+			}
+			start = from
+		}
+		prevEnd = line(d.End())
+		segs = append(segs, segment{start: start, end: prevEnd, symbol: symbol})
+	}
+	segs[len(segs)-1].end = len(lines)
+	return mergeImports(segs)
+}
+
+// mergeImports folds import declarations into the preceding file header so
+// they do not become tiny chunks of their own.
+func mergeImports(segs []segment) []segment {
+	out := segs[:0]
+	for _, s := range segs {
+		if s.symbol == "import" && len(out) > 0 && strings.HasPrefix(out[len(out)-1].symbol, "package ") {
+			out[len(out)-1].end = s.end
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// goFuncName describes a function declaration, e.g. "func (*Client) Complete".
+func goFuncName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return "func " + d.Name.Name
+	}
+	return "func (" + goTypeName(d.Recv.List[0].Type) + ") " + d.Name.Name
+}
+
+// goTypeName renders a receiver type.
+func goTypeName(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.StarExpr:
+		return "*" + goTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	case *ast.IndexExpr:
+		return goTypeName(t.X)
+	case *ast.IndexListExpr:
+		return goTypeName(t.X)
+	}
+	return "?"
+}
+
+// goGenName describes a type, var, const or import declaration.
+func goGenName(d *ast.GenDecl) string {
+	kw := d.Tok.String()
+	var names []string
+	for _, s := range d.Specs {
+		switch s := s.(type) {
+		case *ast.TypeSpec:
+			names = append(names, s.Name.Name)
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return kw
+	}
+	if len(names) > 3 {
+		names = append(names[:3], "...")
+	}
+	return kw + " " + strings.Join(names, ", ")
+}
+
+// Top-level definitions and the lines that belong to the definition below
+// them (decorators and comments).
+var (
+	pyDef    = regexp.MustCompile(`^(?:async\s+)?(def|class)\s+([A-Za-z_]\w*)`)
+	pyAttach = regexp.MustCompile(`^(@|#)`)
+	jsDef    = regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:declare\s+)?(?:async\s+)?(?:abstract\s+)?` +
+		`(?:(function)\*?\s*([A-Za-z_$][\w$]*)|(class|interface|type|enum)\s+([A-Za-z_$][\w$]*)|` +
+		`(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[A-Za-z_$][\w$]*\s*=>))`)
+	jsAttach = regexp.MustCompile(`^(//|/\*|\*|@)|^\s+\*`)
+)
+
+// patternSegments splits lines at top-level definitions matched by def.
+// Comment and decorator lines directly above a definition belong to it and
+// everything before the first definition forms a header segment.
+func patternSegments(lines []string, def, attach *regexp.Regexp) []segment {
+	var segs []segment
+	prevDef := -1
+	for i, l := range lines {
+		m := def.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		start := i
+		for start-1 > prevDef && attach.MatchString(lines[start-1]) {
+			start--
+		}
+		if len(segs) == 0 {
+			segs = append(segs, segment{start: 1, symbol: "module"})
+		}
+		segs[len(segs)-1].end = start
+		segs = append(segs, segment{start: start + 1, symbol: defName(m)})
+		prevDef = i
+	}
+	if len(segs) == 0 {
+		return nil
+	}
+	segs[len(segs)-1].end = len(lines)
+	return segs
+}
+
+// defName renders the keyword and name captured by a definition pattern.
+func defName(m []string) string {
+	var kw string
+	for _, g := range m[1:] {
+		switch {
+		case g == "":
+		case kw == "" && isKeyword(g):
+			kw = g
+		default:
+			if kw == "" {
+				return g
+			}
+			return kw + " " + g
+		}
+	}
+	return kw
+}
+
+// isKeyword reports whether a captured group is a definition keyword.
+func isKeyword(s string) bool {
+	switch s {
+	case "def", "class", "function", "interface", "type", "enum":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package knowledge
+
+// Embedders turn text into vectors for similarity search. The llama embedder
+// uses the model server's /embedding endpoint; the hashing embedder needs no
+// model at all and is used whenever the server cannot produce embeddings.
+
+import (
+	"codex/src/llama"
+	"codex/src/models"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Embedder produces vectors for text.
+type Embedder interface {
+	// Name identifies the vector space. Vectors from embedders with
+	// different names are never compared.
+	Name() string
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// QueryEmbedder is implemented by embedders that embed search queries
+// differently from documents, for instance without retrying.
+type QueryEmbedder interface {
+	EmbedQuery(ctx context.Context, text string) ([]float32, error)
+}
+
+// HashEmbedder maps words and word pairs into a fixed number of dimensions
+// with feature hashing. It is deterministic, fast and works offline, at the
+// cost of only matching shared vocabulary.
+type HashEmbedder struct {
+	Dims int
+}
+
+// hashDims is the dimensionality of the default hashing embedder.
+const hashDims = 512
+
+// Name implements Embedder.
+func (h HashEmbedder) Name() string {
+	if h.Dims == 0 || h.Dims == hashDims {
+		return "hash-512"
+	}
+	return "hash-" + strconv.Itoa(h.Dims)
+}
+
+// Embed implements Embedder.
+func (h HashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	return h.vector(text), nil
+}
+
+// tokens splits text into lower-case words, also splitting camelCase and
+// snake_case identifiers so code and prose share vocabulary.
+func tokens(text string) []string {
+	var out []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 1 {
+			out = append(out, string(cur))
+		}
+		cur = cur[:0]
+	}
+	var prev rune
+	for _, r := range text {
+		switch {
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			flush()
+			cur = append(cur, unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			cur = append(cur, unicode.ToLower(r))
+		default:
+			flush()
+		}
+		prev = r
+	}
+	flush()
+	return out
+}
+
+func (h HashEmbedder) vector(text string) []float32 {
+	dims := h.Dims
+	if dims <= 0 {
+		dims = hashDims
+	}
+	counts := map[string]float64{}
+	toks := tokens(text)
+	for i, t := range toks {
+		if stopwords[t] {
+			continue
+		}
+		counts[t]++
+		if i+1 < len(toks) && !stopwords[toks[i+1]] {
+			counts[t+" "+toks[i+1]] += 0.5
+		}
+	}
+	v := make([]float32, dims)
+	for term, n := range counts {
+		f := fnv.New64a()
+		f.Write([]byte(term))
+		sum := f.Sum64()
+		sign := float32(1)
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		v[sum%uint64(dims)] += sign * float32(1+math.Log(n))
+	}
+	normalize(v)
+	return v
+}
+
+var stopwords = func() map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields("the and for are but not you all any can her was one our out has his how its may new now see two way who did get let put say she too use that with this from they will have what when your which their there about would these other into more some than them then been were also just only over such") {
+		m[w] = true
+	}
+	return m
+}()
+
+// normalize scales v to unit length in place.
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	n := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= n
+	}
+}
+
+// cosine returns the cosine similarity of two vectors of equal length.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// LlamaEmbedder uses the model server's /embedding endpoint. Model names the
+// loaded model so vectors from different models are kept apart.
+type LlamaEmbedder struct {
+	Model string
+}
+
+// Name implements Embedder.
+func (l LlamaEmbedder) Name() string { return "llama:" + l.Model }
+
+// Embed implements Embedder.
+func (l LlamaEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	v, err := llama.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	normalize(v)
+	return v, nil
+}
+
+// EmbedQuery implements QueryEmbedder. The query is not retried: Search
+// falls back to the hashing embedder when the model server does not answer.
+func (l LlamaEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	v, err := llama.EmbedQuery(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	normalize(v)
+	return v, nil
+}
+
+// EmbedderByName returns the embedder selected by the --embeddings flag:
+// "hash" for the built-in hashing embedder or "llama" for the model server,
+// labelled with the active model so its vectors stay separate from other
+// models'.
+func EmbedderByName(name string) (Embedder, error) {
+	switch name {
+	case "", "hash":
+		return HashEmbedder{}, nil
+	case "llama":
+		model := "default"
+		if lm, err := models.ActiveModel(); err == nil && lm != nil {
+			model = lm.ID
+		}
+		return LlamaEmbedder{Model: model}, nil
+	}
+	return nil, fmt.Errorf("unknown embedder %q (want hash or llama)", name)
+}
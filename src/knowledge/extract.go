@@ -0,0 +1,74 @@
+package knowledge
+
+// Text extraction for ingested files. Plain text, Markdown and source files
+// are read as UTF-8; PDFs go through a small extractor for text-based PDFs.
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupported is returned for files that are neither text nor PDF.
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Document kinds stored with each ingested source.
+const (
+	KindText     = "text"
+	KindMarkdown = "markdown"
+	KindCode     = "code"
+	KindPDF      = "pdf"
+)
+
+// codeExts lists source file extensions treated as code.
+var codeExts = map[string]bool{
+	".go": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".mjs": true, ".py": true,
+	".java": true, ".kt": true, ".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true,
+	".rs": true, ".rb": true, ".php": true, ".cs": true, ".swift": true, ".scala": true,
+	".sh": true, ".bash": true, ".sql": true, ".css": true, ".html": true, ".vue": true,
+	".yaml": true, ".yml": true, ".toml": true, ".json": true, ".proto": true,
+}
+
+// Kind classifies a file name.
+func Kind(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case ext == ".pdf":
+		return KindPDF
+	case ext == ".md" || ext == ".markdown" || ext == ".mdx" || ext == ".rst":
+		return KindMarkdown
+	case codeExts[ext]:
+		return KindCode
+	}
+	return KindText
+}
+
+// Extract returns the text of a file's contents and its kind. Content that is
+// not valid UTF-8 text (other than PDFs) yields ErrUnsupported.
+func Extract(name string, data []byte) (string, string, error) {
+	kind := Kind(name)
+	if kind == KindPDF || bytes.HasPrefix(data, []byte("%PDF-")) {
+		text, err := extractPDF(data)
+		return text, KindPDF, err
+	}
+	if !isText(data) {
+		return "", kind, ErrUnsupported
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	return strings.ReplaceAll(text, "\r\n", "\n"), kind, nil
+}
+
+// isText reports whether data looks like UTF-8 text.
+func isText(data []byte) bool {
+	sample := data
+	if len(sample) > 8192 {
+		sample = sample[:8192]
+		// tolerate a multi-byte character cut at the sample boundary
+		for i := 0; i < 3 && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	return utf8.Valid(sample) && !bytes.ContainsRune(sample, 0)
+}
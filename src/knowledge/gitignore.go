@@ -0,0 +1,144 @@
+package knowledge
+
+// A small .gitignore matcher for the repository indexer. It supports the
+// common syntax: blank lines and comments, negation with "!", directory-only
+// patterns with a trailing "/", patterns anchored by a leading or inner "/",
+// shell globs and "**" for any number of directories. Each directory's
+// .gitignore applies to the paths below it and later rules override earlier
+// ones, as in git.
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+	// base is the slash-separated directory of the .gitignore file relative
+	// to the repository root, "" for the root.
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Ignorer decides which paths of a repository are ignored.
+type Ignorer struct {
+	rules []ignoreRule
+}
+
+// parseIgnore reads the rules of a .gitignore file located in the directory
+// rel. A missing file yields no rules.
+func parseIgnore(file, rel string) ([]ignoreRule, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: rel}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules, sc.Err()
+}
+
+// load adds the rules of dir's .gitignore. rel is dir relative to the root.
+func (ig *Ignorer) load(dir, rel string) error {
+	rules, err := parseIgnore(filepath.Join(dir, ".gitignore"), rel)
+	if err != nil {
+		return err
+	}
+	ig.rules = append(ig.rules, rules...)
+	return nil
+}
+
+// Ignored reports whether the slash-separated path rel (relative to the
+// repository root) is ignored.
+func (ig *Ignorer) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = rel[len(r.base)+1:]
+		}
+		var ok bool
+		if r.anchored {
+			ok = globMatch(r.pattern, sub)
+		} else {
+			ok = globMatch(r.pattern, path.Base(sub))
+		}
+		if ok {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// globMatch matches a slash-separated path against a pattern in which "**"
+// stands for zero or more whole segments.
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 0 && pat[0] == "**" {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
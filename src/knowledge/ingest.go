@@ -0,0 +1,237 @@
+package knowledge
+
+// Package knowledge is the project knowledge base used for retrieval
+// augmented generation.  Documents are extracted to text, split into
+// overlapping chunks, embedded and stored per project through the memory
+// package; chat retrieves the most similar chunks for each prompt and cites
+// them in its answer.
+//
+// AI Awareness: retrieved chunks are inserted into prompts verbatim, so
+// whatever is ingested can steer the assistant.
+
+import (
+	"codex/src/memory"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFileSize is the largest file ingested; bigger files are skipped.
+const MaxFileSize = 20 << 20
+
+var (
+	embedderMu      sync.RWMutex
+	defaultEmbedder Embedder = HashEmbedder{}
+)
+
+// SetEmbedder selects the embedder used for ingestion and search.
+func SetEmbedder(e Embedder) {
+	if e == nil {
+		e = HashEmbedder{}
+	}
+	embedderMu.Lock()
+	defaultEmbedder = e
+	embedderMu.Unlock()
+}
+
+// DefaultEmbedder returns the configured embedder.
+func DefaultEmbedder() Embedder {
+	embedderMu.RLock()
+	defer embedderMu.RUnlock()
+	return defaultEmbedder
+}
+
+// Ingester adds documents to a project's knowledge base. The zero value uses
+// the default embedder and chunk sizes.
+type Ingester struct {
+	Embedder  Embedder
+	ChunkSize int
+	Overlap   int
+}
+
+// Result reports the outcome of ingesting one source.
+type Result struct {
+	Source string
+	Kind   string
+	Chunks int
+	// Unchanged is set when the stored copy already matched.
+	Unchanged bool
+	// Removed is set when the repository indexer deleted a document whose
+	// file no longer exists or is now ignored.
+	Removed bool
+	Err     error
+}
+
+func (in *Ingester) embedder() Embedder {
+	if in.Embedder != nil {
+		return in.Embedder
+	}
+	return DefaultEmbedder()
+}
+
+// IngestData stores data under source in project. Content identical to the
+// stored copy is not re-embedded.
+func (in *Ingester) IngestData(ctx context.Context, db *sql.DB, project, source string, data []byte, modTime time.Time) Result {
+	res := Result{Source: source}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	existing, err := memory.GetDocument(db, project, source)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if existing != nil && existing.Hash == hash {
+		res.Kind, res.Chunks, res.Unchanged = existing.Kind, existing.Chunks, true
+		if !modTime.IsZero() && !existing.ModTime.Equal(modTime) {
+			db.Exec(`UPDATE documents SET mod_time = ? WHERE id = ?`, modTime, existing.ID)
+		}
+		return res
+	}
+
+	text, kind, err := Extract(source, data)
+	res.Kind = kind
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	pieces := Split(source, kind, text, in.ChunkSize, in.Overlap)
+	chunks, err := in.embed(ctx, source, pieces)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	doc := &memory.Document{Project: project, Source: source, Kind: kind, Hash: hash, ModTime: modTime}
+	if err := memory.SaveDocument(db, doc, chunks); err != nil {
+		res.Err = err
+		return res
+	}
+	res.Chunks = doc.Chunks
+	return res
+}
+
+// Split chunks text according to its kind. Source code is split along
+// declaration boundaries by CodePieces.
+func Split(source, kind, text string, size, overlap int) []Piece {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap <= 0 {
+		overlap = DefaultOverlap
+	}
+	if kind == KindCode {
+		return CodePieces(source, text, size, overlap)
+	}
+	return ChunkText(text, size, overlap, kind == KindMarkdown)
+}
+
+// embed computes embeddings for pieces. When the configured embedder fails
+// the whole document falls back to the hashing embedder so its vectors share
+// one space.
+func (in *Ingester) embed(ctx context.Context, source string, pieces []Piece) ([]memory.Chunk, error) {
+	emb := in.embedder()
+	chunks := make([]memory.Chunk, len(pieces))
+	for i, p := range pieces {
+		chunks[i] = memory.Chunk{Content: p.Text, StartLine: p.StartLine, EndLine: p.EndLine, Symbol: p.Symbol}
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		var err error
+		for i := range chunks {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			var v []float32
+			v, err = emb.Embed(ctx, embedText(source, chunks[i]))
+			if err != nil {
+				break
+			}
+			chunks[i].Embedder, chunks[i].Embedding = emb.Name(), v
+		}
+		if err == nil {
+			return chunks, nil
+		}
+		if _, isHash := emb.(HashEmbedder); isHash || ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("knowledge: %s embedder failed for %s, using hashing embedder: %v", emb.Name(), source, err)
+		emb = HashEmbedder{}
+	}
+	return chunks, nil
+}
+
+// embedText is what gets embedded for a chunk: its location plus content, so
+// file and section names are searchable too.
+func embedText(source string, c memory.Chunk) string {
+	if c.Symbol != "" {
+		return source + " " + c.Symbol + "\n" + c.Content
+	}
+	return source + "\n" + c.Content
+}
+
+// IngestPaths ingests files and directories into project, calling report for
+// every file. Directories are walked recursively, skipping hidden entries.
+// Unsupported and oversized files are reported with an error and skipped.
+func (in *Ingester) IngestPaths(ctx context.Context, project string, paths []string, report func(Result)) error {
+	db, err := memory.InitDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := memory.AddProject(db, project); err != nil {
+		return err
+	}
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				report(Result{Source: filepath.ToSlash(path), Err: err})
+				return nil
+			}
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !d.Type().IsRegular() {
+				return nil
+			}
+			report(in.ingestFile(ctx, db, project, path))
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingestFile reads and ingests one file.
+func (in *Ingester) ingestFile(ctx context.Context, db *sql.DB, project, path string) Result {
+	source := filepath.ToSlash(filepath.Clean(path))
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Result{Source: source, Err: err}
+	}
+	if fi.Size() > MaxFileSize {
+		return Result{Source: source, Err: fmt.Errorf("file larger than %d MB", MaxFileSize>>20)}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{Source: source, Err: err}
+	}
+	return in.IngestData(ctx, db, project, source, data, fi.ModTime())
+}
+
+// IsUnsupported reports whether a result was skipped because the file type
+// cannot be ingested.
+func (r Result) IsUnsupported() bool {
+	return errors.Is(r.Err, ErrUnsupported)
+}
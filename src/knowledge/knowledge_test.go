@@ -0,0 +1,112 @@
+package knowledge
+
+// Tests for extraction, chunking, ingestion and retrieval.
+
+import (
+	"bytes"
+	"codex/src/memory"
+	"compress/zlib"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestChunkTextOverlap checks line ranges and that consecutive chunks share
+// text.
+func TestChunkTextOverlap(t *testing.T) {
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, strings.Repeat("x", 18))
+	}
+	pieces := ChunkText(strings.Join(lines, "\n"), 200, 50, false)
+	if len(pieces) < 4 {
+		t.Fatalf("expected several pieces, got %d", len(pieces))
+	}
+	if pieces[0].StartLine != 1 || pieces[len(pieces)-1].EndLine != 40 {
+		t.Fatalf("unexpected line ranges: %+v", pieces)
+	}
+	for i := 1; i < len(pieces); i++ {
+		if pieces[i].StartLine > pieces[i-1].EndLine || pieces[i].StartLine <= pieces[i-1].StartLine {
+			t.Fatalf("pieces %d and %d do not overlap: %+v %+v", i-1, i, pieces[i-1], pieces[i])
+		}
+	}
+}
+
+// TestExtractPDF reads plain and deflated content streams.
+func TestExtractPDF(t *testing.T) {
+	content := "BT /F1 12 Tf 72 712 Td (Quarterly \\(Q3\\) report) Tj 0 -14 Td [(rev)-20(enue grew)-400(fast)] TJ ET"
+	plain := "%PDF-1.4\n1 0 obj\n<< >>\nstream\n" + content + "\nendstream\nendobj\n%%EOF"
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte(content))
+	zw.Close()
+	deflated := "%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode >>\nstream\n" + z.String() + "\nendstream\nendobj\n%%EOF"
+
+	for _, doc := range []string{plain, deflated} {
+		text, kind, err := Extract("report.pdf", []byte(doc))
+		if err != nil || kind != KindPDF {
+			t.Fatalf("Extract error: %v %s", err, kind)
+		}
+		if text != "Quarterly (Q3) report\nrevenue grew fast" {
+			t.Fatalf("unexpected text %q", text)
+		}
+	}
+	if _, _, err := Extract("blob.bin", []byte{0, 1, 2, 0xff}); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+// TestIngestAndSearch ingests a directory and retrieves the matching chunk.
+func TestIngestAndSearch(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("docs/.hidden", 0755)
+	os.WriteFile("docs/deploy.md", []byte("# Deployment\n\nProduction deploys run from the release branch every Tuesday.\n"), 0644)
+	os.WriteFile("docs/style.md", []byte("# Style\n\nUse tabs for indentation and keep functions short.\n"), 0644)
+	os.WriteFile("docs/.hidden/secret.md", []byte("deploy secrets"), 0644)
+	os.WriteFile(filepath.Join("docs", "logo.png"), []byte{0x89, 'P', 'N', 'G', 0, 0}, 0644)
+
+	var results []Result
+	in := &Ingester{}
+	if err := in.IngestPaths(context.Background(), "proj", []string{"docs"}, func(r Result) { results = append(results, r) }); err != nil {
+		t.Fatalf("IngestPaths error: %v", err)
+	}
+	if len(results) != 3 || results[0].Chunks != 1 || !results[1].IsUnsupported() {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	hits, err := Search(context.Background(), db, "proj", "when do production deploys happen?", 1, 0.1)
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("Search: %v %+v", err, hits)
+	}
+	if hits[0].Source != "docs/deploy.md" || hits[0].Symbol != "Deployment" || hits[0].StartLine != 1 {
+		t.Fatalf("unexpected hit: %+v", hits[0])
+	}
+
+	// chunks of an embedder that is no longer active are not candidates
+	stale := &memory.Document{Project: "proj", Source: "old.md", Kind: KindText, Hash: "x"}
+	content := "Production deploys happen from the release branch."
+	if err := memory.SaveDocument(db, stale, []memory.Chunk{{Content: content, StartLine: 1, EndLine: 1, Embedder: "llama:old", Embedding: HashEmbedder{}.vector(content)}}); err != nil {
+		t.Fatalf("SaveDocument error: %v", err)
+	}
+	hits, err = Search(context.Background(), db, "proj", "when do production deploys happen?", 5, 0.1)
+	if err != nil || len(hits) != 1 || hits[0].Source != "docs/deploy.md" {
+		t.Fatalf("Search with stale chunks: %v %+v", err, hits)
+	}
+
+	results = nil
+	in.IngestPaths(context.Background(), "proj", []string{"docs/deploy.md"}, func(r Result) { results = append(results, r) })
+	if len(results) != 1 || !results[0].Unchanged {
+		t.Fatalf("expected unchanged re-ingest: %+v", results)
+	}
+}
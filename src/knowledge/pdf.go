@@ -0,0 +1,199 @@
+package knowledge
+
+// A minimal PDF text extractor. It inflates content streams and collects the
+// strings shown by the text operators (Tj, TJ, ' and "), starting new lines on
+// line-positioning operators. This handles the text layer of typical
+// generated PDFs; scanned documents and fonts with custom encodings yield
+// little or no text and are reported as errors.
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var streamRe = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+// extractPDF returns the text found in a PDF's content streams.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", errors.New("not a PDF file")
+	}
+	var out strings.Builder
+	for _, m := range streamRe.FindAllSubmatch(data, -1) {
+		content := m[1]
+		if zr, err := zlib.NewReader(bytes.NewReader(content)); err == nil {
+			inflated, err := io.ReadAll(io.LimitReader(zr, 64<<20))
+			zr.Close()
+			if err == nil || len(inflated) > 0 {
+				content = inflated
+			}
+		}
+		if !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+		pdfText(content, &out)
+	}
+	text := strings.TrimSpace(out.String())
+	if text == "" {
+		return "", errors.New("no extractable text in PDF (scanned or encoded)")
+	}
+	return text, nil
+}
+
+// pdfText scans a content stream and writes shown text to out.
+func pdfText(content []byte, out *strings.Builder) {
+	inText := false
+	var line strings.Builder
+	endLine := func() {
+		if s := strings.TrimSpace(line.String()); s != "" {
+			out.WriteString(s)
+			out.WriteByte('\n')
+		}
+		line.Reset()
+	}
+	var operands [][]byte
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			operands = append(operands, s)
+			i += n
+			continue
+		case c == '[':
+			// TJ arrays: collect strings, treat large negative kerning as a
+			// space
+			end := bytes.IndexByte(content[i:], ']')
+			if end < 0 {
+				end = len(content) - i - 1
+			}
+			arr := content[i+1 : i+end]
+			var joined []byte
+			for j := 0; j < len(arr); {
+				if arr[j] == '(' {
+					s, n := pdfLiteral(arr[j:])
+					joined = append(joined, s...)
+					j += n
+					continue
+				}
+				if arr[j] == '-' && j+1 < len(arr) && arr[j+1] >= '0' && arr[j+1] <= '9' {
+					k := j + 1
+					for k < len(arr) && (arr[k] >= '0' && arr[k] <= '9' || arr[k] == '.') {
+						k++
+					}
+					if k-j > 3 {
+						joined = append(joined, ' ')
+					}
+					j = k
+					continue
+				}
+				j++
+			}
+			operands = append(operands, joined)
+			i += end + 1
+			continue
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+			continue
+		case isPDFSpace(c):
+			i++
+			continue
+		}
+		// read a token
+		j := i
+		for j < len(content) && !isPDFSpace(content[j]) && !bytes.ContainsRune([]byte("()[]<>/%"), rune(content[j])) {
+			j++
+		}
+		if j == i {
+			j = i + 1
+		}
+		tok := string(content[i:j])
+		i = j
+		switch tok {
+		case "BT":
+			inText = true
+		case "ET":
+			inText = false
+			endLine()
+		case "Tj", "TJ":
+			if inText && len(operands) > 0 {
+				line.Write(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			if inText && len(operands) > 0 {
+				endLine()
+				line.Write(operands[len(operands)-1])
+			}
+		case "T*", "Td", "TD", "Tm":
+			if inText {
+				endLine()
+			}
+		default:
+			continue
+		}
+		operands = operands[:0]
+	}
+	endLine()
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// pdfLiteral decodes a (...) string starting at b[0] and returns it with the
+// number of bytes consumed. Nested parentheses and escapes are handled.
+func pdfLiteral(b []byte) ([]byte, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return out, i + 1
+			}
+		case c == '\\' && i+1 < len(b):
+			i++
+			switch e := b[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\n', '\r':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					k := 0
+					for k < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7' {
+						v = v*8 + int(b[i]-'0')
+						i++
+						k++
+					}
+					i--
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, len(b)
+}
@@ -0,0 +1,168 @@
+package knowledge
+
+// Repository indexing. A RepoIndexer walks a source tree, honours its
+// .gitignore files, chunks code along function boundaries and keeps the
+// project's knowledge base in sync: files whose modification time is
+// unchanged are not even read, changed files are re-chunked only when their
+// content differs and documents of deleted or newly ignored files are
+// removed. Sources are named "<repo>/<path>" so citations read as file:line
+// references.
+
+import (
+	"codex/src/memory"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RepoStats summarises an indexing run.
+type RepoStats struct {
+	Files     int `json:"files"`
+	Indexed   int `json:"indexed"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+	Skipped   int `json:"skipped"`
+	Chunks    int `json:"chunks"`
+}
+
+// RepoIndexer indexes source repositories into a project's knowledge base.
+type RepoIndexer struct {
+	Ingester
+	// Name prefixes the sources of the repository's files. It defaults to
+	// the base name of the root directory.
+	Name string
+	// Report, when set, receives every file that was indexed, skipped or
+	// removed. Unchanged files are not reported.
+	Report func(Result)
+}
+
+// RepoName returns the source prefix used for files below root.
+func (ix *RepoIndexer) RepoName(root string) (string, error) {
+	if ix.Name != "" {
+		return strings.Trim(filepath.ToSlash(ix.Name), "/"), nil
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(abs), nil
+}
+
+// Index brings the project's copy of the repository at root up to date.
+func (ix *RepoIndexer) Index(ctx context.Context, project, root string) (RepoStats, error) {
+	var st RepoStats
+	fi, err := os.Stat(root)
+	if err != nil {
+		return st, err
+	}
+	if !fi.IsDir() {
+		return st, fmt.Errorf("%s is not a directory", root)
+	}
+	name, err := ix.RepoName(root)
+	if err != nil {
+		return st, err
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		return st, err
+	}
+	defer db.Close()
+	if err := memory.AddProject(db, project); err != nil {
+		return st, err
+	}
+
+	report := func(r Result) {
+		if ix.Report != nil {
+			ix.Report(r)
+		}
+	}
+	seen := map[string]bool{}
+	ig := &Ignorer{}
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return ig.load(path, "")
+		}
+		if strings.HasPrefix(d.Name(), ".") || ig.Ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return ig.load(path, rel)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		source := name + "/" + rel
+		seen[source] = true
+		st.Files++
+		res := ix.indexFile(ctx, db, project, source, path)
+		switch {
+		case res.Err != nil:
+			st.Skipped++
+			report(res)
+		case res.Unchanged:
+			st.Unchanged++
+		default:
+			st.Indexed++
+			st.Chunks += res.Chunks
+			report(res)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return st, err
+	}
+
+	docs, err := memory.ListDocuments(db, project, name+"/")
+	if err != nil {
+		return st, err
+	}
+	for _, d := range docs {
+		if seen[d.Source] {
+			continue
+		}
+		if err := memory.DeleteDocument(db, project, d.Source); err != nil {
+			return st, err
+		}
+		st.Removed++
+		report(Result{Source: d.Source, Kind: d.Kind, Removed: true})
+	}
+	return st, nil
+}
+
+// indexFile ingests one file unless its stored copy has the same
+// modification time.
+func (ix *RepoIndexer) indexFile(ctx context.Context, db *sql.DB, project, source, path string) Result {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Result{Source: source, Err: err}
+	}
+	if fi.Size() > MaxFileSize {
+		return Result{Source: source, Err: fmt.Errorf("file larger than %d MB", MaxFileSize>>20)}
+	}
+	existing, err := memory.GetDocument(db, project, source)
+	if err != nil {
+		return Result{Source: source, Err: err}
+	}
+	if existing != nil && existing.ModTime.Equal(fi.ModTime()) {
+		return Result{Source: source, Kind: existing.Kind, Chunks: existing.Chunks, Unchanged: true}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{Source: source, Err: err}
+	}
+	return ix.IngestData(ctx, db, project, source, data, fi.ModTime())
+}
@@ -0,0 +1,157 @@
+package knowledge
+
+// Tests for code chunking, .gitignore matching and repository indexing.
+
+import (
+	"codex/src/memory"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const goSource = `// Package calc adds numbers.
+package calc
+
+import "fmt"
+
+// Sum adds a and b.
+func Sum(a, b int) int {
+	return a + b
+}
+
+// Acc accumulates values.
+type Acc struct{ n int }
+
+// Add records v.
+func (a *Acc) Add(v int) {
+	a.n += v
+	fmt.Println(a.n)
+}
+`
+
+// TestCodePiecesGo checks that Go files are split per declaration with doc
+// comments and line ranges.
+func TestCodePiecesGo(t *testing.T) {
+	pieces := CodePieces("calc.go", goSource, 1200, 200)
+	want := []Piece{
+		{StartLine: 1, EndLine: 4, Symbol: "package calc"},
+		{StartLine: 6, EndLine: 9, Symbol: "func Sum"},
+		{StartLine: 11, EndLine: 12, Symbol: "type Acc"},
+		{StartLine: 14, EndLine: 19, Symbol: "func (*Acc) Add"},
+	}
+	if len(pieces) != len(want) {
+		t.Fatalf("expected %d pieces, got %+v", len(want), pieces)
+	}
+	for i, w := range want {
+		p := pieces[i]
+		if p.StartLine != w.StartLine || p.EndLine != w.EndLine || p.Symbol != w.Symbol {
+			t.Fatalf("piece %d = %d-%d %q, want %d-%d %q", i, p.StartLine, p.EndLine, p.Symbol, w.StartLine, w.EndLine, w.Symbol)
+		}
+	}
+	if !strings.HasPrefix(pieces[3].Text, "// Add records v.") {
+		t.Fatalf("doc comment not attached: %q", pieces[3].Text)
+	}
+}
+
+// TestCodePiecesPatterns checks Python and JavaScript boundaries.
+func TestCodePiecesPatterns(t *testing.T) {
+	py := "import os\n\n@cache\ndef load(path):\n    return open(path).read()\n\nclass Store:\n    def get(self):\n        pass\n"
+	pieces := CodePieces("store.py", py, 1200, 200)
+	if len(pieces) != 3 || pieces[1].Symbol != "def load" || pieces[1].StartLine != 3 || pieces[2].Symbol != "class Store" || pieces[2].EndLine != 10 {
+		t.Fatalf("unexpected python pieces: %+v", pieces)
+	}
+	js := "// greet says hi\nexport async function greet(name) {\n  return `hi ${name}`\n}\nexport const add = (a, b) => a + b\n"
+	pieces = CodePieces("util.js", js, 1200, 200)
+	if len(pieces) != 2 || pieces[0].Symbol != "function greet" || pieces[0].StartLine != 1 || pieces[1].Symbol != "add" {
+		t.Fatalf("unexpected js pieces: %+v", pieces)
+	}
+}
+
+// TestIgnorer covers negation, anchoring, directory-only and ** patterns.
+func TestIgnorer(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/.gitignore", []byte("# build output\n*.log\n!keep.log\n/bin\nnode_modules/\ndocs/**/draft.md\n"), 0644)
+	ig := &Ignorer{}
+	if err := ig.load(dir, ""); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	cases := []struct {
+		path string
+		dir  bool
+		want bool
+	}{
+		{"app.log", false, true},
+		{"sub/app.log", false, true},
+		{"keep.log", false, false},
+		{"bin", true, true},
+		{"sub/bin", true, false},
+		{"web/node_modules", true, true},
+		{"node_modules", false, false},
+		{"docs/a/b/draft.md", false, true},
+		{"docs/draft.md", false, true},
+		{"main.go", false, false},
+	}
+	for _, c := range cases {
+		if got := ig.Ignored(c.path, c.dir); got != c.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", c.path, c.dir, got, c.want)
+		}
+	}
+}
+
+// TestRepoIndexIncremental indexes a repository twice and checks that
+// unchanged files are skipped, ignored files never indexed and deleted files
+// removed.
+func TestRepoIndexIncremental(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("repo/gen", 0755)
+	os.MkdirAll("repo/pkg", 0755)
+	os.WriteFile("repo/.gitignore", []byte("gen/\n"), 0644)
+	os.WriteFile("repo/pkg/.gitignore", []byte("*_local.go\n"), 0644)
+	os.WriteFile("repo/pkg/calc.go", []byte(goSource), 0644)
+	os.WriteFile("repo/pkg/conf_local.go", []byte("package calc\n"), 0644)
+	os.WriteFile("repo/gen/out.go", []byte("package gen\n"), 0644)
+	os.WriteFile("repo/README.md", []byte("# Calc\n\nA calculator.\n"), 0644)
+
+	ix := &RepoIndexer{}
+	ctx := context.Background()
+	st, err := ix.Index(ctx, "proj", "repo")
+	if err != nil {
+		t.Fatalf("Index error: %v", err)
+	}
+	if st.Files != 2 || st.Indexed != 2 || st.Chunks != 5 {
+		t.Fatalf("unexpected first run: %+v", st)
+	}
+
+	st, _ = ix.Index(ctx, "proj", "repo")
+	if st.Indexed != 0 || st.Unchanged != 2 {
+		t.Fatalf("unexpected second run: %+v", st)
+	}
+
+	os.Remove("repo/README.md")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes("repo/pkg/calc.go", later, later)
+	st, _ = ix.Index(ctx, "proj", "repo")
+	// the touched file is read again but its content is unchanged
+	if st.Removed != 1 || st.Indexed != 0 || st.Unchanged != 1 {
+		t.Fatalf("unexpected third run: %+v", st)
+	}
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	hits, err := Search(ctx, db, "proj", "Add records a value on Acc", 1, 0.1)
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("Search: %v %+v", err, hits)
+	}
+	if hits[0].Source != "repo/pkg/calc.go" || hits[0].StartLine != 14 || hits[0].Symbol != "func (*Acc) Add" {
+		t.Fatalf("unexpected hit: %+v", hits[0])
+	}
+}
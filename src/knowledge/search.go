@@ -0,0 +1,83 @@
+package knowledge
+
+// Retrieval. Chunks are scored by cosine similarity against the query in the
+// vector space they were embedded in. Only chunks of the active embedder and
+// of the hashing embedder are candidates; when the active embedder cannot
+// embed the query its chunks are scored with the hashing embedder computed
+// on the fly, so retrieval keeps working when the model server is down.
+
+import (
+	"codex/src/memory"
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// Hit is a retrieved chunk.
+type Hit struct {
+	Source    string  `json:"source"`
+	StartLine int     `json:"start_line"`
+	EndLine   int     `json:"end_line"`
+	Symbol    string  `json:"symbol,omitempty"`
+	Content   string  `json:"content"`
+	Score     float64 `json:"score"`
+}
+
+// maxCandidates caps how many chunks a search loads and scores, so a large
+// project cannot make each chat scan its whole knowledge base.
+const maxCandidates = 20000
+
+// Search returns up to k chunks of project most similar to query with a
+// score of at least minScore.
+func Search(ctx context.Context, db *sql.DB, project, query string, k int, minScore float64) ([]Hit, error) {
+	hash := HashEmbedder{}
+	emb := DefaultEmbedder()
+	embedders := []string{hash.Name()}
+	if emb.Name() != hash.Name() {
+		embedders = append(embedders, emb.Name())
+	}
+	chunks, err := memory.ProjectChunks(db, project, embedders, maxCandidates)
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	qHash := hash.vector(query)
+
+	var qEmb []float32
+	needEmb := false
+	for _, c := range chunks {
+		if c.Embedder != hash.Name() {
+			needEmb = true
+			break
+		}
+	}
+	if needEmb {
+		// an unavailable embedder leaves qEmb nil and falls back below
+		if qe, ok := emb.(QueryEmbedder); ok {
+			qEmb, _ = qe.EmbedQuery(ctx, query)
+		} else {
+			qEmb, _ = emb.Embed(ctx, query)
+		}
+	}
+
+	hits := make([]Hit, 0, len(chunks))
+	for _, c := range chunks {
+		var score float64
+		switch {
+		case c.Embedder == hash.Name():
+			score = cosine(qHash, c.Embedding)
+		case qEmb != nil:
+			score = cosine(qEmb, c.Embedding)
+		default:
+			score = cosine(qHash, hash.vector(embedText(c.Source, c)))
+		}
+		if score < minScore {
+			continue
+		}
+		hits = append(hits, Hit{Source: c.Source, StartLine: c.StartLine, EndLine: c.EndLine, Symbol: c.Symbol, Content: c.Content, Score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits, nil
+}
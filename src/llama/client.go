@@ -9,12 +9,134 @@ package llama
 // mechanisms can be added here to support new model backends.
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+// defaultBaseURL is where the llama.cpp server is expected to listen.
+const defaultBaseURL = "http://localhost:8080"
+
+// Errors returned by the client. Callers should test for them with errors.Is;
+// the concrete error is usually a *BackendError carrying the HTTP status and
+// the server's message.
+var (
+	// ErrBackendUnavailable means the model server could not be reached or
+	// answered with a gateway style error.
+	ErrBackendUnavailable = errors.New("model backend unavailable")
+	// ErrModelLoading means the server is up but still loading the model.
+	ErrModelLoading = errors.New("model is loading")
+	// ErrContextOverflow means the prompt does not fit the model's context
+	// window. Retrying the same prompt cannot succeed.
+	ErrContextOverflow = errors.New("prompt exceeds model context size")
+	// ErrBadResponse means the server answered with an unexpected status or
+	// a body that could not be decoded.
+	ErrBadResponse = errors.New("bad response from model backend")
+	// ErrTimeout means a single request ran longer than Client.Timeout. The
+	// server is up but slow, so the request is not retried.
+	ErrTimeout = errors.New("model backend timed out")
+)
+
+// BackendError describes a failed request to the model server. Kind is one of
+// the package level errors above and is returned by Unwrap.
+type BackendError struct {
+	Kind       error
+	StatusCode int
+	Message    string
+}
+
+func (e *BackendError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v: status %d: %s", e.Kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%v: %s", e.Kind, e.Message)
+}
+
+func (e *BackendError) Unwrap() error { return e.Kind }
+
+// retryable reports whether a failed request may succeed when repeated.
+func retryable(err error) bool {
+	return errors.Is(err, ErrBackendUnavailable) || errors.Is(err, ErrModelLoading)
+}
+
+// Client talks to a llama.cpp compatible server. The zero value is not usable;
+// create clients with NewClient.
+type Client struct {
+	// BaseURL is the server address without a trailing slash.
+	BaseURL string
+	// HTTP performs the requests. Cancellation and deadlines come from the
+	// request context so the client itself carries no timeout.
+	HTTP *http.Client
+	// Timeout bounds a single attempt. Zero relies on the caller's context.
+	Timeout time.Duration
+	// MaxRetries is the number of extra attempts made after a transient
+	// failure (unreachable server, model loading, 502/503/504).
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the jittered delay between attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Sampling holds the parameters of completions that do not override
+	// them through Options.
+	Sampling Sampling
+}
+
+// Sampling holds default completion parameters.
+type Sampling struct {
+	// NPredict is the number of tokens to generate; -1 generates until the
+	// model stops.
+	NPredict    int
+	Temperature float64
+	// TopP and TopK are sent when non-zero.
+	TopP float64
+	TopK int
+}
+
+// DefaultSampling returns the sampling parameters of a new client.
+func DefaultSampling() Sampling {
+	return Sampling{NPredict: 300, Temperature: 0.7}
+}
+
+// NewClient returns a client for the server at baseURL with the default
+// timeout, retry policy and sampling parameters.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTP:       &http.Client{},
+		Timeout:    2 * time.Minute,
+		MaxRetries: 2,
+		MinBackoff: 250 * time.Millisecond,
+		MaxBackoff: 2 * time.Second,
+		Sampling:   DefaultSampling(),
+	}
+}
+
+var (
+	clientMu      sync.RWMutex
+	defaultClient = NewClient(defaultBaseURL)
+)
+
+// SetDefaultClient replaces the client used by the package level helpers.
+func SetDefaultClient(c *Client) {
+	clientMu.Lock()
+	defaultClient = c
+	clientMu.Unlock()
+}
+
+// DefaultClient returns the client used by the package level helpers.
+func DefaultClient() *Client {
+	clientMu.RLock()
+	defer clientMu.RUnlock()
+	return defaultClient
+}
+
 type completionRequest struct {
 	// Prompt is the text sent to the LLM.
 	Prompt string `json:"prompt"`
@@ -22,43 +144,95 @@ type completionRequest struct {
 	NPredict int `json:"n_predict"`
 	// Temperature controls sampling randomness.
 	Temperature float64 `json:"temperature"`
+	// TopP and TopK restrict sampling to the most likely tokens. Zero
+	// leaves the server default.
+	TopP float64 `json:"top_p,omitempty"`
+	TopK int     `json:"top_k,omitempty"`
+	// Grammar is a GBNF grammar constraining the generated text.
+	Grammar string `json:"grammar,omitempty"`
+}
+
+// Options adjusts a single completion. Zero values keep the defaults.
+type Options struct {
+	// NPredict overrides the number of tokens to generate.
+	NPredict int
+	// Grammar constrains sampling to a GBNF grammar, e.g. one produced by
+	// the grammar package from a JSON Schema.
+	Grammar string
 }
 
 type completionResponse struct {
 	// Content is the raw text returned by the LLM server.
 	Content string `json:"content"`
+	// TokensPredicted and TokensEvaluated count the generated and prompt
+	// tokens; Timings reports the generation speed. They feed the metrics.
+	TokensPredicted int `json:"tokens_predicted"`
+	TokensEvaluated int `json:"tokens_evaluated"`
+	Timings         struct {
+		PredictedPerSecond float64 `json:"predicted_per_second"`
+	} `json:"timings"`
 }
 
 // SendPrompt sends the provided prompt to the local LLM server and returns the
-// generated text.  Higher level components such as the HTTP handlers rely on
-// this function to interact with the language model.  The request format is
-// currently fixed but could be extended with additional parameters if new
-// model features become available.
+// generated text.  It is a convenience wrapper around SendPromptContext using
+// a background context.
 func SendPrompt(prompt string) (string, error) {
+	return SendPromptContext(context.Background(), prompt)
+}
+
+// SendPromptContext sends prompt using the default client. Higher level
+// components such as the HTTP handlers pass the request context so a client
+// disconnect cancels generation on the backend.
+func SendPromptContext(ctx context.Context, prompt string) (string, error) {
+	return DefaultClient().Complete(ctx, prompt)
+}
+
+// SendPromptWith sends prompt with per-request options using the default
+// client.
+func SendPromptWith(ctx context.Context, prompt string, opts Options) (string, error) {
+	return DefaultClient().CompleteWith(ctx, prompt, opts)
+}
+
+// Complete requests a completion for prompt with the default options.
+func (c *Client) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.CompleteWith(ctx, prompt, Options{})
+}
+
+// CompleteWith requests a completion for prompt. Transient failures are
+// retried up to MaxRetries times; other failures are returned as
+// *BackendError values wrapping one of the package errors, or the context's
+// error when ctx ends.
+func (c *Client) CompleteWith(ctx context.Context, prompt string, opts Options) (string, error) {
 	reqBody := completionRequest{
 		Prompt:      prompt,
-		NPredict:    300,
-		Temperature: 0.7,
+		NPredict:    c.Sampling.NPredict,
+		Temperature: c.Sampling.Temperature,
+		TopP:        c.Sampling.TopP,
+		TopK:        c.Sampling.TopK,
+		Grammar:     opts.Grammar,
 	}
-
-	body, _ := json.Marshal(reqBody)
-
-	// TODO: make the endpoint configurable so alternative LLM backends can
-	// be targeted in the future.  This is a natural extension point for
-	// supporting remote or cloud hosted models.
-	resp, err := http.Post("http://localhost:8080/completion", "application/json", bytes.NewReader(body))
+	if opts.NPredict > 0 {
+		reqBody.NPredict = opts.NPredict
+	}
+	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	promptBytes.Observe(float64(len(prompt)))
 
-	resBody, _ := io.ReadAll(resp.Body)
+	resBody, err := c.do(ctx, http.MethodPost, "/completion", body, true)
+	if err != nil {
+		return "", err
+	}
 
 	// Parse the JSON response returned by the LLM server. If the format
 	// changes in future this section will need to adapt.
 	var respData completionResponse
-	json.Unmarshal(resBody, &respData)
-
+	if err := json.Unmarshal(resBody, &respData); err != nil {
+		backendErrors.With("/completion", "bad_response").Inc()
+		return "", &BackendError{Kind: ErrBadResponse, StatusCode: http.StatusOK, Message: err.Error()}
+	}
+	recordCompletion(respData)
 	return respData.Content, nil
 }
 
@@ -66,15 +240,163 @@ func SendPrompt(prompt string) (string, error) {
 // path. This relies on the `/props` endpoint which reloads the active model when
 // the `model` property is set.
 func LoadModel(path string) error {
-	body, _ := json.Marshal(map[string]string{"model": path})
-	resp, err := http.Post("http://localhost:8080/props", "application/json", bytes.NewReader(body))
+	return DefaultClient().LoadModel(context.Background(), path)
+}
+
+// LoadModel asks the server to load the model at path. It is not retried
+// because a reload in progress would be restarted.
+func (c *Client) LoadModel(ctx context.Context, path string) error {
+	body, err := json.Marshal(map[string]string{"model": path})
 	if err != nil {
 		return err
 	}
+	_, err = c.do(ctx, http.MethodPost, "/props", body, false)
+	return err
+}
+
+// do performs a request against the server and returns the body of a 200
+// response. When retry is set, transient failures are retried with jittered
+// exponential backoff until MaxRetries is exhausted or ctx ends.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, retry bool) ([]byte, error) {
+	attempts := 1
+	if retry && c.MaxRetries > 0 {
+		attempts += c.MaxRetries
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			wait := c.backoff(i)
+			log.Printf("llama request %s %s failed (%v), retrying in %s", method, path, err, wait)
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			case <-t.C:
+			}
+		}
+		var res []byte
+		res, err = c.attempt(ctx, method, path, body)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// attempt performs a single request bounded by Timeout. Failures are
+// counted by type unless the caller gave up on the request.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte) (res []byte, err error) {
+	parent := ctx
+	defer func() {
+		if err != nil && parent.Err() == nil {
+			backendErrors.With(path, errorType(err)).Inc()
+		}
+	}()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		if timedOut(parent, ctx) {
+			return nil, &BackendError{Kind: ErrTimeout, Message: fmt.Sprintf("no answer within %s", c.Timeout)}
+		}
+		return nil, &BackendError{Kind: ErrBackendUnavailable, Message: err.Error()}
+	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return errors.New(string(b))
+	resBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if timedOut(parent, ctx) {
+			return nil, &BackendError{Kind: ErrTimeout, StatusCode: resp.StatusCode, Message: fmt.Sprintf("no answer within %s", c.Timeout)}
+		}
+		return nil, &BackendError{Kind: ErrBadResponse, StatusCode: resp.StatusCode, Message: "reading body: " + err.Error()}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resBody, nil
+	}
+	return nil, classify(resp.StatusCode, resBody)
+}
+
+// timedOut reports whether a failed request ended because its own Timeout
+// expired rather than because the caller gave up.
+func timedOut(parent, attempt context.Context) bool {
+	return parent.Err() == nil && errors.Is(attempt.Err(), context.DeadlineExceeded)
+}
+
+// classify turns a non-200 response into a *BackendError. llama.cpp reports
+// errors as {"error":{"code":..,"message":..,"type":..}}.
+func classify(status int, body []byte) error {
+	var data struct {
+		Status string `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &data)
+	msg := data.Error.Message
+	if msg == "" {
+		msg = strings.TrimSpace(string(body))
+	}
+	if len(msg) > 512 {
+		msg = msg[:512]
+	}
+	lower := strings.ToLower(data.Status + " " + data.Error.Type + " " + msg)
+
+	kind := ErrBadResponse
+	switch {
+	case status == http.StatusServiceUnavailable && strings.Contains(lower, "loading"):
+		kind = ErrModelLoading
+	case strings.Contains(lower, "exceed_context_size") ||
+		strings.Contains(lower, "context size") ||
+		strings.Contains(lower, "context length") ||
+		strings.Contains(lower, "too many tokens"):
+		kind = ErrContextOverflow
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout:
+		kind = ErrBackendUnavailable
+	}
+	return &BackendError{Kind: kind, StatusCode: status, Message: msg}
+}
+
+// backoff returns the delay before retry attempt n (starting at 1): a random
+// duration between MinBackoff and an exponentially growing cap.
+func (c *Client) backoff(n int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	ceil := min << uint(n-1)
+	if ceil > max || ceil <= 0 {
+		ceil = max
+	}
+	if ceil == min {
+		return min
 	}
-	return nil
+	return min + time.Duration(rand.Int63n(int64(ceil-min)))
 }
@@ -4,11 +4,15 @@ package llama
 // that mimics the real model endpoint.
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestSendPrompt runs the client against a mocked LLM HTTP server to ensure
@@ -38,3 +42,207 @@ func TestSendPrompt(t *testing.T) {
 		t.Fatalf("unexpected response: %s", out)
 	}
 }
+
+// fastClient returns a client for srv that retries without noticeable delay.
+func fastClient(srv *httptest.Server) *Client {
+	c := NewClient(srv.URL)
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 2 * time.Millisecond
+	return c
+}
+
+// TestCompleteRetriesTransient checks that a loading backend is retried until
+// it answers.
+func TestCompleteRetriesTransient(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`))
+			return
+		}
+		w.Write([]byte(`{"content":"ok"}`))
+	}))
+	defer srv.Close()
+
+	out, err := fastClient(srv).Complete(context.Background(), "hi")
+	if err != nil || out != "ok" {
+		t.Fatalf("unexpected result %q %v", out, err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+}
+
+// TestCompleteErrors checks that permanent failures are typed and not
+// retried.
+func TestCompleteErrors(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusBadRequest, `{"error":{"code":400,"message":"the request exceeds the available context size, try increasing it","type":"exceed_context_size_error"}}`, ErrContextOverflow},
+		{http.StatusInternalServerError, `{"error":{"code":500,"message":"boom"}}`, ErrBadResponse},
+		{http.StatusOK, `not json`, ErrBadResponse},
+	}
+	for _, tc := range cases {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+		_, err := fastClient(srv).Complete(context.Background(), "hi")
+		srv.Close()
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("status %d: expected %v, got %v", tc.status, tc.want, err)
+		}
+		if calls != 1 {
+			t.Fatalf("status %d: expected a single attempt, got %d", tc.status, calls)
+		}
+	}
+}
+
+// TestCompleteCancelled checks that cancelling the context aborts an
+// in-flight request with the context's error.
+func TestCompleteCancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := fastClient(srv).Complete(ctx, "hi"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+// TestCompleteWithGrammar checks that per-request options reach the backend.
+func TestCompleteWithGrammar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req completionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Grammar != `root ::= "yes"` || req.NPredict != 8 {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		w.Write([]byte(`{"content":"yes"}`))
+	}))
+	defer srv.Close()
+
+	out, err := fastClient(srv).CompleteWith(context.Background(), "ok?", Options{Grammar: `root ::= "yes"`, NPredict: 8})
+	if err != nil || out != "yes" {
+		t.Fatalf("unexpected result %q %v", out, err)
+	}
+}
+
+// TestCompletionMetrics checks that generated tokens, speed and failed
+// attempts are recorded, and that a timeout is told apart from other
+// failures and not retried.
+func TestCompletionMetrics(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`))
+		case 2:
+			w.Write([]byte(`{"content":"ok","tokens_predicted":12,"tokens_evaluated":40,"timings":{"predicted_per_second":24.5}}`))
+		default:
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	tokens := tokensGenerated.Value()
+	loading := backendErrors.With("/completion", "loading").Value()
+	timeouts := backendErrors.With("/completion", "timeout").Value()
+	c := fastClient(srv)
+	if _, err := c.Complete(context.Background(), "hi"); err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	if got := tokensGenerated.Value() - tokens; got != 12 {
+		t.Fatalf("tokens generated = %v, want 12", got)
+	}
+	if got := backendErrors.With("/completion", "loading").Value() - loading; got != 1 {
+		t.Fatalf("loading errors = %v, want 1", got)
+	}
+
+	// a slow generation is not retried: the backend is up, and every retry
+	// would hold the queue slot for another Timeout
+	c.Timeout = 10 * time.Millisecond
+	c.MaxRetries = 3
+	if _, err := c.Complete(context.Background(), "hi"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("timed out request sent %d times", n-2)
+	}
+	if got := backendErrors.With("/completion", "timeout").Value() - timeouts; got != 1 {
+		t.Fatalf("timeout errors = %v, want 1", got)
+	}
+}
+
+// TestEmbedQueryNoRetry checks that Embed retries a loading backend while
+// EmbedQuery gives up after the first attempt.
+func TestEmbedQueryNoRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`))
+			return
+		}
+		w.Write([]byte(`{"embedding":[0.5,0.5]}`))
+	}))
+	defer srv.Close()
+
+	c := fastClient(srv)
+	if v, err := c.Embed(context.Background(), "hi"); err != nil || len(v) != 2 {
+		t.Fatalf("Embed: %v %v", v, err)
+	}
+	if _, err := c.EmbedQuery(context.Background(), "hi"); !errors.Is(err, ErrModelLoading) {
+		t.Fatalf("expected ErrModelLoading, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+}
+
+// TestUseModelExternal checks that without a supervisor the model file, not
+// its directory, is posted to /props of the default client's server.
+func TestUseModelExternal(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/props" {
+			t.Errorf("request to %s", r.URL.Path)
+		}
+		got <- req.Model
+		w.Write([]byte(`{"success":true}`))
+	}))
+	defer srv.Close()
+	prev := DefaultClient()
+	defer SetDefaultClient(prev)
+	SetDefaultClient(NewClient(srv.URL))
+
+	if ActiveSupervisor() != nil {
+		t.Fatalf("unexpected supervisor")
+	}
+	file := "/data/models/tiny/tiny-q4.gguf"
+	if err := UseModel("tiny", file); err != nil {
+		t.Fatalf("UseModel error: %v", err)
+	}
+	if path := <-got; path != file {
+		t.Fatalf("/props got model %q, want %q", path, file)
+	}
+}
@@ -0,0 +1,66 @@
+package llama
+
+// Embeddings. llama-server answers POST /embedding when started with
+// --embeddings; the knowledge base uses it to index and retrieve documents.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Embed returns the embedding of text using the default client.
+func Embed(ctx context.Context, text string) ([]float32, error) {
+	return DefaultClient().Embed(ctx, text)
+}
+
+// EmbedQuery returns the embedding of a search query using the default
+// client.
+func EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return DefaultClient().EmbedQuery(ctx, text)
+}
+
+// Embed requests the embedding of text. Older servers answer
+// {"embedding":[...]} while newer ones return
+// [{"index":0,"embedding":[[...]]}]; both are accepted.
+func (c *Client) Embed(ctx context.Context, text string) ([]float32, error) {
+	return c.embed(ctx, text, true)
+}
+
+// EmbedQuery is Embed without retries. Queries are embedded while a chat
+// waits for its answer, and the caller would rather fall back to the
+// hashing embedder than sit through the backoff.
+func (c *Client) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return c.embed(ctx, text, false)
+}
+
+func (c *Client) embed(ctx context.Context, text string, retry bool) ([]float32, error) {
+	body, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, http.MethodPost, "/embedding", body, retry)
+	if err != nil {
+		return nil, err
+	}
+	var single struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(res, &single); err == nil && len(single.Embedding) > 0 {
+		return single.Embedding, nil
+	}
+	var list []struct {
+		Embedding json.RawMessage `json:"embedding"`
+	}
+	if err := json.Unmarshal(res, &list); err == nil && len(list) > 0 {
+		var flat []float32
+		if json.Unmarshal(list[0].Embedding, &flat) == nil && len(flat) > 0 {
+			return flat, nil
+		}
+		var nested [][]float32
+		if json.Unmarshal(list[0].Embedding, &nested) == nil && len(nested) > 0 && len(nested[0]) > 0 {
+			return nested[0], nil
+		}
+	}
+	return nil, &BackendError{Kind: ErrBadResponse, StatusCode: http.StatusOK, Message: "no embedding in response"}
+}
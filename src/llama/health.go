@@ -0,0 +1,28 @@
+package llama
+
+// Backend health probing.  llama.cpp's server exposes GET /health which
+// answers 200 once a model is loaded and 503 while it is still loading.  The
+// health package polls this through Health so chat requests can fail fast
+// instead of waiting on a backend that cannot answer.
+
+import (
+	"context"
+	"net/http"
+)
+
+// Health probes the default client's backend. See Client.Health.
+func Health(ctx context.Context) error {
+	return DefaultClient().Health(ctx)
+}
+
+// Health probes the backend's /health endpoint. It returns nil when the
+// server is ready, an error wrapping ErrModelLoading while the model loads and
+// one wrapping ErrBackendUnavailable when the server cannot be reached. The
+// probe is never retried so the caller sees the current state.
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/health", nil, false)
+	if err != nil && ctx.Err() != nil {
+		return &BackendError{Kind: ErrBackendUnavailable, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package llama
+
+// Tests for backend health probing against a mocked llama.cpp server.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestHealth checks that a loading model and a ready server are told apart.
+func TestHealth(t *testing.T) {
+	var loading atomic.Bool
+	loading.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if loading.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Loading model"}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	prev := DefaultClient()
+	defer SetDefaultClient(prev)
+	SetDefaultClient(NewClient(srv.URL))
+
+	if err := Health(context.Background()); !errors.Is(err, ErrModelLoading) {
+		t.Fatalf("expected ErrModelLoading, got %v", err)
+	}
+	loading.Store(false)
+	if err := Health(context.Background()); err != nil {
+		t.Fatalf("expected healthy backend, got %v", err)
+	}
+	srv.Close()
+	if err := Health(context.Background()); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("expected ErrBackendUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package llama
+
+// Metrics of completions and backend failures for `GET /metrics`.
+
+import (
+	"codex/src/metrics"
+	"errors"
+)
+
+var (
+	tokensGenerated = metrics.NewCounter("codex_llama_tokens_generated_total",
+		"Tokens generated by the model backend for chats and background work.")
+	tokensPerSecond = metrics.NewHistogram("codex_llama_tokens_per_second",
+		"Generation speed of completions as reported by the backend.",
+		[]float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 200})
+	promptTokens = metrics.NewHistogram("codex_llama_prompt_tokens",
+		"Prompt size in tokens as reported by the backend.",
+		metrics.ExponentialBuckets(64, 2, 10))
+	promptBytes = metrics.NewHistogram("codex_llama_prompt_bytes",
+		"Prompt size in bytes sent to the backend.",
+		metrics.ExponentialBuckets(256, 2, 10))
+	backendErrors = metrics.NewCounterVec("codex_llama_backend_errors_total",
+		"Failed requests to the model backend, including retried ones and health probes, by endpoint and type.",
+		"endpoint", "type")
+)
+
+// errorType names the kind of a failed backend request for backendErrors.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrModelLoading):
+		return "loading"
+	case errors.Is(err, ErrContextOverflow):
+		return "context_overflow"
+	case errors.Is(err, ErrBackendUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrBadResponse):
+		return "bad_response"
+	}
+	return "other"
+}
+
+// recordCompletion records the statistics llama.cpp returns with a
+// completion. Older servers without timings only count tokens.
+func recordCompletion(r completionResponse) {
+	tokensGenerated.Add(float64(r.TokensPredicted))
+	if r.Timings.PredictedPerSecond > 0 {
+		tokensPerSecond.Observe(r.Timings.PredictedPerSecond)
+	}
+	if r.TokensEvaluated > 0 {
+		promptTokens.Observe(float64(r.TokensEvaluated))
+	}
+}
@@ -0,0 +1,436 @@
+package llama
+
+// The supervisor runs llama.cpp's `llama-server` as a child process so Codex
+// no longer depends on someone starting the backend by hand.  It launches the
+// binary with the active model's GGUF file, restarts it with exponential
+// backoff when it crashes, swaps models by restarting the process and keeps
+// the tail of its output for status reporting.
+//
+// AI Awareness: when a supervisor is active, model activation restarts the
+// backend and chat requests fail until the new model has loaded.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supervisor states reported by Status.
+const (
+	StateIdle     = "idle"     // no model selected, nothing running
+	StateRunning  = "running"  // process started and has not exited
+	StateBackoff  = "backoff"  // waiting to restart after a crash
+	StateStopped  = "stopped"  // Stop was called
+	StateDisabled = "external" // no supervisor, backend managed elsewhere
+)
+
+// ServerConfig describes how to launch llama-server.
+type ServerConfig struct {
+	// Binary is the path to the llama-server executable.
+	Binary string
+	// Host and Port are passed to the server and must match the URL the
+	// client uses to reach it.
+	Host string
+	Port int
+	// CtxSize, Threads and BatchSize map to --ctx-size, --threads and
+	// --batch-size. Zero leaves the server default.
+	CtxSize   int
+	Threads   int
+	BatchSize int
+	// ExtraArgs are appended verbatim, e.g. "--n-gpu-layers", "99".
+	ExtraArgs []string
+	// LogPath receives the server's stdout and stderr. Empty disables the
+	// log file; the in-memory tail is always kept.
+	LogPath string
+	// MinBackoff and MaxBackoff bound the delay between crash restarts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultServerConfig returns the settings used by `codex serve` when only a
+// binary path is configured.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Host:       "127.0.0.1",
+		Port:       8080,
+		LogPath:    "llama-server.log",
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// SupervisorStatus is a snapshot of the managed process.
+type SupervisorStatus struct {
+	Managed   bool      `json:"managed"`
+	State     string    `json:"state"`
+	Model     string    `json:"model,omitempty"`
+	ModelPath string    `json:"model_path,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	Restarts  int       `json:"restarts"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	LastExit  string    `json:"last_exit,omitempty"`
+	Logs      []string  `json:"logs,omitempty"`
+}
+
+// stableRun is how long the process must stay up before the crash backoff is
+// reset to MinBackoff.
+const stableRun = time.Minute
+
+// logTailLines is the number of output lines retained in memory.
+const logTailLines = 200
+
+// Supervisor manages a single llama-server process.
+type Supervisor struct {
+	cfg ServerConfig
+
+	mu        sync.Mutex
+	state     string
+	model     string
+	path      string
+	gen       int // incremented by Swap so stale swap signals are ignored
+	cmd       *exec.Cmd
+	startedAt time.Time
+	restarts  int
+	lastExit  string
+	logs      []string
+	swap      chan struct{}
+	done      chan struct{}
+	cancel    context.CancelFunc
+}
+
+// NewSupervisor returns a supervisor for the given configuration. Nothing is
+// launched until Start is called.
+func NewSupervisor(cfg ServerConfig) *Supervisor {
+	def := DefaultServerConfig()
+	if cfg.Host == "" {
+		cfg.Host = def.Host
+	}
+	if cfg.Port == 0 {
+		cfg.Port = def.Port
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = def.MinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	return &Supervisor{cfg: cfg, state: StateIdle, swap: make(chan struct{}, 1)}
+}
+
+// Start begins supervising. When path is empty the supervisor stays idle until
+// Swap provides a model. The process is stopped when ctx is cancelled.
+func (s *Supervisor) Start(ctx context.Context, model, path string) error {
+	if _, err := exec.LookPath(s.cfg.Binary); err != nil {
+		return fmt.Errorf("llama-server binary: %w", err)
+	}
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return errors.New("supervisor already started")
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	s.model, s.path = model, path
+	s.mu.Unlock()
+	go s.run(ctx)
+	return nil
+}
+
+// Swap replaces the served model by restarting the process with path.
+func (s *Supervisor) Swap(model, path string) error {
+	if path == "" {
+		return errors.New("model has no GGUF file")
+	}
+	s.mu.Lock()
+	if s.done == nil {
+		s.mu.Unlock()
+		return errors.New("supervisor not started")
+	}
+	s.model, s.path = model, path
+	s.gen++
+	s.mu.Unlock()
+	log.Printf("llama supervisor swapping to %s", model)
+	select {
+	case s.swap <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stop terminates the process and waits for the supervision loop to exit.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Status returns a snapshot of the process state including the log tail.
+func (s *Supervisor) Status() SupervisorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SupervisorStatus{
+		Managed:   true,
+		State:     s.state,
+		Model:     s.model,
+		ModelPath: s.path,
+		Restarts:  s.restarts,
+		LastExit:  s.lastExit,
+		Logs:      append([]string(nil), s.logs...),
+	}
+	if s.cmd != nil && s.cmd.Process != nil && s.state == StateRunning {
+		st.PID = s.cmd.Process.Pid
+		st.StartedAt = s.startedAt
+	}
+	return st
+}
+
+// args builds the llama-server command line for the model at path.
+func (s *Supervisor) args(path string) []string {
+	args := []string{"--model", path, "--host", s.cfg.Host, "--port", strconv.Itoa(s.cfg.Port)}
+	if s.cfg.CtxSize > 0 {
+		args = append(args, "--ctx-size", strconv.Itoa(s.cfg.CtxSize))
+	}
+	if s.cfg.Threads > 0 {
+		args = append(args, "--threads", strconv.Itoa(s.cfg.Threads))
+	}
+	if s.cfg.BatchSize > 0 {
+		args = append(args, "--batch-size", strconv.Itoa(s.cfg.BatchSize))
+	}
+	return append(args, s.cfg.ExtraArgs...)
+}
+
+// run is the supervision loop. It starts the process, waits for it to exit
+// and decides whether to restart immediately (model swap), after a backoff
+// (crash) or not at all (shutdown).
+func (s *Supervisor) run(ctx context.Context) {
+	defer func() {
+		s.setState(StateStopped)
+		close(s.done)
+	}()
+	backoff := s.cfg.MinBackoff
+	for {
+		s.mu.Lock()
+		path, gen := s.path, s.gen
+		s.mu.Unlock()
+		if path == "" {
+			s.setState(StateIdle)
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.swap:
+				continue
+			}
+		}
+
+		exited, err := s.launch(path)
+		if err != nil {
+			s.recordExit(err)
+		} else {
+			swapped := false
+			for !swapped && exited != nil {
+				select {
+				case <-ctx.Done():
+					s.terminate(exited)
+					return
+				case <-s.swap:
+					s.mu.Lock()
+					swapped = s.gen != gen
+					s.mu.Unlock()
+				case err = <-exited:
+					s.recordExit(err)
+					exited = nil
+				}
+			}
+			if swapped {
+				s.terminate(exited)
+				backoff = s.cfg.MinBackoff
+				continue
+			}
+			s.mu.Lock()
+			if time.Since(s.startedAt) > stableRun {
+				backoff = s.cfg.MinBackoff
+			}
+			s.mu.Unlock()
+		}
+
+		s.mu.Lock()
+		s.state = StateBackoff
+		s.restarts++
+		s.mu.Unlock()
+		log.Printf("llama-server exited, restarting in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.swap:
+			backoff = s.cfg.MinBackoff
+		case <-time.After(backoff):
+			backoff *= 2
+			if backoff > s.cfg.MaxBackoff {
+				backoff = s.cfg.MaxBackoff
+			}
+		}
+	}
+}
+
+// launch starts the process and returns a channel receiving its exit error.
+func (s *Supervisor) launch(path string) (<-chan error, error) {
+	cmd := exec.Command(s.cfg.Binary, s.args(path)...)
+	out := io.Writer(&tailWriter{s: s})
+	var logFile *os.File
+	if s.cfg.LogPath != "" {
+		f, err := os.OpenFile(s.cfg.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("llama-server log file: %v", err)
+		} else {
+			logFile = f
+			out = io.MultiWriter(f, out)
+		}
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	log.Printf("starting %s %v", s.cfg.Binary, cmd.Args[1:])
+	if err := cmd.Start(); err != nil {
+		if logFile != nil {
+			logFile.Close()
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cmd = cmd
+	s.state = StateRunning
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+		exited <- err
+	}()
+	return exited, nil
+}
+
+// terminate asks the process to exit and kills it if it does not stop within
+// a few seconds.
+func (s *Supervisor) terminate(exited <-chan error) {
+	s.mu.Lock()
+	cmd := s.cmd
+	s.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case err := <-exited:
+		s.recordExit(err)
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		s.recordExit(<-exited)
+	}
+}
+
+// recordExit stores a description of how the process ended.
+func (s *Supervisor) recordExit(err error) {
+	msg := "exited"
+	if err != nil {
+		msg = err.Error()
+	}
+	s.mu.Lock()
+	s.lastExit = msg
+	s.cmd = nil
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// appendLog adds a line to the in-memory tail.
+func (s *Supervisor) appendLog(line string) {
+	s.mu.Lock()
+	s.logs = append(s.logs, line)
+	if len(s.logs) > logTailLines {
+		s.logs = s.logs[len(s.logs)-logTailLines:]
+	}
+	s.mu.Unlock()
+}
+
+// tailWriter splits process output into lines for the in-memory log tail.
+type tailWriter struct {
+	s       *Supervisor
+	partial []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.s.appendLog(strings.TrimRight(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) > 64<<10 {
+		w.s.appendLog(string(w.partial))
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+var (
+	activeMu         sync.RWMutex
+	activeSupervisor *Supervisor
+)
+
+// SetSupervisor registers the supervisor used by the HTTP handlers. Passing
+// nil marks the backend as externally managed.
+func SetSupervisor(s *Supervisor) {
+	activeMu.Lock()
+	activeSupervisor = s
+	activeMu.Unlock()
+}
+
+// ActiveSupervisor returns the registered supervisor or nil.
+func ActiveSupervisor() *Supervisor {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return activeSupervisor
+}
+
+// UseModel switches the backend to the model file with the given id. A
+// managed backend is restarted with it; an external one is asked to load
+// the file in place through /props.
+func UseModel(id, file string) error {
+	if s := ActiveSupervisor(); s != nil {
+		return s.Swap(id, file)
+	}
+	return LoadModel(file)
+}
+
+// BackendStatus reports the supervisor status or, without a supervisor, that
+// the backend is managed externally.
+func BackendStatus() SupervisorStatus {
+	if s := ActiveSupervisor(); s != nil {
+		return s.Status()
+	}
+	return SupervisorStatus{State: StateDisabled}
+}
@@ -0,0 +1,96 @@
+package llama
+
+// Tests for the llama-server supervisor. A small shell script stands in for
+// the real binary so restarts, model swaps and log capture can be observed.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer writes a script that prints its arguments and then runs body.
+func fakeServer(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script stand-in requires a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "llama-server")
+	script := "#!/bin/sh\necho \"args: $*\"\n" + body + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// waitFor polls cond until it returns true or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// TestSupervisorRestartsOnCrash checks that an exiting process is restarted
+// and that its output is captured in the status log tail.
+func TestSupervisorRestartsOnCrash(t *testing.T) {
+	bin := fakeServer(t, "exit 1")
+	s := NewSupervisor(ServerConfig{Binary: bin, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	if err := s.Start(context.Background(), "m", "/models/m.gguf"); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	defer s.Stop()
+
+	waitFor(t, "restarts", func() bool { return s.Status().Restarts >= 2 })
+	st := s.Status()
+	if len(st.Logs) == 0 || !strings.Contains(st.Logs[0], "--model /models/m.gguf") {
+		t.Fatalf("args not logged: %v", st.Logs)
+	}
+	if st.LastExit == "" {
+		t.Fatalf("exit not recorded")
+	}
+}
+
+// TestSupervisorSwap verifies that swapping models restarts the process with
+// the new model path and that Stop terminates it.
+func TestSupervisorSwap(t *testing.T) {
+	bin := fakeServer(t, "exec sleep 30")
+	s := NewSupervisor(ServerConfig{Binary: bin, CtxSize: 2048})
+	if err := s.Start(context.Background(), "", ""); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if st := s.Status(); st.State != StateIdle {
+		t.Fatalf("expected idle without model, got %s", st.State)
+	}
+	if err := s.Swap("a", "/models/a.gguf"); err != nil {
+		t.Fatalf("Swap error: %v", err)
+	}
+	waitFor(t, "first model", func() bool {
+		st := s.Status()
+		return st.State == StateRunning && len(st.Logs) == 1
+	})
+	if err := s.Swap("b", "/models/b.gguf"); err != nil {
+		t.Fatalf("Swap error: %v", err)
+	}
+	waitFor(t, "second model", func() bool { return len(s.Status().Logs) == 2 })
+	st := s.Status()
+	if st.Model != "b" || !strings.Contains(st.Logs[1], "--model /models/b.gguf --host 127.0.0.1 --port 8080 --ctx-size 2048") {
+		t.Fatalf("unexpected status after swap: %+v", st)
+	}
+	if st.Restarts != 0 {
+		t.Fatalf("swap should not count as crash restart: %d", st.Restarts)
+	}
+	s.Stop()
+	if st := s.Status(); st.State != StateStopped {
+		t.Fatalf("expected stopped, got %s", st.State)
+	}
+}
@@ -0,0 +1,150 @@
+package memory
+
+// API keys let scripts and editor plugins authenticate without a browser
+// session. Only a hash of each key is stored; the short prefix is kept in
+// clear so a key can be found and recognised in listings.
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrKeyNotFound is returned when an API key does not exist.
+var ErrKeyNotFound = errors.New("api key not found")
+
+// APIKey is a stored key. The secret part is never stored.
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Hash   string `json:"-"`
+	// Scopes limits what the key may do beyond its user's role.
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt, LastUsed and RevokedAt are nil when unset and then left
+	// out of the JSON.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// initAPIKeys creates the api_keys table.
+func initAPIKeys(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT,
+		prefix TEXT NOT NULL UNIQUE,
+		hash TEXT NOT NULL,
+		scopes TEXT,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		last_used DATETIME,
+		revoked_at DATETIME
+	);`); err != nil {
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS api_keys_user ON api_keys(user_id)`)
+	return nil
+}
+
+// nullTime stores a nil time as NULL.
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// timePtr returns the time of a nullable column, or nil for NULL.
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// CreateAPIKey stores k and sets its ID.
+func CreateAPIKey(db *sql.DB, k *APIKey) error {
+	res, err := db.Exec(`INSERT INTO api_keys(user_id, name, prefix, hash, scopes, created_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		k.UserID, k.Name, k.Prefix, k.Hash, strings.Join(k.Scopes, ","), k.CreatedAt.UTC(), nullTime(k.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	k.ID = int(id)
+	return err
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used, revoked_at`
+
+// scanAPIKey reads a row selected with apiKeyColumns.
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var k APIKey
+	var name, scopes sql.NullString
+	var expires, used, revoked sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &name, &k.Prefix, &k.Hash, &scopes, &k.CreatedAt, &expires, &used, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	k.Name = name.String
+	if scopes.String != "" {
+		k.Scopes = strings.Split(scopes.String, ",")
+	}
+	k.ExpiresAt, k.LastUsed, k.RevokedAt = timePtr(expires), timePtr(used), timePtr(revoked)
+	return &k, nil
+}
+
+// GetAPIKeyByPrefix loads the key with the given prefix.
+func GetAPIKeyByPrefix(db *sql.DB, prefix string) (*APIKey, error) {
+	return scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = ?`, prefix))
+}
+
+// ListAPIKeys returns the keys of a user, or of every user when userID is
+// zero, newest first.
+func ListAPIKeys(db *sql.DB, userID int) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE ? = 0 OR user_id = ? ORDER BY id DESC`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks the key with the given prefix as revoked.
+func RevokeAPIKey(db *sql.DB, prefix string, at time.Time) error {
+	res, err := db.Exec(`UPDATE api_keys SET revoked_at = ? WHERE prefix = ? AND revoked_at IS NULL`, at.UTC(), prefix)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used.
+func TouchAPIKey(db *sql.DB, id int, at time.Time) error {
+	_, err := db.Exec(`UPDATE api_keys SET last_used = ? WHERE id = ?`, at.UTC(), id)
+	return err
+}
+
+// deleteUserKeys removes the keys of a user inside a DeleteUser transaction.
+func deleteUserKeys(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(`DELETE FROM api_keys WHERE user_id = ?`, userID)
+	return err
+}
@@ -0,0 +1,262 @@
+package memory
+
+// Storage for the project knowledge base. Ingested documents are split into
+// chunks, each stored with its source location and an embedding vector so the
+// knowledge package can retrieve relevant passages for chat.
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+// Document describes one ingested source within a project.
+type Document struct {
+	ID      int       `json:"id"`
+	Project string    `json:"project"`
+	Source  string    `json:"source"`
+	Kind    string    `json:"kind"`
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"mod_time"`
+	Chunks  int       `json:"chunks"`
+	// IngestedAt is when the document was last (re)ingested.
+	IngestedAt time.Time `json:"ingested_at"`
+}
+
+// Chunk is a retrievable passage of a document.
+type Chunk struct {
+	ID         int
+	DocumentID int
+	Project    string
+	Source     string
+	Ordinal    int
+	Content    string
+	StartLine  int
+	EndLine    int
+	// Symbol names the function or section the chunk covers, if known.
+	Symbol string
+	// Embedder identifies the vector space of Embedding; vectors from
+	// different embedders are not comparable.
+	Embedder  string
+	Embedding []float32
+}
+
+// initKnowledge creates the documents and chunks tables.
+func initKnowledge(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project TEXT NOT NULL,
+		source TEXT NOT NULL,
+		kind TEXT,
+		hash TEXT,
+		mod_time DATETIME,
+		chunks INTEGER DEFAULT 0,
+		ingested_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(project, source)
+	);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		document_id INTEGER NOT NULL,
+		project TEXT NOT NULL,
+		ordinal INTEGER,
+		content TEXT,
+		start_line INTEGER,
+		end_line INTEGER,
+		symbol TEXT,
+		embedder TEXT,
+		embedding BLOB
+	);`); err != nil {
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS chunks_document ON chunks(document_id)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS chunks_project ON chunks(project)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS chunks_project_embedder ON chunks(project, embedder)`)
+	return nil
+}
+
+// encodeVector packs a vector as little-endian float32 values.
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+// decodeVector reverses encodeVector.
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// SaveDocument stores doc and replaces its chunks in one transaction. doc.ID
+// and doc.Chunks are updated.
+func SaveDocument(db *sql.DB, doc *Document, chunks []Chunk) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO documents(project, source, kind, hash, mod_time, chunks, ingested_at)
+		VALUES(?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(project, source) DO UPDATE SET kind = excluded.kind, hash = excluded.hash,
+			mod_time = excluded.mod_time, chunks = excluded.chunks, ingested_at = CURRENT_TIMESTAMP`,
+		doc.Project, doc.Source, doc.Kind, doc.Hash, doc.ModTime, len(chunks))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.QueryRow(`SELECT id FROM documents WHERE project = ? AND source = ?`, doc.Project, doc.Source).Scan(&doc.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE document_id = ?`, doc.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO chunks(document_id, project, ordinal, content, start_line, end_line, symbol, embedder, embedding)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for i, c := range chunks {
+		if _, err := stmt.Exec(doc.ID, doc.Project, i, c.Content, c.StartLine, c.EndLine, c.Symbol, c.Embedder, encodeVector(c.Embedding)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	doc.Chunks = len(chunks)
+	return tx.Commit()
+}
+
+// GetDocument returns the document for source in project, or nil when it has
+// not been ingested.
+func GetDocument(db *sql.DB, project, source string) (*Document, error) {
+	row := db.QueryRow(`SELECT id, project, source, kind, hash, mod_time, chunks, ingested_at
+		FROM documents WHERE project = ? AND source = ?`, project, source)
+	var d Document
+	var kind, hash sql.NullString
+	var mod sql.NullTime
+	err := row.Scan(&d.ID, &d.Project, &d.Source, &kind, &hash, &mod, &d.Chunks, &d.IngestedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Kind, d.Hash, d.ModTime = kind.String, hash.String, mod.Time
+	return &d, nil
+}
+
+// ListDocuments returns the documents of a project ordered by source. A
+// non-empty prefix limits the result to sources starting with it.
+func ListDocuments(db *sql.DB, project, prefix string) ([]Document, error) {
+	rows, err := db.Query(`SELECT id, project, source, kind, hash, mod_time, chunks, ingested_at
+		FROM documents WHERE project = ? AND substr(source, 1, ?) = ? ORDER BY source`,
+		project, len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []Document
+	for rows.Next() {
+		var d Document
+		var kind, hash sql.NullString
+		var mod sql.NullTime
+		if err := rows.Scan(&d.ID, &d.Project, &d.Source, &kind, &hash, &mod, &d.Chunks, &d.IngestedAt); err != nil {
+			return nil, err
+		}
+		d.Kind, d.Hash, d.ModTime = kind.String, hash.String, mod.Time
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+// DeleteDocument removes a document and its chunks.
+func DeleteDocument(db *sql.DB, project, source string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE document_id IN (SELECT id FROM documents WHERE project = ? AND source = ?)`, project, source); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM documents WHERE project = ? AND source = ?`, project, source); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ProjectChunks loads up to limit chunks of a project embedded by one of
+// embedders, with their embedding and source. Retrieval scores them in
+// memory, so limit bounds the work a single search can cause.
+func ProjectChunks(db *sql.DB, project string, embedders []string, limit int) ([]Chunk, error) {
+	if len(embedders) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	args := []any{project}
+	for _, e := range embedders {
+		args = append(args, e)
+	}
+	args = append(args, limit)
+	rows, err := db.Query(`SELECT c.id, c.document_id, c.project, d.source, c.ordinal, c.content,
+			c.start_line, c.end_line, COALESCE(c.symbol, ''), COALESCE(c.embedder, ''), c.embedding
+		FROM chunks c JOIN documents d ON d.id = c.document_id
+		WHERE c.project = ? AND c.embedder IN (?`+strings.Repeat(", ?", len(embedders)-1)+`)
+		ORDER BY c.document_id, c.ordinal LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Chunk
+	for rows.Next() {
+		var c Chunk
+		var emb []byte
+		if err := rows.Scan(&c.ID, &c.DocumentID, &c.Project, &c.Source, &c.Ordinal, &c.Content,
+			&c.StartLine, &c.EndLine, &c.Symbol, &c.Embedder, &emb); err != nil {
+			return nil, err
+		}
+		c.Embedding = decodeVector(emb)
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// CountChunks reports how many chunks a project holds.
+func CountChunks(db *sql.DB, project string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM chunks WHERE project = ?`, project).Scan(&n)
+	return n, err
+}
+
+// deleteKnowledge removes the documents and chunks of a project inside a
+// DeleteProject transaction.
+func deleteKnowledge(tx *sql.Tx, name string) error {
+	for _, table := range []string{"chunks", "documents"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE project = ?`, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameKnowledge moves documents and chunks to a new project name inside a
+// RenameProject transaction.
+func renameKnowledge(tx *sql.Tx, oldName, newName string) error {
+	for _, table := range []string{"documents", "chunks"} {
+		if _, err := tx.Exec(`UPDATE `+table+` SET project = ? WHERE project = ?`, newName, oldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
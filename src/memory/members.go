@@ -0,0 +1,280 @@
+package memory
+
+// Project membership and per-principal settings. A principal is an opaque
+// string naming whoever acts on a project, such as "user:3" for an account
+// or "anon:<id>" for an anonymous browser; the auth package builds them. A
+// project without members predates ownership or was created from the CLI and
+// stays shared with everyone. Once it has an owner only its members can see
+// it.
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Access levels on a project, from least to most privileged. Readers may
+// chat with the project's context and read its documents, writers may also
+// record memories and change documents, and owners may additionally rename
+// the project and manage its members.
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+	AccessOwner = "owner"
+)
+
+// accessRank orders the access levels.
+var accessRank = map[string]int{AccessRead: 1, AccessWrite: 2, AccessOwner: 3}
+
+// ErrProjectNotFound is returned when a project does not exist.
+var ErrProjectNotFound = errors.New("project not found")
+
+// ErrLastOwner is returned when removing or demoting the only owner of a
+// project.
+var ErrLastOwner = errors.New("a project needs at least one owner")
+
+// ValidAccess reports whether a is a known access level.
+func ValidAccess(a string) bool {
+	return accessRank[a] > 0
+}
+
+// AccessAllows reports whether a holder of have may do what need requires.
+func AccessAllows(have, need string) bool {
+	return accessRank[have] > 0 && accessRank[have] >= accessRank[need]
+}
+
+// ProjectMember grants a principal access to a project.
+type ProjectMember struct {
+	Project   string    `json:"project"`
+	Principal string    `json:"principal"`
+	Access    string    `json:"access"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+// initMembers creates the project_members table.
+func initMembers(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS project_members (
+		project TEXT NOT NULL,
+		principal TEXT NOT NULL,
+		access TEXT NOT NULL,
+		added_at DATETIME NOT NULL,
+		PRIMARY KEY(project, principal)
+	);`); err != nil {
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS project_members_principal ON project_members(principal)`)
+	return nil
+}
+
+// ProjectExists reports whether a project of that name exists.
+func ProjectExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM projects WHERE name = ?`, name).Scan(&n)
+	return n > 0, err
+}
+
+// CreateProject adds a project owned by owner. An empty owner creates a
+// shared project like AddProject. Creating a project that exists is not an
+// error and leaves its members unchanged.
+func CreateProject(db *sql.DB, name, owner string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT OR IGNORE INTO projects(name) VALUES(?)`, name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 && owner != "" {
+		if _, err := tx.Exec(`INSERT INTO project_members(project, principal, access, added_at) VALUES(?, ?, ?, ?)`,
+			name, owner, AccessOwner, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ProjectAccess returns the access principal holds on project: the member's
+// level, AccessWrite for shared projects without members, or "" when the
+// project does not exist or principal is not a member.
+func ProjectAccess(db *sql.DB, project, principal string) (string, error) {
+	if ok, err := ProjectExists(db, project); err != nil || !ok {
+		return "", err
+	}
+	var access string
+	err := db.QueryRow(`SELECT access FROM project_members WHERE project = ? AND principal = ?`, project, principal).Scan(&access)
+	if err == nil {
+		return access, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM project_members WHERE project = ?`, project).Scan(&n); err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return AccessWrite, nil
+	}
+	return "", nil
+}
+
+// ListProjectsFor returns the names of the projects principal may see,
+// shared projects included, sorted alphabetically.
+func ListProjectsFor(db *sql.DB, principal string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM projects p
+		WHERE NOT EXISTS (SELECT 1 FROM project_members m WHERE m.project = p.name)
+		   OR EXISTS (SELECT 1 FROM project_members m WHERE m.project = p.name AND m.principal = ?)
+		ORDER BY name`, principal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, rows.Err()
+}
+
+// ProjectMembers lists the members of a project, owners first.
+func ProjectMembers(db *sql.DB, project string) ([]ProjectMember, error) {
+	rows, err := db.Query(`SELECT project, principal, access, added_at FROM project_members
+		WHERE project = ? ORDER BY access = 'owner' DESC, added_at, principal`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []ProjectMember
+	for rows.Next() {
+		var m ProjectMember
+		if err := rows.Scan(&m.Project, &m.Principal, &m.Access, &m.AddedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// otherOwners reports how many owners a project has besides principal.
+func otherOwners(tx *sql.Tx, project, principal string) (int, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM project_members WHERE project = ? AND access = 'owner' AND principal <> ?`,
+		project, principal).Scan(&n)
+	return n, err
+}
+
+// SetProjectMember grants principal the given access to project, replacing
+// any previous grant. Demoting the last owner, or granting anything but
+// ownership on a project without owners, fails with ErrLastOwner.
+func SetProjectMember(db *sql.DB, project, principal, access string) error {
+	if ok, err := ProjectExists(db, project); err != nil {
+		return err
+	} else if !ok {
+		return ErrProjectNotFound
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// readers and writers need an owner who can manage them; this also
+	// stops a shared project from being made private without an owner
+	if access != AccessOwner {
+		if n, err := otherOwners(tx, project, principal); err != nil || n == 0 {
+			tx.Rollback()
+			if err == nil {
+				err = ErrLastOwner
+			}
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO project_members(project, principal, access, added_at) VALUES(?, ?, ?, ?)
+		ON CONFLICT(project, principal) DO UPDATE SET access = excluded.access`,
+		project, principal, access, time.Now().UTC()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveProjectMember revokes principal's access to project. Removing the
+// last owner fails with ErrLastOwner so a project never ends up without
+// owners and silently becomes shared.
+func RemoveProjectMember(db *sql.DB, project, principal string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var current string
+	tx.QueryRow(`SELECT access FROM project_members WHERE project = ? AND principal = ?`, project, principal).Scan(&current)
+	if current == "" {
+		tx.Rollback()
+		return nil
+	}
+	if current == AccessOwner {
+		if n, err := otherOwners(tx, project, principal); err != nil || n == 0 {
+			tx.Rollback()
+			if err == nil {
+				err = ErrLastOwner
+			}
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM project_members WHERE project = ? AND principal = ?`, project, principal); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM settings WHERE key = ? AND value = ?`, activeProjectKey(principal), project); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// activeProjectKey is the settings key holding principal's active project.
+// The empty principal, used by the CLI, owns the original global setting.
+func activeProjectKey(principal string) string {
+	if principal == "" {
+		return "active_project"
+	}
+	return "active_project:" + principal
+}
+
+// SetActiveProjectFor records principal's active project without affecting
+// anyone else.
+func SetActiveProjectFor(db *sql.DB, principal, name string) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO settings(key, value) VALUES(?, ?)`, activeProjectKey(principal), name)
+	return err
+}
+
+// GetActiveProjectFor returns principal's active project. A principal that
+// never chose one falls back to the global active project when it may access
+// it, so a single user keeps the project picked with the CLI.
+func GetActiveProjectFor(db *sql.DB, principal string) (string, error) {
+	var name string
+	err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, activeProjectKey(principal)).Scan(&name)
+	if err == nil || principal == "" {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		return name, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	name, err = GetActiveProject(db)
+	if err != nil || name == "" {
+		return "", err
+	}
+	access, err := ProjectAccess(db, name, principal)
+	if err != nil || access == "" {
+		return "", err
+	}
+	return name, nil
+}
@@ -7,9 +7,10 @@ package memory
 
 import (
 	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // MemoryEntry represents a single line of conversation stored in the database.
@@ -24,34 +25,114 @@ type MemoryEntry struct {
 	Importance int
 }
 
-// InitDB opens the SQLite database stored in memory.db in the current working
-// directory and ensures all required tables exist. It returns a handle to the
-// database which callers must close. This function is used throughout the
-// project whenever persistent storage is required.
+// dbPath is the SQLite file opened by InitDB.
+var dbPath = "memory.db"
+
+// SetPath changes the database file opened by InitDB, for example to place
+// it in the configured data directory. Call it before the first InitDB.
+func SetPath(path string) { dbPath = path }
+
+// Path returns the database file opened by InitDB.
+func Path() string { return dbPath }
+
+// Optimize lets SQLite refresh its query planner statistics, which it
+// recommends doing before an application closes its last connection. The
+// server calls it once on shutdown, after every request and background job
+// has closed its handle.
+func Optimize() error {
+	db, err := sql.Open(driverName, dbPath)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`PRAGMA optimize`); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// InitDB opens the SQLite database stored in memory.db, by default in the
+// current working directory (see SetPath), and ensures all required tables
+// exist. It returns a handle to the database which callers must close. This
+// function is used throughout the project whenever persistent storage is
+// required. The schema statements run on the first call for a database
+// file only; later calls just open a handle.
 func InitDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "memory.db")
+	db, err := sql.Open(driverName, dbPath)
 	if err != nil {
 		return nil, err
 	}
+	if schemaCurrent(dbPath) {
+		return db, nil
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	markMigrated(dbPath)
+	return db, nil
+}
+
+var (
+	migratedMu sync.Mutex
+	// migrated maps the absolute path of every database file this process
+	// has migrated to the file it found there.
+	migrated = map[string]os.FileInfo{}
+)
+
+// schemaCurrent reports whether the database file at path has been migrated
+// by this process. A file removed and created again, as tests do, is
+// migrated again.
+func schemaCurrent(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return false
+	}
+	migratedMu.Lock()
+	defer migratedMu.Unlock()
+	prev, ok := migrated[abs]
+	return ok && os.SameFile(prev, fi)
+}
+
+// markMigrated records that the file at path has the current schema.
+func markMigrated(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return
+	}
+	migratedMu.Lock()
+	migrated[abs] = fi
+	migratedMu.Unlock()
+}
+
+// migrate creates every table and index and adds columns introduced since
+// the database was created.
+func migrate(db *sql.DB) error {
 	createMemory := `CREATE TABLE IF NOT EXISTS memory (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         project TEXT,
         role TEXT,
         content TEXT,
         timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-        importance INTEGER DEFAULT 0
+        importance INTEGER DEFAULT 0,
+        summarised INTEGER DEFAULT 0
     );`
 	if _, err := db.Exec(createMemory); err != nil {
-		db.Close()
-		return nil, err
+		return err
 	}
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS projects (name TEXT PRIMARY KEY);`); err != nil {
-		db.Close()
-		return nil, err
+		return err
 	}
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS settings (key TEXT PRIMARY KEY, value TEXT);`); err != nil {
-		db.Close()
-		return nil, err
+		return err
 	}
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS model_cache (
                id TEXT PRIMARY KEY,
@@ -72,10 +153,15 @@ func InitDB() (*sql.DB, error) {
                compatible_backends TEXT,
                license TEXT,
                model_card TEXT,
-               download_size INTEGER
+               download_size INTEGER,
+               context_length INTEGER,
+               num_kv_heads INTEGER,
+               quantization TEXT,
+               chat_template TEXT,
+               parameter_count INTEGER,
+               likes INTEGER DEFAULT 0
        );`); err != nil {
-		db.Close()
-		return nil, err
+		return err
 	}
 	// attempt to add columns for new metadata if the table existed without them
 	db.Exec(`ALTER TABLE model_cache ADD COLUMN llama_compatible INTEGER DEFAULT 0`)
@@ -90,26 +176,90 @@ func InitDB() (*sql.DB, error) {
 	db.Exec(`ALTER TABLE model_cache ADD COLUMN license TEXT`)
 	db.Exec(`ALTER TABLE model_cache ADD COLUMN model_card TEXT`)
 	db.Exec(`ALTER TABLE model_cache ADD COLUMN download_size INTEGER`)
-	return db, nil
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN context_length INTEGER`)
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN num_kv_heads INTEGER`)
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN quantization TEXT`)
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN chat_template TEXT`)
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN parameter_count INTEGER`)
+	db.Exec(`ALTER TABLE model_cache ADD COLUMN likes INTEGER DEFAULT 0`)
+	if err := initKnowledge(db); err != nil {
+		return err
+	}
+	if err := initUsers(db); err != nil {
+		return err
+	}
+	if err := initAPIKeys(db); err != nil {
+		return err
+	}
+	if err := initMembers(db); err != nil {
+		return err
+	}
+	if err := initWebhooks(db); err != nil {
+		return err
+	}
+	db.Exec(`ALTER TABLE memory ADD COLUMN summarised INTEGER DEFAULT 0`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS memory_project_summarised ON memory(project, summarised, id)`)
+	// indexes backing the sorted, paginated listings in QueryModelCache
+	db.Exec(`CREATE INDEX IF NOT EXISTS model_cache_downloads ON model_cache(pipeline, downloads, id)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS model_cache_likes ON model_cache(pipeline, likes, id)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS model_cache_modified ON model_cache(pipeline, last_modified, id)`)
+	return nil
 }
 
 // AddEntry inserts a new memory record into the provided database connection.
-// Importance is optional and defaults to zero. Higher importance can be used by
-// the AI to prioritise which memories to surface during context gathering.
+// Importance is optional; when omitted the configured Scorer rates the entry,
+// a BackgroundScorer after the row is stored.
+// Higher importance can be used by the AI to prioritise which memories to
+// surface during context gathering.
 // AddEntry inserts a single memory row. Extension Point: additional columns such
 // as embeddings could be stored here for advanced retrieval strategies.
 func AddEntry(db *sql.DB, project, role, content string, importance ...int) error {
-	imp := 0
+	var imp int
+	var later *Rescorer
 	if len(importance) > 0 {
 		imp = importance[0]
+	} else {
+		imp, later = scoreEntry(role, content)
 	}
 	stmt, err := db.Prepare("INSERT INTO memory(project, role, content, importance) VALUES(?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(project, role, content, imp)
-	return err
+	res, err := stmt.Exec(project, role, content, imp)
+	if err != nil {
+		return err
+	}
+	if later != nil {
+		if id, err := res.LastInsertId(); err == nil {
+			later.enqueue(rescoreJob{id: id, role: role, content: content, initial: imp})
+		}
+	}
+	if hook := entryHook(); hook != nil {
+		id, _ := res.LastInsertId()
+		hook(MemoryEntry{ID: int(id), Project: project, Role: role, Content: content, Timestamp: time.Now(), Importance: imp})
+	}
+	return nil
+}
+
+var (
+	entryHookMu sync.RWMutex
+	onEntry     func(MemoryEntry)
+)
+
+// SetEntryHook registers a function called after AddEntry stores a memory,
+// for example to notify webhooks. It must not block; nil removes it.
+func SetEntryHook(f func(MemoryEntry)) {
+	entryHookMu.Lock()
+	onEntry = f
+	entryHookMu.Unlock()
+}
+
+// entryHook returns the registered entry hook or nil.
+func entryHook() func(MemoryEntry) {
+	entryHookMu.RLock()
+	defer entryHookMu.RUnlock()
+	return onEntry
 }
 
 // LastNEntries retrieves the most recent `n` memories for the given project
@@ -171,6 +321,33 @@ func TopImportantEntries(db *sql.DB, project string, n int) ([]MemoryEntry, erro
 	return entries, nil
 }
 
+// SearchEntries returns up to `n` memories of a project whose content
+// contains query, case-insensitively, newest first. The assistant's
+// memory_search tool uses this to look up earlier conversation. Extension
+// Point: a full text index could replace the LIKE scan for large projects.
+func SearchEntries(db *sql.DB, project, query string, n int) ([]MemoryEntry, error) {
+	rows, err := db.Query(`SELECT id, project, role, content, timestamp, importance FROM memory
+		WHERE project = ? AND content LIKE ? ESCAPE '\' ORDER BY timestamp DESC, id DESC LIMIT ?`,
+		project, "%"+escapeLike(query)+"%", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []MemoryEntry
+	for rows.Next() {
+		var e MemoryEntry
+		if err := rows.Scan(&e.ID, &e.Project, &e.Role, &e.Content, &e.Timestamp, &e.Importance); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 // AddProject inserts a project name into the projects table if it does not
 // already exist. Projects segment stored conversations so multiple contexts can
 // be maintained. Extension Point: project-level metadata could be stored in a
@@ -180,28 +357,39 @@ func AddProject(db *sql.DB, name string) error {
 	return err
 }
 
-// DeleteProject removes a project from the projects table and clears related
-// settings such as the active project. Memory records themselves are not
-// removed which allows historical data inspection if needed. Extension Point:
-// consider cascading deletes if permanent removal is desired.
+// DeleteProject removes a project together with its members, memory records
+// and knowledge base and clears every active project setting pointing at it,
+// all in one transaction. Nothing is left behind for whoever creates a
+// project of the same name later: projects are scoped to their members, and
+// the next owner must not inherit the previous one's conversations.
 func DeleteProject(db *sql.DB, name string) error {
-	if _, err := db.Exec(`DELETE FROM projects WHERE name = ?`, name); err != nil {
-		return err
-	}
-	// clear active project if it was deleted
-	active, err := GetActiveProject(db)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	if active == name {
-		_, err = db.Exec(`DELETE FROM settings WHERE key = 'active_project'`)
+	for _, q := range []string{
+		`DELETE FROM projects WHERE name = ?`,
+		`DELETE FROM project_members WHERE project = ?`,
+		`DELETE FROM memory WHERE project = ?`,
+		// clear the active project of everyone who had it selected
+		`DELETE FROM settings WHERE (key = 'active_project' OR key LIKE 'active_project:%') AND value = ?`,
+	} {
+		if _, err := tx.Exec(q, name); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	return err
+	if err := deleteKnowledge(tx, name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // RenameProject updates all references when a project changes name. Both the
 // projects table and existing memory entries are updated in a single
-// transaction. The active project setting is also adjusted if needed.
+// transaction. Its members and every active project setting naming it are
+// adjusted too.
 func RenameProject(db *sql.DB, oldName, newName string) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -215,13 +403,18 @@ func RenameProject(db *sql.DB, oldName, newName string) error {
 		tx.Rollback()
 		return err
 	}
-	var active string
-	tx.QueryRow(`SELECT value FROM settings WHERE key = 'active_project'`).Scan(&active)
-	if active == oldName {
-		if _, err := tx.Exec(`UPDATE settings SET value = ? WHERE key = 'active_project'`, newName); err != nil {
-			tx.Rollback()
-			return err
-		}
+	if err := renameKnowledge(tx, oldName, newName); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE project_members SET project = ? WHERE project = ?`, newName, oldName); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE settings SET value = ?
+		WHERE (key = 'active_project' OR key LIKE 'active_project:%') AND value = ?`, newName, oldName); err != nil {
+		tx.Rollback()
+		return err
 	}
 	return tx.Commit()
 }
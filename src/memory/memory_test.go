@@ -4,7 +4,11 @@ package memory
 // store conversation memories and project metadata.
 
 import (
+	"bytes"
+	"codex/src/metrics"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -171,3 +175,200 @@ func TestRenameProject(t *testing.T) {
 		t.Fatalf("entries not renamed: %+v", entries)
 	}
 }
+
+// TestProjectMembers checks per-principal visibility and active projects,
+// the last owner guard and that renames and deletes carry memberships along.
+func TestProjectMembers(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+
+	if err := AddProject(db, "shared"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := CreateProject(db, "alice-notes", "user:1"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	list, _ := ListProjectsFor(db, "user:2")
+	if len(list) != 1 || list[0] != "shared" {
+		t.Fatalf("user:2 should only see the shared project: %v", list)
+	}
+	if a, _ := ProjectAccess(db, "alice-notes", "user:1"); a != AccessOwner {
+		t.Fatalf("owner access = %q", a)
+	}
+	if a, _ := ProjectAccess(db, "shared", "user:2"); a != AccessWrite {
+		t.Fatalf("shared access = %q", a)
+	}
+	if err := SetProjectMember(db, "alice-notes", "user:2", AccessRead); err != nil {
+		t.Fatalf("share: %v", err)
+	}
+	list, _ = ListProjectsFor(db, "user:2")
+	if len(list) != 2 {
+		t.Fatalf("shared project not visible: %v", list)
+	}
+	if err := SetProjectMember(db, "alice-notes", "user:1", AccessWrite); err != ErrLastOwner {
+		t.Fatalf("expected ErrLastOwner demoting the owner, got %v", err)
+	}
+	if err := SetProjectMember(db, "shared", "user:2", AccessRead); err != ErrLastOwner {
+		t.Fatalf("expected ErrLastOwner sharing an ownerless project, got %v", err)
+	}
+	if err := RemoveProjectMember(db, "alice-notes", "user:1"); err != ErrLastOwner {
+		t.Fatalf("expected ErrLastOwner removing the owner, got %v", err)
+	}
+
+	// active projects are per principal; the global one is a fallback
+	SetActiveProject(db, "alice-notes")
+	SetActiveProjectFor(db, "user:1", "alice-notes")
+	SetActiveProjectFor(db, "user:2", "shared")
+	if a, _ := GetActiveProjectFor(db, "user:2"); a != "shared" {
+		t.Fatalf("user:2 active = %q", a)
+	}
+	if a, _ := GetActiveProjectFor(db, "user:3"); a != "" {
+		t.Fatalf("user:3 must not fall back to a private project: %q", a)
+	}
+	if a, _ := GetActiveProjectFor(db, "user:1"); a != "alice-notes" {
+		t.Fatalf("user:1 active = %q", a)
+	}
+
+	if err := RenameProject(db, "alice-notes", "notes"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if a, _ := ProjectAccess(db, "notes", "user:2"); a != AccessRead {
+		t.Fatalf("membership not renamed: %q", a)
+	}
+	if a, _ := GetActiveProjectFor(db, "user:1"); a != "notes" {
+		t.Fatalf("active project not renamed: %q", a)
+	}
+	if err := RemoveProjectMember(db, "notes", "user:2"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if a, _ := ProjectAccess(db, "notes", "user:2"); a != "" {
+		t.Fatalf("removed member still has %q", a)
+	}
+	doc := &Document{Project: "notes", Source: "a.md", Kind: "markdown"}
+	if err := SaveDocument(db, doc, []Chunk{{Content: "x", Embedder: "hash", Embedding: []float32{1}}}); err != nil {
+		t.Fatalf("save document: %v", err)
+	}
+	if err := AddEntry(db, "notes", "user", "launch plan", 3); err != nil {
+		t.Fatalf("add entry: %v", err)
+	}
+	if err := DeleteProject(db, "notes"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if entries, _ := LastNEntries(db, "notes", 10); len(entries) != 0 {
+		t.Fatalf("memory survived delete: %+v", entries)
+	}
+	if n, _ := CountChunks(db, "notes"); n != 0 {
+		t.Fatalf("%d chunks survived delete", n)
+	}
+	if docs, _ := ListDocuments(db, "notes", ""); len(docs) != 0 {
+		t.Fatalf("documents survived delete: %v", docs)
+	}
+	if a, _ := GetActiveProjectFor(db, "user:1"); a != "" {
+		t.Fatalf("active project survived delete: %q", a)
+	}
+	if m, _ := ProjectMembers(db, "notes"); len(m) != 0 {
+		t.Fatalf("members survived delete: %v", m)
+	}
+}
+
+// TestQueryMetrics checks that statements run through InitDB's handle are
+// timed by type, including prepared statements and queries.
+func TestQueryMetrics(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	before := queryCount(t)
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	if err := AddEntry(db, "proj", "user", "hello"); err != nil {
+		t.Fatalf("AddEntry error: %v", err)
+	}
+	if _, err := LastNEntries(db, "proj", 5); err != nil {
+		t.Fatalf("LastNEntries error: %v", err)
+	}
+	after := queryCount(t)
+	for _, kind := range []string{"create", "insert", "select"} {
+		if after[kind] <= before[kind] {
+			t.Errorf("%s statements not timed: %v -> %v", kind, before[kind], after[kind])
+		}
+	}
+}
+
+// queryCount reads the number of timed statements per type from the
+// metrics output.
+func queryCount(t *testing.T) map[string]float64 {
+	var b bytes.Buffer
+	metrics.Default().Write(&b)
+	counts := map[string]float64{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		rest, ok := strings.CutPrefix(line, `codex_sqlite_query_duration_seconds_count{statement="`)
+		if !ok {
+			continue
+		}
+		kind, value, _ := strings.Cut(rest, `"} `)
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("bad metrics line %q", line)
+		}
+		counts[kind] = n
+	}
+	return counts
+}
+
+// TestInitDBMigratesOnce checks that the schema statements run on the first
+// InitDB for a database file only, and again when the file is replaced.
+func TestInitDBMigratesOnce(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	if _, err := db.Exec(`DROP TABLE projects`); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	db.Close()
+
+	// a second open on the same file must not recreate the table
+	db, err = InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'projects'`).Scan(&n)
+	db.Close()
+	if n != 0 {
+		t.Fatalf("schema migrated again on the same file")
+	}
+
+	// a new file at the same path is migrated
+	if err := os.Remove("memory.db"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove("memory.db-wal")
+	os.Remove("memory.db-shm")
+	db, err = InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	if err := CreateProject(db, "p", ""); err != nil {
+		t.Fatalf("CreateProject after recreating the file: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package memory
+
+// SQLite query timing for `GET /metrics`. InitDB opens the database through
+// a driver that wraps go-sqlite3 and times every statement, so queries are
+// measured wherever they are issued without touching each call site.
+
+import (
+	"codex/src/metrics"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the name InitDB opens the database with.
+const driverName = "sqlite3_timed"
+
+// queryDuration records how long statements take, by their leading keyword.
+// Queries are measured until their rows are closed.
+var queryDuration = metrics.NewHistogramVec("codex_sqlite_query_duration_seconds",
+	"Time spent in SQLite statements, by statement type.",
+	[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5}, "statement")
+
+func init() {
+	sql.Register(driverName, timedDriver{&sqlite3.SQLiteDriver{}})
+}
+
+// statementKind returns the leading keyword of query, such as "select", to
+// label its duration. Other statements share "other" to keep labels few.
+func statementKind(query string) string {
+	word, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	switch word = strings.ToLower(word); word {
+	case "select", "insert", "update", "delete", "create", "alter", "pragma", "with":
+		return word
+	}
+	return "other"
+}
+
+// observe records the time since start for query.
+func observe(kind string, start time.Time) {
+	queryDuration.With(kind).Observe(time.Since(start).Seconds())
+}
+
+// timedDriver opens connections that time their statements.
+type timedDriver struct{ d *sqlite3.SQLiteDriver }
+
+func (t timedDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := t.d.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{c.(*sqlite3.SQLiteConn)}, nil
+}
+
+// timedConn times statements run directly on a connection or a transaction
+// and prepares timed statements.
+type timedConn struct{ c *sqlite3.SQLiteConn }
+
+func (t *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return t.PrepareContext(context.Background(), query)
+}
+
+func (t *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := t.c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{s.(*sqlite3.SQLiteStmt), statementKind(query)}, nil
+}
+
+func (t *timedConn) Close() error { return t.c.Close() }
+
+func (t *timedConn) Begin() (driver.Tx, error) {
+	return t.c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (t *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return t.c.BeginTx(ctx, opts)
+}
+
+func (t *timedConn) Ping(ctx context.Context) error { return t.c.Ping(ctx) }
+
+func (t *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer observe(statementKind(query), time.Now())
+	return t.c.ExecContext(ctx, query, args)
+}
+
+func (t *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := t.c.QueryContext(ctx, query, args)
+	if err != nil {
+		observe(statementKind(query), start)
+		return nil, err
+	}
+	return &timedRows{rows, statementKind(query), start}, nil
+}
+
+// timedStmt times executions of a prepared statement.
+type timedStmt struct {
+	s    *sqlite3.SQLiteStmt
+	kind string
+}
+
+func (t *timedStmt) Close() error  { return t.s.Close() }
+func (t *timedStmt) NumInput() int { return t.s.NumInput() }
+
+func (t *timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	defer observe(t.kind, time.Now())
+	return t.s.Exec(args)
+}
+
+func (t *timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := t.s.Query(args)
+	if err != nil {
+		observe(t.kind, start)
+		return nil, err
+	}
+	return &timedRows{rows, t.kind, start}, nil
+}
+
+func (t *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer observe(t.kind, time.Now())
+	return t.s.ExecContext(ctx, args)
+}
+
+func (t *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := t.s.QueryContext(ctx, args)
+	if err != nil {
+		observe(t.kind, start)
+		return nil, err
+	}
+	return &timedRows{rows, t.kind, start}, nil
+}
+
+// timedRows records its query's duration when closed, since SQLite does
+// most of the work while rows are read.
+type timedRows struct {
+	driver.Rows
+	kind  string
+	start time.Time
+}
+
+func (t *timedRows) Close() error {
+	defer observe(t.kind, t.start)
+	return t.Rows.Close()
+}
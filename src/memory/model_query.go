@@ -0,0 +1,232 @@
+package memory
+
+// Search, sorting and pagination over the model_cache table.  The admin UI
+// browses thousands of cached models so listings are filtered and paged in
+// SQL rather than in the browser.  Pages are addressed with an opaque cursor
+// holding the sort key of the last row (keyset pagination) which stays cheap
+// no matter how deep the user pages.
+
+import (
+	"codex/src/models"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded or was issued
+// for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// modelSortColumns maps the public sort names to model_cache columns.
+var modelSortColumns = map[string]string{
+	"downloads": "downloads",
+	"likes":     "likes",
+	"modified":  "last_modified",
+	"id":        "id",
+}
+
+// ModelQuery describes a filtered listing of cached models. Zero values
+// disable the corresponding filter.
+type ModelQuery struct {
+	// Pipeline restricts results to a pipeline tag.
+	Pipeline string
+	// Q matches a case-insensitive substring of the model ID.
+	Q string
+	// Sort is one of downloads (default), likes, modified or id.
+	Sort string
+	// Order is "asc" or "desc". It defaults to desc, except for id.
+	Order string
+	// Limit caps the page size; zero returns every match.
+	Limit int
+	// Cursor continues a previous listing from QueryModelCache.
+	Cursor string
+	// Tags must all be present on the model.
+	Tags []string
+	// License matches the license column or a "license:<name>" tag.
+	License string
+	// GGUF keeps models that ship GGUF files.
+	GGUF bool
+	// LlamaCompatible keeps models usable with llama.cpp.
+	LlamaCompatible bool
+	// Backend keeps models listing this entry in compatible_backends.
+	Backend string
+}
+
+// ModelQueryResult is a page of cached models. Next is the cursor for the
+// following page or empty on the last page; Total counts all matches.
+type ModelQueryResult struct {
+	Models []models.ModelInfo
+	Next   string
+	Total  int
+}
+
+// modelCursor is the decoded form of a pagination cursor.
+type modelCursor struct {
+	Sort  string      `json:"s"`
+	Order string      `json:"o"`
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+// QueryModelCache returns cached models matching q ordered by the requested
+// column with the model ID as tie breaker.
+func QueryModelCache(db *sql.DB, q ModelQuery) (*ModelQueryResult, error) {
+	if q.Sort == "" {
+		q.Sort = "downloads"
+	}
+	col, ok := modelSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	if q.Order == "" {
+		q.Order = "desc"
+		if q.Sort == "id" {
+			q.Order = "asc"
+		}
+	}
+	if q.Order != "asc" && q.Order != "desc" {
+		return nil, fmt.Errorf("unknown order %q", q.Order)
+	}
+
+	var where []string
+	var args []interface{}
+	if q.Pipeline != "" {
+		where = append(where, "pipeline = ?")
+		args = append(args, q.Pipeline)
+	}
+	if q.Q != "" {
+		where = append(where, "id LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(q.Q)+"%")
+	}
+	for _, t := range q.Tags {
+		where = append(where, "tags LIKE ? ESCAPE '\\'")
+		args = append(args, `%"`+escapeLike(t)+`"%`)
+	}
+	if q.License != "" {
+		where = append(where, "(license = ? OR tags LIKE ? ESCAPE '\\')")
+		args = append(args, q.License, `%"license:`+escapeLike(q.License)+`"%`)
+	}
+	if q.GGUF {
+		where = append(where, `(gguf = 1 OR tags LIKE '%"gguf"%')`)
+	}
+	if q.LlamaCompatible {
+		where = append(where, "llama_compatible = 1")
+	}
+	if q.Backend != "" {
+		where = append(where, "compatible_backends LIKE ? ESCAPE '\\'")
+		args = append(args, `%"`+escapeLike(q.Backend)+`"%`)
+	}
+
+	res := &ModelQueryResult{}
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM model_cache`+filter, args...).Scan(&res.Total); err != nil {
+		log.Printf("QueryModelCache count error: %v", err)
+		return nil, err
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeModelCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort || c.Order != q.Order {
+			return nil, ErrInvalidCursor
+		}
+		cmp := "<"
+		if q.Order == "asc" {
+			cmp = ">"
+		}
+		if col == "id" {
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, c.ID)
+		} else {
+			where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id > ?))", col, cmp, col))
+			args = append(args, c.Value, c.Value, c.ID)
+		}
+	}
+	query := `SELECT id,last_modified,downloads,likes,tags FROM model_cache`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s", col, strings.ToUpper(q.Order))
+	if col != "id" {
+		query += ", id ASC"
+	}
+	if q.Limit > 0 {
+		// fetch one extra row to learn whether another page exists
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("QueryModelCache query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	res.Models = []models.ModelInfo{}
+	for rows.Next() {
+		var m models.ModelInfo
+		var lm, tagsStr sql.NullString
+		var dl, likes sql.NullInt64
+		if err := rows.Scan(&m.ID, &lm, &dl, &likes, &tagsStr); err != nil {
+			log.Printf("QueryModelCache scan error: %v", err)
+			return nil, err
+		}
+		m.LastModified = lm.String
+		m.Downloads = int(dl.Int64)
+		m.Likes = int(likes.Int64)
+		json.Unmarshal([]byte(tagsStr.String), &m.Tags)
+		res.Models = append(res.Models, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(res.Models) > q.Limit {
+		res.Models = res.Models[:q.Limit]
+		last := res.Models[q.Limit-1]
+		c := modelCursor{Sort: q.Sort, Order: q.Order, ID: last.ID}
+		switch q.Sort {
+		case "downloads":
+			c.Value = last.Downloads
+		case "likes":
+			c.Value = last.Likes
+		case "modified":
+			c.Value = last.LastModified
+		}
+		b, _ := json.Marshal(c)
+		res.Next = base64.RawURLEncoding.EncodeToString(b)
+	}
+	log.Printf("QueryModelCache %+v count=%d total=%d", q, len(res.Models), res.Total)
+	return res, nil
+}
+
+// CountCachedModels returns how many models are cached for the pipeline.
+func CountCachedModels(db *sql.DB, pipeline string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM model_cache WHERE pipeline = ?`, pipeline).Scan(&n)
+	return n, err
+}
+
+// decodeModelCursor parses a cursor produced by QueryModelCache.
+func decodeModelCursor(s string) (*modelCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c modelCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s using backslash.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,78 @@
+package memory
+
+// Tests for filtered and paginated model cache listings.
+
+import (
+	"codex/src/models"
+	"os"
+	"testing"
+)
+
+// TestQueryModelCache pages through cached models sorted by downloads and
+// checks that the tag, search and license filters narrow the results.
+func TestQueryModelCache(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+
+	list := []models.ModelInfo{
+		{ID: "org/a", Downloads: 30, Likes: 1, Tags: []string{"gguf", "license:apache-2.0"}},
+		{ID: "org/b", Downloads: 20, Likes: 5, Tags: []string{"gguf"}},
+		{ID: "org/c", Downloads: 20, Likes: 2, Tags: []string{"license:mit"}},
+		{ID: "other/d_x", Downloads: 10, Likes: 9},
+	}
+	if err := SaveModelList(db, "text-generation", list); err != nil {
+		t.Fatalf("SaveModelList error: %v", err)
+	}
+
+	var ids []string
+	q := ModelQuery{Pipeline: "text-generation", Limit: 2}
+	for {
+		res, err := QueryModelCache(db, q)
+		if err != nil {
+			t.Fatalf("QueryModelCache error: %v", err)
+		}
+		if res.Total != 4 {
+			t.Fatalf("expected total 4, got %d", res.Total)
+		}
+		for _, m := range res.Models {
+			ids = append(ids, m.ID)
+		}
+		if res.Next == "" {
+			break
+		}
+		q.Cursor = res.Next
+	}
+	want := []string{"org/a", "org/b", "org/c", "other/d_x"}
+	if len(ids) != len(want) {
+		t.Fatalf("unexpected pages: %v", ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("unexpected order: %v", ids)
+		}
+	}
+
+	res, err := QueryModelCache(db, ModelQuery{GGUF: true, Sort: "likes"})
+	if err != nil || len(res.Models) != 2 || res.Models[0].ID != "org/b" {
+		t.Fatalf("gguf filter: %v %+v", err, res)
+	}
+	res, err = QueryModelCache(db, ModelQuery{License: "mit"})
+	if err != nil || len(res.Models) != 1 || res.Models[0].ID != "org/c" {
+		t.Fatalf("license filter: %v %+v", err, res)
+	}
+	res, err = QueryModelCache(db, ModelQuery{Q: "D_X"})
+	if err != nil || len(res.Models) != 1 || res.Models[0].ID != "other/d_x" {
+		t.Fatalf("search: %v %+v", err, res)
+	}
+	if _, err := QueryModelCache(db, ModelQuery{Sort: "likes", Cursor: q.Cursor, Limit: 2}); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 )
 
 // SaveModelList stores a slice of model metadata for a given pipeline.
+// Existing rows keep any enriched metadata saved by SaveModelMetadata; only
+// the listing fields are updated.
 func SaveModelList(db *sql.DB, pipeline string, list []models.ModelInfo) error {
 	log.Printf("SaveModelList pipeline=%s count=%d", pipeline, len(list))
 	tx, err := db.Begin()
@@ -15,7 +17,9 @@ func SaveModelList(db *sql.DB, pipeline string, list []models.ModelInfo) error {
 		log.Printf("SaveModelList begin tx error: %v", err)
 		return err
 	}
-	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO model_cache(id,pipeline,last_modified,downloads,tags) VALUES(?,?,?,?,?)`)
+	stmt, err := tx.Prepare(`INSERT INTO model_cache(id,pipeline,last_modified,downloads,likes,tags) VALUES(?,?,?,?,?,?)
+                ON CONFLICT(id) DO UPDATE SET pipeline=excluded.pipeline, last_modified=excluded.last_modified,
+                downloads=excluded.downloads, likes=excluded.likes, tags=excluded.tags`)
 	if err != nil {
 		tx.Rollback()
 		log.Printf("SaveModelList prepare error: %v", err)
@@ -24,7 +28,7 @@ func SaveModelList(db *sql.DB, pipeline string, list []models.ModelInfo) error {
 	defer stmt.Close()
 	for _, m := range list {
 		tags, _ := json.Marshal(m.Tags)
-		if _, err := stmt.Exec(m.ID, pipeline, m.LastModified, m.Downloads, string(tags)); err != nil {
+		if _, err := stmt.Exec(m.ID, pipeline, m.LastModified, m.Downloads, m.Likes, string(tags)); err != nil {
 			tx.Rollback()
 			log.Printf("SaveModelList exec error id=%s: %v", m.ID, err)
 			return err
@@ -40,7 +44,7 @@ func SaveModelList(db *sql.DB, pipeline string, list []models.ModelInfo) error {
 // GetModelList returns cached models for a pipeline if present.
 func GetModelList(db *sql.DB, pipeline string) ([]models.ModelInfo, error) {
 	log.Printf("GetModelList pipeline=%s", pipeline)
-	rows, err := db.Query(`SELECT id,last_modified,downloads,tags FROM model_cache WHERE pipeline=?`, pipeline)
+	rows, err := db.Query(`SELECT id,last_modified,downloads,likes,tags FROM model_cache WHERE pipeline=?`, pipeline)
 	if err != nil {
 		log.Printf("GetModelList query error: %v", err)
 		return nil, err
@@ -50,13 +54,14 @@ func GetModelList(db *sql.DB, pipeline string) ([]models.ModelInfo, error) {
 	for rows.Next() {
 		var id, lm, tagsStr string
 		var dl int
-		if err := rows.Scan(&id, &lm, &dl, &tagsStr); err != nil {
+		var likes sql.NullInt64
+		if err := rows.Scan(&id, &lm, &dl, &likes, &tagsStr); err != nil {
 			log.Printf("GetModelList scan error: %v", err)
 			return nil, err
 		}
 		var tags []string
 		json.Unmarshal([]byte(tagsStr), &tags)
-		res = append(res, models.ModelInfo{ID: id, LastModified: lm, Downloads: dl, Tags: tags})
+		res = append(res, models.ModelInfo{ID: id, LastModified: lm, Downloads: dl, Likes: int(likes.Int64), Tags: tags})
 	}
 	if err := rows.Err(); err != nil {
 		log.Printf("GetModelList rows error: %v", err)
@@ -81,21 +86,27 @@ func SaveModelDetail(db *sql.DB, pipeline string, detail *models.ModelDetail) er
 
 // SaveModelMetadata persists the enriched model information produced by
 // models.GetModelMetadata. Boolean values are stored as integers for SQLite
-// compatibility.
+// compatibility. An empty pipeline keeps the pipeline already recorded for the
+// model so saving details does not remove it from cached listings.
 func SaveModelMetadata(db *sql.DB, pipeline string, md *models.ModelMetadata) error {
 	log.Printf("SaveModelMetadata id=%s pipeline=%s", md.ID, pipeline)
 	tags, _ := json.Marshal(md.Tags)
 	files, _ := json.Marshal(md.Files)
 	backends, _ := json.Marshal(md.CompatibleBackends)
+	if pipeline == "" {
+		db.QueryRow(`SELECT pipeline FROM model_cache WHERE id=?`, md.ID).Scan(&pipeline)
+	}
 	_, err := db.Exec(`INSERT OR REPLACE INTO model_cache(
-                id,pipeline,last_modified,downloads,tags,sha,files,
+                id,pipeline,last_modified,downloads,likes,tags,sha,files,
                 llama_compatible,model_type,hidden_size,n_layer,num_attention_heads,
-                quantized,gguf,safetensors,compatible_backends,license,model_card,download_size)
-                VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
-		md.ID, pipeline, md.LastModified, md.Downloads, string(tags), md.SHA, string(files),
+                quantized,gguf,safetensors,compatible_backends,license,model_card,download_size,
+                context_length,num_kv_heads,quantization,chat_template,parameter_count)
+                VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		md.ID, pipeline, md.LastModified, md.Downloads, md.Likes, string(tags), md.SHA, string(files),
 		boolToInt(md.LlamaCompatible), md.ModelType, md.HiddenSize, md.NLayer, md.NumAttentionHeads,
 		boolToInt(md.Quantized), boolToInt(md.GGUF), boolToInt(md.Safetensors), string(backends),
 		md.License, md.ModelCard, md.DownloadSize,
+		md.ContextLength, md.NumKVHeads, md.Quantization, md.ChatTemplate, int64(md.ParameterCount),
 	)
 	if err != nil {
 		log.Printf("SaveModelMetadata exec error: %v", err)
@@ -106,19 +117,23 @@ func SaveModelMetadata(db *sql.DB, pipeline string, md *models.ModelMetadata) er
 // GetModelMetadata returns cached enriched detail for the given model ID if present.
 func GetModelMetadata(db *sql.DB, id string) (*models.ModelMetadata, error) {
 	log.Printf("GetModelMetadata id=%s", id)
-	row := db.QueryRow(`SELECT pipeline,last_modified,downloads,tags,sha,files,
+	row := db.QueryRow(`SELECT pipeline,last_modified,downloads,likes,tags,sha,files,
                 llama_compatible,model_type,hidden_size,n_layer,num_attention_heads,
-                quantized,gguf,safetensors,compatible_backends,license,model_card,download_size
+                quantized,gguf,safetensors,compatible_backends,license,model_card,download_size,
+                context_length,num_kv_heads,quantization,chat_template,parameter_count
                 FROM model_cache WHERE id=?`, id)
 	var pipeline, lm, tagsStr, sha, filesStr string
 	var llama, quant, gguf, safe int
 	var modelType, license, card, backendsStr sql.NullString
 	var hidden, nl, heads sql.NullInt64
 	var dl int
-	var size sql.NullInt64
-	if err := row.Scan(&pipeline, &lm, &dl, &tagsStr, &sha, &filesStr,
+	var size, likes sql.NullInt64
+	var ctxLen, kvHeads, params sql.NullInt64
+	var quantization, template sql.NullString
+	if err := row.Scan(&pipeline, &lm, &dl, &likes, &tagsStr, &sha, &filesStr,
 		&llama, &modelType, &hidden, &nl, &heads,
-		&quant, &gguf, &safe, &backendsStr, &license, &card, &size); err != nil {
+		&quant, &gguf, &safe, &backendsStr, &license, &card, &size,
+		&ctxLen, &kvHeads, &quantization, &template, &params); err != nil {
 		if err == sql.ErrNoRows {
 			log.Printf("GetModelMetadata no rows")
 			return nil, nil
@@ -136,7 +151,7 @@ func GetModelMetadata(db *sql.DB, id string) (*models.ModelMetadata, error) {
 	}
 	md := &models.ModelMetadata{
 		ModelDetail: models.ModelDetail{
-			ModelInfo: models.ModelInfo{ID: id, LastModified: lm, Downloads: dl, Tags: tags},
+			ModelInfo: models.ModelInfo{ID: id, LastModified: lm, Downloads: dl, Likes: int(likes.Int64), Tags: tags},
 			SHA:       sha,
 			Files:     files,
 		},
@@ -152,6 +167,11 @@ func GetModelMetadata(db *sql.DB, id string) (*models.ModelMetadata, error) {
 		License:            license.String,
 		ModelCard:          card.String,
 		DownloadSize:       size.Int64,
+		ContextLength:      int(ctxLen.Int64),
+		NumKVHeads:         int(kvHeads.Int64),
+		Quantization:       quantization.String,
+		ChatTemplate:       template.String,
+		ParameterCount:     uint64(params.Int64),
 	}
 	log.Printf("GetModelMetadata result %+v", md)
 	return md, nil
@@ -0,0 +1,339 @@
+package memory
+
+// Importance scoring. AddEntry asks the configured Scorer to rate every entry
+// stored without an explicit importance, so TopImportantEntries and
+// RankedEntries have something to work with. The default HeuristicScorer is
+// cheap and deterministic; LLMScorer asks the local model and falls back to
+// the heuristics when the model is unavailable. Asking the model takes
+// seconds, so entries are stored with the heuristic score first and rescored
+// in the background by a Rescorer with a bounded number of workers.
+//
+// AI Awareness: scores decide which memories resurface in chat context.
+// Extension Point: install a different Scorer with SetScorer.
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Importance scores range from MinImportance to MaxImportance.
+const (
+	MinImportance = 0
+	MaxImportance = 10
+)
+
+// Scorer rates how important a memory is likely to be later.
+type Scorer interface {
+	Score(role, content string) int
+}
+
+// BackgroundScorer is a Scorer too slow to run while a chat waits for its
+// answer. AddEntry stores entries with the quick Initial score and replaces
+// it with the result of Score in the background.
+type BackgroundScorer interface {
+	Scorer
+	Initial(role, content string) int
+}
+
+// ScorerFunc adapts a function to the Scorer interface.
+type ScorerFunc func(role, content string) int
+
+// Score calls f.
+func (f ScorerFunc) Score(role, content string) int { return f(role, content) }
+
+var (
+	scorerMu      sync.RWMutex
+	currentScorer Scorer = HeuristicScorer{}
+)
+
+// SetScorer replaces the scorer used by AddEntry. Nil restores the
+// heuristics. A BackgroundScorer is run by a Rescorer with
+// DefaultRescoreWorkers workers unless it is a Rescorer already.
+func SetScorer(s Scorer) {
+	switch bg := s.(type) {
+	case nil:
+		s = HeuristicScorer{}
+	case *Rescorer:
+	case BackgroundScorer:
+		s = NewRescorer(bg, DefaultRescoreWorkers)
+	}
+	scorerMu.Lock()
+	currentScorer = s
+	scorerMu.Unlock()
+}
+
+// scoreEntry rates content with the configured scorer. For a Rescorer it
+// returns the initial score and the Rescorer to finish the job with.
+func scoreEntry(role, content string) (int, *Rescorer) {
+	scorerMu.RLock()
+	s := currentScorer
+	scorerMu.RUnlock()
+	if r, ok := s.(*Rescorer); ok {
+		return clampImportance(r.Initial(role, content)), r
+	}
+	return clampImportance(s.Score(role, content)), nil
+}
+
+// Limits of background rescoring.
+const (
+	// DefaultRescoreWorkers is how many entries SetScorer rates at once.
+	DefaultRescoreWorkers = 2
+	// rescoreQueue bounds the entries waiting for a rating. Entries beyond
+	// it keep their initial score.
+	rescoreQueue = 256
+)
+
+// rescoreJob is a stored entry waiting for its rating.
+type rescoreJob struct {
+	id            int64
+	role, content string
+	initial       int
+}
+
+// Rescorer rates stored entries with a BackgroundScorer on at most a fixed
+// number of goroutines, which are started as entries arrive and exit when
+// none are left. Install it with SetScorer.
+type Rescorer struct {
+	BackgroundScorer
+
+	jobs       chan rescoreJob
+	pending    sync.WaitGroup
+	mu         sync.Mutex
+	workers    int
+	maxWorkers int
+}
+
+// NewRescorer returns a Rescorer rating with s on up to workers goroutines.
+func NewRescorer(s BackgroundScorer, workers int) *Rescorer {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Rescorer{BackgroundScorer: s, jobs: make(chan rescoreJob, rescoreQueue), maxWorkers: workers}
+}
+
+// Wait blocks until every entry queued so far has been rated.
+func (r *Rescorer) Wait() {
+	r.pending.Wait()
+}
+
+// enqueue schedules the stored entry id for rating, starting a worker when
+// fewer than the limit are running.
+func (r *Rescorer) enqueue(j rescoreJob) {
+	r.pending.Add(1)
+	select {
+	case r.jobs <- j:
+	default:
+		r.pending.Done()
+		log.Printf("rescore memory %d: queue full, keeping importance %d", j.id, j.initial)
+		return
+	}
+	r.mu.Lock()
+	if r.workers < r.maxWorkers {
+		r.workers++
+		go r.work()
+	}
+	r.mu.Unlock()
+}
+
+// work rates queued entries until the queue is empty. The check and the
+// exit happen under mu so enqueue never leaves a job without a worker.
+func (r *Rescorer) work() {
+	for {
+		r.mu.Lock()
+		select {
+		case j := <-r.jobs:
+			r.mu.Unlock()
+			r.rescore(j)
+			r.pending.Done()
+		default:
+			r.workers--
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// rescore rates one entry and updates its importance when the rating
+// differs from the initial one.
+func (r *Rescorer) rescore(j rescoreJob) {
+	imp := clampImportance(r.Score(j.role, j.content))
+	if imp == j.initial {
+		return
+	}
+	db, err := InitDB()
+	if err != nil {
+		log.Printf("rescore memory %d: %v", j.id, err)
+		return
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE memory SET importance = ? WHERE id = ?`, imp, j.id); err != nil {
+		log.Printf("rescore memory %d: %v", j.id, err)
+	}
+}
+
+func clampImportance(v int) int {
+	if v < MinImportance {
+		return MinImportance
+	}
+	if v > MaxImportance {
+		return MaxImportance
+	}
+	return v
+}
+
+var (
+	rememberRe = regexp.MustCompile(`(?i)\b(remember (this|that)|don'?t forget|note that|keep in mind|important:|for future reference)\b`)
+	decisionRe = regexp.MustCompile(`(?i)\b(we (decided|agreed|will)|decision|let'?s go with|deadline|must|action item|todo|going forward)\b`)
+	trivialRe  = regexp.MustCompile(`(?i)^\s*(ok(ay)?|thanks?( you)?|thx|cool|great|yes|no|sure|hi|hello)[.!]*\s*$`)
+)
+
+// HeuristicScorer rates entries from their text: explicit "remember this"
+// phrases, decisions, questions, code blocks and longer messages score
+// higher; acknowledgements score zero.
+type HeuristicScorer struct{}
+
+// Score implements Scorer.
+func (HeuristicScorer) Score(role, content string) int {
+	if trivialRe.MatchString(content) {
+		return 0
+	}
+	score := 1
+	if rememberRe.MatchString(content) {
+		score += 4
+	}
+	if decisionRe.MatchString(content) {
+		score += 2
+	}
+	if strings.Contains(content, "?") {
+		score++
+	}
+	if strings.Contains(content, "```") {
+		score++
+	}
+	switch n := len(content); {
+	case n > 800:
+		score += 2
+	case n > 200:
+		score++
+	}
+	switch role {
+	case "system":
+		score++
+	case "tool":
+		score--
+	}
+	return clampImportance(score)
+}
+
+// LLMScorer asks the language model to rate entries. Complete is normally
+// llama.SendPromptContext wrapped by queue.Queued, so ratings wait their
+// turn behind chats; it is injected so this package does not depend on the
+// model client. As a BackgroundScorer it rates entries after they are
+// stored.
+type LLMScorer struct {
+	Complete func(ctx context.Context, prompt string) (string, error)
+	// Timeout bounds each rating, including the wait for a backend slot;
+	// zero means thirty seconds.
+	Timeout time.Duration
+	// Fallback rates entries when the model fails; nil uses the heuristics.
+	Fallback Scorer
+}
+
+var firstIntRe = regexp.MustCompile(`\d+`)
+
+// fallback returns the scorer used when the model cannot rate an entry.
+func (s LLMScorer) fallback() Scorer {
+	if s.Fallback == nil {
+		return HeuristicScorer{}
+	}
+	return s.Fallback
+}
+
+// Initial implements BackgroundScorer with the fallback's score.
+func (s LLMScorer) Initial(role, content string) int {
+	return s.fallback().Score(role, content)
+}
+
+// Score implements Scorer.
+func (s LLMScorer) Score(role, content string) int {
+	fallback := s.fallback()
+	if s.Complete == nil {
+		return fallback.Score(role, content)
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	text := content
+	if len(text) > 2000 {
+		text = text[:2000]
+	}
+	prompt := "Rate from 0 to 10 how important it is to remember the following " + role +
+		" message in a long running project conversation. 0 is small talk, 10 is a key decision or fact the user asked to remember. Reply with only the number.\n\nMessage:\n" +
+		text + "\n\nRating:"
+	out, err := s.Complete(ctx, prompt)
+	if err != nil {
+		return fallback.Score(role, content)
+	}
+	m := firstIntRe.FindString(out)
+	v, err := strconv.Atoi(m)
+	if err != nil {
+		return fallback.Score(role, content)
+	}
+	return clampImportance(v)
+}
+
+// DefaultHalfLife is the decay half-life used for chat context.
+const DefaultHalfLife = 7 * 24 * time.Hour
+
+// DecayedScore blends importance with recency: the score halves every
+// halfLife, so an old important entry eventually ranks below a new ordinary
+// one. A non-positive halfLife disables decay.
+func DecayedScore(e MemoryEntry, now time.Time, halfLife time.Duration) float64 {
+	base := float64(e.Importance + 1)
+	if halfLife <= 0 {
+		return base
+	}
+	age := now.Sub(e.Timestamp)
+	if age < 0 {
+		age = 0
+	}
+	return base * math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// rankScanLimit is how many of a project's newest entries RankedEntries
+// considers.
+const rankScanLimit = 1000
+
+// RankedEntries returns the `n` unsummarised entries of a project with the
+// highest DecayedScore. AI Awareness: chat context uses this to surface older
+// notes that are still relevant.
+func RankedEntries(db *sql.DB, project string, n int, halfLife time.Duration) ([]MemoryEntry, error) {
+	rows, err := db.Query(`SELECT id, project, role, content, timestamp, importance FROM memory
+		WHERE project = ? AND summarised = 0 ORDER BY id DESC LIMIT ?`, project, rankScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return DecayedScore(entries[i], now, halfLife) > DecayedScore(entries[j], now, halfLife)
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package memory
+
+// Tests for automatic importance scoring and time-decayed ranking.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHeuristicScorer checks the relative order of typical messages.
+func TestHeuristicScorer(t *testing.T) {
+	var h HeuristicScorer
+	thanks := h.Score("user", "thanks!")
+	plain := h.Score("user", "the build is green")
+	question := h.Score("user", "which port does the backend use?")
+	remember := h.Score("user", "Remember this: we decided to ship on Friday")
+	if !(thanks < plain && plain < question && question < remember) {
+		t.Fatalf("unexpected scores: thanks=%d plain=%d question=%d remember=%d", thanks, plain, question, remember)
+	}
+	if remember > MaxImportance {
+		t.Fatalf("score above maximum: %d", remember)
+	}
+}
+
+// TestLLMScorer checks parsing of the model's rating and the fallback.
+func TestLLMScorer(t *testing.T) {
+	s := LLMScorer{Complete: func(ctx context.Context, prompt string) (string, error) { return " 7.", nil }}
+	if v := s.Score("user", "x"); v != 7 {
+		t.Fatalf("expected 7, got %d", v)
+	}
+	s.Complete = func(ctx context.Context, prompt string) (string, error) { return "", errors.New("down") }
+	if v := s.Score("user", "thanks"); v != 0 {
+		t.Fatalf("expected heuristic fallback 0, got %d", v)
+	}
+}
+
+// TestRankedEntries checks that AddEntry scores entries and that ranking
+// decays importance with age.
+func TestRankedEntries(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+
+	AddEntry(db, "p", "user", "ok")
+	AddEntry(db, "p", "user", "Remember this: the API key lives in vault")
+	db.Exec(`INSERT INTO memory(project, role, content, timestamp, importance) VALUES('p', 'user', 'old decision', datetime('now', '-30 days'), 9)`)
+
+	top, err := RankedEntries(db, "p", 3, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("RankedEntries error: %v", err)
+	}
+	if len(top) != 3 || top[0].Content != "Remember this: the API key lives in vault" || top[0].Importance < 5 {
+		t.Fatalf("unexpected ranking: %+v", top)
+	}
+	if top[2].Content != "old decision" {
+		t.Fatalf("old entry should decay below recent ones: %+v", top)
+	}
+}
+
+// TestBackgroundScoring checks that AddEntry does not wait for the model:
+// the entry is stored with the heuristic score and updated once the model
+// has rated it.
+func TestBackgroundScoring(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+
+	rate := make(chan struct{})
+	var calls, running, most int32
+	r := NewRescorer(LLMScorer{Complete: func(ctx context.Context, prompt string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		<-rate
+		return "9", nil
+	}}, 2)
+	SetScorer(r)
+	defer SetScorer(nil)
+
+	if err := AddEntry(db, "p", "user", "thanks"); err != nil {
+		t.Fatalf("AddEntry error: %v", err)
+	}
+	entries, _ := LastNEntries(db, "p", 1)
+	if len(entries) != 1 || entries[0].Importance != 0 {
+		t.Fatalf("initial entry = %+v, want heuristic importance 0", entries)
+	}
+	// more entries than workers: at most two ratings run at once
+	for i := 0; i < 4; i++ {
+		AddEntry(db, "p", "user", "ok")
+	}
+	close(rate)
+	r.Wait()
+	entries, _ = LastNEntries(db, "p", 5)
+	for _, e := range entries {
+		if e.Importance != 9 {
+			t.Fatalf("importance after rescoring = %d, want 9", e.Importance)
+		}
+	}
+	if calls != 5 || most > 2 {
+		t.Fatalf("%d ratings with up to %d at once, want 5 with at most 2", calls, most)
+	}
+}
@@ -0,0 +1,126 @@
+package memory
+
+// Storage helpers for conversation summaries. The summary package condenses
+// older memories of a project into a single row with role "summary" and marks
+// the source rows as summarised; chat context then uses the summaries in
+// place of the raw turns they cover.
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// SummaryRole is the role of rows produced by the summariser.
+const SummaryRole = "summary"
+
+// scanEntries reads memory rows selected as id, project, role, content,
+// timestamp, importance.
+func scanEntries(rows *sql.Rows) ([]MemoryEntry, error) {
+	defer rows.Close()
+	var entries []MemoryEntry
+	for rows.Next() {
+		var e MemoryEntry
+		if err := rows.Scan(&e.ID, &e.Project, &e.Role, &e.Content, &e.Timestamp, &e.Importance); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// reverse flips entries fetched newest first into chronological order.
+func reverse(entries []MemoryEntry) []MemoryEntry {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// MemoryProjects returns every project that has stored memories, including
+// ones missing from the projects table.
+func MemoryProjects(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT project FROM memory ORDER BY project`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+// SummaryCandidates returns up to limit of a project's oldest unsummarised
+// entries, oldest first, leaving the newest keepRecent rows untouched so the
+// live conversation is never summarised away.
+func SummaryCandidates(db *sql.DB, project string, keepRecent, limit int) ([]MemoryEntry, error) {
+	rows, err := db.Query(`SELECT id, project, role, content, timestamp, importance FROM memory
+		WHERE project = ? AND summarised = 0 AND role != ?
+		  AND id NOT IN (SELECT id FROM memory WHERE project = ? AND role != ? ORDER BY id DESC LIMIT ?)
+		ORDER BY id ASC LIMIT ?`,
+		project, SummaryRole, project, SummaryRole, keepRecent, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// SaveSummary stores a summary for project and marks the source entries as
+// summarised in one transaction.
+func SaveSummary(db *sql.DB, project, content string, importance int, sourceIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO memory(project, role, content, importance) VALUES(?, ?, ?, ?)`,
+		project, SummaryRole, content, importance); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(sourceIDs) > 0 {
+		args := make([]interface{}, len(sourceIDs))
+		for i, id := range sourceIDs {
+			args[i] = id
+		}
+		q := `UPDATE memory SET summarised = 1 WHERE id IN (?` + strings.Repeat(",?", len(sourceIDs)-1) + `)`
+		if _, err := tx.Exec(q, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ConversationContext returns the context used for a chat turn: the newest
+// summaries and the newest unsummarised turns of a project, each in
+// chronological order. AI Awareness: summaries stand in for the older raw
+// turns they replaced, keeping prompts short on long projects.
+func ConversationContext(db *sql.DB, project string, summaries, recent int) ([]MemoryEntry, []MemoryEntry, error) {
+	rows, err := db.Query(`SELECT id, project, role, content, timestamp, importance FROM memory
+		WHERE project = ? AND role = ? ORDER BY id DESC LIMIT ?`, project, SummaryRole, summaries)
+	if err != nil {
+		return nil, nil, err
+	}
+	sums, err := scanEntries(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err = db.Query(`SELECT id, project, role, content, timestamp, importance FROM memory
+		WHERE project = ? AND role != ? AND summarised = 0 ORDER BY id DESC LIMIT ?`, project, SummaryRole, recent)
+	if err != nil {
+		return nil, nil, err
+	}
+	turns, err := scanEntries(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reverse(sums), reverse(turns), nil
+}
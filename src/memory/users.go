@@ -0,0 +1,319 @@
+package memory
+
+// User accounts and login sessions. Passwords are stored only as hashes
+// produced by the auth package and sessions are keyed by a hash of the token
+// held in the browser cookie, so a copy of memory.db does not reveal
+// credentials that can be replayed.
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrUserExists is returned when creating a user whose name is taken.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserNotFound is returned when a user does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is an account that can log in to the web UI and API.
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	// Role is "viewer", "member" or "admin"; see the auth package.
+	Role string `json:"role"`
+	// Email receives notifications; admins get alerts from the notify
+	// package.
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastLogin is zero until the first successful login.
+	LastLogin time.Time `json:"last_login,omitempty"`
+}
+
+// Session is a server-side login session. ID is the hash of the cookie
+// token.
+type Session struct {
+	ID        string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+}
+
+// initUsers creates the users, sessions and user_devices tables.
+func initUsers(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'member',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login DATETIME
+	);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		user_agent TEXT,
+		ip TEXT
+	);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS user_devices (
+		user_id INTEGER NOT NULL,
+		device TEXT NOT NULL,
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		PRIMARY KEY(user_id, device)
+	);`); err != nil {
+		return err
+	}
+	db.Exec(`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member'`)
+	db.Exec(`ALTER TABLE users ADD COLUMN email TEXT`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS sessions_user ON sessions(user_id)`)
+	return nil
+}
+
+// CreateUser stores a new user with an already hashed password and a role.
+func CreateUser(db *sql.DB, username, passwordHash, role string) (*User, error) {
+	if _, err := GetUserByName(db, username); err == nil {
+		return nil, ErrUserExists
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	res, err := db.Exec(`INSERT INTO users(username, password_hash, role, created_at) VALUES(?, ?, ?, ?)`,
+		username, passwordHash, role, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(db, int(id))
+}
+
+const userColumns = `id, username, password_hash, role, email, created_at, last_login`
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var u User
+	var email sql.NullString
+	var last sql.NullTime
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &email, &u.CreatedAt, &last); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	u.Email, u.LastLogin = email.String, last.Time
+	return &u, nil
+}
+
+// GetUser loads a user by ID.
+func GetUser(db *sql.DB, id int) (*User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByName loads a user by name, case-insensitively.
+func GetUserByName(db *sql.DB, username string) (*User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+// ListUsers returns every user ordered by name.
+func ListUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// CountUsers reports how many users exist.
+func CountUsers(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n)
+	return n, err
+}
+
+// SetPasswordHash replaces a user's password hash.
+func SetPasswordHash(db *sql.DB, id int, passwordHash string) error {
+	res, err := db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserRole changes a user's role.
+func SetUserRole(db *sql.DB, id int, role string) error {
+	res, err := db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserEmail changes a user's email address; an empty address removes it.
+func SetUserEmail(db *sql.DB, id int, email string) error {
+	res, err := db.Exec(`UPDATE users SET email = NULLIF(?, '') WHERE id = ?`, email, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AdminEmails returns the email addresses of the admin users.
+func AdminEmails(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT email FROM users WHERE role = 'admin' AND email IS NOT NULL AND email <> '' ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// RecordDevice notes that a user logged in from device, an opaque
+// fingerprint. It reports whether the device is new and whether the user
+// had logged in from any other device before.
+func RecordDevice(db *sql.DB, userID int, device string, at time.Time) (isNew, seenOthers bool, err error) {
+	res, err := db.Exec(`UPDATE user_devices SET last_seen = ? WHERE user_id = ? AND device = ?`, at.UTC(), userID, device)
+	if err != nil {
+		return false, false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, true, nil
+	}
+	var others int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM user_devices WHERE user_id = ?`, userID).Scan(&others); err != nil {
+		return false, false, err
+	}
+	_, err = db.Exec(`INSERT INTO user_devices(user_id, device, first_seen, last_seen) VALUES(?, ?, ?, ?)`,
+		userID, device, at.UTC(), at.UTC())
+	return true, others > 0, err
+}
+
+// RecordLogin stores the time of a successful login.
+func RecordLogin(db *sql.DB, id int, at time.Time) error {
+	_, err := db.Exec(`UPDATE users SET last_login = ? WHERE id = ?`, at.UTC(), id)
+	return err
+}
+
+// DeleteUser removes a user with all of their sessions, devices and API
+// keys.
+func DeleteUser(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, q := range []string{`DELETE FROM sessions WHERE user_id = ?`, `DELETE FROM user_devices WHERE user_id = ?`} {
+		if _, err := tx.Exec(q, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := deleteUserKeys(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ErrUserNotFound
+	}
+	return tx.Commit()
+}
+
+// CreateSession stores a new session.
+func CreateSession(db *sql.DB, s Session) error {
+	_, err := db.Exec(`INSERT INTO sessions(id, user_id, created_at, expires_at, last_seen, user_agent, ip)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.UserID, s.CreatedAt.UTC(), s.ExpiresAt.UTC(), s.LastSeen.UTC(), s.UserAgent, s.IP)
+	return err
+}
+
+// GetSession loads a session that has not expired at now. It returns nil
+// when there is none.
+func GetSession(db *sql.DB, id string, now time.Time) (*Session, error) {
+	var s Session
+	var ua, ip sql.NullString
+	err := db.QueryRow(`SELECT id, user_id, created_at, expires_at, last_seen, user_agent, ip
+		FROM sessions WHERE id = ? AND expires_at > ?`, id, now.UTC()).
+		Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &s.LastSeen, &ua, &ip)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.UserAgent, s.IP = ua.String, ip.String
+	return &s, nil
+}
+
+// TouchSession records activity on a session and moves its expiry.
+func TouchSession(db *sql.DB, id string, seen, expires time.Time) error {
+	_, err := db.Exec(`UPDATE sessions SET last_seen = ?, expires_at = ? WHERE id = ?`, seen.UTC(), expires.UTC(), id)
+	return err
+}
+
+// DeleteSession removes one session.
+func DeleteSession(db *sql.DB, id string) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+// DeleteUserSessions removes every session of a user, logging them out
+// everywhere.
+func DeleteUserSessions(db *sql.DB, userID int) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+// PurgeExpiredSessions deletes sessions that expired before now and returns
+// how many were removed.
+func PurgeExpiredSessions(db *sql.DB, now time.Time) (int, error) {
+	res, err := db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
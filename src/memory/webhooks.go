@@ -0,0 +1,299 @@
+package memory
+
+// Outgoing webhooks and their delivery log. A webhook receives the events
+// named in its filter; every event produces one delivery row per matching
+// webhook which the webhook package's dispatcher sends and retries. The rows
+// double as the delivery log shown to admins.
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a registered receiver of events.
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Secret keys the HMAC-SHA256 signature of every delivery. It is only
+	// shown when the webhook is created.
+	Secret string `json:"-"`
+	// Events lists the event names sent to the webhook; "*" matches all.
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether the webhook subscribes to event.
+func (w Webhook) Wants(event string) bool {
+	for _, e := range w.Events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one attempt to send an event to a webhook, with its outcome.
+type Delivery struct {
+	ID        int    `json:"id"`
+	WebhookID int    `json:"webhook_id"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	// ResponseCode and Error describe the last attempt.
+	ResponseCode int       `json:"response_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	NextAttempt  time.Time `json:"next_attempt,omitempty"`
+	DeliveredAt  time.Time `json:"delivered_at,omitempty"`
+}
+
+// initWebhooks creates the webhooks and webhook_deliveries tables.
+func initWebhooks(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL
+	);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER,
+		error TEXT,
+		created_at DATETIME NOT NULL,
+		next_attempt DATETIME,
+		delivered_at DATETIME
+	);`); err != nil {
+		return err
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS webhook_deliveries_hook ON webhook_deliveries(webhook_id, id)`)
+	return nil
+}
+
+// CreateWebhook stores w and sets its ID.
+func CreateWebhook(db *sql.DB, w *Webhook) error {
+	res, err := db.Exec(`INSERT INTO webhooks(url, secret, events, active, created_at) VALUES(?, ?, ?, ?, ?)`,
+		w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, w.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	w.ID = int(id)
+	return err
+}
+
+const webhookColumns = `id, url, secret, events, active, created_at`
+
+// scanWebhook reads a row selected with webhookColumns.
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var w Webhook
+	var events string
+	if err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+// GetWebhook loads a webhook by ID.
+func GetWebhook(db *sql.DB, id int) (*Webhook, error) {
+	return scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+}
+
+// ListWebhooks returns every webhook in creation order.
+func ListWebhooks(db *sql.DB) ([]Webhook, error) {
+	rows, err := db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *w)
+	}
+	return res, rows.Err()
+}
+
+// SetWebhookActive pauses or resumes a webhook.
+func SetWebhookActive(db *sql.DB, id int, active bool) error {
+	res, err := db.Exec(`UPDATE webhooks SET active = ? WHERE id = ?`, active, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func DeleteWebhook(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ErrWebhookNotFound
+	}
+	return tx.Commit()
+}
+
+// AddDelivery queues a pending delivery due immediately and sets its ID.
+func AddDelivery(db *sql.DB, d *Delivery) error {
+	d.Status = DeliveryPending
+	res, err := db.Exec(`INSERT INTO webhook_deliveries(webhook_id, event, payload, status, created_at, next_attempt)
+		VALUES(?, ?, ?, ?, ?, ?)`, d.WebhookID, d.Event, d.Payload, d.Status, d.CreatedAt.UTC(), d.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	d.ID = int(id)
+	return err
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, response_code, error, created_at, next_attempt, delivered_at`
+
+// scanDelivery reads a row selected with deliveryColumns.
+func scanDelivery(row interface{ Scan(...any) error }) (*Delivery, error) {
+	var d Delivery
+	var code sql.NullInt64
+	var msg sql.NullString
+	var next, delivered sql.NullTime
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &code, &msg, &d.CreatedAt, &next, &delivered); err != nil {
+		return nil, err
+	}
+	d.ResponseCode, d.Error = int(code.Int64), msg.String
+	d.NextAttempt, d.DeliveredAt = next.Time, delivered.Time
+	return &d, nil
+}
+
+// queryDeliveries runs a query selecting deliveryColumns.
+func queryDeliveries(db *sql.DB, query string, args ...any) ([]Delivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *d)
+	}
+	return res, rows.Err()
+}
+
+// DueDeliveries returns up to limit pending deliveries whose next attempt
+// is due at now, oldest first. Deliveries listed in skip and deliveries to
+// the webhooks in busy are left out, so the dispatcher does not fetch the
+// ones it is already sending or has no slot for.
+func DueDeliveries(db *sql.DB, now time.Time, limit int, skip, busy []int) ([]Delivery, error) {
+	args := []any{now.UTC()}
+	for _, id := range skip {
+		args = append(args, id)
+	}
+	for _, id := range busy {
+		args = append(args, id)
+	}
+	args = append(args, limit)
+	return queryDeliveries(db, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt <= ?
+			AND id NOT IN (`+placeholders(len(skip))+`) AND webhook_id NOT IN (`+placeholders(len(busy))+`)
+		ORDER BY next_attempt, id LIMIT ?`, args...)
+}
+
+// placeholders returns n comma separated parameters for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// NextDeliveryDue returns when the earliest pending delivery due after
+// after is due, or the zero time when none is pending.
+func NextDeliveryDue(db *sql.DB, after time.Time) (time.Time, error) {
+	var next sql.NullTime
+	err := db.QueryRow(`SELECT next_attempt FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt > ? ORDER BY next_attempt LIMIT 1`, after.UTC()).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return next.Time, err
+}
+
+// ListDeliveries returns the newest deliveries of a webhook, newest first.
+func ListDeliveries(db *sql.DB, webhookID, limit int) ([]Delivery, error) {
+	return queryDeliveries(db, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit)
+}
+
+// UpdateDelivery stores the outcome of a delivery attempt.
+func UpdateDelivery(db *sql.DB, d *Delivery) error {
+	var next, delivered, code any
+	if !d.NextAttempt.IsZero() {
+		next = d.NextAttempt.UTC()
+	}
+	if !d.DeliveredAt.IsZero() {
+		delivered = d.DeliveredAt.UTC()
+	}
+	if d.ResponseCode != 0 {
+		code = d.ResponseCode
+	}
+	_, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, error = ?,
+		next_attempt = ?, delivered_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, code, d.Error, next, delivered, d.ID)
+	return err
+}
+
+// PruneDeliveries deletes finished deliveries created before cutoff and
+// returns how many were removed.
+func PruneDeliveries(db *sql.DB, cutoff time.Time) (int, error) {
+	res, err := db.Exec(`DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
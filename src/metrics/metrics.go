@@ -0,0 +1,442 @@
+package metrics
+
+// Package metrics keeps counters, gauges and histograms in memory and writes
+// them in the Prometheus text exposition format for `GET /metrics`. It
+// implements the small part of the Prometheus client that Codex needs so the
+// server keeps its short dependency list.
+//
+// Packages declare their metrics as package level variables, which register
+// them with the default registry:
+//
+//	var requests = metrics.NewCounterVec("codex_x_total", "Things done.", "kind")
+//	requests.With("a").Inc()
+//
+// AI Awareness: every distinct set of label values becomes a series that is
+// kept until the process exits. Only use labels with a small, fixed set of
+// values such as routes or error kinds, never user input, IDs or prompts.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ExponentialBuckets returns count buckets starting at start, each factor
+// times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	b := make([]float64, count)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// collector is a metric family known to a registry.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics written together. The zero value is empty
+// and ready to use.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+var defaultRegistry = &Registry{}
+
+// Default returns the registry served by `GET /metrics`. The New functions
+// of this package register with it.
+func Default() *Registry { return defaultRegistry }
+
+// register adds c, panicking on a duplicate name since that is a
+// programming error found on startup.
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.collectors == nil {
+		r.collectors = make(map[string]collector)
+	}
+	if _, dup := r.collectors[c.name()]; dup {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// Write writes every metric, ordered by name, in the text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	list := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		list = append(list, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name() < list[j].name() })
+	for _, c := range list {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// family holds what every kind of metric shares: its name, help text, type
+// and the series per set of label values.
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]any
+	values map[string][]string
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		series:     make(map[string]any),
+		values:     make(map[string][]string),
+	}
+}
+
+func (f *family) name() string { return f.metricName }
+
+// get returns the series for values, creating it with create.
+func (f *family) get(values []string, create func() any) any {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = create()
+		f.series[key] = s
+		f.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn for every series ordered by label values.
+func (f *family) each(fn func(labels string, s any)) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		s      any
+	}
+	entries := make([]entry, len(keys))
+	for i, k := range keys {
+		entries[i] = entry{labelString(f.labels, f.values[k]), f.series[k]}
+	}
+	f.mu.Unlock()
+	for _, e := range entries {
+		fn(e.labels, e.s)
+	}
+}
+
+// header writes the HELP and TYPE lines.
+func (f *family) header(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, help, f.metricName, f.kind)
+}
+
+// labelString formats label pairs as {a="x",b="y"}, or "" without labels.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + esc.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withLabel adds one more pair to a label string from labelString.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// formatFloat formats v as Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 updated without locks.
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (a *atomicFloat) add(v float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (a *atomicFloat) set(v float64) { a.bits.Store(math.Float64bits(v)) }
+
+func (a *atomicFloat) get() float64 { return math.Float64frombits(a.bits.Load()) }
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ v atomicFloat }
+
+// Inc adds one.
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.v.add(v)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 { return c.v.get() }
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(name, help, "counter", labels)}
+	r.register(v)
+	return v
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter with the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return defaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewCounter registers a counter without labels with the default registry.
+func NewCounter(name, help string) *Counter { return defaultRegistry.NewCounter(name, help) }
+
+// With returns the counter for the label values, in the order the labels
+// were declared.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.get(values, func() any { return new(Counter) }).(*Counter)
+}
+
+func (v *CounterVec) name() string { return v.f.name() }
+
+func (v *CounterVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, s any) {
+		fmt.Fprintf(w, "%s%s %s\n", v.f.metricName, labels, formatFloat(s.(*Counter).Value()))
+	})
+}
+
+// Gauge is a value that goes up and down, such as active downloads.
+type Gauge struct{ v atomicFloat }
+
+// Set replaces the value.
+func (g *Gauge) Set(v float64) { g.v.set(v) }
+
+// Add adds v, which may be negative.
+func (g *Gauge) Add(v float64) { g.v.add(v) }
+
+// Inc adds one.
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec subtracts one.
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 { return g.v.get() }
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// NewGaugeVec registers a gauge with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(name, help, "gauge", labels)}
+	r.register(v)
+	return v
+}
+
+// NewGauge registers a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewGaugeVec registers a gauge with the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return defaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewGauge registers a gauge without labels with the default registry.
+func NewGauge(name, help string) *Gauge { return defaultRegistry.NewGauge(name, help) }
+
+// With returns the gauge for the label values.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.f.get(values, func() any { return new(Gauge) }).(*Gauge)
+}
+
+func (v *GaugeVec) name() string { return v.f.name() }
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, s any) {
+		fmt.Fprintf(w, "%s%s %s\n", v.f.metricName, labels, formatFloat(s.(*Gauge).Value()))
+	})
+}
+
+// funcMetric is a counter or gauge whose value is read when scraped, for
+// state another package already tracks.
+type funcMetric struct {
+	f  *family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn's result at scrape time.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{newFamily(name, help, "gauge", nil), fn})
+}
+
+// NewCounterFunc registers a counter whose value is fn's result at scrape
+// time. fn must never return less than before.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{newFamily(name, help, "counter", nil), fn})
+}
+
+// NewGaugeFunc registers a gauge function with the default registry.
+func NewGaugeFunc(name, help string, fn func() float64) { defaultRegistry.NewGaugeFunc(name, help, fn) }
+
+// NewCounterFunc registers a counter function with the default registry.
+func NewCounterFunc(name, help string, fn func() float64) {
+	defaultRegistry.NewCounterFunc(name, help, fn)
+}
+
+func (m *funcMetric) name() string { return m.f.name() }
+
+func (m *funcMetric) write(w io.Writer) {
+	m.f.header(w)
+	fmt.Fprintf(w, "%s %s\n", m.f.metricName, formatFloat(m.fn()))
+}
+
+// Histogram counts observations, such as latencies or sizes, in buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	upper  []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	f       *family
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds,
+// in increasing order, and label names. A +Inf bucket is implied.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " not sorted")
+	}
+	v := &HistogramVec{newFamily(name, help, "histogram", labels), buckets}
+	r.register(v)
+	return v
+}
+
+// NewHistogram registers a histogram without labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec registers a histogram with the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return defaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogram registers a histogram without labels with the default
+// registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return defaultRegistry.NewHistogram(name, help, buckets)
+}
+
+// With returns the histogram for the label values.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.get(values, func() any {
+		return &Histogram{upper: v.buckets, counts: make([]uint64, len(v.buckets))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) name() string { return v.f.name() }
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, s any) {
+		h := s.(*Histogram)
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.mu.Unlock()
+		var cum uint64
+		for i, le := range v.buckets {
+			cum += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.f.metricName, withLabel(labels, "le", formatFloat(le)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.f.metricName, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.f.metricName, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.f.metricName, labels, count)
+	})
+}
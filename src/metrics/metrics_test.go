@@ -0,0 +1,75 @@
+package metrics
+
+// Tests for the text exposition format.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestWrite checks the output of every kind of metric against the format
+// Prometheus parses.
+func TestWrite(t *testing.T) {
+	r := &Registry{}
+	req := r.NewCounterVec("test_requests_total", "Requests.\nBy route.", "route", "code")
+	req.With("/api/chat", "200").Add(2)
+	req.With("/api/chat", "500").Inc()
+	req.With(`/a"b`, "200").Inc()
+	g := r.NewGauge("test_active", "Active things.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	r.NewGaugeFunc("test_depth", "Queue depth.", func() float64 { return 7 })
+	h := r.NewHistogramVec("test_seconds", "Latency.", []float64{0.1, 1}, "op")
+	h.With("select").Observe(0.05)
+	h.With("select").Observe(0.1)
+	h.With("select").Observe(3)
+
+	var b bytes.Buffer
+	r.Write(&b)
+	want := `# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 1
+# HELP test_depth Queue depth.
+# TYPE test_depth gauge
+test_depth 7
+# HELP test_requests_total Requests.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b",code="200"} 1
+test_requests_total{route="/api/chat",code="200"} 2
+test_requests_total{route="/api/chat",code="500"} 1
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="select",le="0.1"} 2
+test_seconds_bucket{op="select",le="1"} 2
+test_seconds_bucket{op="select",le="+Inf"} 3
+test_seconds_sum{op="select"} 3.15
+test_seconds_count{op="select"} 3
+`
+	if b.String() != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+// TestRegisterErrors checks that mistakes in declaring or using metrics
+// are caught.
+func TestRegisterErrors(t *testing.T) {
+	r := &Registry{}
+	v := r.NewCounterVec("test_total", "Test.", "kind")
+	for name, f := range map[string]func(){
+		"duplicate":        func() { r.NewGauge("test_total", "Again.") },
+		"label count":      func() { v.With("a", "b") },
+		"negative counter": func() { v.With("a").Add(-1) },
+		"unsorted buckets": func() { r.NewHistogram("test_h", "H.", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if p := recover(); p == nil || !strings.HasPrefix(p.(string), "metrics:") {
+					t.Errorf("%s: panic = %v", name, p)
+				}
+			}()
+			f()
+		}()
+	}
+}
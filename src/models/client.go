@@ -0,0 +1,175 @@
+package models
+
+// HTTP client settings for talking to Hugging Face.  Every request made by
+// this package goes through hfGet so the endpoint, authentication, timeouts
+// and offline mode are applied consistently.  The defaults come from the
+// environment which keeps the CLI and server usable without extra flags:
+//
+//	HF_ENDPOINT     base URL of a mirror or local stand-in
+//	HF_TOKEN        bearer token for gated or private repositories
+//	HF_TIMEOUT      request timeout such as "30s"
+//	HF_HUB_OFFLINE  set to 1 to never contact Hugging Face
+//
+// AI: Extension - Alternative model hubs can be targeted by changing BaseURL
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOffline is returned by functions that would contact Hugging Face while
+// offline mode is enabled. Callers are expected to fall back to cached data.
+var ErrOffline = errors.New("offline mode: Hugging Face is not contacted")
+
+// ClientConfig controls how the package reaches the Hugging Face API.
+type ClientConfig struct {
+	// BaseURL is the hub root, e.g. https://huggingface.co. API calls are
+	// made against BaseURL + "/api/...".
+	BaseURL string
+	// Token is sent as a bearer token when non-empty.
+	Token string
+	// Timeout bounds metadata requests. File downloads only apply it to
+	// the wait for response headers since bodies may be gigabytes.
+	Timeout time.Duration
+	// UserAgent identifies the client to the hub.
+	UserAgent string
+	// Offline disables all network access.
+	Offline bool
+}
+
+// DefaultClientConfig returns the settings used when nothing is configured.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		BaseURL:   "https://huggingface.co",
+		Timeout:   30 * time.Second,
+		UserAgent: "codex/1.0",
+	}
+}
+
+// ClientConfigFromEnv returns DefaultClientConfig overridden by the HF_*
+// environment variables documented at the top of this file.
+func ClientConfigFromEnv() ClientConfig {
+	cfg := DefaultClientConfig()
+	if v := os.Getenv("HF_ENDPOINT"); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv("HF_TOKEN"); v != "" {
+		cfg.Token = v
+	} else if v := os.Getenv("HUGGING_FACE_HUB_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	if v := os.Getenv("HF_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Timeout = d
+		} else {
+			log.Printf("invalid HF_TIMEOUT %q: %v", v, err)
+		}
+	}
+	switch strings.ToLower(os.Getenv("HF_HUB_OFFLINE")) {
+	case "1", "true", "yes":
+		cfg.Offline = true
+	}
+	return cfg
+}
+
+var (
+	clientMu       sync.RWMutex
+	clientCfg      ClientConfig
+	apiClient      *http.Client
+	downloadClient *http.Client
+)
+
+func init() {
+	Configure(ClientConfigFromEnv())
+}
+
+// Configure replaces the active client settings. It is safe to call while
+// requests are in flight; they finish with the previous settings.
+func Configure(cfg ClientConfig) {
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultClientConfig().BaseURL
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultClientConfig().UserAgent
+	}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	clientCfg = cfg
+	apiClient = &http.Client{Timeout: cfg.Timeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Timeout
+	downloadClient = &http.Client{Transport: transport}
+}
+
+// CurrentClientConfig returns the active client settings.
+func CurrentClientConfig() ClientConfig {
+	clientMu.RLock()
+	defer clientMu.RUnlock()
+	return clientCfg
+}
+
+// Offline reports whether offline mode is enabled. Handlers use it to decide
+// whether to serve exclusively from the model_cache table.
+func Offline() bool {
+	return CurrentClientConfig().Offline
+}
+
+// hfGet performs a GET request for the hub-relative path (for example
+// "/api/models/foo") and returns the response when the status is 200. Any
+// other status is converted into an error carrying the response body. Absolute
+// URLs, such as pagination links returned by the hub, are used unchanged. The
+// download flag selects the client without an overall timeout.
+func hfGet(path string, download bool) (*http.Response, error) {
+	return hfGetContext(context.Background(), path, download)
+}
+
+// hfGetContext is hfGet with a context that aborts the request, including
+// the transfer of the response body.
+func hfGetContext(ctx context.Context, path string, download bool) (*http.Response, error) {
+	clientMu.RLock()
+	cfg, client := clientCfg, apiClient
+	if download {
+		client = downloadClient
+	}
+	clientMu.RUnlock()
+	if cfg.Offline {
+		return nil, ErrOffline
+	}
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = cfg.BaseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	// The token is only sent to the configured hub, never to hosts named
+	// in pagination links or redirects.
+	if cfg.Token != "" && strings.HasPrefix(target, cfg.BaseURL+"/") {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
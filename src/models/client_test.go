@@ -0,0 +1,106 @@
+package models
+
+// Tests for the Hugging Face client configuration. A local HTTP server stands
+// in for the hub so the base URL, auth header and offline mode can be checked.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestClientConfigApplied verifies requests go to the configured base URL
+// with the bearer token and user agent set.
+func TestClientConfigApplied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("User-Agent") != "codex-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/models" || r.URL.Query().Get("pipeline_tag") != "text-generation" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "org/model", "downloads": 7}})
+	}))
+	defer srv.Close()
+
+	prev := CurrentClientConfig()
+	defer Configure(prev)
+	Configure(ClientConfig{BaseURL: srv.URL + "/", Token: "secret", UserAgent: "codex-test"})
+
+	list, err := ListModelsByType("text-generation")
+	if err != nil {
+		t.Fatalf("ListModelsByType error: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != "org/model" || list[0].Downloads != 7 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+}
+
+// TestOfflineMode ensures no request is attempted when offline.
+func TestOfflineMode(t *testing.T) {
+	prev := CurrentClientConfig()
+	defer Configure(prev)
+	Configure(ClientConfig{BaseURL: "http://127.0.0.1:1", Offline: true})
+
+	if _, err := GetModelDetail("org/model"); !errors.Is(err, ErrOffline) {
+		t.Fatalf("expected ErrOffline, got %v", err)
+	}
+	if !Offline() {
+		t.Fatalf("Offline() should report true")
+	}
+}
+
+// TestDownloadCancelled checks that a download stopped through its context
+// removes the partial file instead of leaving a truncated model, and that
+// the download metrics follow it.
+func TestDownloadCancelled(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bytesBefore := downloadBytes.Value()
+	var active float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/models/org/model" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"sha": "abc", "siblings": []map[string]string{{"rfilename": "model.gguf"}}})
+			return
+		}
+		// send part of the file, then stall until the download is cancelled
+		active = activeDownloads.Value()
+		w.Write([]byte("GGUF partial"))
+		w.(http.Flusher).Flush()
+		for i := 0; i < 100 && downloadBytes.Value() == bytesBefore; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	prev := CurrentClientConfig()
+	defer Configure(prev)
+	Configure(ClientConfig{BaseURL: srv.URL, UserAgent: "codex-test"})
+
+	if _, err := DownloadModelContext(ctx, "org/model", nil); err == nil {
+		t.Fatalf("expected an error from the cancelled download")
+	}
+	files, _ := os.ReadDir(modelDir("org/model"))
+	if len(files) != 0 {
+		t.Fatalf("partial files left behind: %v", files)
+	}
+	if got := downloadBytes.Value() - bytesBefore; got != float64(len("GGUF partial")) {
+		t.Fatalf("download bytes = %v", got)
+	}
+	if active != 1 || activeDownloads.Value() != 0 {
+		t.Fatalf("active downloads = %v during, %v after", active, activeDownloads.Value())
+	}
+}
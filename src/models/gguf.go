@@ -0,0 +1,439 @@
+package models
+
+// GGUF is the single-file container format used by llama.cpp.  Every file
+// starts with a small header followed by a list of typed key/value pairs that
+// describe the model (architecture, name, tokenizer and so on) and a table of
+// tensor descriptors.  The reader in this file only parses those sections so
+// models can be inspected without loading any tensor data.
+//
+// AI: Feature - Local model introspection
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ggufMagic is the little-endian encoding of the ASCII bytes "GGUF" found at
+// the start of every GGUF file.
+const ggufMagic = 0x46554747
+
+// maxGGUFArrayLen bounds how many array elements are kept in memory when
+// reading metadata. Tokenizer vocabularies contain hundreds of thousands of
+// entries which are of no interest when describing a model, so larger arrays
+// are skipped and only their length is recorded.
+const maxGGUFArrayLen = 1024
+
+// maxGGUFStringLen guards against corrupt files that declare absurd string
+// lengths. Chat templates are the largest strings in practice and stay well
+// below this limit.
+const maxGGUFStringLen = 16 << 20
+
+// GGUF metadata value types as defined by the format specification.
+const (
+	ggufTypeUint8 uint32 = iota
+	ggufTypeInt8
+	ggufTypeUint16
+	ggufTypeInt16
+	ggufTypeUint32
+	ggufTypeInt32
+	ggufTypeFloat32
+	ggufTypeBool
+	ggufTypeString
+	ggufTypeArray
+	ggufTypeUint64
+	ggufTypeInt64
+	ggufTypeFloat64
+)
+
+// ErrNotGGUF is returned when a file does not start with the GGUF magic bytes.
+var ErrNotGGUF = errors.New("not a GGUF file")
+
+// GGUFArray stands in for metadata arrays that were too large to keep in
+// memory. Only the element type and length are retained.
+type GGUFArray struct {
+	Type uint32 `json:"type"`
+	Len  uint64 `json:"len"`
+}
+
+// maxGGUFDims is the largest tensor rank accepted. ggml tensors have at most
+// four dimensions (GGML_MAX_DIMS).
+const maxGGUFDims = 4
+
+// GGUFTensorInfo describes a single tensor stored in a GGUF file.
+type GGUFTensorInfo struct {
+	Name   string   `json:"name"`
+	Dims   []uint64 `json:"dims"`
+	Type   uint32   `json:"type"`
+	Offset uint64   `json:"offset"`
+}
+
+// Elements returns the number of values held by the tensor.
+func (t GGUFTensorInfo) Elements() uint64 {
+	n := uint64(1)
+	for _, d := range t.Dims {
+		n *= d
+	}
+	return n
+}
+
+// GGUFHeader holds the parsed header, metadata and tensor table of a GGUF
+// file. Metadata values are decoded into native Go types: integers become
+// uint64 or int64, floats become float64, strings stay strings and arrays
+// become []interface{} (or GGUFArray when skipped).
+type GGUFHeader struct {
+	Version     uint32                 `json:"version"`
+	TensorCount uint64                 `json:"tensor_count"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Tensors     []GGUFTensorInfo       `json:"tensors"`
+}
+
+// ReadGGUFHeader opens the file at path and parses its GGUF header.
+func ReadGGUFHeader(path string) (*GGUFHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return parseGGUFHeader(bufio.NewReader(f), st.Size())
+}
+
+// ParseGGUFHeader reads the GGUF header, metadata key/value pairs and tensor
+// descriptors from r.
+// Only versions 2 and 3 are supported; version 1 files predate the 64-bit
+// length fields and are no longer produced by llama.cpp.
+func ParseGGUFHeader(r io.Reader) (*GGUFHeader, error) {
+	return parseGGUFHeader(r, -1)
+}
+
+// parseGGUFHeader is ParseGGUFHeader for a reader holding size bytes, or an
+// unknown amount when size is negative. A known size lets corrupt counts be
+// rejected before anything is allocated or skipped.
+func parseGGUFHeader(r io.Reader, size int64) (*GGUFHeader, error) {
+	g := &ggufReader{r: r, left: size}
+	magic := g.u32()
+	if g.err == io.EOF || g.err == io.ErrUnexpectedEOF || (g.err == nil && magic != ggufMagic) {
+		return nil, ErrNotGGUF
+	}
+	h := &GGUFHeader{Version: g.u32()}
+	if g.err != nil {
+		return nil, g.err
+	}
+	if h.Version < 2 || h.Version > 3 {
+		return nil, fmt.Errorf("unsupported GGUF version %d", h.Version)
+	}
+	h.TensorCount = g.u64()
+	kvCount := g.u64()
+	if g.err != nil {
+		return nil, g.err
+	}
+	h.Metadata = make(map[string]interface{})
+	for i := uint64(0); i < kvCount; i++ {
+		key := g.str()
+		typ := g.u32()
+		val := g.value(typ)
+		if g.err != nil {
+			return nil, fmt.Errorf("gguf metadata %d: %w", i, g.err)
+		}
+		h.Metadata[key] = val
+	}
+	for i := uint64(0); i < h.TensorCount; i++ {
+		t := GGUFTensorInfo{Name: g.str()}
+		n := g.u32()
+		if g.err == nil && n > maxGGUFDims {
+			g.err = fmt.Errorf("tensor %q has %d dimensions", t.Name, n)
+		}
+		if g.err != nil {
+			return nil, fmt.Errorf("gguf tensor %d: %w", i, g.err)
+		}
+		t.Dims = make([]uint64, n)
+		for d := range t.Dims {
+			t.Dims[d] = g.u64()
+		}
+		t.Type = g.u32()
+		t.Offset = g.u64()
+		if g.err != nil {
+			return nil, fmt.Errorf("gguf tensor %d: %w", i, g.err)
+		}
+		h.Tensors = append(h.Tensors, t)
+	}
+	return h, nil
+}
+
+// String returns the metadata value for key when it is a string.
+func (h *GGUFHeader) String(key string) string {
+	s, _ := h.Metadata[key].(string)
+	return s
+}
+
+// Uint returns the metadata value for key as an unsigned integer. Signed
+// values are converted when non-negative; anything else yields zero.
+func (h *GGUFHeader) Uint(key string) uint64 {
+	switch v := h.Metadata[key].(type) {
+	case uint64:
+		return v
+	case int64:
+		if v >= 0 {
+			return uint64(v)
+		}
+	}
+	return 0
+}
+
+// Architecture reports the value of `general.architecture`, e.g. "llama".
+func (h *GGUFHeader) Architecture() string {
+	return h.String("general.architecture")
+}
+
+// GGUFInfo is the condensed description of a GGUF model used to populate
+// ModelMetadata and LocalModel. Zero values mean the file did not record the
+// corresponding key.
+type GGUFInfo struct {
+	Architecture    string `json:"architecture,omitempty"`
+	Name            string `json:"name,omitempty"`
+	ContextLength   int    `json:"context_length,omitempty"`
+	EmbeddingLength int    `json:"embedding_length,omitempty"`
+	BlockCount      int    `json:"block_count,omitempty"`
+	HeadCount       int    `json:"head_count,omitempty"`
+	HeadCountKV     int    `json:"head_count_kv,omitempty"`
+	Quantization    string `json:"quantization,omitempty"`
+	ChatTemplate    string `json:"chat_template,omitempty"`
+	ParameterCount  uint64 `json:"parameter_count,omitempty"`
+}
+
+// Info extracts the commonly needed model properties from the header.
+// Architecture specific keys are looked up under the `<arch>.` prefix as
+// written by llama.cpp's conversion scripts. The parameter count is summed
+// from the tensor table and the quantization is taken from
+// `general.file_type`, falling back to the dominant tensor type.
+func (h *GGUFHeader) Info() *GGUFInfo {
+	arch := h.Architecture()
+	info := &GGUFInfo{
+		Architecture:    arch,
+		Name:            h.String("general.name"),
+		ContextLength:   int(h.Uint(arch + ".context_length")),
+		EmbeddingLength: int(h.Uint(arch + ".embedding_length")),
+		BlockCount:      int(h.Uint(arch + ".block_count")),
+		HeadCount:       int(h.Uint(arch + ".attention.head_count")),
+		HeadCountKV:     int(h.Uint(arch + ".attention.head_count_kv")),
+		ChatTemplate:    h.String("tokenizer.chat_template"),
+	}
+	byType := make(map[uint32]uint64)
+	for _, t := range h.Tensors {
+		n := t.Elements()
+		info.ParameterCount += n
+		if len(t.Dims) > 1 {
+			byType[t.Type] += n
+		}
+	}
+	if _, ok := h.Metadata["general.file_type"]; ok {
+		info.Quantization = fileTypeNames[uint32(h.Uint("general.file_type"))]
+	}
+	if info.Quantization == "" {
+		var best uint64
+		for typ, n := range byType {
+			if n > best {
+				best = n
+				info.Quantization = tensorTypeNames[typ]
+			}
+		}
+	}
+	return info
+}
+
+// ReadGGUFInfo reads the GGUF file at path and returns its condensed info.
+func ReadGGUFInfo(path string) (*GGUFInfo, error) {
+	h, err := ReadGGUFHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	return h.Info(), nil
+}
+
+// fileTypeNames maps llama.cpp's `general.file_type` values (llama_ftype) to
+// the quantization names used in GGUF file names.
+var fileTypeNames = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S",
+	15: "Q4_K_M", 16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS",
+	20: "IQ2_XS", 21: "Q2_K_S", 22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S",
+	25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M", 28: "IQ2_S", 29: "IQ2_M",
+	30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0", 37: "TQ2_0",
+}
+
+// tensorTypeNames maps ggml tensor types to their names. It is used when a
+// file does not record `general.file_type`.
+var tensorTypeNames = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0",
+	9: "Q8_1", 10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K",
+	15: "Q8_K", 16: "IQ2_XXS", 17: "IQ2_XS", 18: "IQ3_XXS", 19: "IQ1_S",
+	20: "IQ4_NL", 21: "IQ3_S", 22: "IQ2_S", 23: "IQ4_XS", 24: "I8", 25: "I16",
+	26: "I32", 27: "I64", 28: "F64", 29: "IQ1_M", 30: "BF16", 34: "TQ1_0",
+	35: "TQ2_0",
+}
+
+// ggufReader wraps an io.Reader and records the first error encountered so
+// the parsing code can read a sequence of fields without checking each one.
+// left counts the bytes remaining in the file, or is negative when the size
+// is unknown.
+type ggufReader struct {
+	r    io.Reader
+	err  error
+	buf  [8]byte
+	left int64
+}
+
+// consume accounts for n bytes about to be read and fails when the file
+// does not hold that many.
+func (g *ggufReader) consume(n uint64) bool {
+	if g.err != nil {
+		return false
+	}
+	if g.left < 0 {
+		return true
+	}
+	if n > uint64(g.left) {
+		g.err = fmt.Errorf("%d bytes declared but only %d left in file", n, g.left)
+		return false
+	}
+	g.left -= int64(n)
+	return true
+}
+
+func (g *ggufReader) read(n int) []byte {
+	if !g.consume(uint64(n)) {
+		return g.buf[:n]
+	}
+	_, g.err = io.ReadFull(g.r, g.buf[:n])
+	return g.buf[:n]
+}
+
+func (g *ggufReader) u8() uint8   { return g.read(1)[0] }
+func (g *ggufReader) u16() uint16 { return binary.LittleEndian.Uint16(g.read(2)) }
+func (g *ggufReader) u32() uint32 { return binary.LittleEndian.Uint32(g.read(4)) }
+func (g *ggufReader) u64() uint64 { return binary.LittleEndian.Uint64(g.read(8)) }
+
+// str reads a length-prefixed UTF-8 string.
+func (g *ggufReader) str() string {
+	n := g.u64()
+	if g.err != nil {
+		return ""
+	}
+	if n > maxGGUFStringLen {
+		g.err = fmt.Errorf("string length %d exceeds limit", n)
+		return ""
+	}
+	if !g.consume(n) {
+		return ""
+	}
+	b := make([]byte, n)
+	_, g.err = io.ReadFull(g.r, b)
+	return string(b)
+}
+
+// skip discards n bytes from the underlying reader.
+func (g *ggufReader) skip(n uint64) {
+	if !g.consume(n) {
+		return
+	}
+	if n > math.MaxInt64 {
+		g.err = fmt.Errorf("cannot skip %d bytes", n)
+		return
+	}
+	_, g.err = io.CopyN(io.Discard, g.r, int64(n))
+}
+
+// value decodes a single metadata value of the given type.
+func (g *ggufReader) value(typ uint32) interface{} {
+	switch typ {
+	case ggufTypeUint8:
+		return uint64(g.u8())
+	case ggufTypeInt8:
+		return int64(int8(g.u8()))
+	case ggufTypeUint16:
+		return uint64(g.u16())
+	case ggufTypeInt16:
+		return int64(int16(g.u16()))
+	case ggufTypeUint32:
+		return uint64(g.u32())
+	case ggufTypeInt32:
+		return int64(int32(g.u32()))
+	case ggufTypeFloat32:
+		return float64(math.Float32frombits(g.u32()))
+	case ggufTypeBool:
+		return g.u8() != 0
+	case ggufTypeString:
+		return g.str()
+	case ggufTypeUint64:
+		return g.u64()
+	case ggufTypeInt64:
+		return int64(g.u64())
+	case ggufTypeFloat64:
+		return math.Float64frombits(g.u64())
+	case ggufTypeArray:
+		return g.array()
+	}
+	if g.err == nil {
+		g.err = fmt.Errorf("unknown metadata type %d", typ)
+	}
+	return nil
+}
+
+// array decodes an array value. Arrays longer than maxGGUFArrayLen are
+// skipped and represented by a GGUFArray placeholder.
+func (g *ggufReader) array() interface{} {
+	typ := g.u32()
+	n := g.u64()
+	if g.err != nil {
+		return nil
+	}
+	// every element takes at least one byte, so a count beyond the file
+	// size is corrupt
+	if g.left >= 0 && n > uint64(g.left) {
+		g.err = fmt.Errorf("array of %d elements exceeds the %d bytes left in file", n, g.left)
+		return nil
+	}
+	if n > maxGGUFArrayLen {
+		if size := ggufTypeSize(typ); size > 0 {
+			if n > math.MaxUint64/size {
+				g.err = fmt.Errorf("array of %d elements is too large", n)
+				return nil
+			}
+			g.skip(n * size)
+		} else {
+			for i := uint64(0); i < n && g.err == nil; i++ {
+				g.value(typ)
+			}
+		}
+		return GGUFArray{Type: typ, Len: n}
+	}
+	vals := make([]interface{}, 0, n)
+	for i := uint64(0); i < n && g.err == nil; i++ {
+		vals = append(vals, g.value(typ))
+	}
+	return vals
+}
+
+// ggufTypeSize returns the encoded size of fixed width types or 0 for strings
+// and arrays whose size depends on their content.
+func ggufTypeSize(typ uint32) uint64 {
+	switch typ {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		return 1
+	case ggufTypeUint16, ggufTypeInt16:
+		return 2
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		return 4
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package models
+
+// Tests for the GGUF metadata reader. Files are assembled in memory so the
+// tests cover every value type the parser needs to walk over.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// ggufBuilder assembles a GGUF byte stream for tests.
+type ggufBuilder struct {
+	kv      bytes.Buffer
+	kvCount uint64
+	tensors bytes.Buffer
+	tCount  uint64
+}
+
+func (b *ggufBuilder) key(buf *bytes.Buffer, k string, typ uint32) {
+	binary.Write(buf, binary.LittleEndian, uint64(len(k)))
+	buf.WriteString(k)
+	binary.Write(buf, binary.LittleEndian, typ)
+	b.kvCount++
+}
+
+func (b *ggufBuilder) str(k, v string) {
+	b.key(&b.kv, k, ggufTypeString)
+	binary.Write(&b.kv, binary.LittleEndian, uint64(len(v)))
+	b.kv.WriteString(v)
+}
+
+func (b *ggufBuilder) u32(k string, v uint32) {
+	b.key(&b.kv, k, ggufTypeUint32)
+	binary.Write(&b.kv, binary.LittleEndian, v)
+}
+
+// strArray writes an array of n identical strings.
+func (b *ggufBuilder) strArray(k string, n int) {
+	b.key(&b.kv, k, ggufTypeArray)
+	binary.Write(&b.kv, binary.LittleEndian, ggufTypeString)
+	binary.Write(&b.kv, binary.LittleEndian, uint64(n))
+	for i := 0; i < n; i++ {
+		binary.Write(&b.kv, binary.LittleEndian, uint64(3))
+		b.kv.WriteString("tok")
+	}
+}
+
+func (b *ggufBuilder) tensor(name string, typ uint32, dims ...uint64) {
+	binary.Write(&b.tensors, binary.LittleEndian, uint64(len(name)))
+	b.tensors.WriteString(name)
+	binary.Write(&b.tensors, binary.LittleEndian, uint32(len(dims)))
+	for _, d := range dims {
+		binary.Write(&b.tensors, binary.LittleEndian, d)
+	}
+	binary.Write(&b.tensors, binary.LittleEndian, typ)
+	binary.Write(&b.tensors, binary.LittleEndian, uint64(0))
+	b.tCount++
+}
+
+func (b *ggufBuilder) bytes() []byte {
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, uint32(ggufMagic))
+	binary.Write(&out, binary.LittleEndian, uint32(3))
+	binary.Write(&out, binary.LittleEndian, b.tCount)
+	binary.Write(&out, binary.LittleEndian, b.kvCount)
+	out.Write(b.kv.Bytes())
+	out.Write(b.tensors.Bytes())
+	return out.Bytes()
+}
+
+// TestGGUFInfo parses a synthetic llama file and checks every extracted field.
+func TestGGUFInfo(t *testing.T) {
+	var b ggufBuilder
+	b.str("general.architecture", "llama")
+	b.str("general.name", "Tiny Llama")
+	b.u32("llama.context_length", 4096)
+	b.u32("llama.embedding_length", 64)
+	b.u32("llama.block_count", 2)
+	b.u32("llama.attention.head_count", 8)
+	b.u32("llama.attention.head_count_kv", 4)
+	b.u32("general.file_type", 15)
+	b.strArray("tokenizer.ggml.tokens", maxGGUFArrayLen+10)
+	b.str("tokenizer.chat_template", "{{ messages }}")
+	b.tensor("token_embd.weight", 12, 64, 100)
+	b.tensor("output_norm.weight", 0, 64)
+
+	h, err := ParseGGUFHeader(bytes.NewReader(b.bytes()))
+	if err != nil {
+		t.Fatalf("ParseGGUFHeader error: %v", err)
+	}
+	if arr, ok := h.Metadata["tokenizer.ggml.tokens"].(GGUFArray); !ok || arr.Len != maxGGUFArrayLen+10 {
+		t.Fatalf("large array not skipped: %#v", h.Metadata["tokenizer.ggml.tokens"])
+	}
+	info := h.Info()
+	want := GGUFInfo{
+		Architecture:    "llama",
+		Name:            "Tiny Llama",
+		ContextLength:   4096,
+		EmbeddingLength: 64,
+		BlockCount:      2,
+		HeadCount:       8,
+		HeadCountKV:     4,
+		Quantization:    "Q4_K_M",
+		ChatTemplate:    "{{ messages }}",
+		ParameterCount:  64*100 + 64,
+	}
+	if *info != want {
+		t.Fatalf("unexpected info:\n got %+v\nwant %+v", *info, want)
+	}
+}
+
+// TestGGUFQuantizationFallback derives the quantization from tensor types
+// when general.file_type is absent.
+func TestGGUFQuantizationFallback(t *testing.T) {
+	var b ggufBuilder
+	b.str("general.architecture", "qwen2")
+	b.tensor("blk.0.attn_q.weight", 8, 32, 32)
+	b.tensor("blk.0.attn_norm.weight", 0, 32)
+
+	h, err := ParseGGUFHeader(bytes.NewReader(b.bytes()))
+	if err != nil {
+		t.Fatalf("ParseGGUFHeader error: %v", err)
+	}
+	if q := h.Info().Quantization; q != "Q8_0" {
+		t.Fatalf("expected Q8_0, got %q", q)
+	}
+}
+
+// TestParseGGUFHeaderRejectsGarbage checks that non-GGUF input is reported.
+func TestParseGGUFHeaderRejectsGarbage(t *testing.T) {
+	if _, err := ParseGGUFHeader(bytes.NewReader([]byte("PK\x03\x04"))); err != ErrNotGGUF {
+		t.Fatalf("expected ErrNotGGUF, got %v", err)
+	}
+}
+
+// TestGGUFHostileArrayLength checks that array counts the file cannot hold
+// are rejected instead of overflowing the skip length or being read.
+func TestGGUFHostileArrayLength(t *testing.T) {
+	for _, n := range []uint64{1 << 62, 1 << 40, 5000} {
+		var b ggufBuilder
+		b.key(&b.kv, "tokenizer.ggml.scores", ggufTypeArray)
+		binary.Write(&b.kv, binary.LittleEndian, ggufTypeFloat32)
+		binary.Write(&b.kv, binary.LittleEndian, n)
+		data := b.bytes()
+		if _, err := parseGGUFHeader(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("array of %d elements accepted", n)
+		}
+		if _, err := ParseGGUFHeader(bytes.NewReader(data)); err == nil {
+			t.Errorf("array of %d elements accepted without a size", n)
+		}
+	}
+}
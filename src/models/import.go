@@ -0,0 +1,208 @@
+package models
+
+// Importing lets users register GGUF files that already exist on disk, for
+// example models converted locally or copied from another machine, without
+// going through a Hugging Face download. Imported models are tracked in
+// state.json exactly like downloaded ones so `codex models use` and the admin
+// UI can activate them.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportMode selects how an imported model's files are made available under
+// the models directory.
+type ImportMode string
+
+const (
+	// ImportInPlace registers the files where they are without touching
+	// the models directory.
+	ImportInPlace ImportMode = ""
+	// ImportLink creates symlinks under models/<id> pointing at the files.
+	ImportLink ImportMode = "link"
+	// ImportCopy copies the files into models/<id>.
+	ImportCopy ImportMode = "copy"
+)
+
+// ImportOptions configures ImportModel. ID defaults to the file or directory
+// name without the .gguf extension.
+type ImportOptions struct {
+	ID   string
+	Mode ImportMode
+}
+
+// ImportModel registers the GGUF file or directory at src as a LocalModel and
+// persists it to the state file. The SHA-256 of the GGUF data is used as the
+// model version and the model description is read from the file header.
+func ImportModel(src string, opts ImportOptions) (*LocalModel, error) {
+	switch opts.Mode {
+	case ImportInPlace, ImportLink, ImportCopy:
+	default:
+		return nil, fmt.Errorf("unknown import mode %q", opts.Mode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	files, err := findGGUFFiles(src)
+	if err != nil {
+		return nil, err
+	}
+
+	id := opts.ID
+	if id == "" {
+		id = filepath.Base(src)
+		if strings.EqualFold(filepath.Ext(id), ".gguf") {
+			id = strings.TrimSuffix(id, filepath.Ext(id))
+		}
+	}
+	if id == "" || filepath.IsAbs(id) || strings.Contains(id, "..") {
+		return nil, fmt.Errorf("invalid model id %q", id)
+	}
+
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := state.Models[id]; ok {
+		return nil, fmt.Errorf("model already exists: %s", id)
+	}
+
+	version, err := hashFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	primary := files[0]
+	info, err := ReadGGUFInfo(primary)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", primary, err)
+	}
+
+	lm := &LocalModel{
+		ID:         id,
+		Version:    version,
+		Downloaded: time.Now(),
+		Source:     "import",
+		GGUF:       info,
+	}
+	// created is set when the import made the model directory, which is
+	// then removed again if the import fails
+	created := false
+	if opts.Mode == ImportInPlace {
+		lm.Path = filepath.Dir(primary)
+		lm.File = primary
+	} else {
+		dir := modelDir(id)
+		_, statErr := os.Lstat(dir)
+		created = os.IsNotExist(statErr)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			dst := filepath.Join(dir, filepath.Base(f))
+			if opts.Mode == ImportLink {
+				err = os.Symlink(f, dst)
+			} else {
+				err = copyFile(dst, f)
+			}
+			if err != nil {
+				if created {
+					os.RemoveAll(dir)
+				}
+				return nil, err
+			}
+		}
+		lm.Path = dir
+		lm.File = filepath.Join(dir, filepath.Base(primary))
+	}
+
+	state.Models[id] = lm
+	if err := SaveState(state); err != nil {
+		if created {
+			os.RemoveAll(lm.Path)
+		}
+		return nil, err
+	}
+	return lm, nil
+}
+
+// modelDir returns the directory under which files for the given model ID are
+// stored. It lives next to the state file.
+func modelDir(id string) string {
+	return filepath.Join(filepath.Dir(statePath), id)
+}
+
+// findGGUFFiles returns the GGUF files at path. A file path must itself be a
+// GGUF file; a directory is searched (non-recursively) for *.gguf files. The
+// result is sorted so split models list their first shard first.
+func findGGUFFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if !strings.EqualFold(filepath.Ext(path), ".gguf") {
+			return nil, fmt.Errorf("%s: %w", path, ErrNotGGUF)
+		}
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".gguf") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no .gguf files found in " + path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// hashFiles computes a SHA-256 digest over the contents of all files in order.
+func hashFiles(files []string) (string, error) {
+	h := sha256.New()
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile copies src to dst, creating or truncating dst.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,86 @@
+package models
+
+// Tests for importing local GGUF files. A tiny synthetic GGUF file is written
+// to a temporary directory so no real model weights are required.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestGGUF writes a minimal GGUF v3 file containing the given string
+// metadata and no tensors.
+func writeTestGGUF(t *testing.T, path string, kv map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, uint32(ggufMagic))
+	binary.Write(&buf, le, uint32(3))
+	binary.Write(&buf, le, uint64(0))
+	binary.Write(&buf, le, uint64(len(kv)))
+	for k, v := range kv {
+		binary.Write(&buf, le, uint64(len(k)))
+		buf.WriteString(k)
+		binary.Write(&buf, le, ggufTypeString)
+		binary.Write(&buf, le, uint64(len(v)))
+		buf.WriteString(v)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestImportModelCopy imports a GGUF file in copy mode and checks that the
+// state file records the hash, architecture and copied file location.
+func TestImportModelCopy(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	src := filepath.Join(dir, "tiny-q4.gguf")
+	data := writeTestGGUF(t, src, map[string]string{"general.architecture": "llama"})
+
+	lm, err := ImportModel(src, ImportOptions{Mode: ImportCopy})
+	if err != nil {
+		t.Fatalf("ImportModel error: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if lm.ID != "tiny-q4" || lm.Version != hex.EncodeToString(sum[:]) || lm.GGUF == nil || lm.GGUF.Architecture != "llama" {
+		t.Fatalf("unexpected model: %+v", lm)
+	}
+	if _, err := os.Stat(filepath.Join("models", "tiny-q4", "tiny-q4.gguf")); err != nil {
+		t.Fatalf("file not copied: %v", err)
+	}
+
+	state, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState error: %v", err)
+	}
+	if got := state.Models["tiny-q4"]; got == nil || got.Source != "import" {
+		t.Fatalf("model not persisted: %+v", state.Models)
+	}
+	if _, err := ImportModel(src, ImportOptions{}); err == nil {
+		t.Fatalf("expected duplicate import to fail")
+	}
+}
+
+// TestImportModelRejectsNonGGUF ensures arbitrary files are not registered.
+func TestImportModelRejectsNonGGUF(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	src := filepath.Join(dir, "fake.gguf")
+	os.WriteFile(src, []byte("not a model"), 0644)
+	if _, err := ImportModel(src, ImportOptions{}); err == nil {
+		t.Fatalf("expected error for invalid file")
+	}
+}
@@ -0,0 +1,211 @@
+package models
+
+// Filtered and paginated model listings.  The Hugging Face API accepts search
+// and sort parameters on /api/models and returns the URL of the next page in
+// an RFC 8288 `Link` header.  ListModels maps ListOptions onto those
+// parameters while FilterModels applies the same options to cached data when
+// running offline.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPageSize is the largest page the hub serves in a single request.
+const maxPageSize = 1000
+
+// sortFields maps the sort names accepted by the CLI to Hugging Face fields.
+var sortFields = map[string]string{
+	"downloads": "downloads",
+	"likes":     "likes",
+	"modified":  "lastModified",
+}
+
+// ListOptions selects which models ListModels returns. Empty fields are not
+// sent to the hub.
+type ListOptions struct {
+	Pipeline string
+	Search   string
+	Author   string
+	// Sort is one of "downloads", "likes" or "modified". Results are
+	// always sorted in descending order.
+	Sort string
+	// Limit caps the number of results. Zero uses the hub default for a
+	// single request and no limit for ListAllModels.
+	Limit int
+	// GGUFOnly restricts the results to repositories tagged gguf.
+	GGUFOnly bool
+}
+
+// ModelPage is a single page of results. Next is the URL of the following
+// page or empty when there are no more results.
+type ModelPage struct {
+	Models []ModelInfo `json:"models"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// Query returns the hub query parameters for the options.
+func (o ListOptions) Query() (url.Values, error) {
+	q := url.Values{}
+	if o.Pipeline != "" {
+		q.Set("pipeline_tag", o.Pipeline)
+	}
+	if o.Search != "" {
+		q.Set("search", o.Search)
+	}
+	if o.Author != "" {
+		q.Set("author", o.Author)
+	}
+	if o.Sort != "" {
+		field, ok := sortFields[o.Sort]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort %q (use downloads, likes or modified)", o.Sort)
+		}
+		q.Set("sort", field)
+		q.Set("direction", "-1")
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.GGUFOnly {
+		q.Set("filter", "gguf")
+	}
+	return q, nil
+}
+
+// ListModels fetches the first page of models matching opts.
+func ListModels(opts ListOptions) (*ModelPage, error) {
+	q, err := opts.Query()
+	if err != nil {
+		return nil, err
+	}
+	path := "/api/models"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return fetchModelPage(path)
+}
+
+// ListAllModels follows the hub's pagination links until opts.Limit models
+// have been collected or no pages remain.
+func ListAllModels(opts ListOptions) ([]ModelInfo, error) {
+	limit := opts.Limit
+	if limit <= 0 || limit > maxPageSize {
+		opts.Limit = maxPageSize
+	}
+	page, err := ListModels(opts)
+	if err != nil {
+		return nil, err
+	}
+	res := page.Models
+	for page.Next != "" && (limit <= 0 || len(res) < limit) {
+		if page, err = fetchModelPage(page.Next); err != nil {
+			return nil, err
+		}
+		res = append(res, page.Models...)
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// fetchModelPage requests a listing URL and decodes the models and the next
+// page link.
+func fetchModelPage(path string) (*ModelPage, error) {
+	resp, err := hfGet(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list []struct {
+		ID           string   `json:"id"`
+		LastModified string   `json:"lastModified"`
+		Downloads    int      `json:"downloads"`
+		Likes        int      `json:"likes"`
+		Tags         []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	page := &ModelPage{Models: make([]ModelInfo, len(list))}
+	for i, m := range list {
+		page.Models[i] = ModelInfo{ID: m.ID, LastModified: m.LastModified, Downloads: m.Downloads, Likes: m.Likes, Tags: m.Tags}
+	}
+	page.Next = nextLink(resp.Header.Values("Link"))
+	return page, nil
+}
+
+// nextLink extracts the URL with rel="next" from Link header values such as
+// `<https://huggingface.co/api/models?cursor=abc>; rel="next"`.
+func nextLink(headers []string) string {
+	for _, h := range headers {
+		for _, part := range strings.Split(h, ",") {
+			segs := strings.Split(part, ";")
+			target := strings.TrimSpace(segs[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range segs[1:] {
+				p = strings.ReplaceAll(strings.TrimSpace(p), " ", "")
+				if p == `rel="next"` || p == "rel=next" {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// FilterModels applies opts to an already fetched list. It mirrors the hub's
+// behaviour closely enough for offline use: search is a case-insensitive
+// substring match on the ID and author matches the ID's namespace. Pipeline
+// is not checked since cached lists are stored per pipeline.
+func FilterModels(list []ModelInfo, opts ListOptions) ([]ModelInfo, error) {
+	if opts.Sort != "" {
+		if _, ok := sortFields[opts.Sort]; !ok {
+			return nil, fmt.Errorf("unknown sort %q (use downloads, likes or modified)", opts.Sort)
+		}
+	}
+	search := strings.ToLower(opts.Search)
+	var res []ModelInfo
+	for _, m := range list {
+		id := strings.ToLower(m.ID)
+		if search != "" && !strings.Contains(id, search) {
+			continue
+		}
+		if opts.Author != "" && !strings.HasPrefix(id, strings.ToLower(opts.Author)+"/") {
+			continue
+		}
+		if opts.GGUFOnly && !hasTag(m.Tags, "gguf") {
+			continue
+		}
+		res = append(res, m)
+	}
+	switch opts.Sort {
+	case "downloads":
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Downloads > res[j].Downloads })
+	case "likes":
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Likes > res[j].Likes })
+	case "modified":
+		sort.SliceStable(res, func(i, j int) bool { return res[i].LastModified > res[j].LastModified })
+	}
+	if opts.Limit > 0 && len(res) > opts.Limit {
+		res = res[:opts.Limit]
+	}
+	return res, nil
+}
+
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package models
+
+// Download metrics for `GET /metrics`.
+
+import (
+	"codex/src/metrics"
+	"io"
+)
+
+var (
+	downloadBytes = metrics.NewCounter("codex_model_download_bytes_total",
+		"Bytes of model files received from the Hugging Face hub.")
+	activeDownloads = metrics.NewGauge("codex_model_downloads_active",
+		"Model downloads in progress.")
+)
+
+// countingReader adds the bytes read through it to downloadBytes as they
+// arrive, so the rate of a long download is visible while it runs.
+type countingReader struct{ r io.Reader }
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	downloadBytes.Add(float64(n))
+	return n, err
+}
@@ -9,12 +9,12 @@ package models
 // AI: Extension - Handles selection of model type and storage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,6 +27,7 @@ type ModelInfo struct {
 	ID           string   `json:"modelId"`
 	LastModified string   `json:"lastModified"`
 	Downloads    int      `json:"downloads"`
+	Likes        int      `json:"likes"`
 	Tags         []string `json:"tags"`
 }
 
@@ -62,6 +63,14 @@ type ModelMetadata struct {
 	NLayer            int    `json:"n_layer,omitempty"`
 	NumAttentionHeads int    `json:"num_attention_heads,omitempty"`
 
+	// Values only known from a local GGUF file header. They are filled
+	// when the model has been downloaded or imported.
+	ContextLength  int    `json:"context_length,omitempty"`
+	NumKVHeads     int    `json:"num_kv_heads,omitempty"`
+	Quantization   string `json:"quantization,omitempty"`
+	ChatTemplate   string `json:"chat_template,omitempty"`
+	ParameterCount uint64 `json:"parameter_count,omitempty"`
+
 	// Flags derived from the list of available files.
 	Quantized          bool     `json:"quantized"`
 	GGUF               bool     `json:"gguf_available"`
@@ -86,6 +95,15 @@ type LocalModel struct {
 	Version    string    `json:"version"`
 	Downloaded time.Time `json:"downloaded_at"`
 	Active     bool      `json:"active"`
+
+	// Source records how the model entered the state file: "huggingface"
+	// for downloads or "import" for files registered from local disk.
+	Source string `json:"source,omitempty"`
+	// File is the GGUF file the backend should load. It is empty for
+	// models that do not ship a GGUF file.
+	File string `json:"file,omitempty"`
+	// GGUF holds the properties read from the GGUF file header, if any.
+	GGUF *GGUFInfo `json:"gguf,omitempty"`
 }
 
 // State is the persisted representation of all downloaded models and which one
@@ -99,6 +117,14 @@ type State struct {
 // and the currently active selection.
 var statePath = filepath.Join("models", "state.json")
 
+// SetDir moves the state file and downloaded models to dir, for example the
+// models directory inside the configured data directory. Call it before any
+// other function of this package.
+func SetDir(dir string) { statePath = filepath.Join(dir, "state.json") }
+
+// Dir returns the directory holding the state file and downloaded models.
+func Dir() string { return filepath.Dir(statePath) }
+
 // LoadState reads the state file from disk and returns the parsed structure.
 // If the file does not exist a new empty state is returned.
 func LoadState() (*State, error) {
@@ -137,48 +163,25 @@ func SaveState(s *State) error {
 }
 
 // ListModelsByType queries the Hugging Face API for models of the given
-// pipeline category and returns a simplified slice of model metadata.
+// pipeline category and returns a simplified slice of model metadata. Only the
+// first page of results is returned; use ListModels for filtering and paging.
 func ListModelsByType(pipeline string) ([]ModelInfo, error) {
-	url := "https://huggingface.co/api/models?pipeline_tag=" + pipeline
-	resp, err := http.Get(url)
+	page, err := ListModels(ListOptions{Pipeline: pipeline})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(b))
-	}
-	var list []struct {
-		ID           string   `json:"id"`
-		LastModified string   `json:"lastModified"`
-		Downloads    int      `json:"downloads"`
-		Tags         []string `json:"tags"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
-		return nil, err
-	}
-	res := make([]ModelInfo, len(list))
-	for i, m := range list {
-		res[i] = ModelInfo{ID: m.ID, LastModified: m.LastModified, Downloads: m.Downloads, Tags: m.Tags}
-	}
-	return res, nil
+	return page.Models, nil
 }
 
 // GetModelDetail retrieves metadata for a specific model using the Hugging
 // Face API. The returned structure includes the SHA identifier and file list
 // in addition to the summary information provided by ListModelsByType.
 func GetModelDetail(id string) (*ModelDetail, error) {
-	url := "https://huggingface.co/api/models/" + id
-	resp, err := http.Get(url)
+	resp, err := hfGet("/api/models/"+id, false)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(b))
-	}
 	var data struct {
 		ID           string   `json:"id"`
 		LastModified string   `json:"lastModified"`
@@ -212,19 +215,11 @@ func GetModelDetail(id string) (*ModelDetail, error) {
 // models. It relies on the X-Total-Count header which is returned when
 // requesting models with limit=1.
 func GetGlobalStats() (*GlobalStats, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://huggingface.co/api/models?limit=1", nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := hfGet("/api/models?limit=1", false)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(b))
-	}
 	totalStr := resp.Header.Get("X-Total-Count")
 	if totalStr == "" {
 		return nil, errors.New("total count header missing")
@@ -246,16 +241,21 @@ func DownloadModel(id string) (string, error) {
 // files downloaded so far and the total count. It mirrors DownloadModel when no
 // callback is provided.
 func DownloadModelWithProgress(id string, progress func(done, total int)) (string, error) {
-	infoURL := "https://huggingface.co/api/models/" + id
-	resp, err := http.Get(infoURL)
+	return DownloadModelContext(context.Background(), id, progress)
+}
+
+// DownloadModelContext is DownloadModelWithProgress stopping when ctx ends,
+// for example when the server shuts down. Files are written under a .part
+// name and only renamed once complete, so an interrupted download never
+// leaves a truncated model behind.
+func DownloadModelContext(ctx context.Context, id string, progress func(done, total int)) (string, error) {
+	activeDownloads.Inc()
+	defer activeDownloads.Dec()
+	resp, err := hfGetContext(ctx, "/api/models/"+id, false)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return "", errors.New(string(b))
-	}
 	var data struct {
 		Siblings []struct {
 			Rfilename string `json:"rfilename"`
@@ -265,14 +265,14 @@ func DownloadModelWithProgress(id string, progress func(done, total int)) (strin
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		return "", err
 	}
-	dir := filepath.Join("models", id)
+	dir := modelDir(id)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", err
 	}
 	total := len(data.Siblings)
 	for i, sbl := range data.Siblings {
-		fileURL := "https://huggingface.co/" + id + "/resolve/main/" + sbl.Rfilename
-		if err := downloadFile(filepath.Join(dir, filepath.Base(sbl.Rfilename)), fileURL); err != nil {
+		filePath := "/" + id + "/resolve/main/" + sbl.Rfilename
+		if err := downloadFile(ctx, filepath.Join(dir, filepath.Base(sbl.Rfilename)), filePath); err != nil {
 			return "", err
 		}
 		if progress != nil {
@@ -282,39 +282,49 @@ func DownloadModelWithProgress(id string, progress func(done, total int)) (strin
 	return data.Sha, nil
 }
 
-// downloadFile retrieves a single file via HTTP and saves it to the provided
-// path.  It is a helper used by DownloadModel.
-func downloadFile(path, url string) error {
-	resp, err := http.Get(url)
+// downloadFile retrieves a single hub-relative file via HTTP and saves it to
+// the provided path.  It is a helper used by DownloadModel. The data is
+// written to path + ".part", synced and renamed into place; on failure the
+// partial file is removed.
+func downloadFile(ctx context.Context, path, hubPath string) error {
+	resp, err := hfGetContext(ctx, hubPath, true)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return errors.New(string(b))
-	}
-	f, err := os.Create(path)
+	part := path + ".part"
+	f, err := os.Create(part)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
+	_, err = io.Copy(f, countingReader{resp.Body})
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		os.Remove(part)
+	}
 	return err
 }
 
 // ActivateModel marks a previously downloaded model as the active one without
-// performing any download step. It returns the local path to the model
-// directory so the server can reload it.
-func ActivateModel(id string) (string, error) {
+// performing any download step. It returns the model entry, with its GGUF file
+// resolved when one exists, so the server can reload it.
+func ActivateModel(id string) (*LocalModel, error) {
 	state, err := LoadState()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	lm, ok := state.Models[id]
 	if !ok {
-		return "", errors.New("model not downloaded")
+		return nil, errors.New("model not downloaded")
 	}
 
 	for _, m := range state.Models {
@@ -322,32 +332,72 @@ func ActivateModel(id string) (string, error) {
 	}
 	lm.Active = true
 	state.Active = id
+	if lm.File == "" {
+		if err := DescribeLocalModel(lm); err != nil {
+			log.Printf("model %s GGUF header unreadable: %v", id, err)
+		}
+	}
 
 	if err := SaveState(state); err != nil {
-		return "", err
+		return nil, err
+	}
+	return lm, nil
+}
+
+// ActiveModel returns the currently active model or nil when none is set.
+func ActiveModel() (*LocalModel, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	if state.Active == "" {
+		return nil, nil
+	}
+	lm, ok := state.Models[state.Active]
+	if !ok {
+		return nil, nil
+	}
+	if lm.File == "" {
+		DescribeLocalModel(lm)
 	}
-	return lm.Path, nil
+	return lm, nil
 }
 
 // GetModelMetadata queries Hugging Face for detailed model information and
-// enriches it with config values and file type flags. It returns a populated
-// ModelMetadata structure that callers can persist or display. No download is
-// performed.
+// enriches it with config values and file type flags. When the model exists
+// locally its GGUF header fills in anything config.json did not provide, and
+// the local description is returned on its own if Hugging Face cannot be
+// reached. No download is performed.
 func GetModelMetadata(id string) (*ModelMetadata, error) {
-	url := "https://huggingface.co/api/models/" + id
-	resp, err := http.Get(url)
+	local := localGGUFInfo(id)
+	md, err := getRemoteMetadata(id)
+	if err != nil {
+		if local == nil {
+			return nil, err
+		}
+		log.Printf("model %s metadata from local file only: %v", id, err)
+		md = &ModelMetadata{ModelDetail: ModelDetail{ModelInfo: ModelInfo{ID: id}}, GGUF: true}
+		md.CompatibleBackends = []string{"gguf"}
+	}
+	if local != nil {
+		applyGGUFInfo(md, local)
+	}
+	return md, nil
+}
+
+// getRemoteMetadata builds ModelMetadata from the Hugging Face API and the
+// repository's config.json.
+func getRemoteMetadata(id string) (*ModelMetadata, error) {
+	resp, err := hfGet("/api/models/"+id, false)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(b))
-	}
 	var data struct {
 		ID           string   `json:"id"`
 		LastModified string   `json:"lastModified"`
 		Downloads    int      `json:"downloads"`
+		Likes        int      `json:"likes"`
 		Tags         []string `json:"tags"`
 		SHA          string   `json:"sha"`
 		CardData     struct {
@@ -369,6 +419,7 @@ func GetModelMetadata(id string) (*ModelMetadata, error) {
 				ID:           data.ID,
 				LastModified: data.LastModified,
 				Downloads:    data.Downloads,
+				Likes:        data.Likes,
 				Tags:         data.Tags,
 			},
 			SHA: data.SHA,
@@ -399,7 +450,7 @@ func GetModelMetadata(id string) (*ModelMetadata, error) {
 	md.Files = files
 
 	// fetch configuration for architecture information
-	cfgURL := "https://huggingface.co/" + id + "/raw/main/config.json"
+	cfgURL := "/" + id + "/raw/main/config.json"
 	var cfg struct {
 		Architectures     []string `json:"architectures"`
 		ModelType         string   `json:"model_type"`
@@ -433,16 +484,107 @@ func GetModelMetadata(id string) (*ModelMetadata, error) {
 	return md, nil
 }
 
+// applyGGUFInfo copies values read from a local GGUF header into md. Values
+// from config.json take precedence; the header only fills the gaps. Any model
+// with a GGUF file can be served by llama.cpp so it is marked compatible.
+func applyGGUFInfo(md *ModelMetadata, info *GGUFInfo) {
+	if md.ModelType == "" {
+		md.ModelType = info.Architecture
+	}
+	if md.HiddenSize == 0 {
+		md.HiddenSize = info.EmbeddingLength
+	}
+	if md.NLayer == 0 {
+		md.NLayer = info.BlockCount
+	}
+	if md.NumAttentionHeads == 0 {
+		md.NumAttentionHeads = info.HeadCount
+	}
+	md.ContextLength = info.ContextLength
+	md.NumKVHeads = info.HeadCountKV
+	md.Quantization = info.Quantization
+	md.ChatTemplate = info.ChatTemplate
+	md.ParameterCount = info.ParameterCount
+	md.LlamaCompatible = true
+	if info.Quantization != "" && info.Quantization != "F32" && info.Quantization != "F16" && info.Quantization != "BF16" {
+		md.Quantized = true
+	}
+}
+
+// localGGUFInfo returns the GGUF description of a downloaded or imported model
+// or nil if the model is not present locally or has no GGUF file.
+func localGGUFInfo(id string) *GGUFInfo {
+	state, err := LoadState()
+	if err != nil {
+		return nil
+	}
+	lm, ok := state.Models[id]
+	if !ok {
+		return nil
+	}
+	if lm.GGUF == nil {
+		if err := DescribeLocalModel(lm); err != nil {
+			return nil
+		}
+	}
+	return lm.GGUF
+}
+
+// DescribeLocalModel locates the GGUF file for lm (if File is not already set)
+// and fills lm.GGUF from its header. Models without a GGUF file are left
+// unchanged and no error is returned.
+func DescribeLocalModel(lm *LocalModel) error {
+	if lm.File == "" {
+		files, err := findGGUFFiles(lm.Path)
+		if err != nil {
+			return nil
+		}
+		lm.File = files[0]
+	}
+	info, err := ReadGGUFInfo(lm.File)
+	if err != nil {
+		return err
+	}
+	lm.GGUF = info
+	return nil
+}
+
+// RecordDownload adds a freshly downloaded model to the state file, reading
+// its GGUF header when one was downloaded. Existing entries are replaced so a
+// forced re-download updates the version.
+func RecordDownload(id, version string) (*LocalModel, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	lm := &LocalModel{
+		ID:         id,
+		Path:       modelDir(id),
+		Version:    version,
+		Downloaded: time.Now(),
+		Source:     "huggingface",
+	}
+	if old, ok := state.Models[id]; ok {
+		lm.Active = old.Active
+		lm.Type = old.Type
+	}
+	if err := DescribeLocalModel(lm); err != nil {
+		log.Printf("model %s GGUF header unreadable: %v", id, err)
+	}
+	state.Models[id] = lm
+	if err := SaveState(state); err != nil {
+		return nil, err
+	}
+	return lm, nil
+}
+
 // fetchJSON is a small helper used by GetModelMetadata to retrieve and decode a
-// JSON document via HTTP.
-func fetchJSON(url string, out interface{}) error {
-	resp, err := http.Get(url)
+// hub-relative JSON document via HTTP.
+func fetchJSON(hubPath string, out interface{}) error {
+	resp, err := hfGet(hubPath, false)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
 	return json.NewDecoder(resp.Body).Decode(out)
 }
@@ -0,0 +1,396 @@
+package notify
+
+// Package notify emails administrators about events that need attention: a
+// model download finishing or failing, the model backend becoming unhealthy
+// and a login from a device the user has not used before. Messages are
+// rendered from templates and handed to a Notifier, which delivers them
+// through the configured SMTP server in the background and retries
+// failed deliveries with growing delays so a briefly unreachable mail server
+// does not lose alerts.
+//
+// AI Awareness: Send never blocks and does nothing while no notifier is
+// registered, so callers can report events unconditionally.
+
+import (
+	"codex/src/memory"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a Notifier created with New.
+const (
+	DefaultMaxAttempts = 5
+	DefaultRetryDelay  = 30 * time.Second
+	DefaultQueueSize   = 100
+	DefaultTimeout     = 30 * time.Second
+)
+
+// ErrNoRecipients is returned when no admin has an email address.
+var ErrNoRecipients = errors.New("no admin has an email address")
+
+// Message is one email.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// job is a queued message with its delivery state.
+type job struct {
+	msg      Message
+	attempts int
+	next     time.Time
+}
+
+// Notifier delivers messages through an SMTP server. Use New to create one
+// and Run to start delivering.
+type Notifier struct {
+	// Addr is the host:port of the SMTP server and From the sender address.
+	Addr string
+	From string
+	// Auth authenticates with the server; nil sends without authentication.
+	Auth smtp.Auth
+	// Recipients returns the addresses events are sent to. New sets it to
+	// the email addresses of the admin users.
+	Recipients func() ([]string, error)
+	// MaxAttempts bounds the deliveries tried per message; the delay before
+	// each retry doubles starting from RetryDelay.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// QueueSize bounds the messages waiting for delivery. Further messages
+	// are dropped with a log line.
+	QueueSize int
+	// Timeout bounds one delivery, from dialling the server to QUIT, so a
+	// server that accepts connections but never answers cannot stall the
+	// queue.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	queue []*job
+	wake  chan struct{}
+}
+
+// New returns a notifier sending through addr from the given sender to the
+// admins.
+func New(addr, from string) *Notifier {
+	return &Notifier{
+		Addr:        addr,
+		From:        from,
+		Recipients:  adminEmails,
+		MaxAttempts: DefaultMaxAttempts,
+		RetryDelay:  DefaultRetryDelay,
+		QueueSize:   DefaultQueueSize,
+		Timeout:     DefaultTimeout,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// FromSettings returns a notifier sending through addr, or nil when addr is
+// empty. An empty from defaults to codex@localhost and a username enables
+// PLAIN authentication, which net/smtp only allows over TLS or to localhost.
+// The smtp section of codex.yaml, or SMTP_ADDR, SMTP_FROM, SMTP_USERNAME and
+// SMTP_PASSWORD, map onto it.
+func FromSettings(addr, from, username, password string) *Notifier {
+	if addr == "" {
+		return nil
+	}
+	if from == "" {
+		from = "codex@localhost"
+	}
+	n := New(addr, from)
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		n.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+// adminEmails returns the email addresses of the admin users.
+func adminEmails() ([]string, error) {
+	db, err := memory.InitDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return memory.AdminEmails(db)
+}
+
+// Notify renders the template of an event and queues it for the admins.
+func (n *Notifier) Notify(ev Event, data any) error {
+	to, err := n.Recipients()
+	if err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return ErrNoRecipients
+	}
+	subject, body, err := Render(ev, data)
+	if err != nil {
+		return err
+	}
+	n.Enqueue(Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Enqueue queues a message for delivery by Run.
+func (n *Notifier) Enqueue(m Message) {
+	n.mu.Lock()
+	if n.QueueSize > 0 && len(n.queue) >= n.QueueSize {
+		n.mu.Unlock()
+		log.Printf("notify: queue full, dropping %q", m.Subject)
+		return
+	}
+	n.queue = append(n.queue, &job{msg: m, next: time.Now()})
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the number of messages waiting for delivery.
+func (n *Notifier) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queue)
+}
+
+// Run delivers queued messages until ctx is cancelled. Messages that fail
+// are retried later; after MaxAttempts failures they are dropped.
+func (n *Notifier) Run(ctx context.Context) {
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		wait := n.deliverDue(ctx, time.Now())
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// Flush makes one last attempt at every queued message, due or not, until
+// ctx is done. The server calls it on shutdown after Run has returned so
+// alerts raised while stopping, or waiting for a retry, are not silently
+// lost, and bounds it so an unreachable mail server cannot hold up exit.
+func (n *Notifier) Flush(ctx context.Context) {
+	if n.Pending() == 0 {
+		return
+	}
+	// later than any retry Run could have scheduled
+	n.deliverDue(ctx, time.Now().Add(n.RetryDelay<<n.MaxAttempts))
+	if p := n.Pending(); p > 0 {
+		log.Printf("notify: stopping with %d undelivered messages", p)
+	}
+}
+
+// deliverDue sends every message whose next attempt is due and returns how
+// long to wait for the next one. Messages still due when ctx is done stay
+// queued without counting an attempt.
+func (n *Notifier) deliverDue(ctx context.Context, now time.Time) time.Duration {
+	n.mu.Lock()
+	var due []*job
+	rest := n.queue[:0]
+	for _, j := range n.queue {
+		if !j.next.After(now) {
+			due = append(due, j)
+		} else {
+			rest = append(rest, j)
+		}
+	}
+	n.queue = rest
+	n.mu.Unlock()
+
+	for i, j := range due {
+		if ctx.Err() != nil {
+			n.mu.Lock()
+			n.queue = append(n.queue, due[i:]...)
+			n.mu.Unlock()
+			break
+		}
+		err := n.send(ctx, j.msg)
+		if err == nil {
+			log.Printf("notify: sent %q to %s", j.msg.Subject, strings.Join(j.msg.To, ", "))
+			continue
+		}
+		if ctx.Err() != nil {
+			n.mu.Lock()
+			n.queue = append(n.queue, due[i:]...)
+			n.mu.Unlock()
+			break
+		}
+		j.attempts++
+		if j.attempts >= n.MaxAttempts {
+			log.Printf("notify: giving up on %q after %d attempts: %v", j.msg.Subject, j.attempts, err)
+			continue
+		}
+		j.next = time.Now().Add(n.RetryDelay << (j.attempts - 1))
+		log.Printf("notify: sending %q failed (attempt %d), retrying at %s: %v", j.msg.Subject, j.attempts, j.next.Format(time.TimeOnly), err)
+		n.mu.Lock()
+		n.queue = append(n.queue, j)
+		n.mu.Unlock()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wait := time.Hour
+	for _, j := range n.queue {
+		if d := time.Until(j.next); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// Send delivers a message immediately, bypassing the queue.
+func (n *Notifier) Send(m Message) error {
+	return n.send(context.Background(), m)
+}
+
+// send delivers a message within Timeout, or sooner when ctx is done. It
+// follows smtp.SendMail, which has no way to bound the conversation:
+// STARTTLS is used when offered and Auth requires the AUTH extension.
+func (n *Notifier) send(ctx context.Context, m Message) error {
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn, err := net.DialTimeout("tcp", n.Addr, time.Until(deadline))
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	// cancelling ctx interrupts whatever the client is waiting for
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		host = n.Addr
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(n.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.format(m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format builds the RFC 5322 message text.
+func (n *Notifier) format(m Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@codex>\r\n", messageID())
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue removes line breaks so template data cannot inject headers.
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// messageID returns a random message identifier.
+func messageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultNotifier *Notifier
+)
+
+// SetDefault registers the notifier used by Send.
+func SetDefault(n *Notifier) {
+	defaultMu.Lock()
+	defaultNotifier = n
+	defaultMu.Unlock()
+}
+
+// Default returns the registered notifier or nil.
+func Default() *Notifier {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultNotifier
+}
+
+// Send notifies the admins of an event through the default notifier. It is a
+// no-op while none is registered and only logs failures.
+func Send(ev Event, data any) {
+	n := Default()
+	if n == nil {
+		return
+	}
+	if err := n.Notify(ev, data); err != nil {
+		log.Printf("notify: %s: %v", ev, err)
+	}
+}
@@ -0,0 +1,237 @@
+package notify
+
+// Tests for message rendering and delivery. A small SMTP stand-in listening
+// on localhost plays the mail server so the real net/smtp client is used.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn is a minimal SMTP server that records received messages and can
+// reject the first few with a temporary error.
+type standIn struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	failures int
+	messages []string
+	received chan struct{}
+}
+
+// newStandIn starts a stand-in rejecting the first failures messages.
+func newStandIn(t *testing.T, failures int) *standIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &standIn{ln: ln, failures: failures, received: make(chan struct{}, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// serve speaks just enough SMTP for the notifier.
+func (s *standIn) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(line string) { fmt.Fprintf(c, "%s\r\n", line) }
+	reply("220 stand-in ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 stand-in")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"), cmd == "RSET", cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			if s.failures > 0 {
+				s.failures--
+				s.mu.Unlock()
+				reply("451 try again later")
+				continue
+			}
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			reply("250 queued")
+			s.received <- struct{}{}
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// wait blocks until a message was accepted.
+func (s *standIn) wait(t *testing.T) string {
+	select {
+	case <-s.received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages[len(s.messages)-1]
+}
+
+// TestNotifyDelivers queues an event and checks the stand-in receives it
+// with the rendered subject and body.
+func TestNotifyDelivers(t *testing.T) {
+	srv := newStandIn(t, 0)
+	n := New(srv.ln.Addr().String(), "codex@example.com")
+	n.Recipients = func() ([]string, error) { return []string{"ada@example.com"}, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	err := n.Notify(EventDownloadDone, Download{Model: "org/tiny-GGUF", Version: "abc123", Duration: time.Minute, Time: time.Now()})
+	if err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	msg := srv.wait(t)
+	for _, want := range []string{
+		"From: codex@example.com\r\n",
+		"To: ada@example.com\r\n",
+		"Subject: Model org/tiny-GGUF downloaded\r\n",
+		"Revision: abc123",
+		"codex models use org/tiny-GGUF",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("message lacks %q:\n%s", want, msg)
+		}
+	}
+}
+
+// TestNotifyRetries rejects the first delivery and checks the message is
+// retried, and that a message is dropped after MaxAttempts failures.
+func TestNotifyRetries(t *testing.T) {
+	srv := newStandIn(t, 1)
+	n := New(srv.ln.Addr().String(), "codex@example.com")
+	n.RetryDelay = 10 * time.Millisecond
+	n.Recipients = func() ([]string, error) { return []string{"ada@example.com"}, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	if err := n.Notify(EventBackendUnhealthy, Backend{Status: "unavailable", Error: "connection refused", Time: time.Now()}); err != nil {
+		t.Fatalf("Notify error: %v", err)
+	}
+	if msg := srv.wait(t); !strings.Contains(msg, "Subject: Model backend is unavailable") {
+		t.Fatalf("unexpected message:\n%s", msg)
+	}
+
+	down := New("127.0.0.1:1", "codex@example.com")
+	down.MaxAttempts = 2
+	down.RetryDelay = time.Millisecond
+	down.Enqueue(Message{To: []string{"ada@example.com"}, Subject: "lost"})
+	deadline := time.Now().Add(5 * time.Second)
+	for down.Pending() > 0 && time.Now().Before(deadline) {
+		down.deliverDue(context.Background(), time.Now())
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p := down.Pending(); p != 0 {
+		t.Fatalf("message not dropped after MaxAttempts: %d pending", p)
+	}
+}
+
+// TestSendTimeout checks that a server which accepts connections but never
+// answers fails a delivery after Timeout, and that Flush gives up when its
+// context is done and keeps the message queued.
+func TestSendTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	n := New(ln.Addr().String(), "codex@example.com")
+	n.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if err := n.Send(Message{To: []string{"ada@example.com"}, Subject: "stuck"}); err == nil {
+		t.Fatalf("expected a timeout")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Send took %s", d)
+	}
+
+	n.Timeout = time.Hour
+	n.Enqueue(Message{To: []string{"ada@example.com"}, Subject: "stuck"})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	n.Flush(ctx)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Flush took %s", d)
+	}
+	if p := n.Pending(); p != 1 {
+		t.Fatalf("pending = %d, want the message kept", p)
+	}
+}
+
+// TestRender checks every template renders and that subjects cannot carry
+// extra headers.
+func TestRender(t *testing.T) {
+	data := map[Event]any{
+		EventDownloadDone:     Download{Model: "m"},
+		EventDownloadFailed:   Download{Model: "m", Error: "404"},
+		EventBackendUnhealthy: Backend{Status: "error"},
+		EventNewDevice:        Login{Username: "ada", IP: "10.0.0.1", UserAgent: "curl"},
+		EventTest:             Test{Addr: "mail:25"},
+	}
+	for ev := range templateText {
+		subject, body, err := Render(ev, data[ev])
+		if err != nil || subject == "" || body == "" {
+			t.Fatalf("%s: subject %q body %q err %v", ev, subject, body, err)
+		}
+	}
+	if _, _, err := Render("nope", nil); err == nil {
+		t.Fatalf("expected error for unknown event")
+	}
+	n := New("", "codex@example.com")
+	msg := string(n.format(Message{To: []string{"a@example.com"}, Subject: "hi\r\nBcc: evil@example.com"}))
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Fatalf("header injected:\n%s", msg)
+	}
+}
@@ -0,0 +1,131 @@
+package notify
+
+// Message templates. Each event has a text/template defining a "subject"
+// and a "body" template executed with the event's data struct.
+//
+// Extension Point: new events add a constant, a data struct and a template
+// here.
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event names a kind of notification.
+type Event string
+
+// Events sent to admins.
+const (
+	EventDownloadDone     Event = "model.download.done"
+	EventDownloadFailed   Event = "model.download.failed"
+	EventBackendUnhealthy Event = "backend.unhealthy"
+	EventNewDevice        Event = "login.new_device"
+	EventTest             Event = "test"
+)
+
+// Download is the data of EventDownloadDone and EventDownloadFailed.
+type Download struct {
+	Model    string
+	Version  string
+	Error    string
+	Duration time.Duration
+	Time     time.Time
+}
+
+// Backend is the data of EventBackendUnhealthy.
+type Backend struct {
+	Status string
+	Error  string
+	Model  string
+	Time   time.Time
+}
+
+// Login is the data of EventNewDevice.
+type Login struct {
+	Username  string
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+// Test is the data of EventTest.
+type Test struct {
+	Addr string
+	Time time.Time
+}
+
+// templateText holds the subject and body of every event.
+var templateText = map[Event]string{
+	EventDownloadDone: `{{define "subject"}}Model {{.Model}} downloaded{{end}}
+{{define "body"}}The download of {{.Model}} finished at {{stamp .Time}} after {{.Duration}}.
+{{- if .Version}}
+Revision: {{.Version}}{{end}}
+
+Activate it from the Models page or with: codex models use {{.Model}}
+{{end}}`,
+	EventDownloadFailed: `{{define "subject"}}Model {{.Model}} download failed{{end}}
+{{define "body"}}The download of {{.Model}} failed at {{stamp .Time}} after {{.Duration}}:
+
+    {{.Error}}
+
+Start the download again from the Models page once the cause is fixed.
+{{end}}`,
+	EventBackendUnhealthy: `{{define "subject"}}Model backend is {{.Status}}{{end}}
+{{define "body"}}The model backend became {{.Status}} at {{stamp .Time}}.
+{{- if .Error}}
+
+    {{.Error}}{{end}}
+{{- if .Model}}
+
+Active model: {{.Model}}{{end}}
+
+Chat requests fail with 503 until the backend recovers. GET /healthz shows
+the current status.
+{{end}}`,
+	EventNewDevice: `{{define "subject"}}New device login for {{.Username}}{{end}}
+{{define "body"}}{{.Username}} logged in from a device not seen before at {{stamp .Time}}.
+
+IP address: {{.IP}}
+Browser:    {{.UserAgent}}
+
+If this was not expected, change the password with
+codex users passwd {{.Username}}, which also ends every session.
+{{end}}`,
+	EventTest: `{{define "subject"}}Codex test notification{{end}}
+{{define "body"}}This is a test message sent through {{.Addr}} at {{stamp .Time}}.
+Email notifications are working.
+{{end}}`,
+}
+
+// funcs are available to every template.
+var funcs = template.FuncMap{
+	"stamp": func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+}
+
+// templates are parsed once at start-up; a broken template is a programming
+// error.
+var templates = func() map[Event]*template.Template {
+	m := map[Event]*template.Template{}
+	for ev, text := range templateText {
+		m[ev] = template.Must(template.New(string(ev)).Funcs(funcs).Parse(text))
+	}
+	return m
+}()
+
+// Render returns the subject and body of an event.
+func Render(ev Event, data any) (string, string, error) {
+	t, ok := templates[ev]
+	if !ok {
+		return "", "", fmt.Errorf("unknown event %q", ev)
+	}
+	var subject, body strings.Builder
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := t.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
@@ -0,0 +1,308 @@
+package queue
+
+// Package queue limits how many prompts are forwarded to the model backend at
+// once.  llama.cpp serves a fixed number of slots; sending more concurrent
+// requests only makes every one of them slower until they time out.  A Queue
+// admits up to MaxInFlight requests and holds the rest in per-user FIFOs that
+// are served round-robin, so one user submitting many prompts cannot starve
+// everyone else.
+//
+// AI Awareness: requests waiting here have not reached the model yet. The chat
+// handler reports the queue position to streaming clients while they wait.
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Join when MaxQueue requests are already waiting.
+var ErrQueueFull = errors.New("request queue is full")
+
+// Stats is a snapshot of queue activity.
+type Stats struct {
+	MaxInFlight int `json:"max_in_flight"`
+	MaxQueue    int `json:"max_queue"`
+	InFlight    int `json:"in_flight"`
+	Queued      int `json:"queued"`
+	// Users is the number of distinct users with queued requests.
+	Users int `json:"users"`
+	// Admitted, Rejected and Abandoned count requests since start: admitted
+	// requests reached the backend, rejected ones found the queue full and
+	// abandoned ones left (e.g. client disconnect) before being admitted.
+	Admitted  uint64 `json:"admitted"`
+	Rejected  uint64 `json:"rejected"`
+	Abandoned uint64 `json:"abandoned"`
+	// AvgWaitMS and MaxWaitMS describe the time admitted requests spent
+	// waiting in the queue.
+	AvgWaitMS float64 `json:"avg_wait_ms"`
+	MaxWaitMS float64 `json:"max_wait_ms"`
+}
+
+// Queue is a fair admission queue. Use New to create one.
+type Queue struct {
+	maxInFlight int
+	maxQueue    int
+
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+	order    []string // users with waiting tickets, in service order
+	waiting  map[string][]*Ticket
+
+	admitted  uint64
+	rejected  uint64
+	abandoned uint64
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+// New returns a queue admitting maxInFlight concurrent requests and holding
+// at most maxQueue waiting ones. maxInFlight below 1 is treated as 1; a
+// negative maxQueue means unbounded.
+func New(maxInFlight, maxQueue int) *Queue {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &Queue{maxInFlight: maxInFlight, maxQueue: maxQueue, waiting: map[string][]*Ticket{}}
+}
+
+// Ticket represents one request's place in the queue.
+type Ticket struct {
+	q        *Queue
+	user     string
+	joined   time.Time
+	ready    chan struct{}
+	position chan int
+	admitted bool
+	once     sync.Once
+}
+
+// Join adds a request for user to the queue. The returned ticket may already
+// be admitted when a slot is free. Callers must call Release exactly once,
+// typically deferred, whether or not the ticket was admitted.
+func (q *Queue) Join(user string) (*Ticket, error) {
+	t := &Ticket{q: q, user: user, joined: time.Now(), ready: make(chan struct{}), position: make(chan int, 1)}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inFlight < q.maxInFlight && q.queued == 0 {
+		q.admit(t)
+		return t, nil
+	}
+	if q.maxQueue >= 0 && q.queued >= q.maxQueue {
+		q.rejected++
+		return nil, ErrQueueFull
+	}
+	if len(q.waiting[user]) == 0 {
+		q.order = append(q.order, user)
+	}
+	q.waiting[user] = append(q.waiting[user], t)
+	q.queued++
+	q.updatePositions()
+	return t, nil
+}
+
+// Acquire joins the queue and blocks until the request is admitted or ctx
+// ends. The returned function releases the slot.
+func (q *Queue) Acquire(ctx context.Context, user string) (func(), error) {
+	t, err := q.Join(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Wait(ctx); err != nil {
+		t.Release()
+		return nil, err
+	}
+	return t.Release, nil
+}
+
+// CompleteFunc sends a prompt to the model backend, like
+// llama.SendPromptContext.
+type CompleteFunc func(ctx context.Context, prompt string) (string, error)
+
+// Queued wraps complete so every call first waits for a slot of the default
+// queue as user. Background work such as summaries uses it to take turns
+// with chats instead of competing with them for the backend; give each job
+// its own user so fairness applies to it too. Without a default queue
+// complete is called directly.
+func Queued(user string, complete CompleteFunc) CompleteFunc {
+	return func(ctx context.Context, prompt string) (string, error) {
+		q := Default()
+		if q == nil {
+			return complete(ctx, prompt)
+		}
+		release, err := q.Acquire(ctx, user)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return complete(ctx, prompt)
+	}
+}
+
+// Ready is closed once the ticket is admitted.
+func (t *Ticket) Ready() <-chan struct{} { return t.ready }
+
+// Position delivers the ticket's 1-based place in the queue whenever it
+// changes. Only the latest value is kept.
+func (t *Ticket) Position() <-chan int { return t.position }
+
+// Wait blocks until the ticket is admitted or ctx ends.
+func (t *Ticket) Wait(ctx context.Context) error {
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the ticket's slot or, if it is still waiting, removes it from
+// the queue. Further calls are no-ops.
+func (t *Ticket) Release() {
+	t.once.Do(func() {
+		q := t.q
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if t.admitted {
+			q.inFlight--
+		} else {
+			q.remove(t)
+			q.abandoned++
+		}
+		q.dispatch()
+	})
+}
+
+// admit marks t as running. Callers hold q.mu.
+func (q *Queue) admit(t *Ticket) {
+	t.admitted = true
+	q.inFlight++
+	q.admitted++
+	wait := time.Since(t.joined)
+	q.waitTotal += wait
+	if wait > q.waitMax {
+		q.waitMax = wait
+	}
+	close(t.ready)
+}
+
+// dispatch admits waiting tickets round-robin across users while slots are
+// free. Callers hold q.mu.
+func (q *Queue) dispatch() {
+	changed := false
+	for q.inFlight < q.maxInFlight && len(q.order) > 0 {
+		user := q.order[0]
+		list := q.waiting[user]
+		t := list[0]
+		q.order = q.order[1:]
+		if len(list) > 1 {
+			q.waiting[user] = list[1:]
+			q.order = append(q.order, user)
+		} else {
+			delete(q.waiting, user)
+		}
+		q.queued--
+		q.admit(t)
+		changed = true
+	}
+	if changed {
+		q.updatePositions()
+	}
+}
+
+// remove drops a waiting ticket. Callers hold q.mu.
+func (q *Queue) remove(t *Ticket) {
+	list := q.waiting[t.user]
+	for i, w := range list {
+		if w != t {
+			continue
+		}
+		list = append(list[:i:i], list[i+1:]...)
+		q.queued--
+		if len(list) > 0 {
+			q.waiting[t.user] = list
+		} else {
+			delete(q.waiting, t.user)
+			for j, u := range q.order {
+				if u == t.user {
+					q.order = append(q.order[:j:j], q.order[j+1:]...)
+					break
+				}
+			}
+		}
+		q.updatePositions()
+		return
+	}
+}
+
+// updatePositions tells every waiting ticket where it stands in the order
+// dispatch will serve them. Callers hold q.mu.
+func (q *Queue) updatePositions() {
+	pos := 1
+	for depth := 0; ; depth++ {
+		found := false
+		for _, u := range q.order {
+			list := q.waiting[u]
+			if depth < len(list) {
+				list[depth].setPosition(pos)
+				pos++
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// setPosition replaces any unread position with p.
+func (t *Ticket) setPosition(p int) {
+	select {
+	case <-t.position:
+	default:
+	}
+	t.position <- p
+}
+
+// Stats returns a snapshot of the queue counters.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := Stats{
+		MaxInFlight: q.maxInFlight,
+		MaxQueue:    q.maxQueue,
+		InFlight:    q.inFlight,
+		Queued:      q.queued,
+		Users:       len(q.order),
+		Admitted:    q.admitted,
+		Rejected:    q.rejected,
+		Abandoned:   q.abandoned,
+		MaxWaitMS:   float64(q.waitMax) / float64(time.Millisecond),
+	}
+	if q.admitted > 0 {
+		st.AvgWaitMS = float64(q.waitTotal) / float64(q.admitted) / float64(time.Millisecond)
+	}
+	return st
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultQueue *Queue
+)
+
+// SetDefault registers the queue used by the chat handler. Nil disables
+// queueing.
+func SetDefault(q *Queue) {
+	defaultMu.Lock()
+	defaultQueue = q
+	defaultMu.Unlock()
+}
+
+// Default returns the registered queue or nil.
+func Default() *Queue {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultQueue
+}
@@ -0,0 +1,138 @@
+package queue
+
+// Tests for the fair admission queue.
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// admitted reports whether t has been admitted without blocking.
+func admitted(t *Ticket) bool {
+	select {
+	case <-t.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+// TestQueueFairness checks that waiting users are served round-robin and that
+// positions reflect that order.
+func TestQueueFairness(t *testing.T) {
+	q := New(1, 10)
+	running, err := q.Join("a")
+	if err != nil || !admitted(running) {
+		t.Fatalf("first ticket should be admitted: %v", err)
+	}
+	a1, _ := q.Join("a")
+	a2, _ := q.Join("a")
+	b1, _ := q.Join("b")
+	if p := <-b1.Position(); p != 2 {
+		t.Fatalf("expected b1 at position 2, got %d", p)
+	}
+	if p := <-a2.Position(); p != 3 {
+		t.Fatalf("expected a2 at position 3, got %d", p)
+	}
+
+	var order []*Ticket
+	cur := running
+	for i := 0; i < 3; i++ {
+		cur.Release()
+		for _, c := range []*Ticket{a1, a2, b1} {
+			if admitted(c) && !contains(order, c) {
+				order = append(order, c)
+				cur = c
+			}
+		}
+	}
+	cur.Release()
+	if len(order) != 3 || order[0] != a1 || order[1] != b1 || order[2] != a2 {
+		t.Fatalf("unexpected service order")
+	}
+	if st := q.Stats(); st.InFlight != 0 || st.Queued != 0 || st.Admitted != 4 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func contains(list []*Ticket, t *Ticket) bool {
+	for _, x := range list {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// TestQueueFullAndCancel checks the queue length limit and that a cancelled
+// waiter gives up its place.
+func TestQueueFullAndCancel(t *testing.T) {
+	q := New(1, 1)
+	release, err := q.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Acquire(ctx, "b")
+		done <- err
+	}()
+	for q.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := q.Join("c"); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	release()
+	st := q.Stats()
+	if st.Queued != 0 || st.InFlight != 0 || st.Rejected != 1 || st.Abandoned != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+// TestQueued checks that wrapped completions wait for a slot of the default
+// queue and release it afterwards.
+func TestQueued(t *testing.T) {
+	q := New(1, 10)
+	SetDefault(q)
+	defer SetDefault(nil)
+	var inFlight int
+	complete := Queued("system:test", func(ctx context.Context, prompt string) (string, error) {
+		inFlight = q.Stats().InFlight
+		return "ok", nil
+	})
+
+	chat, _ := q.Join("alice")
+	done := make(chan struct{})
+	go func() {
+		complete(context.Background(), "hi")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("completion ran while the slot was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+	chat.Release()
+	<-done
+	if inFlight != 1 {
+		t.Fatalf("completion ran with %d requests in flight, want its own slot", inFlight)
+	}
+	if st := q.Stats(); st.InFlight != 0 || st.Admitted != 2 {
+		t.Fatalf("slot not released: %+v", st)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	busy, _ := q.Join("alice")
+	defer busy.Release()
+	cancel()
+	if _, err := complete(ctx, "hi"); err == nil {
+		t.Fatalf("expected the cancelled wait to fail")
+	}
+}
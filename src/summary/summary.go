@@ -0,0 +1,181 @@
+package summary
+
+// Package summary condenses old conversation into summary memories.  Long
+// projects accumulate thousands of memory rows; a Summariser periodically
+// takes the oldest unsummarised entries of each project, asks the local model
+// for a concise summary, stores it with role "summary" and elevated
+// importance and marks the source rows as summarised.
+//
+// AI Awareness: summaries are written by the model itself and later replace
+// the raw turns in chat context, so a poor summary loses information.
+
+import (
+	"codex/src/memory"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CompleteFunc generates text for a prompt, normally llama.SendPromptContext.
+type CompleteFunc func(ctx context.Context, prompt string) (string, error)
+
+// Config controls when and how much is summarised.
+type Config struct {
+	// Interval between passes over all projects.
+	Interval time.Duration
+	// KeepRecent is the number of newest entries per project that are
+	// never summarised.
+	KeepRecent int
+	// BatchSize is the maximum number of entries condensed into one
+	// summary.
+	BatchSize int
+	// MinBatch is the number of eligible entries required before a
+	// summary is written, so projects are not summarised a few rows at a
+	// time.
+	MinBatch int
+	// Importance is stored on summary rows.
+	Importance int
+	// MaxChars bounds the transcript sent to the model per batch.
+	MaxChars int
+}
+
+// DefaultConfig returns the settings used by `codex serve`.
+func DefaultConfig() Config {
+	return Config{
+		Interval:   10 * time.Minute,
+		KeepRecent: 20,
+		BatchSize:  40,
+		MinBatch:   10,
+		Importance: 8,
+		MaxChars:   12000,
+	}
+}
+
+// Summariser runs summarisation passes.
+type Summariser struct {
+	cfg      Config
+	complete CompleteFunc
+}
+
+// New returns a summariser using complete to call the model. Zero fields in
+// cfg take their DefaultConfig values.
+func New(cfg Config, complete CompleteFunc) *Summariser {
+	def := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.KeepRecent <= 0 {
+		cfg.KeepRecent = def.KeepRecent
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.MinBatch <= 0 || cfg.MinBatch > cfg.BatchSize {
+		cfg.MinBatch = min(def.MinBatch, cfg.BatchSize)
+	}
+	if cfg.Importance <= 0 {
+		cfg.Importance = def.Importance
+	}
+	if cfg.MaxChars <= 0 {
+		cfg.MaxChars = def.MaxChars
+	}
+	return &Summariser{cfg: cfg, complete: complete}
+}
+
+// Run performs a pass every Interval until ctx is cancelled.
+func (s *Summariser) Run(ctx context.Context) {
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := s.RunOnce(ctx)
+		if err != nil {
+			log.Printf("summariser error: %v", err)
+		} else if n > 0 {
+			log.Printf("summariser wrote %d summaries", n)
+		}
+	}
+}
+
+// RunOnce summarises every project with enough eligible entries, writing at
+// most one summary per project. It returns the number of summaries written.
+// A failure for one project is logged and the pass continues.
+func (s *Summariser) RunOnce(ctx context.Context) (int, error) {
+	db, err := memory.InitDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	projects, err := memory.MemoryProjects(db)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, p := range projects {
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		entries, err := memory.SummaryCandidates(db, p, s.cfg.KeepRecent, s.cfg.BatchSize)
+		if err != nil {
+			return written, err
+		}
+		if len(entries) < s.cfg.MinBatch {
+			continue
+		}
+		entries = s.fit(entries)
+		text, err := s.complete(ctx, Prompt(entries))
+		if err != nil {
+			log.Printf("summariser project %q: %v", p, err)
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			log.Printf("summariser project %q: empty summary", p)
+			continue
+		}
+		ids := make([]int, len(entries))
+		for i, e := range entries {
+			ids[i] = e.ID
+		}
+		first, last := entries[0].Timestamp, entries[len(entries)-1].Timestamp
+		content := fmt.Sprintf("Summary of %d messages from %s to %s:\n%s",
+			len(entries), first.Format("2006-01-02 15:04"), last.Format("2006-01-02 15:04"), text)
+		if err := memory.SaveSummary(db, p, content, s.cfg.Importance, ids); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// fit trims a batch so its transcript stays within MaxChars, always keeping
+// at least one entry.
+func (s *Summariser) fit(entries []memory.MemoryEntry) []memory.MemoryEntry {
+	total := 0
+	for i, e := range entries {
+		total += len(e.Role) + len(e.Content) + 3
+		if total > s.cfg.MaxChars && i > 0 {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+// Prompt builds the summarisation request for a batch of entries.
+func Prompt(entries []memory.MemoryEntry) string {
+	var b strings.Builder
+	b.WriteString("Summarise the following conversation excerpt for future reference. ")
+	b.WriteString("Keep decisions, facts, names, open questions and commitments; drop small talk. ")
+	b.WriteString("Write at most ten short bullet points.\n\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s: %s\n", e.Role, e.Content)
+	}
+	b.WriteString("\nSummary:\n")
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package summary
+
+// Tests for the background summariser using a fake model.
+
+import (
+	"codex/src/memory"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRunOnce checks that old entries are condensed into one summary, the
+// newest ones are kept and chat context then prefers the summary.
+func TestRunOnce(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 15; i++ {
+		memory.AddEntry(db, "proj", "user", fmt.Sprintf("message %d", i))
+	}
+	memory.AddEntry(db, "small", "user", "only one")
+
+	var prompts []string
+	s := New(Config{KeepRecent: 5, BatchSize: 20, MinBatch: 5}, func(ctx context.Context, prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		return "- discussed messages 0-9", nil
+	})
+	n, err := s.RunOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("RunOnce: %d %v", n, err)
+	}
+	if !strings.Contains(prompts[0], "user: message 9\n") || strings.Contains(prompts[0], "message 10") {
+		t.Fatalf("unexpected prompt:\n%s", prompts[0])
+	}
+
+	sums, turns, err := memory.ConversationContext(db, "proj", 3, 50)
+	if err != nil {
+		t.Fatalf("ConversationContext error: %v", err)
+	}
+	if len(sums) != 1 || sums[0].Importance != 8 || !strings.Contains(sums[0].Content, "Summary of 10 messages") {
+		t.Fatalf("unexpected summaries: %+v", sums)
+	}
+	if len(turns) != 5 || turns[0].Content != "message 10" {
+		t.Fatalf("unexpected turns: %+v", turns)
+	}
+
+	// Nothing left to summarise.
+	if n, err := s.RunOnce(context.Background()); err != nil || n != 0 {
+		t.Fatalf("second RunOnce: %d %v", n, err)
+	}
+}
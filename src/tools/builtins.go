@@ -0,0 +1,266 @@
+package tools
+
+// Built-in tools available to the assistant: memory search, project listing,
+// the current time and a read-only file reader confined to a sandbox
+// directory.
+
+import (
+	"codex/src/auth"
+	"codex/src/memory"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFileBytes caps how much of a file read_file returns.
+const maxFileBytes = 64 << 10
+
+// errNoFileRoot is returned by read_file until a root is configured.
+var errNoFileRoot = errors.New("read_file is disabled on this server")
+
+var (
+	rootMu     sync.RWMutex
+	fileRoot   string
+	fileDenied []string
+)
+
+// SetFileRoot sets the directory read_file is confined to; empty disables
+// the tool. Files and directories in deny stay unreadable even inside dir.
+// AI Awareness: any chat caller can read the root through the model, so
+// the server denies its data directory, holding the database, models and
+// TLS key, and its config file, which holds the SMTP password.
+func SetFileRoot(dir string, deny ...string) {
+	var resolved []string
+	for _, d := range deny {
+		if d == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(d); err == nil {
+			d = abs
+		}
+		if r, err := filepath.EvalSymlinks(d); err == nil {
+			d = r
+		}
+		resolved = append(resolved, d)
+	}
+	rootMu.Lock()
+	fileRoot, fileDenied = dir, resolved
+	rootMu.Unlock()
+}
+
+func currentFileRoot() (string, []string) {
+	rootMu.RLock()
+	defer rootMu.RUnlock()
+	return fileRoot, fileDenied
+}
+
+// RegisterBuiltins adds the built-in tools to r.
+func RegisterBuiltins(r *Registry) {
+	for _, t := range []Tool{
+		{
+			Name:        "memory_search",
+			Description: "Search earlier conversation in the current project for text.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{
+				"query":{"type":"string","minLength":1},
+				"limit":{"type":"integer","minimum":1,"maximum":20}},
+				"required":["query"],"additionalProperties":false}`),
+			Handler: memorySearch,
+		},
+		{
+			Name:        "list_projects",
+			Description: "List the names of all projects and which one is active.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{},"additionalProperties":false}`),
+			Handler:     listProjects,
+		},
+		{
+			Name:        "clock",
+			Description: "Return the current date and time, optionally in an IANA time zone such as Europe/Berlin.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{
+				"timezone":{"type":"string"}},"additionalProperties":false}`),
+			Handler: clock,
+		},
+		{
+			Name:        "read_file",
+			Description: "Read a UTF-8 text file by path relative to the workspace directory.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{
+				"path":{"type":"string","minLength":1}},
+				"required":["path"],"additionalProperties":false}`),
+			Handler: readFile,
+		},
+	} {
+		if err := r.Register(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// memorySearch looks up memories of the caller's active project.
+func memorySearch(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Limit == 0 {
+		args.Limit = 5
+	}
+	db, err := memory.InitDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	project, err := auth.ActiveProject(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if project == "" {
+		return "no active project", nil
+	}
+	entries, err := memory.SearchEntries(db, project, args.Query, args.Limit)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "no matching memories", nil
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", e.Timestamp.Format(time.RFC3339), e.Role, e.Content)
+	}
+	return b.String(), nil
+}
+
+// listProjects reports the projects the caller may see and its active
+// project.
+func listProjects(ctx context.Context, raw json.RawMessage) (string, error) {
+	db, err := memory.InitDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	list, err := auth.VisibleProjects(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	active, _ := auth.ActiveProject(ctx, db)
+	b, err := json.Marshal(map[string]interface{}{"projects": list, "active": active})
+	return string(b), err
+}
+
+// clock returns the current time.
+func clock(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+		}
+		now = now.In(loc)
+	}
+	return now.Format("Monday, 2006-01-02 15:04:05 MST"), nil
+}
+
+// readFile returns the start of a text file inside the sandbox root. Paths
+// escaping the root, hidden files and directories and non UTF-8 content are
+// rejected.
+func readFile(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	root, deny := currentFileRoot()
+	if root == "" {
+		return "", errNoFileRoot
+	}
+	path, err := sandboxPath(root, args.Path)
+	if err != nil {
+		return "", err
+	}
+	for _, d := range deny {
+		if within(d, path) {
+			return "", fmt.Errorf("%s is not readable", args.Path)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot open %s", args.Path)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", args.Path)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxFileBytes+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := len(data) > maxFileBytes
+	if truncated {
+		data = data[:maxFileBytes]
+		// do not cut a multi-byte character in half
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%s is not a text file", args.Path)
+	}
+	out := string(data)
+	if truncated {
+		out += "\n[truncated]"
+	}
+	return out, nil
+}
+
+// sandboxPath resolves rel inside root, following symlinks, and fails when
+// the result lies outside root or touches a hidden path component.
+func sandboxPath(root, rel string) (string, error) {
+	if filepath.IsAbs(rel) {
+		return "", errors.New("path must be relative to the workspace")
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.EvalSymlinks(absRoot); err == nil {
+		absRoot = r
+	}
+	clean := filepath.Clean(rel)
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		if part == ".." || (strings.HasPrefix(part, ".") && part != ".") {
+			return "", errors.New("path outside the workspace or hidden")
+		}
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(absRoot, clean))
+	if err != nil {
+		return "", fmt.Errorf("cannot open %s", rel)
+	}
+	if !within(absRoot, path) {
+		return "", errors.New("path outside the workspace")
+	}
+	return path, nil
+}
+
+// within reports whether path is dir or lies below it. Both are absolute
+// with symlinks resolved.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
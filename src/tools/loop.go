@@ -0,0 +1,177 @@
+package tools
+
+// The tool call loop. The model is shown the enabled tools and a simple text
+// protocol: to call a tool it replies with
+//
+//	<tool_call>{"name": "clock", "arguments": {}}</tool_call>
+//
+// and receives the output in a <tool_result> block on the next turn. A reply
+// without tool calls is the final answer.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CompleteFunc generates text for a prompt, normally llama.SendPromptWith.
+type CompleteFunc func(ctx context.Context, prompt string) (string, error)
+
+// DefaultMaxSteps bounds how many rounds of tool calls Run performs before
+// asking the model for a final answer.
+const DefaultMaxSteps = 4
+
+// Options configures Run.
+type Options struct {
+	// Tools are the tools the model may call.
+	Tools []*Tool
+	// MaxSteps limits tool call rounds; zero uses DefaultMaxSteps.
+	MaxSteps int
+	// OnCall is invoked after every tool call, e.g. to record it in
+	// memory.
+	OnCall func(Call)
+}
+
+var toolCallRe = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+
+// request is the JSON body of a <tool_call> block.
+type request struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// parseCalls extracts tool calls from model output. A reply consisting only
+// of a {"name":..,"arguments":..} object is also treated as a call because
+// smaller models often drop the tags. Malformed blocks are returned as calls
+// with an empty name so the model is told about the mistake.
+func parseCalls(out string) []request {
+	var calls []request
+	for _, m := range toolCallRe.FindAllStringSubmatch(out, -1) {
+		var req request
+		if err := json.Unmarshal([]byte(m[1]), &req); err != nil {
+			req = request{Arguments: json.RawMessage(quoteJSON(m[1]))}
+		}
+		calls = append(calls, req)
+	}
+	if len(calls) == 0 {
+		trimmed := strings.TrimSpace(out)
+		var req request
+		if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &req) == nil && req.Name != "" && len(req.Arguments) > 0 {
+			calls = append(calls, req)
+		}
+	}
+	return calls
+}
+
+// quoteJSON encodes s as a JSON string.
+func quoteJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// preamble describes the tools and the call protocol.
+func preamble(tools []*Tool) string {
+	var b strings.Builder
+	b.WriteString("You can call tools to help answer the user. Available tools:\n")
+	for _, t := range tools {
+		fmt.Fprintf(&b, "- %s: %s\n  arguments schema: %s\n", t.Name, t.Description, compactJSON(t.Parameters))
+	}
+	b.WriteString("To call a tool, reply with only:\n")
+	b.WriteString(`<tool_call>{"name": "tool_name", "arguments": {...}}</tool_call>` + "\n")
+	b.WriteString("Tool output is returned in <tool_result> blocks. When you have enough information, reply with the final answer and no tool calls.\n\n")
+	return b.String()
+}
+
+// compactJSON strips insignificant whitespace from a schema.
+func compactJSON(raw json.RawMessage) string {
+	var v interface{}
+	if json.Unmarshal(raw, &v) != nil {
+		return string(raw)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Run answers prompt, letting the model call the given tools. It returns the
+// final answer and every call made. With no tools the prompt is completed
+// directly.
+func Run(ctx context.Context, prompt string, complete CompleteFunc, opts Options) (string, []Call, error) {
+	if len(opts.Tools) == 0 {
+		out, err := complete(ctx, prompt)
+		return out, nil, err
+	}
+	maxSteps := opts.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	enabled := map[string]*Tool{}
+	for _, t := range opts.Tools {
+		enabled[t.Name] = t
+	}
+
+	var transcript strings.Builder
+	transcript.WriteString(preamble(opts.Tools))
+	fmt.Fprintf(&transcript, "User: %s\nAssistant: ", prompt)
+
+	var calls []Call
+	for step := 0; ; step++ {
+		out, err := complete(ctx, transcript.String())
+		if err != nil {
+			return "", calls, err
+		}
+		reqs := parseCalls(out)
+		if len(reqs) == 0 {
+			return strings.TrimSpace(out), calls, nil
+		}
+		if step == maxSteps {
+			// The model keeps calling tools; ask once more without
+			// allowing further calls.
+			transcript.WriteString(out + "\nTool call limit reached. Answer the user now without calling tools.\nAssistant: ")
+			out, err := complete(ctx, transcript.String())
+			if err != nil {
+				return "", calls, err
+			}
+			return strings.TrimSpace(toolCallRe.ReplaceAllString(out, "")), calls, nil
+		}
+
+		transcript.WriteString(strings.TrimSpace(out) + "\n")
+		for _, req := range reqs {
+			call := invoke(ctx, enabled, req)
+			calls = append(calls, call)
+			if opts.OnCall != nil {
+				opts.OnCall(call)
+			}
+			result := call.Result
+			if call.Error != "" {
+				result = "error: " + call.Error
+			}
+			fmt.Fprintf(&transcript, "<tool_result name=%q>\n%s\n</tool_result>\n", call.Name, result)
+		}
+		transcript.WriteString("Assistant: ")
+	}
+}
+
+// invoke runs one requested call and records its outcome.
+func invoke(ctx context.Context, enabled map[string]*Tool, req request) Call {
+	call := Call{Name: req.Name, Arguments: req.Arguments}
+	start := time.Now()
+	t, ok := enabled[req.Name]
+	switch {
+	case req.Name == "":
+		call.Error = "malformed tool call, expected {\"name\": ..., \"arguments\": {...}}"
+	case !ok:
+		call.Error = fmt.Sprintf("%v: %s", ErrUnknownTool, req.Name)
+	default:
+		res, err := t.Invoke(ctx, req.Arguments)
+		if err != nil {
+			call.Error = err.Error()
+		} else {
+			call.Result = res
+		}
+	}
+	call.Duration = time.Since(start)
+	return call
+}
@@ -0,0 +1,154 @@
+package tools
+
+// Package tools lets the assistant take actions instead of only returning
+// text.  A Registry holds named tools, each with a JSON Schema describing its
+// arguments and a Go handler.  Run drives a model-directed loop: the model is
+// told which tools exist, replies with tool calls, the calls are executed and
+// their results fed back until the model produces a final answer.
+//
+// AI Awareness: every tool registered here becomes something the model can
+// trigger. Tools must validate their inputs and stay within the sandbox they
+// are given.
+//
+// Extension Point: register additional tools with Registry.Register.
+
+import (
+	"codex/src/grammar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handler executes a tool with arguments already validated against the
+// tool's parameter schema and returns text for the model.
+type Handler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool describes one callable action.
+type Tool struct {
+	// Name is how the model refers to the tool.
+	Name string `json:"name"`
+	// Description tells the model when to use the tool.
+	Description string `json:"description"`
+	// Parameters is a JSON Schema for the arguments object.
+	Parameters json.RawMessage `json:"parameters"`
+	// Handler performs the action.
+	Handler Handler `json:"-"`
+
+	schema *grammar.Schema
+}
+
+// Call records one tool invocation made during a Run.
+type Call struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    string          `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Duration  time.Duration   `json:"duration_ns"`
+}
+
+// ErrUnknownTool is returned when the model asks for a tool that is not
+// registered or not enabled.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// Registry holds the available tools. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]*Tool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]*Tool{}}
+}
+
+// Register adds t, replacing any tool with the same name. The parameter
+// schema must parse; an empty schema accepts any arguments object.
+func (r *Registry) Register(t Tool) error {
+	if t.Name == "" || t.Handler == nil {
+		return errors.New("tool needs a name and a handler")
+	}
+	if len(t.Parameters) == 0 {
+		t.Parameters = json.RawMessage(`{"type":"object"}`)
+	}
+	s, err := grammar.Parse(t.Parameters)
+	if err != nil {
+		return fmt.Errorf("tool %s: %w", t.Name, err)
+	}
+	t.schema = s
+	r.mu.Lock()
+	r.tools[t.Name] = &t
+	r.mu.Unlock()
+	return nil
+}
+
+// Get returns the named tool.
+func (r *Registry) Get(name string) (*Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// List returns all tools sorted by name.
+func (r *Registry) List() []*Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*Tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Select returns the tools named in names. "*" selects every tool. Unknown
+// names produce an error wrapping ErrUnknownTool.
+func (r *Registry) Select(names []string) ([]*Tool, error) {
+	var out []*Tool
+	seen := map[string]bool{}
+	for _, n := range names {
+		if n == "*" {
+			return r.List(), nil
+		}
+		t, ok := r.Get(n)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTool, n)
+		}
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// Invoke validates args and runs the tool.
+func (t *Tool) Invoke(ctx context.Context, args json.RawMessage) (string, error) {
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage(`{}`)
+	}
+	if err := grammar.Validate(t.schema, args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	return t.Handler(ctx, args)
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the registry used by the chat handler. It is created with
+// the built-in tools on first use, sandboxing read_file to the working
+// directory unless SetFileRoot was called first.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = NewRegistry()
+		RegisterBuiltins(defaultRegistry)
+	})
+	return defaultRegistry
+}
@@ -0,0 +1,101 @@
+package tools
+
+// Tests for the tool registry, the call loop and the file sandbox.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunLoop drives the loop with a scripted model that calls a tool, sees
+// an argument error, retries and then answers.
+func TestRunLoop(t *testing.T) {
+	r := NewRegistry()
+	err := r.Register(Tool{
+		Name:       "add",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"a":{"type":"integer"},"b":{"type":"integer"}},"required":["a","b"]}`),
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var args struct{ A, B int }
+			json.Unmarshal(raw, &args)
+			return strings.Repeat("|", args.A+args.B), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+	tools, err := r.Select([]string{"add"})
+	if err != nil {
+		t.Fatalf("Select error: %v", err)
+	}
+
+	replies := []string{
+		`<tool_call>{"name": "add", "arguments": {"a": 1}}</tool_call>`,
+		`{"name": "add", "arguments": {"a": 1, "b": 2}}`,
+		`The answer is three.`,
+	}
+	var prompts []string
+	complete := func(ctx context.Context, prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		out := replies[0]
+		replies = replies[1:]
+		return out, nil
+	}
+	var recorded []Call
+	answer, calls, err := Run(context.Background(), "1+2?", complete, Options{Tools: tools, OnCall: func(c Call) { recorded = append(recorded, c) }})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if answer != "The answer is three." {
+		t.Fatalf("unexpected answer %q", answer)
+	}
+	if len(calls) != 2 || len(recorded) != 2 {
+		t.Fatalf("expected two calls, got %+v", calls)
+	}
+	if !strings.Contains(calls[0].Error, "missing required property") || calls[1].Result != "|||" {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	if !strings.Contains(prompts[2], "<tool_result name=\"add\">\n|||\n</tool_result>") {
+		t.Fatalf("tool result not fed back:\n%s", prompts[2])
+	}
+	if _, err := r.Select([]string{"nope"}); err == nil {
+		t.Fatalf("expected unknown tool error")
+	}
+}
+
+// TestReadFileSandbox checks that read_file stays inside its root, away
+// from denied paths, and is off without a root.
+func TestReadFileSandbox(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(root, ".env"), []byte("SECRET=1"), 0644)
+	os.WriteFile(filepath.Join(root, "codex.yaml"), []byte("smtp:\n  password: x\n"), 0600)
+	os.MkdirAll(filepath.Join(root, "data", "tls"), 0700)
+	os.WriteFile(filepath.Join(root, "data", "tls", "key.pem"), []byte("KEY"), 0600)
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(outside, []byte("secret"), 0644)
+	os.Symlink(outside, filepath.Join(root, "link.txt"))
+	os.Symlink(filepath.Join(root, "data", "tls", "key.pem"), filepath.Join(root, "key-link.pem"))
+	defer SetFileRoot("")
+
+	tool, _ := Default().Get("read_file")
+	SetFileRoot("")
+	if _, err := tool.Invoke(context.Background(), json.RawMessage(`{"path":"notes.txt"}`)); !errors.Is(err, errNoFileRoot) {
+		t.Fatalf("read without root: %v", err)
+	}
+
+	SetFileRoot(root, filepath.Join(root, "data"), filepath.Join(root, "codex.yaml"))
+	if out, err := tool.Invoke(context.Background(), json.RawMessage(`{"path":"notes.txt"}`)); err != nil || out != "hello" {
+		t.Fatalf("read notes: %q %v", out, err)
+	}
+	for _, p := range []string{"../secret.txt", ".env", "link.txt", outside, "codex.yaml", "data/tls/key.pem", "key-link.pem"} {
+		args, _ := json.Marshal(map[string]string{"path": p})
+		if out, err := tool.Invoke(context.Background(), args); err == nil {
+			t.Fatalf("%s: expected error, got %q", p, out)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package webhook
+
+// Event data. Each struct is the "data" object of the matching event's
+// payload; the field names are part of the webhook API.
+
+import (
+	"codex/src/memory"
+	"time"
+)
+
+// ChatCompleted is the data of chat.completed.
+type ChatCompleted struct {
+	Project string `json:"project,omitempty"`
+	// Principal identifies the caller, "user:<id>" or "anon:<id>".
+	Principal string `json:"principal,omitempty"`
+	Prompt    string `json:"prompt"`
+	Response  string `json:"response"`
+	ToolCalls int    `json:"tool_calls,omitempty"`
+	Citations int    `json:"citations,omitempty"`
+}
+
+// MemoryAdded is the data of memory.added.
+type MemoryAdded struct {
+	ID         int       `json:"id"`
+	Project    string    `json:"project"`
+	Role       string    `json:"role"`
+	Content    string    `json:"content"`
+	Importance int       `json:"importance"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// ProjectDeleted is the data of project.deleted.
+type ProjectDeleted struct {
+	Project   string `json:"project"`
+	Principal string `json:"principal,omitempty"`
+}
+
+// ModelDownloaded is the data of model.downloaded.
+type ModelDownloaded struct {
+	Model   string `json:"model"`
+	Version string `json:"version,omitempty"`
+}
+
+// ModelActivated is the data of model.activated.
+type ModelActivated struct {
+	Model string `json:"model"`
+	File  string `json:"file,omitempty"`
+}
+
+// Ping is the data of the ping event sent by the test endpoint.
+type Ping struct {
+	Webhook int `json:"webhook"`
+}
+
+// MemoryHook emits memory.added for a stored memory. Register it with
+// memory.SetEntryHook.
+func MemoryHook(e memory.MemoryEntry) {
+	Emit(EventMemoryAdded, MemoryAdded{
+		ID:         e.ID,
+		Project:    e.Project,
+		Role:       e.Role,
+		Content:    e.Content,
+		Importance: e.Importance,
+		Timestamp:  e.Timestamp.UTC(),
+	})
+}
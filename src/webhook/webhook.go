@@ -0,0 +1,463 @@
+package webhook
+
+// Package webhook posts assistant events to URLs registered by admins so
+// Codex can drive a team's automation. Emit hands an event to the registered
+// Dispatcher, which records one delivery per subscribed webhook in the
+// database and sends it in the background, a few at a time per webhook so a
+// slow receiver only delays its own deliveries. Every request body is signed
+// with HMAC-SHA256 using the webhook's secret; failed deliveries are retried
+// with growing delays and every attempt is kept as a delivery log.
+//
+// AI Awareness: Emit never blocks and is a no-op while no dispatcher is
+// registered, for example in CLI commands.
+
+import (
+	"bytes"
+	"codex/src/memory"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events that can be subscribed to.
+const (
+	EventChatCompleted   = "chat.completed"
+	EventMemoryAdded     = "memory.added"
+	EventProjectDeleted  = "project.deleted"
+	EventModelDownloaded = "model.downloaded"
+	EventModelActivated  = "model.activated"
+	// EventPing is only sent by the test endpoint.
+	EventPing = "ping"
+)
+
+// Events lists the subscribable events.
+var Events = []string{EventChatCompleted, EventMemoryAdded, EventProjectDeleted, EventModelDownloaded, EventModelActivated, EventPing}
+
+// Request headers of a delivery. The signature header carries
+// "sha256=<hex HMAC-SHA256 of the body keyed by the secret>".
+const (
+	HeaderEvent     = "X-Codex-Event"
+	HeaderDelivery  = "X-Codex-Delivery"
+	HeaderSignature = "X-Codex-Signature-256"
+)
+
+// Defaults of a Dispatcher created with NewDispatcher.
+const (
+	DefaultMaxAttempts = 6
+	DefaultRetryDelay  = 30 * time.Second
+	DefaultTimeout     = 10 * time.Second
+	DefaultConcurrency = 4
+	// DefaultRetention is how long finished deliveries stay in the log.
+	DefaultRetention = 30 * 24 * time.Hour
+)
+
+// maxErrorBody caps how much of a failed response is kept in the log.
+const maxErrorBody = 512
+
+// Payload is the JSON body of every delivery.
+type Payload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// ValidEvent reports whether name can be subscribed to; "*" subscribes to
+// every event.
+func ValidEvent(name string) bool {
+	if name == "*" {
+		return true
+	}
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature matches body. Receivers written in Go
+// can use it to check deliveries.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// NewSecret returns a random webhook secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Dispatcher stores and delivers events. Use NewDispatcher to create one
+// and Run to start delivering.
+type Dispatcher struct {
+	// Client sends the requests.
+	Client *http.Client
+	// MaxAttempts bounds the deliveries tried per event; the delay before
+	// each retry doubles starting from RetryDelay.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// Retention is how long finished deliveries are kept.
+	Retention time.Duration
+	// Concurrency bounds the deliveries sent to one webhook at once.
+	Concurrency int
+
+	events chan Payload
+	wake   chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[int]bool // IDs of the deliveries being sent
+	perHook  map[int]int  // deliveries being sent by webhook ID
+	sending  sync.WaitGroup
+}
+
+// NewDispatcher returns a dispatcher with the default settings.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: DefaultTimeout},
+		MaxAttempts: DefaultMaxAttempts,
+		RetryDelay:  DefaultRetryDelay,
+		Retention:   DefaultRetention,
+		Concurrency: DefaultConcurrency,
+		events:      make(chan Payload, 256),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Emit queues an event. Events are dropped with a log line when the
+// dispatcher falls far behind.
+func (d *Dispatcher) Emit(event string, data any) {
+	select {
+	case d.events <- Payload{Event: event, CreatedAt: time.Now().UTC(), Data: data}:
+	default:
+		log.Printf("webhook: queue full, dropping %s", event)
+	}
+}
+
+// Wake asks Run to look for due deliveries now, for example after a
+// delivery was queued directly in the database.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run records emitted events and delivers due deliveries until ctx is
+// cancelled, then waits for the deliveries in flight. Deliveries still
+// pending are resumed after a restart.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var recording sync.WaitGroup
+	recording.Add(1)
+	go func() {
+		defer recording.Done()
+		d.recordEvents(ctx)
+	}()
+	defer recording.Wait()
+	defer d.sending.Wait()
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-t.C:
+		case <-prune.C:
+			d.prune()
+			continue
+		}
+		wait := d.deliverDue(ctx)
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(wait)
+	}
+}
+
+// recordEvents stores emitted events until ctx is cancelled. It runs apart
+// from the deliveries so slow receivers cannot fill the event queue and make
+// Emit drop events.
+func (d *Dispatcher) recordEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-d.events:
+			d.record(p)
+			d.Wake()
+		}
+	}
+}
+
+// record stores one delivery of p per active webhook subscribed to it.
+func (d *Dispatcher) record(p Payload) {
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("webhook: InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	hooks, err := memory.ListWebhooks(db)
+	if err != nil {
+		log.Printf("webhook: ListWebhooks error: %v", err)
+		return
+	}
+	var body []byte
+	for _, h := range hooks {
+		if !h.Active || !h.Wants(p.Event) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(p); err != nil {
+				log.Printf("webhook: encode %s: %v", p.Event, err)
+				return
+			}
+		}
+		if err := Enqueue(db, h.ID, p.Event, body); err != nil {
+			log.Printf("webhook: queue %s for %d: %v", p.Event, h.ID, err)
+		}
+	}
+}
+
+// Enqueue stores a pending delivery of body to the webhook.
+func Enqueue(db *sql.DB, webhookID int, event string, body []byte) error {
+	return memory.AddDelivery(db, &memory.Delivery{WebhookID: webhookID, Event: event, Payload: string(body), CreatedAt: time.Now()})
+}
+
+// deliverDue starts every due delivery whose webhook has fewer than
+// Concurrency deliveries in flight and returns how long to wait for the
+// next one. Deliveries are sent in the background and wake Run when they
+// finish, so the ones left waiting for a slot are started then.
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Duration {
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("webhook: InitDB error: %v", err)
+		return time.Minute
+	}
+	defer db.Close()
+	now := time.Now()
+	for ctx.Err() == nil {
+		now = time.Now()
+		skip, busy := d.busy()
+		due, err := memory.DueDeliveries(db, now, 20, skip, busy)
+		if err != nil {
+			log.Printf("webhook: DueDeliveries error: %v", err)
+			return time.Minute
+		}
+		if len(due) == 0 {
+			break
+		}
+		hooks := map[int]*memory.Webhook{}
+		for _, del := range due {
+			h, ok := hooks[del.WebhookID]
+			if !ok {
+				if h, err = memory.GetWebhook(db, del.WebhookID); err != nil {
+					h = nil
+				}
+				hooks[del.WebhookID] = h
+			}
+			if !d.claim(del) {
+				// the webhook's slots filled up earlier in this batch
+				continue
+			}
+			d.sending.Add(1)
+			go func() {
+				defer d.sending.Done()
+				defer d.release(del)
+				d.send(ctx, h, del)
+			}()
+		}
+	}
+	// deliveries due by now are in flight or waiting for a slot
+	next, err := memory.NextDeliveryDue(db, now)
+	if err != nil || next.IsZero() {
+		return time.Hour
+	}
+	if wait := time.Until(next); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// busy returns the deliveries in flight and the webhooks without a free
+// slot.
+func (d *Dispatcher) busy() (deliveries, hooks []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id := range d.inFlight {
+		deliveries = append(deliveries, id)
+	}
+	for id, n := range d.perHook {
+		if n >= d.concurrency() {
+			hooks = append(hooks, id)
+		}
+	}
+	return deliveries, hooks
+}
+
+// claim marks del as in flight if its webhook has a free slot.
+func (d *Dispatcher) claim(del memory.Delivery) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inFlight[del.ID] || d.perHook[del.WebhookID] >= d.concurrency() {
+		return false
+	}
+	if d.inFlight == nil {
+		d.inFlight, d.perHook = map[int]bool{}, map[int]int{}
+	}
+	d.inFlight[del.ID] = true
+	d.perHook[del.WebhookID]++
+	return true
+}
+
+// release frees the slot of a finished delivery and wakes Run to fill it.
+func (d *Dispatcher) release(del memory.Delivery) {
+	d.mu.Lock()
+	delete(d.inFlight, del.ID)
+	if d.perHook[del.WebhookID]--; d.perHook[del.WebhookID] <= 0 {
+		delete(d.perHook, del.WebhookID)
+	}
+	d.mu.Unlock()
+	d.Wake()
+}
+
+func (d *Dispatcher) concurrency() int {
+	if d.Concurrency > 0 {
+		return d.Concurrency
+	}
+	return 1
+}
+
+// send attempts one delivery on its own database handle.
+func (d *Dispatcher) send(ctx context.Context, h *memory.Webhook, del memory.Delivery) {
+	db, err := memory.InitDB()
+	if err != nil {
+		log.Printf("webhook: InitDB error: %v", err)
+		return
+	}
+	defer db.Close()
+	d.attempt(ctx, db, h, &del)
+}
+
+// attempt sends one delivery and stores the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, db *sql.DB, h *memory.Webhook, del *memory.Delivery) {
+	del.Attempts++
+	del.ResponseCode, del.Error = 0, ""
+	if h == nil || !h.Active {
+		// the webhook was removed or paused after the event was recorded
+		del.Status, del.Error, del.NextAttempt = memory.DeliveryFailed, "webhook deleted or inactive", time.Time{}
+	} else if code, err := d.post(ctx, h, del); err != nil {
+		if ctx.Err() != nil {
+			// stopping: leave the delivery pending for the next start
+			return
+		}
+		del.ResponseCode, del.Error = code, err.Error()
+		if del.Attempts >= d.MaxAttempts {
+			del.Status, del.NextAttempt = memory.DeliveryFailed, time.Time{}
+			log.Printf("webhook: giving up on delivery %d to %s after %d attempts: %v", del.ID, h.URL, del.Attempts, err)
+		} else {
+			del.NextAttempt = time.Now().Add(d.RetryDelay << (del.Attempts - 1))
+			log.Printf("webhook: delivery %d to %s failed (attempt %d): %v", del.ID, h.URL, del.Attempts, err)
+		}
+	} else {
+		del.ResponseCode = code
+		del.Status, del.DeliveredAt, del.NextAttempt = memory.DeliveryDelivered, time.Now(), time.Time{}
+	}
+	if err := memory.UpdateDelivery(db, del); err != nil {
+		log.Printf("webhook: UpdateDelivery error: %v", err)
+	}
+}
+
+// post sends a delivery and returns the response status. Any status outside
+// 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, h *memory.Webhook, del *memory.Delivery) (int, error) {
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Codex-Webhook")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(del.ID))
+	req.Header.Set(HeaderSignature, Sign(h.Secret, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// prune deletes finished deliveries older than Retention.
+func (d *Dispatcher) prune() {
+	db, err := memory.InitDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	if n, err := memory.PruneDeliveries(db, time.Now().Add(-d.Retention)); err != nil {
+		log.Printf("webhook: prune error: %v", err)
+	} else if n > 0 {
+		log.Printf("webhook: pruned %d old deliveries", n)
+	}
+}
+
+var (
+	defaultMu         sync.RWMutex
+	defaultDispatcher *Dispatcher
+)
+
+// SetDefault registers the dispatcher used by Emit.
+func SetDefault(d *Dispatcher) {
+	defaultMu.Lock()
+	defaultDispatcher = d
+	defaultMu.Unlock()
+}
+
+// Default returns the registered dispatcher or nil.
+func Default() *Dispatcher {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultDispatcher
+}
+
+// Emit sends an event through the default dispatcher. It is a no-op while
+// none is registered.
+func Emit(event string, data any) {
+	if d := Default(); d != nil {
+		d.Emit(event, data)
+	}
+}
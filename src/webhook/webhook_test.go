@@ -0,0 +1,233 @@
+package webhook
+
+// Tests for signing and delivering webhooks against a local receiver.
+
+import (
+	"codex/src/memory"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records deliveries and fails the first few with 500.
+type receiver struct {
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	bodies   []Payload
+	got      chan string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !Verify(rc.secret, body, r.Header.Get(HeaderSignature)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	rc.mu.Lock()
+	if rc.failures > 0 {
+		rc.failures--
+		rc.mu.Unlock()
+		http.Error(w, "try later", http.StatusInternalServerError)
+		return
+	}
+	var p Payload
+	json.Unmarshal(body, &p)
+	rc.bodies = append(rc.bodies, p)
+	rc.mu.Unlock()
+	rc.got <- r.Header.Get(HeaderEvent)
+}
+
+// TestSign checks signatures against a known HMAC-SHA256 value.
+func TestSign(t *testing.T) {
+	sig := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if sig != want {
+		t.Fatalf("Sign = %s, want %s", sig, want)
+	}
+	if Verify("other", []byte("The quick brown fox jumps over the lazy dog"), sig) {
+		t.Fatalf("signature verified with the wrong secret")
+	}
+}
+
+// TestDispatch checks that events reach subscribed webhooks only, that a
+// failed delivery is retried and that the log records both attempts.
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	rc := &receiver{secret: "s3cret", failures: 1, got: make(chan string, 10)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	hook := &memory.Webhook{URL: srv.URL, Secret: rc.secret, Events: []string{EventProjectDeleted}, Active: true, CreatedAt: time.Now()}
+	if err := memory.CreateWebhook(db, hook); err != nil {
+		t.Fatalf("CreateWebhook error: %v", err)
+	}
+
+	d := NewDispatcher()
+	d.RetryDelay = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Emit(EventChatCompleted, ChatCompleted{Prompt: "hi", Response: "hello"})
+	d.Emit(EventProjectDeleted, ProjectDeleted{Project: "demo"})
+	select {
+	case ev := <-rc.got:
+		if ev != EventProjectDeleted {
+			t.Fatalf("received %s, want %s", ev, EventProjectDeleted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("delivery not received")
+	}
+	rc.mu.Lock()
+	if len(rc.bodies) != 1 || rc.bodies[0].Event != EventProjectDeleted {
+		t.Fatalf("unexpected bodies %+v", rc.bodies)
+	}
+	rc.mu.Unlock()
+
+	// the log is written after the response, so poll briefly
+	var dels []memory.Delivery
+	for i := 0; i < 50; i++ {
+		if dels, err = memory.ListDeliveries(db, hook.ID, 10); err == nil && len(dels) == 1 && dels[0].Status == memory.DeliveryDelivered {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(dels) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(dels))
+	}
+	if del := dels[0]; del.Status != memory.DeliveryDelivered || del.Attempts != 2 || del.ResponseCode != http.StatusOK {
+		t.Fatalf("unexpected delivery %+v", del)
+	}
+}
+
+// TestInactiveWebhook checks that a delivery queued for a paused webhook is
+// marked failed instead of sent.
+func TestInactiveWebhook(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	rc := &receiver{secret: "s3cret", got: make(chan string, 10)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	hook := &memory.Webhook{URL: srv.URL, Secret: rc.secret, Events: []string{"*"}, Active: true, CreatedAt: time.Now()}
+	if err := memory.CreateWebhook(db, hook); err != nil {
+		t.Fatalf("CreateWebhook error: %v", err)
+	}
+	if err := Enqueue(db, hook.ID, EventPing, []byte(`{"event":"ping"}`)); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+	if err := memory.SetWebhookActive(db, hook.ID, false); err != nil {
+		t.Fatalf("SetWebhookActive error: %v", err)
+	}
+
+	d := NewDispatcher()
+	d.deliverDue(context.Background())
+	d.sending.Wait()
+	dels, err := memory.ListDeliveries(db, hook.ID, 10)
+	if err != nil || len(dels) != 1 {
+		t.Fatalf("ListDeliveries = %v, %v", dels, err)
+	}
+	if dels[0].Status != memory.DeliveryFailed {
+		t.Fatalf("status = %s, want %s", dels[0].Status, memory.DeliveryFailed)
+	}
+	if len(rc.got) != 0 {
+		t.Fatalf("paused webhook received a delivery")
+	}
+}
+
+// TestDeliveryConcurrency checks that a webhook that does not answer holds
+// at most Concurrency deliveries while another webhook is still served.
+func TestDeliveryConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer slow.Close()
+	defer close(release)
+	rc := &receiver{secret: "s3cret", got: make(chan string, 10)}
+	fast := httptest.NewServer(rc)
+	defer fast.Close()
+
+	db, err := memory.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB error: %v", err)
+	}
+	defer db.Close()
+	for _, url := range []string{slow.URL, fast.URL} {
+		hook := &memory.Webhook{URL: url, Secret: rc.secret, Events: []string{"*"}, Active: true, CreatedAt: time.Now()}
+		if err := memory.CreateWebhook(db, hook); err != nil {
+			t.Fatalf("CreateWebhook error: %v", err)
+		}
+	}
+
+	d := NewDispatcher()
+	d.Concurrency = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	for i := 0; i < 5; i++ {
+		d.Emit(EventPing, nil)
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case <-rc.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fast webhook got %d of 5 deliveries", i)
+		}
+	}
+	// the slow requests may still be on their way
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := most
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if most != 2 {
+		t.Fatalf("slow webhook had %d deliveries in flight, want 2", most)
+	}
+}